@@ -47,6 +47,7 @@ var experimentsByName = map[string]func(*Session) *ExperimentBuilder{
 			},
 			config:      &dnscheck.Config{},
 			inputPolicy: InputStrictlyRequired,
+			inputShape:  InputShapeDNSResolverURL,
 		}
 	},
 
@@ -152,6 +153,8 @@ var experimentsByName = map[string]func(*Session) *ExperimentBuilder{
 			},
 			config:      &httphostheader.Config{},
 			inputPolicy: InputOrQueryTestLists,
+			inputShape:  InputShapeDomain,
+			sequential:  true, // the measurer modifies its config in Run
 		}
 	},
 
@@ -189,6 +192,7 @@ var experimentsByName = map[string]func(*Session) *ExperimentBuilder{
 			},
 			config:      &psiphon.Config{},
 			inputPolicy: InputOptional,
+			inputShape:  InputShapeURL,
 		}
 	},
 
@@ -225,6 +229,7 @@ var experimentsByName = map[string]func(*Session) *ExperimentBuilder{
 			},
 			config:      &sniblocking.Config{},
 			inputPolicy: InputOrQueryTestLists,
+			sequential:  true, // the measurer modifies its config in Run
 		}
 	},
 
@@ -237,6 +242,7 @@ var experimentsByName = map[string]func(*Session) *ExperimentBuilder{
 			},
 			config:      &stunreachability.Config{},
 			inputPolicy: InputOptional,
+			inputShape:  InputShapeEndpoint,
 		}
 	},
 
@@ -261,6 +267,7 @@ var experimentsByName = map[string]func(*Session) *ExperimentBuilder{
 			},
 			config:      &tlstool.Config{},
 			inputPolicy: InputOrQueryTestLists,
+			inputShape:  InputShapeEndpoint,
 		}
 	},
 
@@ -285,6 +292,7 @@ var experimentsByName = map[string]func(*Session) *ExperimentBuilder{
 			},
 			config:      &urlgetter.Config{},
 			inputPolicy: InputStrictlyRequired,
+			inputShape:  InputShapeURL,
 		}
 	},
 
@@ -297,6 +305,7 @@ var experimentsByName = map[string]func(*Session) *ExperimentBuilder{
 			},
 			config:      &webconnectivity.Config{},
 			inputPolicy: InputOrQueryTestLists,
+			inputShape:  InputShapeURL,
 		}
 	},
 
@@ -190,6 +190,41 @@ func (e *Experiment) OpenReportContext(ctx context.Context) error {
 	if e.report != nil {
 		return nil // already open
 	}
+	client, err := e.newProbeServicesClient()
+	if err != nil {
+		return err
+	}
+	template := e.newReportTemplate()
+	e.report, err = client.OpenReport(ctx, template)
+	if err != nil {
+		e.session.logger.Debugf("experiment: probe services error: %s", err.Error())
+		return err
+	}
+	return nil
+}
+
+// ResumeReportContext is like OpenReportContext except that, rather
+// than opening a new report, it arranges for measurements to be
+// submitted into an existing report. This only succeeds if the
+// collector knows about the report with the given ID.
+func (e *Experiment) ResumeReportContext(ctx context.Context, reportID string) error {
+	if e.report != nil {
+		return errors.New("Report is already open")
+	}
+	client, err := e.newProbeServicesClient()
+	if err != nil {
+		return err
+	}
+	template := e.newReportTemplate()
+	e.report, err = client.ResumeReport(ctx, template, reportID)
+	if err != nil {
+		e.session.logger.Debugf("experiment: probe services error: %s", err.Error())
+		return err
+	}
+	return nil
+}
+
+func (e *Experiment) newProbeServicesClient() (*probeservices.Client, error) {
 	// use custom client to have proper byte accounting
 	httpClient := &http.Client{
 		Transport: &httptransport.ByteCountingTransport{
@@ -198,21 +233,15 @@ func (e *Experiment) OpenReportContext(ctx context.Context) error {
 		},
 	}
 	if e.session.selectedProbeService == nil {
-		return errors.New("no probe services selected")
+		return nil, errors.New("no probe services selected")
 	}
 	client, err := probeservices.NewClient(e.session, *e.session.selectedProbeService)
 	if err != nil {
 		e.session.logger.Debugf("%+v", err)
-		return err
+		return nil, err
 	}
 	client.HTTPClient = httpClient // patch HTTP client to use
-	template := e.newReportTemplate()
-	e.report, err = client.OpenReport(ctx, template)
-	if err != nil {
-		e.session.logger.Debugf("experiment: probe services error: %s", err.Error())
-		return err
-	}
-	return nil
+	return client, nil
 }
 
 func (e *Experiment) newReportTemplate() probeservices.ReportTemplate {
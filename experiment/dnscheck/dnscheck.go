@@ -17,21 +17,24 @@ import (
 	"github.com/ooni/probe-engine/model"
 	"github.com/ooni/probe-engine/netx"
 	"github.com/ooni/probe-engine/netx/archival"
+	"github.com/ooni/probe-engine/netx/resolver"
 	"github.com/ooni/probe-engine/netx/trace"
 )
 
 const (
 	testName      = "dnscheck"
-	testVersion   = "0.6.0"
+	testVersion   = "0.9.0"
 	defaultDomain = "example.org"
 )
 
 // Config contains the experiment's configuration.
 type Config struct {
+	CAFile        string `json:"ca_file" ooni:"also trust the CAs inside the specified PEM file"`
 	DefaultAddrs  string `json:"default_addrs" ooni:"default addresses for domain"`
 	Domain        string `json:"domain" ooni:"domain to resolve using the specified resolver"`
 	HTTP3Enabled  bool   `json:"http3_enabled" ooni:"use http3 instead of http/1.1 or http2"`
 	HTTPHost      string `json:"http_host" ooni:"force using specific HTTP Host header"`
+	QueryTypes    string `json:"query_types" ooni:"space separated DNS query types to use (e.g. 'A AAAA HTTPS')"`
 	TLSServerName string `json:"tls_server_name" ooni:"force TLS to using a specific SNI in Client Hello"`
 }
 
@@ -41,6 +44,7 @@ type TestKeys struct {
 	Domain           string                        `json:"domain"`
 	HTTP3Enabled     bool                          `json:"x_http3_enabled,omitempty"`
 	HTTPHost         string                        `json:"x_http_host,omitempty"`
+	QueryTypes       string                        `json:"x_query_types,omitempty"`
 	TLSServerName    string                        `json:"x_tls_server_name,omitempty"`
 	Bootstrap        *urlgetter.TestKeys           `json:"bootstrap"`
 	BootstrapFailure *string                       `json:"bootstrap_failure"`
@@ -67,6 +71,7 @@ func (m Measurer) ExperimentVersion() string {
 // that are used by this experiment to implement its functionality.
 var (
 	ErrInputRequired        = errors.New("this experiment needs input")
+	ErrInvalidQueryType     = errors.New("invalid DNS query type")
 	ErrInvalidURL           = errors.New("the input URL is invalid")
 	ErrUnsupportedURLScheme = errors.New("unsupported URL scheme")
 )
@@ -96,6 +101,7 @@ func (m Measurer) Run(
 	tk.Domain = domain
 	tk.HTTP3Enabled = m.Config.HTTP3Enabled
 	tk.HTTPHost = m.Config.HTTPHost
+	tk.QueryTypes = m.Config.QueryTypes
 	tk.TLSServerName = m.Config.TLSServerName
 
 	// 4. parse the input URL describing the resolver to use
@@ -108,11 +114,16 @@ func (m Measurer) Run(
 		return fmt.Errorf("%w: %s", ErrInvalidURL, err.Error())
 	}
 	switch URL.Scheme {
-	case "https", "dot", "udp", "tcp":
+	case "https", "h3", "dot", "doq", "udp", "tcp":
 		// all good
 	default:
 		return ErrUnsupportedURLScheme
 	}
+	for _, name := range strings.Fields(m.Config.QueryTypes) {
+		if _, err := resolver.ParseQueryType(name); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidQueryType, name)
+		}
+	}
 
 	// 5. possibly expand a domain to a list of IP addresses.
 	//
@@ -121,12 +132,12 @@ func (m Measurer) Run(
 	// passing as input an IP address rather than a domain name.
 	begin := measurement.MeasurementStartTimeSaved
 	evsaver := new(trace.Saver)
-	resolver := netx.NewResolver(netx.Config{
+	reso := netx.NewResolver(netx.Config{
 		BogonIsError: true,
 		Logger:       sess.Logger(),
 		ResolveSaver: evsaver,
 	})
-	addrs, err := resolver.LookupHost(ctx, URL.Hostname())
+	addrs, err := reso.LookupHost(ctx, URL.Hostname())
 	queries := archival.NewDNSQueriesList(begin, evsaver.Read(), sess.ASNDatabasePath())
 	tk.BootstrapFailure = archival.NewFailure(err)
 	if len(queries) > 0 {
@@ -153,7 +164,9 @@ func (m Measurer) Run(
 	for addr := range allAddrs {
 		inputs = append(inputs, urlgetter.MultiInput{
 			Config: urlgetter.Config{
+				CAFile:           m.Config.CAFile,
 				DNSHTTPHost:      m.httpHost(URL.Host),
+				DNSQueryTypes:    m.Config.QueryTypes,
 				DNSTLSServerName: m.tlsServerName(URL.Hostname()),
 				HTTP3Enabled:     m.Config.HTTP3Enabled,
 				RejectDNSBogons:  true, // bogons are errors in this context
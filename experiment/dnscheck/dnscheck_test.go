@@ -48,7 +48,7 @@ func TestExperimentNameAndVersion(t *testing.T) {
 	if measurer.ExperimentName() != "dnscheck" {
 		t.Error("unexpected experiment name")
 	}
-	if measurer.ExperimentVersion() != "0.6.0" {
+	if measurer.ExperimentVersion() != "0.9.0" {
 		t.Error("unexpected experiment version")
 	}
 }
@@ -92,6 +92,19 @@ func TestDNSCheckFailsWithUnsupportedProtocol(t *testing.T) {
 	}
 }
 
+func TestDNSCheckFailsWithInvalidQueryType(t *testing.T) {
+	measurer := NewExperimentMeasurer(Config{QueryTypes: "A ANTANI"})
+	err := measurer.Run(
+		context.Background(),
+		newsession(),
+		&model.Measurement{Input: "dot://one.one.one.one"},
+		model.NewPrinterCallbacks(log.Log),
+	)
+	if !errors.Is(err, ErrInvalidQueryType) {
+		t.Fatal("expected invalid query type error")
+	}
+}
+
 func TestWithCancelledContext(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel() // immediately cancel the context
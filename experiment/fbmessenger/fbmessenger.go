@@ -118,6 +118,9 @@ func (tk *TestKeys) ComputeEndpointStatus(v urlgetter.MultiOutput, dns, tcp **bo
 	}
 	for _, query := range v.TestKeys.Queries {
 		for _, ans := range query.Answers {
+			if ans.AnswerType == "CNAME" {
+				continue // part of the CNAME chain, which has no ASN
+			}
 			if ans.ASN != FacebookASN {
 				tk.FacebookDNSBlocking = &trueValue
 				*dns = &falseValue
@@ -37,6 +37,7 @@ func (mgr dialManager) dialWithTestName(ctx context.Context, testName string) (*
 	var reso resolver.Resolver = resolver.SystemResolver{}
 	reso = resolver.LoggingResolver{Resolver: reso, Logger: mgr.logger}
 	var dlr dialer.Dialer = selfcensor.SystemDialer{}
+	dlr = dialer.ImpairmentDialer{Dialer: dlr}
 	dlr = dialer.TimeoutDialer{Dialer: dlr}
 	dlr = dialer.ErrorWrapperDialer{Dialer: dlr}
 	dlr = dialer.LoggingDialer{Dialer: dlr, Logger: mgr.logger}
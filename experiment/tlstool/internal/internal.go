@@ -50,6 +50,47 @@ func NewRandomSplitterDialer(config DialerConfig) Dialer {
 	}
 }
 
+// NewTLSRecordSplitterDialer creates a new dialer that splits
+// the ClientHello into two TLS records such that the SNI ends up
+// being split across the records. All records are written at once,
+// so the TCP segmentation should be the same as the vanilla one.
+func NewTLSRecordSplitterDialer(config DialerConfig) Dialer {
+	return Dialer{
+		Dialer: config.Dialer,
+		Delay:  config.Delay,
+		Splitter: func(b []byte) [][]byte {
+			return TLSRecordSplitter(b, []byte(config.SNI))
+		},
+	}
+}
+
+// DelayedACKTimeout is a reasonable upper bound for the time after
+// which a peer using delayed ACKs acknowledges a segment.
+const DelayedACKTimeout = 200 * time.Millisecond
+
+// NewNagleDialer creates a new dialer emulating in userspace what
+// happens when Nagle's algorithm is enabled and the server delays
+// ACKs: we send the bytes preceding the SNI and then, after the
+// delayed ACK timeout, we coalesce the rest into a single segment.
+// If the configured delay is larger, we use the configured delay.
+//
+// That is, this is a two-segment SNI prefix split with a delay of at
+// least DelayedACKTimeout. Unlike NewSNISplitterDialer, it does not
+// split the SNI itself across several segments.
+func NewNagleDialer(config DialerConfig) Dialer {
+	delay := config.Delay
+	if delay < DelayedACKTimeout {
+		delay = DelayedACKTimeout
+	}
+	return Dialer{
+		Dialer: config.Dialer,
+		Delay:  delay,
+		Splitter: func(b []byte) [][]byte {
+			return SNIPrefixSplitter(b, []byte(config.SNI))
+		},
+	}
+}
+
 // NewVanillaDialer creates a new vanilla dialer that does
 // nothing and is used to establish a baseline.
 func NewVanillaDialer(config DialerConfig) Dialer {
@@ -2,6 +2,9 @@ package internal_test
 
 import (
 	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/ooni/probe-engine/experiment/tlstool/internal"
@@ -35,6 +38,38 @@ func TestNewRandomSplitterDialer(t *testing.T) {
 	dial(t, internal.NewRandomSplitterDialer(config))
 }
 
+func TestNewTLSRecordSplitterDialer(t *testing.T) {
+	dial(t, internal.NewTLSRecordSplitterDialer(config))
+}
+
+func TestNewTLSRecordSplitterDialerWithLocalServer(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	td := netx.NewTLSDialer(netx.Config{
+		Dialer: internal.NewTLSRecordSplitterDialer(internal.DialerConfig{
+			Dialer: netx.NewDialer(netx.Config{}),
+			SNI:    "example.com",
+		}),
+		NoTLSVerify: true,
+		TLSConfig:   &tls.Config{ServerName: "example.com"},
+	})
+	conn, err := td.DialTLSContext(
+		context.Background(), "tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func TestNewNagleDialer(t *testing.T) {
+	d := internal.NewNagleDialer(config)
+	if d.Delay != internal.DelayedACKTimeout {
+		t.Fatal("unexpected delay")
+	}
+	dial(t, d)
+}
+
 func TestNewVanillaDialer(t *testing.T) {
 	dial(t, internal.NewVanillaDialer(config))
 }
@@ -3,7 +3,9 @@ package internal
 import (
 	"bytes"
 	"math/rand"
+	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 // SNISplitter splits input such that SNI is splitted across
@@ -65,3 +67,88 @@ func Splitter3264rand(input []byte) (output [][]byte) {
 	output = append(output, input[offset:])
 	return
 }
+
+// SNIPrefixSplitter splits input in two output buffers such that
+// the first one contains all the bytes preceding the SNI and the
+// second one contains the SNI and all the bytes following it.
+func SNIPrefixSplitter(input []byte, sni []byte) (output [][]byte) {
+	idx := bytes.Index(input, sni)
+	if idx <= 0 {
+		output = append(output, input)
+		return
+	}
+	output = append(output, input[:idx])
+	output = append(output, input[idx:])
+	return
+}
+
+// TLSRecordSplitter splits the TLS record containing the ClientHello
+// into two TLS records such that the SNI is split across them. If
+// we cannot find the SNI, we split the ClientHello in the middle. We
+// emit all the records as a single output buffer, so we change the
+// TLS record layer without changing the TCP segmentation. Servers
+// must reassemble handshake messages spanning several records, while
+// middleboxes parsing just the first record will not see the SNI.
+func TLSRecordSplitter(input []byte, sni []byte) (output [][]byte) {
+	const (
+		headerSize         = 5
+		handshakeRecord    = 22
+		clientHelloMessage = 1
+	)
+	if len(input) <= headerSize || input[0] != handshakeRecord ||
+		input[headerSize] != clientHelloMessage {
+		output = append(output, input)
+		return
+	}
+	length := int(input[3])<<8 | int(input[4])
+	if length < 2 || len(input) < headerSize+length {
+		output = append(output, input)
+		return
+	}
+	payload := input[headerSize : headerSize+length]
+	offset := len(payload) / 2
+	if idx := bytes.Index(payload, sni); idx >= 0 && len(sni) > 1 {
+		offset = idx + len(sni)/2
+	}
+	var buf []byte
+	buf = appendTLSRecord(buf, input[:3], payload[:offset])
+	buf = appendTLSRecord(buf, input[:3], payload[offset:])
+	buf = append(buf, input[headerSize+length:]...)
+	output = append(output, buf)
+	return
+}
+
+// appendTLSRecord appends to buf a TLS record starting with the
+// specified type and version and containing payload.
+func appendTLSRecord(buf, typeAndVersion, payload []byte) []byte {
+	buf = append(buf, typeAndVersion...)
+	buf = append(buf, byte(len(payload)>>8), byte(len(payload)))
+	return append(buf, payload...)
+}
+
+// RandomCase returns a copy of input where the case of each ASCII
+// letter has been randomized. If input contains ASCII letters, we
+// make sure that the output differs from the input.
+func RandomCase(input string) string {
+	if strings.IndexFunc(input, func(r rune) bool {
+		return r < utf8.RuneSelf && isASCIILetter(byte(r))
+	}) < 0 {
+		return input
+	}
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for {
+		output := []byte(input)
+		for idx, chr := range output {
+			if isASCIILetter(chr) && rnd.Intn(2) == 0 {
+				output[idx] = chr ^ 0x20 // flips the case of ASCII letters
+			}
+		}
+		if string(output) != input {
+			return string(output)
+		}
+	}
+}
+
+func isASCIILetter(chr byte) bool {
+	return (chr >= 'a' && chr <= 'z') || (chr >= 'A' && chr <= 'Z')
+}
@@ -1,6 +1,7 @@
 package internal_test
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/ooni/probe-engine/experiment/tlstool/internal"
@@ -141,3 +142,96 @@ func TestSNISplitterWithUnicode(t *testing.T) {
 		t.Fatal("invalid output[7]")
 	}
 }
+
+func TestSNIPrefixSplitterGood(t *testing.T) {
+	input := []byte("1111foo.com2222")
+	output := internal.SNIPrefixSplitter(input, []byte("foo.com"))
+	if len(output) != 2 {
+		t.Fatal("invalid output length")
+	}
+	if string(output[0]) != "1111" {
+		t.Fatal("invalid output[0]")
+	}
+	if string(output[1]) != "foo.com2222" {
+		t.Fatal("invalid output[1]")
+	}
+}
+
+func TestSNIPrefixSplitterNoMatch(t *testing.T) {
+	input := []byte("1111foo.com2222")
+	output := internal.SNIPrefixSplitter(input, []byte("bar.com"))
+	if len(output) != 1 {
+		t.Fatal("invalid output length")
+	}
+	if string(output[0]) != string(input) {
+		t.Fatal("invalid output[0]")
+	}
+}
+
+func newClientHelloRecord(payload string) []byte {
+	record := []byte{22, 3, 1, byte(len(payload) >> 8), byte(len(payload))}
+	return append(record, payload...)
+}
+
+func TestTLSRecordSplitterWithSNI(t *testing.T) {
+	input := newClientHelloRecord("\x011111foo.barbar.com2222")
+	output := internal.TLSRecordSplitter(input, []byte("foo.barbar.com"))
+	if len(output) != 1 {
+		t.Fatal("invalid output length")
+	}
+	expected := string(newClientHelloRecord("\x011111foo.bar")) +
+		"\x16\x03\x01\x00\x0bbar.com2222"
+	if string(output[0]) != expected {
+		t.Fatalf("invalid output[0]: %q", output[0])
+	}
+}
+
+func TestTLSRecordSplitterWithoutSNI(t *testing.T) {
+	input := newClientHelloRecord("\x01234")
+	input = append(input, "trailing"...)
+	output := internal.TLSRecordSplitter(input, []byte("foo.com"))
+	if len(output) != 1 {
+		t.Fatal("invalid output length")
+	}
+	expected := "\x16\x03\x01\x00\x02\x012\x16\x03\x01\x00\x0234trailing"
+	if string(output[0]) != expected {
+		t.Fatalf("invalid output[0]: %q", output[0])
+	}
+}
+
+func TestTLSRecordSplitterIgnoresOtherRecords(t *testing.T) {
+	inputs := [][]byte{
+		[]byte("\x17\x03\x03\x00\x03abc"),         // application data
+		[]byte("\x16\x03\x03\x00\x03\x02bc"),      // not a ClientHello
+		[]byte("\x16\x03\x01\x00\x10\x01bc"),      // truncated record
+		[]byte("\x16\x03\x01"),                    // too short
+		[]byte("\x16\x03\x01\x00\x01\x01trailer"), // payload too short
+	}
+	for _, input := range inputs {
+		output := internal.TLSRecordSplitter(input, []byte("bc"))
+		if len(output) != 1 || string(output[0]) != string(input) {
+			t.Fatalf("unexpected output for %q: %q", input, output)
+		}
+	}
+}
+
+func TestRandomCase(t *testing.T) {
+	for i := 0; i < 32; i++ {
+		output := internal.RandomCase("dns.google")
+		if output == "dns.google" {
+			t.Fatal("output should differ from input")
+		}
+		if strings.ToLower(output) != "dns.google" {
+			t.Fatal("unexpected output", output)
+		}
+	}
+}
+
+func TestRandomCaseWithoutLetters(t *testing.T) {
+	if output := internal.RandomCase("8.8.8.8"); output != "8.8.8.8" {
+		t.Fatal("unexpected output", output)
+	}
+	if output := internal.RandomCase("你好.世界"); output != "你好.世界" {
+		t.Fatal("unexpected output", output)
+	}
+}
@@ -11,6 +11,7 @@ package tlstool
 import (
 	"context"
 	"crypto/tls"
+	"encoding/base64"
 	"fmt"
 	"net"
 	"time"
@@ -20,17 +21,21 @@ import (
 	"github.com/ooni/probe-engine/model"
 	"github.com/ooni/probe-engine/netx"
 	"github.com/ooni/probe-engine/netx/archival"
+	"github.com/ooni/probe-engine/netx/dialer"
+	"github.com/ooni/probe-engine/netx/trace"
 )
 
 const (
 	testName    = "tlstool"
-	testVersion = "0.1.0"
+	testVersion = "0.4.0"
 )
 
 // Config contains the experiment configuration.
 type Config struct {
-	Delay int64  `ooni:"Milliseconds to wait between writes"`
-	SNI   string `ooni:"Force using the specified SNI"`
+	Delay     int64  `ooni:"Milliseconds to wait between writes"`
+	ECHConfig string `ooni:"Base64 ECHConfigList for the ech method (default: use the HTTPS record)"`
+	Parrot    string `ooni:"Mimic the ClientHello of a TLS client (one of 'chrome', 'firefox', 'ios', 'randomized'; parrot and padding methods default to 'chrome')"`
+	SNI       string `ooni:"Force using the specified SNI"`
 }
 
 // TestKeys contains the experiment results.
@@ -38,9 +43,16 @@ type TestKeys struct {
 	Experiment map[string]*ExperimentKeys `json:"experiment"`
 }
 
-// ExperimentKeys contains the specific experiment results.
+// ExperimentKeys contains the specific experiment results. T0 and T
+// are the times, relative to the measurement start, when we started
+// and when we finished running the specific method. Parrot is the
+// TLS client we mimicked, if any, or empty if we used crypto/tls.
 type ExperimentKeys struct {
-	Failure *string `json:"failure"`
+	Failure       *string                 `json:"failure"`
+	Parrot        string                  `json:"parrot,omitempty"`
+	T0            float64                 `json:"t0"`
+	T             float64                 `json:"t"`
+	TLSHandshakes []archival.TLSHandshake `json:"tls_handshakes"`
 }
 
 // Measurer performs the measurement.
@@ -58,9 +70,17 @@ func (m Measurer) ExperimentVersion() string {
 	return testVersion
 }
 
+// method is a strategy we try. When Config.Parrot is empty, we use
+// parrot as the TLS client to mimic, if not empty. Because we need uTLS
+// to control the padding, the padding methods use the chrome parrot and
+// the parrot method is their baseline.
 type method struct {
-	name      string
-	newDialer func(internal.DialerConfig) internal.Dialer
+	ech        bool
+	name       string
+	newDialer  func(internal.DialerConfig) internal.Dialer
+	padding    int
+	parrot     string
+	randomCase bool
 }
 
 var allMethods = []method{{
@@ -75,6 +95,41 @@ var allMethods = []method{{
 }, {
 	name:      "thrice",
 	newDialer: internal.NewThriceSplitterDialer,
+}, {
+	name:      "recordsplit",
+	newDialer: internal.NewTLSRecordSplitterDialer,
+}, {
+	// nagle is like snisplit except that it splits right before the
+	// SNI, rather than inside it, and waits for at least 200 ms.
+	name:      "nagle",
+	newDialer: internal.NewNagleDialer,
+}, {
+	name:       "snicase",
+	newDialer:  internal.NewVanillaDialer,
+	randomCase: true,
+}, {
+	name:      "parrot",
+	newDialer: internal.NewVanillaDialer,
+	parrot:    "chrome",
+}, {
+	name:      "padding256",
+	newDialer: internal.NewVanillaDialer,
+	padding:   256,
+	parrot:    "chrome",
+}, {
+	name:      "padding1024",
+	newDialer: internal.NewVanillaDialer,
+	padding:   1024,
+	parrot:    "chrome",
+}, {
+	name:      "padding4096",
+	newDialer: internal.NewVanillaDialer,
+	padding:   4096,
+	parrot:    "chrome",
+}, {
+	ech:       true,
+	name:      "ech",
+	newDialer: internal.NewVanillaDialer,
 }}
 
 // Run implements ExperimentMeasurer.Run.
@@ -91,47 +146,94 @@ func (m Measurer) Run(
 	measurement.TestKeys = tk
 	address := string(measurement.Input)
 	for idx, meth := range allMethods {
+		if meth.ech && !dialer.ECHSupported {
+			// We omit the method rather than archiving a failure that
+			// would look like the network interfered with ECH.
+			sess.Logger().Infof("tlstool: skipping %s: %s", meth.name, dialer.ErrECHNotSupported)
+			continue
+		}
 		// TODO(bassosimone): here we actually want to use urlgetter
 		// if possible and collect standard test keys.
+		saver := &trace.Saver{}
+		parrot := m.parrot(meth)
+		t0 := time.Since(measurement.MeasurementStartTimeSaved).Seconds()
 		err := m.run(ctx, runConfig{
-			address:   address,
-			logger:    sess.Logger(),
-			newDialer: meth.newDialer,
+			address:    address,
+			ech:        meth.ech,
+			logger:     sess.Logger(),
+			newDialer:  meth.newDialer,
+			padding:    meth.padding,
+			parrot:     parrot,
+			randomCase: meth.randomCase,
+			saver:      saver,
 		})
+		t := time.Since(measurement.MeasurementStartTimeSaved).Seconds()
 		percent := float64(idx) / float64(len(allMethods))
 		callbacks.OnProgress(percent, fmt.Sprintf("%s: %+v", meth.name, err))
 		tk.Experiment[meth.name] = &ExperimentKeys{
 			Failure: archival.NewFailure(err),
+			Parrot:  parrot,
+			T0:      t0,
+			T:       t,
+			TLSHandshakes: archival.NewTLSHandshakesList(
+				measurement.MeasurementStartTimeSaved, saver.Read()),
 		}
 	}
 	return nil
 }
 
-func (m Measurer) newDialer(logger model.Logger) netx.Dialer {
+func (m Measurer) newResolver(logger model.Logger) netx.Resolver {
 	// TODO(bassosimone): this is a resolver that should hopefully work
 	// in many places. Maybe allow to configure it?
 	resolver, err := netx.NewDNSClientWithOverrides(netx.Config{Logger: logger},
 		"https://cloudflare.com/dns-query", "dns.cloudflare.com", "")
 	runtimex.PanicOnError(err, "cannot initialize resolver")
-	return netx.NewDialer(netx.Config{FullResolver: resolver, Logger: logger})
+	return resolver
 }
 
 type runConfig struct {
-	address   string
-	logger    model.Logger
-	newDialer func(internal.DialerConfig) internal.Dialer
+	address    string
+	ech        bool
+	logger     model.Logger
+	newDialer  func(internal.DialerConfig) internal.Dialer
+	padding    int
+	parrot     string
+	randomCase bool
+	saver      *trace.Saver
+}
+
+// parrot returns the TLS client that meth should mimic.
+func (m Measurer) parrot(meth method) string {
+	if meth.ech {
+		return "" // ECH requires crypto/tls
+	}
+	if m.config.Parrot != "" {
+		return m.config.Parrot
+	}
+	return meth.parrot
 }
 
 func (m Measurer) run(ctx context.Context, config runConfig) error {
+	resolver := m.newResolver(config.logger)
 	dialer := config.newDialer(internal.DialerConfig{
-		Dialer: m.newDialer(config.logger),
+		Dialer: netx.NewDialer(netx.Config{FullResolver: resolver, Logger: config.logger}),
 		Delay:  time.Duration(m.config.Delay) * time.Millisecond,
 		SNI:    m.pattern(config.address),
 	})
+	echConfigList, err := base64.StdEncoding.DecodeString(m.config.ECHConfig)
+	if err != nil {
+		return err
+	}
 	tdialer := netx.NewTLSDialer(netx.Config{
-		Dialer:    dialer,
-		Logger:    config.logger,
-		TLSConfig: m.tlsConfig(),
+		Dialer:        dialer,
+		ECHConfigList: echConfigList,
+		ECHEnabled:    config.ech,
+		FullResolver:  resolver,
+		Logger:        config.logger,
+		TLSConfig:     m.tlsConfig(config),
+		TLSPaddingLen: config.padding,
+		TLSParrot:     config.parrot,
+		TLSSaver:      config.saver,
 	})
 	conn, err := tdialer.DialTLSContext(ctx, "tcp", config.address)
 	if err != nil {
@@ -141,7 +243,12 @@ func (m Measurer) run(ctx context.Context, config runConfig) error {
 	return nil
 }
 
-func (m Measurer) tlsConfig() *tls.Config {
+func (m Measurer) tlsConfig(config runConfig) *tls.Config {
+	if config.randomCase {
+		// Hostname verification is case insensitive, hence the
+		// server certificate should still be valid.
+		return &tls.Config{ServerName: internal.RandomCase(m.pattern(config.address))}
+	}
 	if m.config.SNI != "" {
 		return &tls.Config{ServerName: m.config.SNI}
 	}
@@ -2,12 +2,15 @@ package tlstool_test
 
 import (
 	"context"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/apex/log"
 	"github.com/ooni/probe-engine/experiment/tlstool"
 	"github.com/ooni/probe-engine/internal/mockable"
 	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx/dialer"
 )
 
 func TestMeasurerExperimentNameVersion(t *testing.T) {
@@ -15,7 +18,7 @@ func TestMeasurerExperimentNameVersion(t *testing.T) {
 	if measurer.ExperimentName() != "tlstool" {
 		t.Fatal("unexpected ExperimentName")
 	}
-	if measurer.ExperimentVersion() != "0.1.0" {
+	if measurer.ExperimentVersion() != "0.4.0" {
 		t.Fatal("unexpected ExperimentVersion")
 	}
 }
@@ -60,6 +63,7 @@ func TestRunWithCancelledContext(t *testing.T) {
 	measurer := tlstool.NewExperimentMeasurer(tlstool.Config{})
 	measurement := new(model.Measurement)
 	measurement.Input = "dns.google:853"
+	measurement.MeasurementStartTimeSaved = time.Now()
 	err := measurer.Run(
 		ctx,
 		&mockable.Session{},
@@ -69,6 +73,35 @@ func TestRunWithCancelledContext(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	tk := measurement.TestKeys.(*tlstool.TestKeys)
+	names := []string{
+		"vanilla", "snisplit", "random", "thrice", "recordsplit", "nagle",
+		"snicase", "parrot", "padding256", "padding1024", "padding4096",
+	}
+	if dialer.ECHSupported {
+		names = append(names, "ech")
+	} else if _, found := tk.Experiment["ech"]; found {
+		t.Fatal("we should have skipped ech")
+	}
+	for _, name := range names {
+		entry, found := tk.Experiment[name]
+		if !found {
+			t.Fatal("missing strategy", name)
+		}
+		if entry.Failure == nil {
+			t.Fatal("expected a failure for", name)
+		}
+		if entry.T0 < 0 || entry.T < entry.T0 {
+			t.Fatal("invalid timing for", name)
+		}
+		expectParrot := ""
+		if name == "parrot" || strings.HasPrefix(name, "padding") {
+			expectParrot = "chrome"
+		}
+		if entry.Parrot != expectParrot {
+			t.Fatal("unexpected parrot for", name, entry.Parrot)
+		}
+	}
 	sk, err := measurer.GetSummaryKeys(measurement)
 	if err != nil {
 		t.Fatal(err)
@@ -78,6 +111,34 @@ func TestRunWithCancelledContext(t *testing.T) {
 	}
 }
 
+func TestRunWithExplicitParrot(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // cause failure
+	measurer := tlstool.NewExperimentMeasurer(tlstool.Config{Parrot: "firefox"})
+	measurement := new(model.Measurement)
+	measurement.Input = "dns.google:853"
+	measurement.MeasurementStartTimeSaved = time.Now()
+	err := measurer.Run(
+		ctx,
+		&mockable.Session{},
+		measurement,
+		model.NewPrinterCallbacks(log.Log),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*tlstool.TestKeys)
+	for name, entry := range tk.Experiment {
+		expectParrot := "firefox"
+		if name == "ech" {
+			expectParrot = "" // ECH requires crypto/tls
+		}
+		if entry.Parrot != expectParrot {
+			t.Fatal("unexpected parrot for", name, entry.Parrot)
+		}
+	}
+}
+
 func TestSummaryKeysGeneric(t *testing.T) {
 	measurement := &model.Measurement{TestKeys: &tlstool.TestKeys{}}
 	m := &tlstool.Measurer{}
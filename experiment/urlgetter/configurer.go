@@ -3,6 +3,8 @@ package urlgetter
 import (
 	"crypto/tls"
 	"errors"
+	"fmt"
+	"io/ioutil"
 	"net"
 	"net/url"
 	"regexp"
@@ -10,6 +12,7 @@ import (
 
 	"github.com/ooni/probe-engine/model"
 	"github.com/ooni/probe-engine/netx"
+	"github.com/ooni/probe-engine/netx/dialer"
 	"github.com/ooni/probe-engine/netx/trace"
 )
 
@@ -51,6 +54,21 @@ func (c Configurer) NewConfiguration() (Configuration, error) {
 			TLSSaver:            c.Saver,
 		},
 	}
+	// load additional CAs
+	if c.Config.CAFile != "" {
+		if c.Config.CertPool != nil {
+			return configuration, errors.New("cannot use both CertPool and CAFile")
+		}
+		data, err := ioutil.ReadFile(c.Config.CAFile)
+		if err != nil {
+			return configuration, err
+		}
+		pool, err := netx.NewDefaultCertPoolWithPEMs(data)
+		if err != nil {
+			return configuration, fmt.Errorf("invalid CAFile: %w", err)
+		}
+		configuration.HTTPConfig.CertPool = pool
+	}
 	// fill DNS cache
 	if c.Config.DNSCache != "" {
 		entry := strings.Split(c.Config.DNSCache, " ")
@@ -106,6 +124,10 @@ func (c Configurer) NewConfiguration() (Configuration, error) {
 	default:
 		return configuration, errors.New("unsupported TLS version")
 	}
+	if _, found := dialer.TLSParrots[c.Config.TLSParrot]; c.Config.TLSParrot != "" && !found {
+		return configuration, errors.New("unsupported TLS parrot")
+	}
+	configuration.HTTPConfig.TLSParrot = c.Config.TLSParrot
 	configuration.HTTPConfig.NoTLSVerify = c.Config.NoTLSVerify
 	// configure proxy
 	configuration.HTTPConfig.ProxyURL = c.ProxyURL
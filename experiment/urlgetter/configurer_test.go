@@ -2,12 +2,19 @@ package urlgetter_test
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"os"
 	"strings"
 	"testing"
 
 	"github.com/apex/log"
 	"github.com/ooni/probe-engine/experiment/urlgetter"
+	"github.com/ooni/probe-engine/netx"
 	"github.com/ooni/probe-engine/netx/resolver"
 	"github.com/ooni/probe-engine/netx/trace"
 )
@@ -374,6 +381,32 @@ func TestConfigurerNewConfigurationResolverUDP(t *testing.T) {
 	}
 }
 
+func TestConfigurerNewConfigurationResolverParallel(t *testing.T) {
+	configurer := urlgetter.Configurer{
+		Config: urlgetter.Config{
+			ResolverURL: "dot://8.8.8.8:853?parallel=true",
+		},
+		Logger: log.Log,
+		Saver:  new(trace.Saver),
+	}
+	configuration, err := configurer.NewConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer configuration.CloseIdleConnections()
+	pr, ok := configuration.HTTPConfig.BaseResolver.(resolver.ParallelResolver)
+	if !ok {
+		t.Fatal("not the resolver we expected")
+	}
+	stxp, ok := pr.Txp.(resolver.SaverDNSTransport)
+	if !ok {
+		t.Fatal("not the DNS transport we expected")
+	}
+	if stxp.Network() != "dot" || stxp.Address() != "8.8.8.8:853" {
+		t.Fatal("not the DNS transport we expected")
+	}
+}
+
 func TestConfigurerNewConfigurationDNSCacheInvalidString(t *testing.T) {
 	saver := new(trace.Saver)
 	configurer := urlgetter.Configurer{
@@ -714,6 +747,109 @@ func TestConfigurerNewConfigurationTLSvInvalid(t *testing.T) {
 	}
 }
 
+func TestConfigurerNewConfigurationTLSParrot(t *testing.T) {
+	saver := new(trace.Saver)
+	configurer := urlgetter.Configurer{
+		Config: urlgetter.Config{
+			TLSParrot: "firefox",
+		},
+		Logger: log.Log,
+		Saver:  saver,
+	}
+	configuration, err := configurer.NewConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configuration.HTTPConfig.TLSParrot != "firefox" {
+		t.Fatal("invalid TLSParrot")
+	}
+}
+
+func TestConfigurerNewConfigurationTLSParrotInvalid(t *testing.T) {
+	saver := new(trace.Saver)
+	configurer := urlgetter.Configurer{
+		Config: urlgetter.Config{
+			TLSParrot: "netscape",
+		},
+		Logger: log.Log,
+		Saver:  saver,
+	}
+	_, err := configurer.NewConfiguration()
+	if err.Error() != "unsupported TLS parrot" {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func newCAFile(t *testing.T, data []byte) string {
+	filep, err := ioutil.TempFile("", "urlgetter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer filep.Close()
+	if _, err := filep.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	return filep.Name()
+}
+
+func TestConfigurerNewConfigurationCAFile(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	cafile := newCAFile(t, pem.EncodeToMemory(&pem.Block{
+		Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+	defer os.Remove(cafile)
+	configurer := urlgetter.Configurer{
+		Config: urlgetter.Config{CAFile: cafile},
+		Logger: log.Log,
+		Saver:  new(trace.Saver),
+	}
+	configuration, err := configurer.NewConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer configuration.CloseIdleConnections()
+	if configuration.HTTPConfig.CertPool == nil {
+		t.Fatal("expected non-nil CertPool here")
+	}
+	client := &http.Client{Transport: netx.NewHTTPTransport(configuration.HTTPConfig)}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
+
+func TestConfigurerNewConfigurationCAFileFailures(t *testing.T) {
+	invalid := newCAFile(t, []byte("antani"))
+	defer os.Remove(invalid)
+	tests := []struct {
+		name   string
+		config urlgetter.Config
+	}{{
+		name:   "with nonexistent file",
+		config: urlgetter.Config{CAFile: "/nonexistent"},
+	}, {
+		name:   "with invalid file",
+		config: urlgetter.Config{CAFile: invalid},
+	}, {
+		name:   "with also CertPool",
+		config: urlgetter.Config{CAFile: invalid, CertPool: x509.NewCertPool()},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configurer := urlgetter.Configurer{
+				Config: tt.config,
+				Logger: log.Log,
+				Saver:  new(trace.Saver),
+			}
+			if _, err := configurer.NewConfiguration(); err == nil {
+				t.Fatal("expected an error here")
+			}
+		})
+	}
+}
+
 func TestConfigurerNewConfigurationProxyURL(t *testing.T) {
 	URL, _ := url.Parse("socks5://127.0.0.1:9050")
 	saver := new(trace.Saver)
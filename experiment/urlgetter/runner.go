@@ -9,11 +9,13 @@ import (
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
+	"strings"
 
 	"github.com/ooni/probe-engine/internal/httpheader"
 	"github.com/ooni/probe-engine/internal/runtimex"
 	"github.com/ooni/probe-engine/netx"
 	"github.com/ooni/probe-engine/netx/errorx"
+	"github.com/ooni/probe-engine/netx/resolver"
 )
 
 const httpRequestFailed = "http_request_failed"
@@ -105,9 +107,25 @@ func (r Runner) httpGet(ctx context.Context, url string) error {
 }
 
 func (r Runner) dnsLookup(ctx context.Context, hostname string) error {
-	resolver := netx.NewResolver(r.HTTPConfig)
-	_, err := resolver.LookupHost(ctx, hostname)
-	return err
+	reso := netx.NewResolver(r.HTTPConfig)
+	if r.Config.DNSQueryTypes == "" {
+		_, err := reso.LookupHost(ctx, hostname)
+		return err
+	}
+	// Implementation note: we perform all the lookups, so that we
+	// archive them all, and we return the first error.
+	var firstErr error
+	for _, name := range strings.Fields(r.Config.DNSQueryTypes) {
+		qtype, err := resolver.ParseQueryType(name)
+		if err != nil {
+			return fmt.Errorf("urlgetter: invalid DNSQueryTypes: %w", err)
+		}
+		_, err = resolver.LookupRecords(ctx, reso, hostname, qtype)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
 }
 
 func (r Runner) tlsHandshake(ctx context.Context, address string) error {
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -12,6 +13,8 @@ import (
 	"github.com/ooni/probe-engine/atomicx"
 	"github.com/ooni/probe-engine/experiment/urlgetter"
 	"github.com/ooni/probe-engine/internal/httpheader"
+	"github.com/ooni/probe-engine/netx"
+	"github.com/ooni/probe-engine/netx/resolver"
 )
 
 func TestRunnerWithInvalidURLScheme(t *testing.T) {
@@ -42,6 +45,30 @@ func TestRunnerDNSLookupWithContextCanceled(t *testing.T) {
 	}
 }
 
+func TestRunnerDNSLookupQueryTypesWithContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := urlgetter.Runner{
+		Config: urlgetter.Config{DNSQueryTypes: "A TXT"},
+		Target: "dnslookup://www.google.com",
+	}
+	err := r.Run(ctx)
+	if err == nil || err.Error() != "interrupted" {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestRunnerDNSLookupInvalidQueryType(t *testing.T) {
+	r := urlgetter.Runner{
+		Config: urlgetter.Config{DNSQueryTypes: "A ANTANI"},
+		Target: "dnslookup://www.google.com",
+	}
+	err := r.Run(context.Background())
+	if err == nil || !strings.HasSuffix(err.Error(), "unknown query type: ANTANI") {
+		t.Fatal("not the error we expected", err)
+	}
+}
+
 func TestRunnerTLSHandshakeWithContextCanceled(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel()
@@ -111,6 +138,24 @@ func TestRunnerDNSLookupSuccess(t *testing.T) {
 	}
 }
 
+func TestRunnerDNSLookupQueryTypesSuccess(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skip test in short mode")
+	}
+	r := urlgetter.Runner{
+		Config: urlgetter.Config{DNSQueryTypes: "HTTPS MX NS TXT"},
+		HTTPConfig: netx.Config{
+			BaseResolver: resolver.NewSerialResolver(
+				resolver.NewDNSOverUDP(new(net.Dialer), "8.8.8.8:53")),
+		},
+		Target: "dnslookup://cloudflare.com",
+	}
+	err := r.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestRunnerHTTPSSuccess(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skip test in short mode")
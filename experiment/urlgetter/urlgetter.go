@@ -14,7 +14,7 @@ import (
 
 const (
 	testName    = "urlgetter"
-	testVersion = "0.1.0"
+	testVersion = "0.3.0"
 )
 
 // Config contains the experiment's configuration.
@@ -23,8 +23,10 @@ type Config struct {
 	CertPool *x509.CertPool
 
 	// settable from command line
+	CAFile            string `ooni:"Also trust the CAs inside the specified PEM file"`
 	DNSCache          string `ooni:"Add 'DOMAIN IP...' to cache"`
 	DNSHTTPHost       string `ooni:"Force using specific HTTP Host header for DNS requests"`
+	DNSQueryTypes     string `ooni:"Space separated DNS query types to use with dnslookup:// (e.g. 'A AAAA HTTPS')"`
 	DNSTLSServerName  string `ooni:"Force TLS to using a specific SNI for encrypted DNS requests"`
 	FailOnHTTPError   bool   `ooni:"Fail HTTP request if status code is 400 or above"`
 	HTTP3Enabled      bool   `ooni:"use http3 instead of http/1.1 or http2"`
@@ -33,7 +35,8 @@ type Config struct {
 	NoFollowRedirects bool   `ooni:"Disable following redirects"`
 	NoTLSVerify       bool   `ooni:"Disable TLS verification"`
 	RejectDNSBogons   bool   `ooni:"Fail DNS lookup if response contains bogons"`
-	ResolverURL       string `ooni:"URL describing the resolver to use"`
+	ResolverURL       string `ooni:"URL describing the resolver to use (add ?parallel=true to send A and AAAA queries in parallel)"`
+	TLSParrot         string `ooni:"Mimic the ClientHello of a TLS client (one of 'chrome', 'firefox', 'ios', 'randomized')"`
 	TLSServerName     string `ooni:"Force TLS to using a specific SNI in Client Hello"`
 	TLSVersion        string `ooni:"Force specific TLS version (e.g. 'TLSv1.3')"`
 	Tunnel            string `ooni:"Run experiment over a tunnel, e.g. psiphon"`
@@ -13,7 +13,9 @@ import (
 	"testing"
 
 	"github.com/ooni/probe-engine/experiment/example"
+	"github.com/ooni/probe-engine/internal/kvstore"
 	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/probeservices"
 )
 
 func TestCreateAll(t *testing.T) {
@@ -469,6 +471,88 @@ func TestOpenReportFailure(t *testing.T) {
 	}
 }
 
+func TestResumeReportContextFromCheckpoint(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/api/_/check_report_id":
+				if r.URL.Query().Get("report_id") != "_id" {
+					w.Write([]byte(`{"found":false}`))
+					return
+				}
+				w.Write([]byte(`{"found":true}`))
+			case "/report/_id":
+				w.Write([]byte(`{"measurement_id":"e00c584e6e9e5326"}`))
+			default:
+				w.WriteHeader(404)
+			}
+		},
+	))
+	defer server.Close()
+	inputs := []model.URLInfo{{URL: "https://a.org/"}, {URL: "https://b.org/"}}
+	kvs := kvstore.NewMemoryKeyValueStore()
+	checkpoint := newInputCheckpointForTesting(kvs)
+	checkpoint.Load(inputs)
+	if err := checkpoint.Update(1, "_id"); err != nil {
+		t.Fatal(err)
+	}
+	_, state := newInputCheckpointForTesting(kvs).Load(inputs)
+	sess := newSessionForTestingNoLookups(t)
+	defer sess.Close()
+	builder, err := sess.NewExperimentBuilder("example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := builder.NewExperiment()
+	exp.session.selectedProbeService = &model.Service{
+		Address: server.URL,
+		Type:    "https",
+	}
+	if err := exp.ResumeReportContext(context.Background(), state.ReportID); err != nil {
+		t.Fatal(err)
+	}
+	if exp.ReportID() != "_id" {
+		t.Fatal("we did not reuse the checkpointed report ID")
+	}
+	m := exp.newMeasurement("https://b.org/")
+	if err := exp.SubmitAndUpdateMeasurementContext(context.Background(), m); err != nil {
+		t.Fatal(err)
+	}
+	if m.ReportID != "_id" {
+		t.Fatal("we did not submit into the resumed report")
+	}
+	if err := exp.ResumeReportContext(context.Background(), "_id"); err == nil {
+		t.Fatal("expected an error because the report is already open")
+	}
+}
+
+func TestResumeReportContextNotFound(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"found":false}`))
+		},
+	))
+	defer server.Close()
+	sess := newSessionForTestingNoLookups(t)
+	defer sess.Close()
+	builder, err := sess.NewExperimentBuilder("example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := builder.NewExperiment()
+	exp.session.selectedProbeService = &model.Service{
+		Address: server.URL,
+		Type:    "https",
+	}
+	err = exp.ResumeReportContext(context.Background(), "_id")
+	if !errors.Is(err, probeservices.ErrReportNotFound) {
+		t.Fatal("not the error we expected", err)
+	}
+	if exp.ReportID() != "" {
+		t.Fatal("expected no report ID here")
+	}
+}
+
 func TestOpenReportNewClientFailure(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skip test in short mode")
@@ -38,13 +38,41 @@ const (
 	InputNone = InputPolicy("none")
 )
 
+// InputShape describes the kind of input an experiment expects. The
+// InputLoader uses it to validate and normalize the inputs.
+type InputShape string
+
+const (
+	// InputShapeAny indicates that the experiment does not declare
+	// any specific input shape. We only remove duplicate inputs.
+	InputShapeAny = InputShape("")
+
+	// InputShapeURL indicates that the experiment wants URLs
+	// such as `https://www.example.com/`.
+	InputShapeURL = InputShape("url")
+
+	// InputShapeDomain indicates that the experiment wants domain
+	// names such as `www.example.com` or IP addresses.
+	InputShapeDomain = InputShape("domain")
+
+	// InputShapeEndpoint indicates that the experiment wants
+	// endpoints such as `www.example.com:443`.
+	InputShapeEndpoint = InputShape("endpoint")
+
+	// InputShapeDNSResolverURL indicates that the experiment wants
+	// URLs describing a DNS resolver such as `dot://1.1.1.1:853`.
+	InputShapeDNSResolverURL = InputShape("dns_resolver_url")
+)
+
 // ExperimentBuilder is an experiment builder.
 type ExperimentBuilder struct {
 	build         func(interface{}) *Experiment
 	callbacks     model.ExperimentCallbacks
 	config        interface{}
 	inputPolicy   InputPolicy
+	inputShape    InputShape
 	interruptible bool
+	sequential    bool
 }
 
 // Interruptible tells you whether this is an interruptible experiment. This kind
@@ -53,11 +81,23 @@ func (b *ExperimentBuilder) Interruptible() bool {
 	return b.interruptible
 }
 
+// Sequential tells you whether this experiment must measure its inputs
+// sequentially, because its measurer is not safe for concurrent use. When
+// this is true, you MUST NOT set InputProcessor.Parallelism.
+func (b *ExperimentBuilder) Sequential() bool {
+	return b.sequential
+}
+
 // InputPolicy returns the experiment input policy
 func (b *ExperimentBuilder) InputPolicy() InputPolicy {
 	return b.inputPolicy
 }
 
+// InputShape returns the kind of input the experiment expects.
+func (b *ExperimentBuilder) InputShape() InputShape {
+	return b.inputShape
+}
+
 // OptionInfo contains info about an option
 type OptionInfo struct {
 	Doc  string
@@ -26,9 +26,11 @@ require (
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/rogpeppe/go-internal v1.6.2
 	gitlab.com/yawning/obfs4.git v0.0.0-20200410113629-2d8f3c8bbfd7
-	golang.org/x/crypto v0.0.0-20201203163018-be400aefbc4c // indirect
+	gitlab.com/yawning/utls.git v0.0.11-1
+	golang.org/x/crypto v0.0.0-20201203163018-be400aefbc4c
 	golang.org/x/net v0.0.0-20201202161906-c7110b5ffcbb
 	golang.org/x/sys v0.0.0-20201204225414-ed752295db88 // indirect
 	golang.org/x/text v0.3.4 // indirect
 	google.golang.org/protobuf v1.25.0 // indirect
+	gopkg.in/yaml.v2 v2.4.0
 )
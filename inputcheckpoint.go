@@ -0,0 +1,204 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/ooni/probe-engine/model"
+)
+
+// InputCheckpointState is the state of a run saved by InputCheckpoint.
+type InputCheckpointState struct {
+	// InputsHash is the hash of the set of inputs of the run,
+	// which does not depend on the order of the inputs.
+	InputsHash string
+
+	// NextIdx is the index of the first input we did not process.
+	NextIdx int
+
+	// ReportID is the ID of the report used by the run.
+	ReportID string
+}
+
+// InputCheckpointConfig contains settings for NewInputCheckpoint.
+type InputCheckpointConfig struct {
+	// KVStore is the mandatory key-value store where to save
+	// the checkpoint. Typically, the session's KVStore.
+	KVStore model.KeyValueStore
+
+	// Logger is the mandatory logger.
+	Logger model.Logger
+
+	// RunID is the mandatory identifier of the run. Runs using
+	// the same RunID and the same inputs share the checkpoint.
+	RunID string
+}
+
+// InputCheckpoint saves the progress of InputProcessor into a
+// key-value store, such that a run that has been interrupted could
+// later be resumed from the first input it did not process.
+//
+// We also save the order in which the run processes the inputs, such
+// that we can resume runs whose order is not reproducible, e.g. when
+// the InputLoader shuffles the inputs without a seed or sorts them
+// by when we last measured them. We only write the order once, when
+// we update the checkpoint for the first time.
+type InputCheckpoint struct {
+	key        string
+	kvstore    model.KeyValueStore
+	logger     model.Logger
+	mu         sync.Mutex
+	order      []string
+	orderSaved bool
+	state      InputCheckpointState
+}
+
+// NewInputCheckpoint creates a new InputCheckpoint instance.
+func NewInputCheckpoint(config InputCheckpointConfig) *InputCheckpoint {
+	// The RunID is user provided. Hash it, such that it is safe
+	// to use it as part of a file name, which is what happens when
+	// you're using the FileSystemKVStore.
+	digest := sha256.Sum256([]byte(config.RunID))
+	return &InputCheckpoint{
+		key:     "inputcheckpoint." + hex.EncodeToString(digest[:]),
+		kvstore: config.KVStore,
+		logger:  config.Logger,
+	}
+}
+
+// Load loads the checkpoint for the given inputs. If there is no
+// checkpoint, or the checkpoint was saved for a different set of
+// inputs, we return the inputs as they are and a state that causes us
+// to start from scratch. Otherwise, we return the inputs in the order
+// used by the run we are resuming and the state of such run.
+func (ic *InputCheckpoint) Load(
+	inputs []model.URLInfo) ([]model.URLInfo, InputCheckpointState) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	hash := inputCheckpointHash(inputs)
+	ic.state = InputCheckpointState{InputsHash: hash}
+	ic.order, ic.orderSaved = inputCheckpointURLs(inputs), false
+	data, err := ic.kvstore.Get(ic.key)
+	if err != nil {
+		return inputs, ic.state
+	}
+	var state InputCheckpointState
+	if err := json.Unmarshal(data, &state); err != nil {
+		ic.logger.Warnf("inputcheckpoint: cannot parse checkpoint: %s", err.Error())
+		return inputs, ic.state
+	}
+	if state.InputsHash != hash {
+		ic.logger.Infof("inputcheckpoint: inputs changed; starting from scratch")
+		return inputs, ic.state
+	}
+	if state.NextIdx < 0 || state.NextIdx > len(inputs) {
+		return inputs, ic.state
+	}
+	ordered, err := ic.loadOrderLocked(inputs)
+	if err != nil {
+		ic.logger.Warnf("inputcheckpoint: cannot load inputs order: %s", err.Error())
+		return inputs, ic.state
+	}
+	ic.state = state
+	ic.order, ic.orderSaved = inputCheckpointURLs(ordered), true
+	return ordered, ic.state
+}
+
+// loadOrderLocked returns inputs in the order we previously saved.
+func (ic *InputCheckpoint) loadOrderLocked(
+	inputs []model.URLInfo) ([]model.URLInfo, error) {
+	data, err := ic.kvstore.Get(ic.orderKey())
+	if err != nil {
+		return nil, err
+	}
+	var order []string
+	if err := json.Unmarshal(data, &order); err != nil {
+		return nil, err
+	}
+	byURL := make(map[string][]model.URLInfo)
+	for _, input := range inputs {
+		byURL[input.URL] = append(byURL[input.URL], input)
+	}
+	var ordered []model.URLInfo
+	for _, URL := range order {
+		if len(byURL[URL]) <= 0 {
+			return nil, errors.New("inputcheckpoint: order does not match inputs")
+		}
+		ordered = append(ordered, byURL[URL][0])
+		byURL[URL] = byURL[URL][1:]
+	}
+	if len(ordered) != len(inputs) {
+		return nil, errors.New("inputcheckpoint: order does not match inputs")
+	}
+	return ordered, nil
+}
+
+// Update records that we processed all the inputs with index
+// lower than nextIdx, which have been submitted to reportID.
+func (ic *InputCheckpoint) Update(nextIdx int, reportID string) error {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	if !ic.orderSaved {
+		data, err := json.Marshal(ic.order)
+		if err != nil {
+			return err
+		}
+		if err := ic.kvstore.Set(ic.orderKey(), data); err != nil {
+			return err
+		}
+		ic.orderSaved = true
+	}
+	ic.state.NextIdx = nextIdx
+	if reportID != "" {
+		ic.state.ReportID = reportID
+	}
+	data, err := json.Marshal(ic.state)
+	if err != nil {
+		return err
+	}
+	return ic.kvstore.Set(ic.key, data)
+}
+
+// Clear clears the checkpoint, such that a subsequent run with
+// the same RunID starts from scratch.
+func (ic *InputCheckpoint) Clear() error {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	ic.state = InputCheckpointState{}
+	ic.orderSaved = false
+	data, err := json.Marshal(ic.state)
+	if err != nil {
+		return err
+	}
+	return ic.kvstore.Set(ic.key, data)
+}
+
+// orderKey returns the key where we save the order of the inputs.
+func (ic *InputCheckpoint) orderKey() string {
+	return ic.key + ".order"
+}
+
+// inputCheckpointHash computes the hash of a set of inputs.
+func inputCheckpointHash(inputs []model.URLInfo) string {
+	URLs := inputCheckpointURLs(inputs)
+	sort.Strings(URLs)
+	hash := sha256.New()
+	for _, URL := range URLs {
+		hash.Write([]byte(URL))
+		hash.Write([]byte("\n"))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// inputCheckpointURLs returns the URLs of inputs.
+func inputCheckpointURLs(inputs []model.URLInfo) []string {
+	URLs := make([]string, 0, len(inputs))
+	for _, input := range inputs {
+		URLs = append(URLs, input.URL)
+	}
+	return URLs
+}
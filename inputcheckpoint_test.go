@@ -0,0 +1,155 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/internal/kvstore"
+	"github.com/ooni/probe-engine/model"
+)
+
+func newInputCheckpointForTesting(kvs model.KeyValueStore) *InputCheckpoint {
+	return NewInputCheckpoint(InputCheckpointConfig{
+		KVStore: kvs,
+		Logger:  log.Log,
+		RunID:   "a/run id",
+	})
+}
+
+func TestInputCheckpointLifecycle(t *testing.T) {
+	kvs := kvstore.NewMemoryKeyValueStore()
+	inputs := []model.URLInfo{{URL: "https://a.org/"}, {URL: "https://b.org/"}}
+	ic := newInputCheckpointForTesting(kvs)
+	if _, state := ic.Load(inputs); state.NextIdx != 0 || state.ReportID != "" {
+		t.Fatal("expected empty state")
+	}
+	if err := ic.Update(1, "xx"); err != nil {
+		t.Fatal(err)
+	}
+	ic = newInputCheckpointForTesting(kvs)
+	_, state := ic.Load(inputs)
+	if state.NextIdx != 1 || state.ReportID != "xx" {
+		t.Fatal("state not restored", state)
+	}
+	if _, state := ic.Load(inputs[:1]); state.NextIdx != 0 {
+		t.Fatal("inputs changed but state restored")
+	}
+	ic = newInputCheckpointForTesting(kvs)
+	ic.Load(inputs)
+	if err := ic.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if _, state := ic.Load(inputs); state.NextIdx != 0 {
+		t.Fatal("state not cleared")
+	}
+}
+
+func TestInputCheckpointWithInputProcessor(t *testing.T) {
+	kvs := kvstore.NewMemoryKeyValueStore()
+	inputs := []model.URLInfo{
+		{URL: "https://a.org/"}, {URL: "https://b.org/"}, {URL: "https://c.org/"},
+	}
+	expected := errors.New("mocked error")
+	saver := &FakeInputProcessorSaver{Err: nil}
+	ip := InputProcessor{
+		Checkpoint: newInputCheckpointForTesting(kvs),
+		Experiment: NewInputProcessorExperimentWrapper(&FakeInputProcessorExperiment{}),
+		Inputs:     inputs,
+		Saver:      NewInputProcessorSaverWrapper(saver),
+		// make the second input fail to simulate an interrupted run
+		Submitter: inputProcessorSubmitterFunc(
+			func(ctx context.Context, idx int, m *model.Measurement) error {
+				if idx == 1 {
+					return expected
+				}
+				m.ReportID = "xx"
+				return nil
+			}),
+	}
+	if err := ip.Run(context.Background()); !errors.Is(err, expected) {
+		t.Fatalf("not the error we expected: %+v", err)
+	}
+	ip.Checkpoint = newInputCheckpointForTesting(kvs)
+	ip.Submitter = NewInputProcessorSubmitterWrapper(&FlakyInputProcessorSubmitter{})
+	results, err := ip.RunWithResults(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Idx != 1 || results[1].Idx != 2 {
+		t.Fatal("we did not resume from the right index", results)
+	}
+	if len(saver.M) != 3 {
+		t.Fatal("unexpected number of saved measurements")
+	}
+	if _, state := newInputCheckpointForTesting(kvs).Load(inputs); state.NextIdx != 0 {
+		t.Fatal("checkpoint not cleared at the end of the run")
+	}
+}
+
+type inputProcessorSubmitterFunc func(
+	ctx context.Context, idx int, m *model.Measurement) error
+
+func (f inputProcessorSubmitterFunc) SubmitAndUpdateMeasurementContext(
+	ctx context.Context, idx int, m *model.Measurement) error {
+	return f(ctx, idx, m)
+}
+
+func TestInputCheckpointResumesLeastRecentlyMeasuredRun(t *testing.T) {
+	kvs := kvstore.NewMemoryKeyValueStore()
+	history := NewInputHistory(InputHistoryConfig{KVStore: kvs, Logger: log.Log})
+	load := func() []model.URLInfo {
+		il := inputLoader{InputLoaderConfig: InputLoaderConfig{
+			History:  history,
+			Ordering: InputOrderingLeastRecentlyMeasured,
+		}}
+		inputs, err := il.arrange([]model.URLInfo{
+			{URL: "https://a.org/"}, {URL: "https://b.org/"}, {URL: "https://c.org/"},
+		}, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return inputs
+	}
+	expected := errors.New("mocked error")
+	experiment := &FakeInputProcessorExperiment{}
+	ip := InputProcessor{
+		Checkpoint: newInputCheckpointForTesting(kvs),
+		Experiment: NewInputProcessorExperimentWrapper(experiment),
+		History:    history,
+		Inputs:     load(),
+		Saver:      NewInputProcessorSaverWrapper(&FakeInputProcessorSaver{}),
+		// make the second input fail to simulate an interrupted run
+		Submitter: inputProcessorSubmitterFunc(
+			func(ctx context.Context, idx int, m *model.Measurement) error {
+				if idx == 1 {
+					return expected
+				}
+				return nil
+			}),
+	}
+	if err := ip.Run(context.Background()); !errors.Is(err, expected) {
+		t.Fatalf("not the error we expected: %+v", err)
+	}
+	// Now a.org has been measured, so it sorts last, while b.org, which
+	// we could not submit, has not been recorded into the history.
+	if inputs := load(); inputs[0].URL != "https://b.org/" || inputs[2].URL != "https://a.org/" {
+		t.Fatal("the ordering did not change", inputs)
+	}
+	ip.Checkpoint = newInputCheckpointForTesting(kvs)
+	ip.Inputs = load()
+	ip.Submitter = NewInputProcessorSubmitterWrapper(&FakeInputProcessorSubmitter{})
+	experiment.M = nil
+	results, err := ip.RunWithResults(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Input != "https://b.org/" ||
+		results[1].Input != "https://c.org/" {
+		t.Fatal("we did not resume the run", results)
+	}
+	if len(experiment.M) != 2 {
+		t.Fatal("unexpected number of measurements")
+	}
+}
@@ -0,0 +1,185 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ooni/probe-engine/model"
+)
+
+// defaultInputHistoryMaxEntries is the default InputHistoryConfig.MaxEntries.
+const defaultInputHistoryMaxEntries = 10000
+
+// InputHistoryConfig contains settings for NewInputHistory.
+type InputHistoryConfig struct {
+	// ExperimentName is the mandatory name of the experiment. We
+	// keep a separate history for each experiment.
+	ExperimentName string
+
+	// KVStore is the mandatory key-value store where to save
+	// the history. Typically, the session's KVStore.
+	KVStore model.KeyValueStore
+
+	// Logger is the mandatory logger.
+	Logger model.Logger
+
+	// MaxEntries is the maximum number of inputs we remember. When
+	// we exceed this number, we forget the inputs that we measured
+	// less recently. If zero, we use a default value.
+	MaxEntries int
+}
+
+// inputHistoryFlushInterval is the number of inputs that we record
+// before writing the history into the key-value store.
+const inputHistoryFlushInterval = 100
+
+// InputHistory remembers when we last measured each input. The
+// InputProcessor records the inputs it measures and the InputLoader
+// uses the history to measure the least recently measured inputs first.
+//
+// To avoid serializing the whole history for every input, Record only
+// writes the history every inputHistoryFlushInterval inputs. You MUST
+// call Flush when done to write the inputs recorded since then.
+type InputHistory struct {
+	history    map[string]time.Time
+	key        string
+	kvstore    model.KeyValueStore
+	logger     model.Logger
+	maxEntries int
+	mu         sync.Mutex
+	pending    int
+	timeNow    func() time.Time
+}
+
+// NewInputHistory creates a new InputHistory instance.
+func NewInputHistory(config InputHistoryConfig) *InputHistory {
+	if config.MaxEntries <= 0 {
+		config.MaxEntries = defaultInputHistoryMaxEntries
+	}
+	// Like we do for the InputCheckpoint's RunID, hash the name
+	// so that it is safe to use it as part of a file name.
+	digest := sha256.Sum256([]byte(config.ExperimentName))
+	return &InputHistory{
+		key:        "inputhistory." + hex.EncodeToString(digest[:]),
+		kvstore:    config.KVStore,
+		logger:     config.Logger,
+		maxEntries: config.MaxEntries,
+		timeNow:    time.Now,
+	}
+}
+
+// LastMeasured returns when we last measured each input. Inputs
+// that we never measured are not part of the returned map.
+func (ih *InputHistory) LastMeasured() (map[string]time.Time, error) {
+	ih.mu.Lock()
+	defer ih.mu.Unlock()
+	if err := ih.loadLocked(); err != nil {
+		return nil, err
+	}
+	out := make(map[string]time.Time)
+	for input, t := range ih.history {
+		out[input] = t
+	}
+	return out, nil
+}
+
+// Record records that we have just measured input.
+func (ih *InputHistory) Record(input string) error {
+	if input == "" {
+		return nil // the experiment does not take input
+	}
+	ih.mu.Lock()
+	defer ih.mu.Unlock()
+	if err := ih.loadLocked(); err != nil {
+		return err
+	}
+	ih.history[input] = ih.timeNow()
+	ih.pruneLocked()
+	ih.pending++
+	if ih.pending < inputHistoryFlushInterval {
+		return nil
+	}
+	return ih.flushLocked()
+}
+
+// Flush writes into the key-value store the inputs that we
+// have recorded since we last wrote the history.
+func (ih *InputHistory) Flush() error {
+	ih.mu.Lock()
+	defer ih.mu.Unlock()
+	if ih.pending <= 0 {
+		return nil
+	}
+	return ih.flushLocked()
+}
+
+func (ih *InputHistory) flushLocked() error {
+	data, err := json.Marshal(ih.history)
+	if err != nil {
+		return err
+	}
+	if err := ih.kvstore.Set(ih.key, data); err != nil {
+		return err
+	}
+	ih.pending = 0
+	return nil
+}
+
+// pruneLocked removes from the history the inputs that we measured
+// less recently until the history contains at most maxEntries inputs.
+// Because we prune after recording each input, we typically only need
+// to remove the oldest input, which we find without sorting.
+func (ih *InputHistory) pruneLocked() {
+	switch excess := len(ih.history) - ih.maxEntries; {
+	case excess <= 0:
+		return
+	case excess == 1:
+		var oldest string
+		for input, t := range ih.history {
+			if oldest == "" || t.Before(ih.history[oldest]) {
+				oldest = input
+			}
+		}
+		delete(ih.history, oldest)
+		return
+	}
+	inputs := make([]string, 0, len(ih.history))
+	for input := range ih.history {
+		inputs = append(inputs, input)
+	}
+	sort.Slice(inputs, func(i, j int) bool {
+		return ih.history[inputs[i]].Before(ih.history[inputs[j]])
+	})
+	for _, input := range inputs[:len(inputs)-ih.maxEntries] {
+		delete(ih.history, input)
+	}
+}
+
+// loadLocked loads the history, unless we have already loaded it. The
+// first time, when the key does not exist, the history is empty. We
+// return any other error, so that we never overwrite a history that
+// we could not read, e.g., because of a transient error.
+func (ih *InputHistory) loadLocked() error {
+	if ih.history != nil {
+		return nil
+	}
+	history := make(map[string]time.Time)
+	data, err := ih.kvstore.Get(ih.key)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &history); err != nil {
+			return fmt.Errorf("inputhistory: cannot parse history: %w", err)
+		}
+	}
+	ih.history = history
+	return nil
+}
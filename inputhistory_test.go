@@ -0,0 +1,206 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/internal/kvstore"
+	"github.com/ooni/probe-engine/model"
+)
+
+func lastMeasured(t *testing.T, history *InputHistory) map[string]time.Time {
+	last, err := history.LastMeasured()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return last
+}
+
+func TestInputHistoryRecord(t *testing.T) {
+	kvs := kvstore.NewMemoryKeyValueStore()
+	history := NewInputHistory(InputHistoryConfig{KVStore: kvs, Logger: log.Log})
+	if len(lastMeasured(t, history)) != 0 {
+		t.Fatal("expected empty history")
+	}
+	if err := history.Record("https://www.kernel.org/"); err != nil {
+		t.Fatal(err)
+	}
+	if err := history.Record(""); err != nil {
+		t.Fatal(err)
+	}
+	if err := history.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	// make sure we're reading from the KVStore
+	history = NewInputHistory(InputHistoryConfig{KVStore: kvs, Logger: log.Log})
+	last := lastMeasured(t, history)
+	if len(last) != 1 || last["https://www.kernel.org/"].IsZero() {
+		t.Fatal("unexpected history", last)
+	}
+}
+
+func TestInputHistoryInvalidState(t *testing.T) {
+	kvs := kvstore.NewMemoryKeyValueStore()
+	history := NewInputHistory(InputHistoryConfig{KVStore: kvs, Logger: log.Log})
+	if err := kvs.Set(history.key, []byte("{")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := history.LastMeasured(); err == nil {
+		t.Fatal("expected an error here")
+	}
+	if err := history.Record("https://www.kernel.org/"); err == nil {
+		t.Fatal("expected an error here")
+	}
+	if err := history.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := kvs.Get(history.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "{" {
+		t.Fatal("we overwrote the history we could not parse")
+	}
+}
+
+func TestInputHistoryReadError(t *testing.T) {
+	expected := errors.New("mocked error")
+	kvs := kvstore.NewMemoryKeyValueStore()
+	history := NewInputHistory(InputHistoryConfig{
+		KVStore: FakeSubmitQueueKVStore{KeyValueStore: kvs, GetErr: expected},
+		Logger:  log.Log,
+	})
+	if _, err := history.LastMeasured(); !errors.Is(err, expected) {
+		t.Fatal("not the error we expected", err)
+	}
+	if err := history.Record("https://www.kernel.org/"); !errors.Is(err, expected) {
+		t.Fatal("not the error we expected", err)
+	}
+	if _, err := kvs.Get(history.key); err == nil {
+		t.Fatal("we wrote the history although we could not read it")
+	}
+}
+
+func TestInputHistoryBatchesWrites(t *testing.T) {
+	kvs := kvstore.NewMemoryKeyValueStore()
+	history := NewInputHistory(InputHistoryConfig{KVStore: kvs, Logger: log.Log})
+	for idx := 0; idx < inputHistoryFlushInterval-1; idx++ {
+		if err := history.Record(fmt.Sprintf("https://%d.org/", idx)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := kvs.Get(history.key); err == nil {
+		t.Fatal("we wrote the history before the flush interval")
+	}
+	if err := history.Record("https://www.kernel.org/"); err != nil {
+		t.Fatal(err)
+	}
+	other := NewInputHistory(InputHistoryConfig{KVStore: kvs, Logger: log.Log})
+	if last := lastMeasured(t, other); len(last) != inputHistoryFlushInterval {
+		t.Fatal("we did not write the history", len(last))
+	}
+}
+
+func TestInputHistoryIsPerExperiment(t *testing.T) {
+	kvs := kvstore.NewMemoryKeyValueStore()
+	wc := NewInputHistory(InputHistoryConfig{
+		ExperimentName: "web_connectivity",
+		KVStore:        kvs,
+		Logger:         log.Log,
+	})
+	if err := wc.Record("https://www.kernel.org/"); err != nil {
+		t.Fatal(err)
+	}
+	if err := wc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	ug := NewInputHistory(InputHistoryConfig{
+		ExperimentName: "urlgetter",
+		KVStore:        kvs,
+		Logger:         log.Log,
+	})
+	if len(lastMeasured(t, ug)) != 0 {
+		t.Fatal("the history is shared by experiments")
+	}
+}
+
+func TestInputHistoryMaxEntries(t *testing.T) {
+	history := NewInputHistory(InputHistoryConfig{
+		KVStore:    kvstore.NewMemoryKeyValueStore(),
+		Logger:     log.Log,
+		MaxEntries: 2,
+	})
+	now := time.Now()
+	for idx, URL := range []string{"https://a.org/", "https://b.org/", "https://c.org/"} {
+		history.timeNow = func() time.Time {
+			return now.Add(time.Duration(idx) * time.Second)
+		}
+		if err := history.Record(URL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	last := lastMeasured(t, history)
+	if len(last) != 2 || !last["https://a.org/"].IsZero() {
+		t.Fatal("we did not forget the least recently measured input", last)
+	}
+}
+
+func TestInputProcessorRecordsHistory(t *testing.T) {
+	kvs := kvstore.NewMemoryKeyValueStore()
+	history := NewInputHistory(InputHistoryConfig{KVStore: kvs, Logger: log.Log})
+	ip := InputProcessor{
+		Experiment: NewInputProcessorExperimentWrapper(&FakeInputProcessorExperiment{}),
+		History:    history,
+		Inputs: []model.URLInfo{{
+			URL: "https://www.kernel.org/",
+		}, {
+			URL: "https://www.slashdot.org/",
+		}},
+		Saver:     NewInputProcessorSaverWrapper(&FakeInputProcessorSaver{}),
+		Submitter: NewInputProcessorSubmitterWrapper(&FakeInputProcessorSubmitter{}),
+	}
+	if err := ip.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// make sure we flushed the history when done
+	history = NewInputHistory(InputHistoryConfig{KVStore: kvs, Logger: log.Log})
+	if len(lastMeasured(t, history)) != 2 {
+		t.Fatal("we did not record all the inputs")
+	}
+}
+
+func TestInputProcessorDoesNotRecordFailedInputs(t *testing.T) {
+	history := NewInputHistory(InputHistoryConfig{
+		KVStore: kvstore.NewMemoryKeyValueStore(),
+		Logger:  log.Log,
+	})
+	ip := InputProcessor{
+		Experiment: NewInputProcessorExperimentWrapper(&FakeInputProcessorExperiment{}),
+		History:    history,
+		Inputs: []model.URLInfo{{
+			URL: "https://www.kernel.org/",
+		}, {
+			URL: "https://www.slashdot.org/",
+		}},
+		OnError: InputProcessorContinueOnError,
+		Saver:   NewInputProcessorSaverWrapper(&FakeInputProcessorSaver{}),
+		Submitter: inputProcessorSubmitterFunc(
+			func(ctx context.Context, idx int, m *model.Measurement) error {
+				if idx == 1 {
+					return errors.New("mocked error")
+				}
+				return nil
+			}),
+	}
+	if err := ip.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	last := lastMeasured(t, history)
+	if len(last) != 1 || last["https://www.kernel.org/"].IsZero() {
+		t.Fatal("we recorded an input that we could not submit", last)
+	}
+}
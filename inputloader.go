@@ -1,10 +1,12 @@
 package engine
 
 import (
-	"bufio"
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"os"
+	"time"
 
 	"github.com/ooni/probe-engine/internal/fsx"
 	"github.com/ooni/probe-engine/model"
@@ -44,12 +46,21 @@ type InputLoaderSession interface {
 //
 // We gather input from StaticInput and SourceFiles. If there is
 // input, we return it. Otherwise, we use OONI's probe services
-// to gather input using the test lists API.
+// to gather input using the test lists API. If KVStore is set, we
+// cache the response and use it when we cannot reach the API.
 //
 // InputStrictlyRequired
 //
 // Like InputOrQueryTestLists but, if there is no input, it's an
 // user error and we just abort running the experiment.
+//
+// Input shape
+//
+// Regardless of the policy, we validate and normalize the input
+// according to the InputShape, and we remove duplicate inputs. We
+// fail if any StaticInput or any entry of the SourceFiles is not
+// valid. We instead skip invalid inputs returned by the test lists
+// API, after we have tried to convert them to the InputShape.
 type InputLoader interface {
 	// Load attempts to load input using the specified input loader. We will
 	// return a list of URLs because this is the only input we support.
@@ -64,23 +75,71 @@ type InputLoaderConfig struct {
 
 	// SourceFiles contains optional files to read input
 	// from. Each file should contain a single input string
-	// per line. We will fail if any file is unreadable.
+	// per line, or be a citizenlab CSV test list, or contain
+	// a JSON array of model.URLInfo. The `-` file name means
+	// that we should read from the standard input. We will
+	// fail if any file is unreadable.
 	SourceFiles []string
 
+	// History is the optional history of the inputs we
+	// measured, which we need for ordering inputs using
+	// InputOrderingLeastRecentlyMeasured. If not set, all
+	// the inputs look like we have never measured them.
+	History *InputHistory
+
 	// InputPolicy specifies the input policy for the
 	// current experiment. We will not load any input if
 	// the policy says we should not.
 	InputPolicy InputPolicy
 
+	// InputShape is the kind of input expected by the current
+	// experiment. We use it to validate and normalize input.
+	InputShape InputShape
+
+	// KVStore is the optional key-value store where we cache
+	// the responses of the test lists API, per country and
+	// categories. When the probe services are not reachable, we
+	// use the cached response, regardless of its age. Typically,
+	// you want to use the session's KVStore here. If not set, we
+	// do not cache the test lists.
+	KVStore model.KeyValueStore
+
+	// Logger is the optional logger. If not set, we will
+	// not emit any log message.
+	Logger model.Logger
+
+	// MaxPerCategory is the optional maximum number of inputs
+	// for each category code. When set, we keep the first inputs
+	// of each category, after having sorted them according to
+	// the Ordering, thus performing a stratified sampling.
+	MaxPerCategory int
+
+	// Ordering is the optional order in which we return the inputs.
+	Ordering InputOrdering
+
+	// Seed is the seed for InputOrderingShuffle. If zero, we
+	// use a time-dependent seed, which we log.
+	Seed int64
+
 	// Session is the current measurement session.
 	Session InputLoaderSession
 
+	// TestListsMaxAge is the optional maximum age of the
+	// cached test lists response for using it instead of
+	// querying the probe services. If zero, we always query
+	// the probe services and only use the cache as a fallback.
+	TestListsMaxAge time.Duration
+
 	// URLLimit is the optional limit on the number of URLs
-	// that probe services should return to us.
+	// that probe services should return to us. When we use a
+	// non default Ordering or MaxPerCategory, we fetch all the
+	// URLs and apply the limit after ordering and sampling.
 	URLLimit int64
 
 	// URLCategories limits the categories of URLs that
-	// probe services should return to us.
+	// probe services should return to us. We also use it
+	// to filter the inputs read from SourceFiles that have
+	// a category code, e.g., citizenlab CSV test lists.
 	URLCategories []string
 }
 
@@ -91,11 +150,12 @@ func NewInputLoader(config InputLoaderConfig) InputLoader {
 	// we weren't using interfaces. Because now we're using interfaces,
 	// there is the opportunity to select behaviour here depending
 	// on the specified policy rather than later inside Load.
-	return inputLoader{InputLoaderConfig: config}
+	return inputLoader{InputLoaderConfig: config, stdin: os.Stdin}
 }
 
 type inputLoader struct {
 	InputLoaderConfig
+	stdin io.Reader
 }
 
 var _ InputLoader = inputLoader{}
@@ -103,6 +163,9 @@ var _ InputLoader = inputLoader{}
 // Load attempts to load input using the specified input loader. We will
 // return a list of URLs because this is the only input we support.
 func (il inputLoader) Load(ctx context.Context) ([]model.URLInfo, error) {
+	if err := il.checkOrdering(); err != nil {
+		return nil, err
+	}
 	switch il.InputPolicy {
 	case InputOptional:
 		return il.loadOptional()
@@ -147,9 +210,12 @@ func (il inputLoader) loadOrQueryTestList(ctx context.Context) ([]model.URLInfo,
 }
 
 func (il inputLoader) loadLocal() ([]model.URLInfo, error) {
-	inputs := []model.URLInfo{}
-	for _, input := range il.StaticInputs {
-		inputs = append(inputs, model.URLInfo{URL: input})
+	inputs := []inputEntry{}
+	for idx, input := range il.StaticInputs {
+		inputs = append(inputs, inputEntry{
+			URLInfo: model.URLInfo{URL: input},
+			origin:  fmt.Sprintf("static input #%d", idx),
+		})
 	}
 	for _, filepath := range il.SourceFiles {
 		extra, err := il.readfile(filepath, fsx.Open)
@@ -160,32 +226,70 @@ func (il inputLoader) loadLocal() ([]model.URLInfo, error) {
 		if len(extra) <= 0 {
 			return nil, fmt.Errorf("%w: %s", ErrDetectedEmptyFile, filepath)
 		}
-		inputs = append(inputs, extra...)
+		inputs = append(inputs, il.filter(extra)...)
 	}
-	return inputs, nil
+	out := []model.URLInfo{}
+	dedup := make(map[string]int)
+	for _, input := range inputs {
+		URL, err := normalizeInput(il.InputShape, input.URL)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %s", ErrInvalidInput, input.origin, err.Error())
+		}
+		input.URL = URL
+		out = il.appendUnique(out, dedup, input.URLInfo)
+	}
+	return il.arrange(out, 0)
+}
+
+// appendUnique appends input to inputs unless we have already seen
+// its URL, in which case we use input to fill the metadata that the
+// input we have already seen is missing. The dedup map tracks the
+// index of each URL inside inputs.
+func (il inputLoader) appendUnique(
+	inputs []model.URLInfo, dedup map[string]int, input model.URLInfo) []model.URLInfo {
+	idx, found := dedup[input.URL]
+	if !found {
+		dedup[input.URL] = len(inputs)
+		return append(inputs, input)
+	}
+	if inputs[idx].CategoryCode == "" {
+		inputs[idx].CategoryCode = input.CategoryCode
+	}
+	if inputs[idx].CountryCode == "" {
+		inputs[idx].CountryCode = input.CountryCode
+	}
+	return inputs
 }
 
-func (il inputLoader) readfile(filepath string, open func(string) (fsx.File, error)) ([]model.URLInfo, error) {
-	inputs := []model.URLInfo{}
+func (il inputLoader) readfile(filepath string, open func(string) (fsx.File, error)) ([]inputEntry, error) {
+	if filepath == "-" {
+		return parseInputs(filepath, il.stdin)
+	}
 	filep, err := open(filepath)
 	if err != nil {
 		return nil, err
 	}
 	defer filep.Close()
-	// Implementation note: when you save file with vim, you have newline at
-	// end of file and you don't want to consider that an input line. While there
-	// ignore any other empty line that may occur inside the file.
-	scanner := bufio.NewScanner(filep)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line != "" {
-			inputs = append(inputs, model.URLInfo{URL: line})
-		}
+	return parseInputs(filepath, filep)
+}
+
+// filter removes the inputs whose category code is not included
+// into URLCategories. We don't filter inputs without category code.
+func (il inputLoader) filter(inputs []inputEntry) []inputEntry {
+	if len(il.URLCategories) <= 0 {
+		return inputs
 	}
-	if scanner.Err() != nil {
-		return nil, scanner.Err()
+	categories := make(map[string]bool)
+	for _, category := range il.URLCategories {
+		categories[category] = true
 	}
-	return inputs, nil
+	out := []inputEntry{}
+	for _, input := range inputs {
+		if input.CategoryCode == "" || categories[input.CategoryCode] {
+			out = append(out, input)
+		}
+	}
+	return out
 }
 
 type loadRemoteConfig struct {
@@ -194,16 +298,75 @@ type loadRemoteConfig struct {
 }
 
 func (il inputLoader) loadRemote(conf loadRemoteConfig) ([]model.URLInfo, error) {
-	if err := conf.session.MaybeLookupLocationContext(conf.ctx); err != nil {
+	cache := testListsCache{kvstore: il.KVStore}
+	lookupErr := conf.session.MaybeLookupLocationContext(conf.ctx)
+	cc := conf.session.ProbeCC()
+	if lookupErr != nil {
+		if cc = cache.lastCountryCode(); cc == "" {
+			return nil, lookupErr
+		}
+	}
+	config := model.URLListConfig{
+		CountryCode: cc,
+		Limit:       il.URLLimit,
+		Categories:  il.URLCategories,
+	}
+	if il.sampling() {
+		config.Limit = 0 // we need all the URLs to sample them
+	}
+	entry, found := cache.get(config)
+	if found && il.TestListsMaxAge > 0 && time.Since(entry.Fetched) < il.TestListsMaxAge {
+		il.logger().Infof("inputloader: using test lists cached at %s", entry.Fetched)
+		return il.normalizeRemote(entry.URLs)
+	}
+	err := lookupErr
+	if err == nil {
+		var inputs []model.URLInfo
+		if inputs, err = il.fetchURLList(conf, config); err == nil {
+			if err := cache.set(config, inputs); err != nil {
+				il.logger().Warnf("inputloader: cannot cache test lists: %s", err.Error())
+			}
+			return il.normalizeRemote(inputs)
+		}
+	}
+	if !found {
 		return nil, err
 	}
+	il.logger().Warnf("inputloader: cannot fetch test lists: %s", err.Error())
+	il.logger().Warnf("inputloader: using test lists cached at %s", entry.Fetched)
+	return il.normalizeRemote(entry.URLs)
+}
+
+func (il inputLoader) fetchURLList(
+	conf loadRemoteConfig, config model.URLListConfig) ([]model.URLInfo, error) {
 	client, err := conf.session.NewOrchestraClient(conf.ctx)
 	if err != nil {
 		return nil, err
 	}
-	return client.FetchURLList(conf.ctx, model.URLListConfig{
-		CountryCode: conf.session.ProbeCC(),
-		Limit:       il.URLLimit,
-		Categories:  il.URLCategories,
-	})
+	return client.FetchURLList(conf.ctx, config)
+}
+
+// normalizeRemote converts the inputs returned by the test lists API
+// to the InputShape, skipping the invalid ones and the duplicates, and
+// then arranges them according to the Ordering and sampling settings.
+func (il inputLoader) normalizeRemote(inputs []model.URLInfo) ([]model.URLInfo, error) {
+	out := []model.URLInfo{}
+	dedup := make(map[string]int)
+	for _, input := range inputs {
+		URL, err := normalizeInput(il.InputShape, remoteInputToShape(il.InputShape, input.URL))
+		if err != nil {
+			il.logger().Warnf("inputloader: skipping %s: %s", input.URL, err.Error())
+			continue
+		}
+		input.URL = URL
+		out = il.appendUnique(out, dedup, input)
+	}
+	return il.arrange(out, il.URLLimit)
+}
+
+func (il inputLoader) logger() model.Logger {
+	if il.Logger != nil {
+		return il.Logger
+	}
+	return model.DiscardLogger
 }
@@ -5,10 +5,15 @@ import (
 	"errors"
 	"io"
 	"os"
+	"regexp"
+	"strings"
 	"syscall"
 	"testing"
+	"time"
 
+	"github.com/google/go-cmp/cmp"
 	"github.com/ooni/probe-engine/internal/fsx"
+	"github.com/ooni/probe-engine/internal/kvstore"
 	"github.com/ooni/probe-engine/model"
 )
 
@@ -48,8 +53,8 @@ type InputLoaderBrokenSession struct {
 	Error           error
 }
 
-func (InputLoaderBrokenSession) MaybeLookupLocationContext(ctx context.Context) error {
-	return nil
+func (ilbs InputLoaderBrokenSession) MaybeLookupLocationContext(ctx context.Context) error {
+	return ilbs.Error
 }
 
 func (ilbs InputLoaderBrokenSession) NewOrchestraClient(ctx context.Context) (model.ExperimentOrchestraClient, error) {
@@ -108,3 +113,473 @@ func TestInputLoaderFetchURLListFailure(t *testing.T) {
 		t.Fatal("expected nil output here")
 	}
 }
+
+func TestInputLoaderCitizenlabCSV(t *testing.T) {
+	il := inputLoader{InputLoaderConfig: InputLoaderConfig{
+		SourceFiles:   []string{"testdata/it.csv"},
+		URLCategories: []string{"NEWS", "FILE"},
+	}}
+	out, err := il.loadLocal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []model.URLInfo{{
+		CategoryCode: "NEWS",
+		CountryCode:  "IT",
+		URL:          "http://www.ilgiornale.it/",
+	}, {
+		CategoryCode: "FILE",
+		CountryCode:  "IT",
+		URL:          "http://www.tntvillage.scambioetico.org/",
+	}}
+	if diff := cmp.Diff(expected, out); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestInputLoaderJSON(t *testing.T) {
+	il := inputLoader{InputLoaderConfig: InputLoaderConfig{
+		SourceFiles: []string{"testdata/inputloader.json"},
+	}}
+	out, err := il.loadLocal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 2 || out[1].CategoryCode != "ANON" || out[1].CountryCode != "XX" {
+		t.Fatal("not the output we expected", out)
+	}
+}
+
+func TestInputLoaderInvalidJSON(t *testing.T) {
+	out, err := parseInputs("x.json", strings.NewReader(`[{"url": 17}]`))
+	if !errors.Is(err, ErrInvalidInputFile) {
+		t.Fatalf("not the error we expected: %+v", err)
+	}
+	if out != nil {
+		t.Fatal("not the output we expected")
+	}
+}
+
+func TestInputLoaderEmptyJSON(t *testing.T) {
+	out, err := parseInputs("x.json", strings.NewReader(" [ ]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 0 {
+		t.Fatal("not the output we expected", out)
+	}
+}
+
+func TestInputLoaderIPv6EndpointsText(t *testing.T) {
+	data := "[2001:db8::1]:853\n[2001:db8::2]:853\n"
+	out, err := parseInputs("endpoints.txt", strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 2 || out[0].URL != "[2001:db8::1]:853" || out[1].URL != "[2001:db8::2]:853" {
+		t.Fatal("not the output we expected", out)
+	}
+}
+
+func TestInputLoaderCSVMissingColumns(t *testing.T) {
+	data := "url,category_code\nhttps://x.org/\n"
+	out, err := parseInputs("global.csv", strings.NewReader(data))
+	if !errors.Is(err, ErrInvalidInputFile) {
+		t.Fatalf("not the error we expected: %+v", err)
+	}
+	if out != nil {
+		t.Fatal("not the output we expected")
+	}
+}
+
+func TestInputLoaderStdin(t *testing.T) {
+	il := inputLoader{
+		InputLoaderConfig: InputLoaderConfig{
+			SourceFiles: []string{"-"},
+		},
+		stdin: strings.NewReader("url,category_code\nhttps://x.org/,NEWS\n"),
+	}
+	out, err := il.loadLocal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 1 || out[0].URL != "https://x.org/" || out[0].CategoryCode != "NEWS" {
+		t.Fatal("not the output we expected", out)
+	}
+	if out[0].CountryCode != "" {
+		t.Fatal("unexpected country code")
+	}
+}
+
+func TestCitizenlabCountryCode(t *testing.T) {
+	for input, expected := range map[string]string{
+		"lists/it.csv":     "IT",
+		"lists/global.csv": "XX",
+		"antani.csv":       "",
+	} {
+		if out := citizenlabCountryCode(input); out != expected {
+			t.Fatal("unexpected country code", input, out)
+		}
+	}
+}
+
+func TestNormalizeInput(t *testing.T) {
+	type testcase struct {
+		shape    InputShape
+		input    string
+		expected string
+		fails    bool
+	}
+	for _, tc := range []testcase{
+		{shape: InputShapeAny, input: " antani ", expected: "antani"},
+		{shape: InputShapeURL, input: "HTTP://Example.COM", expected: "http://example.com/"},
+		{shape: InputShapeURL, input: "https://Example.com:8443/a?b", expected: "https://example.com:8443/a?b"},
+		{shape: InputShapeURL, input: "https://яндекс.рф/", expected: "https://xn--d1acpjx3f.xn--p1ai/"},
+		{shape: InputShapeURL, input: "https://[::1]/", expected: "https://[::1]/"},
+		{shape: InputShapeURL, input: "dnslookup://example.com", expected: "dnslookup://example.com"},
+		{shape: InputShapeURL, input: "www.example.com", fails: true},
+		{shape: InputShapeURL, input: "https:///x", fails: true},
+		{shape: InputShapeURL, input: "https://exa mple.com/", fails: true},
+		{shape: InputShapeDomain, input: "WWW.Example.com.", expected: "www.example.com"},
+		{shape: InputShapeDomain, input: "8.8.8.8", expected: "8.8.8.8"},
+		{shape: InputShapeDomain, input: "https://example.com/", fails: true},
+		{shape: InputShapeDomain, input: "", fails: true},
+		{shape: InputShapeEndpoint, input: "Example.com:443", expected: "example.com:443"},
+		{shape: InputShapeEndpoint, input: "[::1]:53", expected: "[::1]:53"},
+		{shape: InputShapeEndpoint, input: "example.com", fails: true},
+		{shape: InputShapeEndpoint, input: "example.com:0", fails: true},
+		{shape: InputShapeDNSResolverURL, input: "DoT://1.1.1.1:853", expected: "dot://1.1.1.1:853"},
+		{shape: InputShapeDNSResolverURL, input: "https://dns.Google/dns-query", expected: "https://dns.google/dns-query"},
+		{shape: InputShapeDNSResolverURL, input: "DoQ://dns.AdGuard.com", expected: "doq://dns.adguard.com"},
+		{shape: InputShapeDNSResolverURL, input: "h3://dns.Google/dns-query", expected: "h3://dns.google/dns-query"},
+		{shape: InputShapeDNSResolverURL, input: "system:///", fails: true},
+		{shape: InputShapeDNSResolverURL, input: "ftp://1.1.1.1", fails: true},
+	} {
+		out, err := normalizeInput(tc.shape, tc.input)
+		if tc.fails != (err != nil) {
+			t.Fatalf("%s %q: unexpected error: %+v", tc.shape, tc.input, err)
+		}
+		if out != tc.expected {
+			t.Fatalf("%s %q: expected %q, got %q", tc.shape, tc.input, tc.expected, out)
+		}
+	}
+}
+
+func TestInputLoaderNormalizeAndDedup(t *testing.T) {
+	il := inputLoader{
+		InputLoaderConfig: InputLoaderConfig{
+			InputShape:   InputShapeURL,
+			StaticInputs: []string{"https://X.org", "https://www.kernel.org/"},
+			SourceFiles:  []string{"-"},
+		},
+		stdin: strings.NewReader("url,category_code\nhttps://x.org/,NEWS\nHTTPS://www.kernel.org/,COMT\n"),
+	}
+	out, err := il.loadLocal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []model.URLInfo{{
+		CategoryCode: "NEWS",
+		URL:          "https://x.org/",
+	}, {
+		CategoryCode: "COMT",
+		URL:          "https://www.kernel.org/",
+	}}
+	if diff := cmp.Diff(expected, out); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestInputLoaderInvalidInputLine(t *testing.T) {
+	il := inputLoader{
+		InputLoaderConfig: InputLoaderConfig{
+			InputShape:  InputShapeURL,
+			SourceFiles: []string{"-"},
+		},
+		stdin: strings.NewReader("https://x.org/\n\nwww.example.com\n"),
+	}
+	out, err := il.loadLocal()
+	if !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("not the error we expected: %+v", err)
+	}
+	if !strings.Contains(err.Error(), "-:3: missing URL scheme") {
+		t.Fatal("the error does not mention the line", err)
+	}
+	if out != nil {
+		t.Fatal("not the output we expected")
+	}
+}
+
+type InputLoaderFakeOrchestraClient struct {
+	InputLoaderBrokenOrchestraClient
+	URLs []model.URLInfo
+}
+
+func (c InputLoaderFakeOrchestraClient) FetchURLList(ctx context.Context, config model.URLListConfig) ([]model.URLInfo, error) {
+	return c.URLs, nil
+}
+
+func TestInputLoaderRemoteInputShape(t *testing.T) {
+	il := inputLoader{InputLoaderConfig: InputLoaderConfig{
+		InputShape: InputShapeEndpoint,
+	}}
+	lrc := loadRemoteConfig{
+		ctx: context.Background(),
+		session: InputLoaderBrokenSession{
+			OrchestraClient: InputLoaderFakeOrchestraClient{URLs: []model.URLInfo{
+				{CategoryCode: "NEWS", URL: "https://www.example.com/"},
+				{CategoryCode: "NEWS", URL: "https://WWW.example.com/robots.txt"},
+				{CategoryCode: "ANON", URL: "http://www.torproject.org:8080/"},
+				{CategoryCode: "ANON", URL: "ftp://www.example.org/"},
+			}},
+		},
+	}
+	out, err := il.loadRemote(lrc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []model.URLInfo{
+		{CategoryCode: "NEWS", URL: "www.example.com:443"},
+		{CategoryCode: "ANON", URL: "www.torproject.org:8080"},
+	}
+	if diff := cmp.Diff(expected, out); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestInputLoaderTestListsCacheFallback(t *testing.T) {
+	kvs := kvstore.NewMemoryKeyValueStore()
+	il := inputLoader{InputLoaderConfig: InputLoaderConfig{
+		KVStore:       kvs,
+		URLCategories: []string{"NEWS", "ANON"},
+	}}
+	urls := []model.URLInfo{{CategoryCode: "NEWS", URL: "https://www.example.com/"}}
+	out, err := il.loadRemote(loadRemoteConfig{
+		ctx: context.Background(),
+		session: InputLoaderBrokenSession{
+			OrchestraClient: InputLoaderFakeOrchestraClient{URLs: urls},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(urls, out); diff != "" {
+		t.Fatal(diff)
+	}
+	key := testListsCacheKey(model.URLListConfig{
+		CountryCode: "IT",
+		Categories:  []string{"ANON", "NEWS"},
+	})
+	if key != testListsCacheKey(model.URLListConfig{
+		CountryCode: "IT",
+		Categories:  []string{"NEWS", "ANON"},
+	}) {
+		t.Fatal("the cache key depends on the categories order")
+	}
+	if _, err := kvs.Get(key); err != nil {
+		t.Fatal(err)
+	}
+	// the API fails, so we use the cache
+	out, err = il.loadRemote(loadRemoteConfig{
+		ctx: context.Background(),
+		session: InputLoaderBrokenSession{
+			OrchestraClient: InputLoaderBrokenOrchestraClient{},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(urls, out); diff != "" {
+		t.Fatal(diff)
+	}
+	// we cannot geolocate, so we use the last country code
+	out, err = il.loadRemote(loadRemoteConfig{
+		ctx:     context.Background(),
+		session: InputLoaderBrokenSession{Error: io.EOF},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(urls, out); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestInputLoaderTestListsCacheNoEntry(t *testing.T) {
+	il := inputLoader{InputLoaderConfig: InputLoaderConfig{
+		KVStore: kvstore.NewMemoryKeyValueStore(),
+	}}
+	out, err := il.loadRemote(loadRemoteConfig{
+		ctx:     context.Background(),
+		session: InputLoaderBrokenSession{Error: io.EOF},
+	})
+	if !errors.Is(err, io.EOF) {
+		t.Fatalf("not the error we expected: %+v", err)
+	}
+	if out != nil {
+		t.Fatal("expected nil output here")
+	}
+}
+
+func TestInputLoaderTestListsMaxAge(t *testing.T) {
+	kvs := kvstore.NewMemoryKeyValueStore()
+	cached := []model.URLInfo{{CategoryCode: "NEWS", URL: "https://www.example.com/"}}
+	fresh := []model.URLInfo{{CategoryCode: "NEWS", URL: "https://www.example.org/"}}
+	config := model.URLListConfig{CountryCode: "IT"}
+	if err := (testListsCache{kvstore: kvs}).set(config, cached); err != nil {
+		t.Fatal(err)
+	}
+	lrc := loadRemoteConfig{
+		ctx: context.Background(),
+		session: InputLoaderBrokenSession{
+			OrchestraClient: InputLoaderFakeOrchestraClient{URLs: fresh},
+		},
+	}
+	il := inputLoader{InputLoaderConfig: InputLoaderConfig{
+		KVStore:         kvs,
+		TestListsMaxAge: time.Hour,
+	}}
+	out, err := il.loadRemote(lrc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(cached, out); diff != "" {
+		t.Fatal(diff)
+	}
+	il.TestListsMaxAge = time.Nanosecond
+	out, err = il.loadRemote(lrc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(fresh, out); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestInputLoaderArrange(t *testing.T) {
+	inputs := func() []model.URLInfo {
+		return []model.URLInfo{
+			{CategoryCode: "NEWS", URL: "https://a.org/"},
+			{CategoryCode: "NEWS", URL: "https://b.org/"},
+			{CategoryCode: "ANON", URL: "https://c.org/"},
+			{CategoryCode: "NEWS", URL: "https://d.org/"},
+			{CategoryCode: "ANON", URL: "https://e.org/"},
+		}
+	}
+	urls := func(inputs []model.URLInfo, err error) (out []string) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, input := range inputs {
+			out = append(out, input.URL)
+		}
+		return
+	}
+	t.Run("shuffle is reproducible", func(t *testing.T) {
+		il := inputLoader{InputLoaderConfig: InputLoaderConfig{
+			Ordering: InputOrderingShuffle,
+			Seed:     4,
+		}}
+		first := urls(il.arrange(inputs(), 0))
+		if diff := cmp.Diff(first, urls(il.arrange(inputs(), 0))); diff != "" {
+			t.Fatal(diff)
+		}
+		if diff := cmp.Diff(first, urls(inputs(), nil)); diff == "" {
+			t.Fatal("did not shuffle")
+		}
+	})
+	t.Run("max per category and limit", func(t *testing.T) {
+		il := inputLoader{InputLoaderConfig: InputLoaderConfig{
+			MaxPerCategory: 1,
+		}}
+		expected := []string{"https://a.org/", "https://c.org/"}
+		if diff := cmp.Diff(expected, urls(il.arrange(inputs(), 0))); diff != "" {
+			t.Fatal(diff)
+		}
+		if diff := cmp.Diff(expected[:1], urls(il.arrange(inputs(), 1))); diff != "" {
+			t.Fatal(diff)
+		}
+	})
+	t.Run("least recently measured", func(t *testing.T) {
+		history := NewInputHistory(InputHistoryConfig{
+			KVStore: kvstore.NewMemoryKeyValueStore(),
+			Logger:  model.DiscardLogger,
+		})
+		now := time.Now()
+		for idx, URL := range []string{"https://b.org/", "https://a.org/", "https://e.org/"} {
+			history.timeNow = func() time.Time {
+				return now.Add(time.Duration(idx) * time.Second)
+			}
+			if err := history.Record(URL); err != nil {
+				t.Fatal(err)
+			}
+		}
+		il := inputLoader{InputLoaderConfig: InputLoaderConfig{
+			History:  history,
+			Ordering: InputOrderingLeastRecentlyMeasured,
+		}}
+		expected := []string{
+			"https://c.org/", "https://d.org/", "https://b.org/",
+			"https://a.org/", "https://e.org/",
+		}
+		if diff := cmp.Diff(expected, urls(il.arrange(inputs(), 0))); diff != "" {
+			t.Fatal(diff)
+		}
+	})
+}
+
+func TestInputLoaderInvalidOrdering(t *testing.T) {
+	il := NewInputLoader(InputLoaderConfig{Ordering: "antani"})
+	out, err := il.Load(context.Background())
+	if !errors.Is(err, ErrInvalidInputOrdering) {
+		t.Fatalf("not the error we expected: %+v", err)
+	}
+	if out != nil {
+		t.Fatal("expected nil output here")
+	}
+}
+
+func TestInputLoaderSamplingFetchesAllURLs(t *testing.T) {
+	client := &InputLoaderRecordingOrchestraClient{}
+	il := inputLoader{InputLoaderConfig: InputLoaderConfig{
+		MaxPerCategory: 1,
+		URLLimit:       17,
+	}}
+	_, err := il.loadRemote(loadRemoteConfig{
+		ctx:     context.Background(),
+		session: InputLoaderBrokenSession{OrchestraClient: client},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Config.Limit != 0 {
+		t.Fatal("we did not fetch all the URLs")
+	}
+}
+
+type InputLoaderRecordingOrchestraClient struct {
+	InputLoaderBrokenOrchestraClient
+	Config model.URLListConfig
+}
+
+func (c *InputLoaderRecordingOrchestraClient) FetchURLList(ctx context.Context, config model.URLListConfig) ([]model.URLInfo, error) {
+	c.Config = config
+	return nil, nil
+}
+
+func TestTestListsCacheKeyIsAFileName(t *testing.T) {
+	key := testListsCacheKey(model.URLListConfig{
+		CountryCode: "../IT",
+		Categories:  []string{"NEWS/../..", "AN ON"},
+	})
+	if !regexp.MustCompile("^testlists\\.[0-9a-f]{64}$").MatchString(key) {
+		t.Fatal("unexpected cache key", key)
+	}
+	other := testListsCacheKey(model.URLListConfig{
+		CountryCode: "../IT",
+		Categories:  []string{"NEWS/../..,AN ON"},
+	})
+	if key == other {
+		t.Fatal("distinct queries should have distinct keys")
+	}
+}
@@ -0,0 +1,86 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/ooni/probe-engine/model"
+)
+
+// testListsCacheLastCCKey is the key where we store the country code
+// of the last successful test lists query. We use it when we cannot
+// discover the probe's country because we are offline.
+const testListsCacheLastCCKey = "testlists.lastcc"
+
+// testListsCacheEntry is a test lists API response saved into the
+// key-value store along with the time when we fetched it.
+type testListsCacheEntry struct {
+	Fetched time.Time
+	URLs    []model.URLInfo
+}
+
+// testListsCache caches the responses of the test lists API.
+type testListsCache struct {
+	kvstore model.KeyValueStore
+}
+
+// testListsCacheKey returns the key used for caching the response
+// of a test lists query. The key does not depend on the order in
+// which we specify the categories. Since the country code and the
+// categories come from the user and the key may be used as a file
+// name (e.g. by the FileSystemKVStore), we hash them.
+func testListsCacheKey(config model.URLListConfig) string {
+	categories := append([]string{}, config.Categories...)
+	sort.Strings(categories)
+	data, _ := json.Marshal([]interface{}{config.CountryCode, categories, config.Limit})
+	digest := sha256.Sum256(data)
+	return "testlists." + hex.EncodeToString(digest[:])
+}
+
+// get returns the cached response for config, if any.
+func (c testListsCache) get(config model.URLListConfig) (*testListsCacheEntry, bool) {
+	if c.kvstore == nil {
+		return nil, false
+	}
+	data, err := c.kvstore.Get(testListsCacheKey(config))
+	if err != nil {
+		return nil, false
+	}
+	var entry testListsCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, false
+	}
+	return &entry, true
+}
+
+// set caches the response for config. We also remember the
+// country code, which we need when we cannot geolocate.
+func (c testListsCache) set(config model.URLListConfig, urls []model.URLInfo) error {
+	if c.kvstore == nil {
+		return nil
+	}
+	data, err := json.Marshal(testListsCacheEntry{Fetched: time.Now(), URLs: urls})
+	if err != nil {
+		return err
+	}
+	if err := c.kvstore.Set(testListsCacheKey(config), data); err != nil {
+		return err
+	}
+	return c.kvstore.Set(testListsCacheLastCCKey, []byte(config.CountryCode))
+}
+
+// lastCountryCode returns the country code of the last successful
+// test lists query or an empty string.
+func (c testListsCache) lastCountryCode() string {
+	if c.kvstore == nil {
+		return ""
+	}
+	data, err := c.kvstore.Get(testListsCacheLastCCKey)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
@@ -0,0 +1,162 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// ErrInvalidInput indicates that an input does not have the
+// shape expected by the experiment.
+var ErrInvalidInput = errors.New("invalid input")
+
+// normalizeInput validates the input according to the given shape
+// and returns its normalized form. With InputShapeAny we only trim
+// the surrounding whitespace, because we know nothing about it.
+func normalizeInput(shape InputShape, input string) (string, error) {
+	input = strings.TrimSpace(input)
+	switch shape {
+	case InputShapeURL:
+		return normalizeURL(input)
+	case InputShapeDomain:
+		return normalizeDomain(input)
+	case InputShapeEndpoint:
+		return normalizeEndpoint(input)
+	case InputShapeDNSResolverURL:
+		return normalizeDNSResolverURL(input)
+	default:
+		return input, nil
+	}
+}
+
+// normalizeURL normalizes a URL. We lowercase the scheme and the
+// host, we convert internationalized domain names to ASCII, and we
+// use `/` as the path of HTTP URLs without any path, such that, e.g.,
+// `HTTP://Example.COM` becomes `http://example.com/`.
+func normalizeURL(input string) (string, error) {
+	URL, err := parseURLWithHost(input)
+	if err != nil {
+		return "", err
+	}
+	if (URL.Scheme == "http" || URL.Scheme == "https") && URL.Path == "" {
+		URL.Path = "/"
+	}
+	return URL.String(), nil
+}
+
+// normalizeDNSResolverURL normalizes a URL describing a DNS
+// resolver, e.g., `udp://8.8.8.8:53` or `https://dns.google/dns-query`.
+func normalizeDNSResolverURL(input string) (string, error) {
+	URL, err := parseURLWithHost(input)
+	if err != nil {
+		return "", err
+	}
+	switch URL.Scheme {
+	case "https", "h3", "dot", "doq", "udp", "tcp":
+		return URL.String(), nil
+	default:
+		return "", fmt.Errorf("unsupported DNS resolver URL scheme %q", URL.Scheme)
+	}
+}
+
+// parseURLWithHost parses a URL that must contain a scheme and a
+// host and normalizes its host, preserving the port, if any.
+func parseURLWithHost(input string) (*url.URL, error) {
+	URL, err := url.Parse(input)
+	if err != nil {
+		return nil, err
+	}
+	if URL.Scheme == "" {
+		return nil, errors.New("missing URL scheme")
+	}
+	if URL.Host == "" {
+		return nil, errors.New("missing URL host")
+	}
+	URL.Scheme = strings.ToLower(URL.Scheme)
+	host, err := normalizeDomain(URL.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	switch port := URL.Port(); {
+	case port != "":
+		URL.Host = net.JoinHostPort(host, port)
+	case strings.Contains(host, ":"):
+		URL.Host = "[" + host + "]" // IPv6 address
+	default:
+		URL.Host = host
+	}
+	return URL, nil
+}
+
+// normalizeEndpoint normalizes an endpoint such as `example.com:443`.
+func normalizeEndpoint(input string) (string, error) {
+	host, port, err := net.SplitHostPort(input)
+	if err != nil {
+		return "", err
+	}
+	if number, err := strconv.Atoi(port); err != nil || number <= 0 || number > 65535 {
+		return "", fmt.Errorf("invalid port %q", port)
+	}
+	if host, err = normalizeDomain(host); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// normalizeDomain normalizes a domain name, which we lowercase and
+// convert to ASCII, removing the trailing dot. We also accept IP
+// addresses, which we return unmodified.
+func normalizeDomain(input string) (string, error) {
+	if net.ParseIP(input) != nil {
+		return input, nil
+	}
+	domain, err := idna.ToASCII(strings.ToLower(strings.TrimSuffix(input, ".")))
+	if err != nil {
+		return "", err
+	}
+	if domain == "" {
+		return "", errors.New("missing domain name")
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if label == "" || len(label) > 63 {
+			return "", fmt.Errorf("invalid domain name %q", input)
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') && c != '-' && c != '_' {
+				return "", fmt.Errorf("invalid domain name %q", input)
+			}
+		}
+	}
+	return domain, nil
+}
+
+// remoteInputToShape converts a URL returned by the test lists
+// API, which only returns URLs, to the shape expected by the
+// experiment. If this is not possible, we return the URL, and
+// we let normalizeInput tell us that it's not valid.
+func remoteInputToShape(shape InputShape, input string) string {
+	URL, err := url.Parse(input)
+	if err != nil || URL.Host == "" {
+		return input
+	}
+	switch shape {
+	case InputShapeDomain:
+		return URL.Hostname()
+	case InputShapeEndpoint:
+		if URL.Port() != "" {
+			return URL.Host
+		}
+		switch URL.Scheme {
+		case "http":
+			return net.JoinHostPort(URL.Hostname(), "80")
+		case "https":
+			return net.JoinHostPort(URL.Hostname(), "443")
+		}
+	}
+	return input
+}
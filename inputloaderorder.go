@@ -0,0 +1,94 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/ooni/probe-engine/model"
+)
+
+// InputOrdering is the order in which InputLoader returns inputs.
+type InputOrdering string
+
+const (
+	// InputOrderingAsIs returns inputs in the order in which
+	// we read them or the test lists API returned them.
+	InputOrderingAsIs = InputOrdering("")
+
+	// InputOrderingShuffle randomly shuffles the inputs using
+	// InputLoaderConfig.Seed, so the order is reproducible.
+	InputOrderingShuffle = InputOrdering("shuffle")
+
+	// InputOrderingLeastRecentlyMeasured returns first the inputs
+	// that we never measured and then the inputs that we measured
+	// less recently, according to InputLoaderConfig.History.
+	InputOrderingLeastRecentlyMeasured = InputOrdering("least_recently_measured")
+)
+
+// ErrInvalidInputOrdering indicates that the InputOrdering is not valid.
+var ErrInvalidInputOrdering = errors.New("invalid input ordering")
+
+// checkOrdering returns an error if the Ordering is not valid.
+func (il inputLoader) checkOrdering() error {
+	switch il.Ordering {
+	case InputOrderingAsIs, InputOrderingShuffle, InputOrderingLeastRecentlyMeasured:
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", ErrInvalidInputOrdering, il.Ordering)
+	}
+}
+
+// sampling returns whether we need all the inputs to select
+// which ones to return, rather than just the first ones.
+func (il inputLoader) sampling() bool {
+	return il.Ordering != InputOrderingAsIs || il.MaxPerCategory > 0
+}
+
+// arrange sorts the inputs according to the Ordering, then keeps at
+// most MaxPerCategory inputs for each category code, and finally keeps
+// at most limit inputs, unless limit is zero or negative.
+func (il inputLoader) arrange(inputs []model.URLInfo, limit int64) ([]model.URLInfo, error) {
+	switch il.Ordering {
+	case InputOrderingShuffle:
+		seed := il.Seed
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+			il.logger().Infof("inputloader: shuffling inputs using seed %d", seed)
+		}
+		rng := rand.New(rand.NewSource(seed))
+		rng.Shuffle(len(inputs), func(i, j int) {
+			inputs[i], inputs[j] = inputs[j], inputs[i]
+		})
+	case InputOrderingLeastRecentlyMeasured:
+		var history map[string]time.Time
+		if il.History != nil {
+			var err error
+			if history, err = il.History.LastMeasured(); err != nil {
+				return nil, err
+			}
+		}
+		// Note: the zero time sorts first, so we return first the inputs
+		// we never measured, in the original order, because the sort is stable.
+		sort.SliceStable(inputs, func(i, j int) bool {
+			return history[inputs[i].URL].Before(history[inputs[j].URL])
+		})
+	}
+	if il.MaxPerCategory > 0 {
+		counts := make(map[string]int)
+		out := []model.URLInfo{}
+		for _, input := range inputs {
+			if counts[input.CategoryCode] < il.MaxPerCategory {
+				counts[input.CategoryCode]++
+				out = append(out, input)
+			}
+		}
+		inputs = out
+	}
+	if limit > 0 && int64(len(inputs)) > limit {
+		inputs = inputs[:limit]
+	}
+	return inputs, nil
+}
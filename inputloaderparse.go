@@ -0,0 +1,183 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/ooni/probe-engine/model"
+)
+
+// ErrInvalidInputFile indicates that we could not parse an input file.
+var ErrInvalidInputFile = errors.New("invalid input file")
+
+// inputEntry is an input along with the place where we read it,
+// which we use to emit meaningful errors for invalid inputs.
+type inputEntry struct {
+	model.URLInfo
+	origin string
+}
+
+// parseInputs parses the inputs read from the file at the given
+// path. We support these formats, which we detect by looking at
+// the content, so they also work when reading from stdin:
+//
+// 1. a JSON array of model.URLInfo;
+//
+// 2. a citizenlab test list, i.e., a CSV file where the first line
+// is a header including the `url` and `category_code` columns and the
+// country code is implied by the file name (e.g. `it.csv` for Italy
+// and `global.csv` for the global list, which uses `XX`);
+//
+// 3. a text file containing an input per line.
+func parseInputs(path string, reader io.Reader) ([]inputEntry, error) {
+	bufreader := bufio.NewReader(reader)
+	data, err := bufreader.Peek(512)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, bufio.ErrBufferFull) {
+		return nil, err
+	}
+	trimmed := bytes.TrimSpace(data)
+	if isJSONArrayOfObjects(trimmed) {
+		return parseJSONInputs(path, bufreader)
+	}
+	if isCitizenlabHeader(trimmed) {
+		return parseCSVInputs(path, bufreader)
+	}
+	return parseTextInputs(path, bufreader)
+}
+
+func parseJSONInputs(path string, reader io.Reader) ([]inputEntry, error) {
+	var entries []model.URLInfo
+	if err := json.NewDecoder(reader).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("%w: %s: %s", ErrInvalidInputFile, path, err.Error())
+	}
+	inputs := []inputEntry{}
+	for idx, entry := range entries {
+		if entry.URL != "" {
+			inputs = append(inputs, inputEntry{
+				URLInfo: entry,
+				origin:  fmt.Sprintf("%s: entry #%d", path, idx),
+			})
+		}
+	}
+	return inputs, nil
+}
+
+// isJSONArrayOfObjects returns whether data looks like the beginning
+// of a JSON array of objects, including the empty array. We cannot just
+// check for a leading `[` because a text file may start with an IPv6
+// endpoint, e.g., `[2001:db8::1]:853`.
+func isJSONArrayOfObjects(data []byte) bool {
+	if !bytes.HasPrefix(data, []byte("[")) {
+		return false
+	}
+	data = bytes.TrimSpace(data[1:])
+	return bytes.HasPrefix(data, []byte("{")) || bytes.HasPrefix(data, []byte("]"))
+}
+
+// isCitizenlabHeader returns whether the first line of data
+// looks like the header of a citizenlab test list.
+func isCitizenlabHeader(data []byte) bool {
+	line := string(data)
+	if idx := strings.IndexAny(line, "\r\n"); idx >= 0 {
+		line = line[:idx]
+	}
+	var url, category bool
+	for _, column := range strings.Split(line, ",") {
+		switch strings.TrimSpace(column) {
+		case "url":
+			url = true
+		case "category_code":
+			category = true
+		}
+	}
+	return url && category
+}
+
+func parseCSVInputs(path string, reader io.Reader) ([]inputEntry, error) {
+	csvreader := csv.NewReader(reader)
+	csvreader.FieldsPerRecord = -1 // be liberal in what we accept
+	header, err := csvreader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %s", ErrInvalidInputFile, path, err.Error())
+	}
+	urlIdx, categoryIdx := -1, -1
+	for idx, column := range header {
+		switch strings.TrimSpace(column) {
+		case "url":
+			urlIdx = idx
+		case "category_code":
+			categoryIdx = idx
+		}
+	}
+	countryCode := citizenlabCountryCode(path)
+	inputs := []inputEntry{}
+	for line := 2; ; line++ {
+		record, err := csvreader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %s", ErrInvalidInputFile, path, err.Error())
+		}
+		if urlIdx >= len(record) || categoryIdx >= len(record) {
+			return nil, fmt.Errorf("%w: %s:%d: missing columns", ErrInvalidInputFile, path, line)
+		}
+		if record[urlIdx] == "" {
+			continue
+		}
+		inputs = append(inputs, inputEntry{
+			URLInfo: model.URLInfo{
+				CategoryCode: record[categoryIdx],
+				CountryCode:  countryCode,
+				URL:          record[urlIdx],
+			},
+			origin: fmt.Sprintf("%s:%d", path, line),
+		})
+	}
+	return inputs, nil
+}
+
+// citizenlabCountryCode returns the country code implied by the
+// name of a citizenlab test list, or an empty string.
+func citizenlabCountryCode(path string) string {
+	name := strings.ToLower(filepath.Base(path))
+	if idx := strings.Index(name, "."); idx >= 0 {
+		name = name[:idx]
+	}
+	switch {
+	case name == "global":
+		return "XX"
+	case len(name) == 2:
+		return strings.ToUpper(name)
+	default:
+		return ""
+	}
+}
+
+func parseTextInputs(path string, reader io.Reader) ([]inputEntry, error) {
+	inputs := []inputEntry{}
+	// Implementation note: when you save file with vim, you have newline at
+	// end of file and you don't want to consider that an input line. While there
+	// ignore any other empty line that may occur inside the file.
+	scanner := bufio.NewScanner(reader)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := scanner.Text()
+		if line != "" {
+			inputs = append(inputs, inputEntry{
+				URLInfo: model.URLInfo{URL: line},
+				origin:  fmt.Sprintf("%s:%d", path, lineno),
+			})
+		}
+	}
+	if scanner.Err() != nil {
+		return nil, scanner.Err()
+	}
+	return inputs, nil
+}
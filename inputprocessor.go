@@ -2,6 +2,7 @@ package engine
 
 import (
 	"context"
+	"sync"
 
 	"github.com/ooni/probe-engine/model"
 )
@@ -38,25 +39,109 @@ func (ipew inputProcessorExperimentWrapper) MeasureWithContext(
 
 var _ InputProcessorExperimentWrapper = inputProcessorExperimentWrapper{}
 
+// InputProcessorErrorPolicy is the policy InputProcessor follows
+// when measuring, submitting, or saving a measurement fails.
+type InputProcessorErrorPolicy string
+
+const (
+	// InputProcessorAbortOnError stops processing inputs as soon as
+	// any stage fails and returns the corresponding error.
+	InputProcessorAbortOnError = InputProcessorErrorPolicy("abort")
+
+	// InputProcessorContinueOnError records the failure and continues
+	// with the next input. If submitting fails, we still attempt to save
+	// the measurement. If measuring fails, we still submit and save the
+	// measurement, if any, since it documents the failure.
+	InputProcessorContinueOnError = InputProcessorErrorPolicy("continue")
+
+	// InputProcessorRetryOnError is like InputProcessorContinueOnError
+	// except that we retry the failing stage up to MaxRetries times
+	// before recording the failure and moving on.
+	InputProcessorRetryOnError = InputProcessorErrorPolicy("retry")
+)
+
+// InputProcessorStage is the stage of processing an input.
+type InputProcessorStage string
+
+const (
+	// InputProcessorStageMeasure is the stage where we measure.
+	InputProcessorStageMeasure = InputProcessorStage("measure")
+
+	// InputProcessorStageSubmit is the stage where we submit.
+	InputProcessorStageSubmit = InputProcessorStage("submit")
+
+	// InputProcessorStageSave is the stage where we save.
+	InputProcessorStageSave = InputProcessorStage("save")
+
+	// InputProcessorStageSink is the stage where we stream
+	// measurements to the optional Sink.
+	InputProcessorStageSink = InputProcessorStage("sink")
+)
+
+// InputProcessorResult summarizes what happened to a single input.
+type InputProcessorResult struct {
+	// Idx is the index of the input inside InputProcessor.Inputs.
+	Idx int
+
+	// Input is the input.
+	Input string
+
+	// Stage is the stage that failed first, if any, or an
+	// empty string if we processed the input successfully.
+	Stage InputProcessorStage
+
+	// Err is the error that occurred in Stage, if any.
+	Err error
+}
+
 // InputProcessor processes inputs. We perform a Measurement
 // for each input using the given Experiment.
 type InputProcessor struct {
 	// Annotations contains the measurement annotations
 	Annotations map[string]string
 
+	// Checkpoint is the optional checkpoint. When set, we skip
+	// the inputs that a previous run with the same checkpoint
+	// already processed and we record our progress.
+	Checkpoint *InputCheckpoint
+
 	// Experiment is the code that will run the experiment.
 	Experiment InputProcessorExperimentWrapper
 
+	// History is the optional history where we record the inputs
+	// that we successfully measured, submitted and saved. We flush
+	// the history when we are done.
+	History *InputHistory
+
 	// Inputs is the list of inputs to measure.
 	Inputs []model.URLInfo
 
+	// MaxRetries is the number of times we retry a failing stage
+	// when OnError is InputProcessorRetryOnError.
+	MaxRetries int
+
+	// OnError is the policy to follow when measuring, submitting, or
+	// saving fails. If empty, we use InputProcessorAbortOnError.
+	OnError InputProcessorErrorPolicy
+
 	// Options contains command line options for this experiment.
 	Options []string
 
+	// Parallelism is the number of inputs we measure in parallel. When
+	// this value is zero or one, we measure inputs sequentially. If the
+	// experiment is not safe for concurrent use, which you can check
+	// using ExperimentBuilder.Sequential, you MUST NOT set this field.
+	Parallelism int
+
 	// Saver is the code that will save measurement results
 	// on persistent storage (e.g. the file system).
 	Saver InputProcessorSaverWrapper
 
+	// Sink is the optional code that will stream measurements
+	// to a third party service (see the Sink type). We pass
+	// measurements to the Sink after we have saved them.
+	Sink InputProcessorSaverWrapper
+
 	// Submitter is the code that will submit measurements
 	// to the OONI collector.
 	Submitter InputProcessorSubmitterWrapper
@@ -79,6 +164,9 @@ func NewInputProcessorSaverWrapper(saver Saver) InputProcessorSaverWrapper {
 
 func (ipsw inputProcessorSaverWrapper) SaveMeasurement(
 	idx int, m *model.Measurement) error {
+	if saver, ok := ipsw.saver.(saverWithIndex); ok {
+		return saver.SaveMeasurementWithIndex(idx, m)
+	}
 	return ipsw.saver.SaveMeasurement(m)
 }
 
@@ -104,37 +192,256 @@ func (ipsw inputProcessorSubmitterWrapper) SubmitAndUpdateMeasurementContext(
 	return ipsw.submitter.SubmitAndUpdateMeasurementContext(ctx, m)
 }
 
-// Run processes all the input subject to the duration of the
-// context. The code will perform measurements using the given
+// Run is like RunWithResults but only returns the error.
+func (ip InputProcessor) Run(ctx context.Context) error {
+	_, err := ip.RunWithResults(ctx)
+	return err
+}
+
+// RunWithResults processes all the input subject to the duration
+// of the context. The code will perform measurements using the given
 // experiment; submit measurements using the given submitter;
 // save measurements using the given saver.
 //
 // Annotations and Options will be saved in the measurement.
 //
+// When Parallelism is greater than one, we run at most Parallelism
+// measurements concurrently. Regardless of the parallelism, we
+// submit and save measurements sequentially and in the same order
+// in which the inputs appear in Inputs. In parallel mode, we stop
+// starting new measurements as soon as the context is done.
+//
 // The default behaviour of this code is that an error while
 // measuring, while submitting, or while saving a measurement
-// is always causing us to break out of the loop. The user
-// though is free to choose different policies by configuring
-// the Experiment, Submitter, and Saver fields properly.
-func (ip InputProcessor) Run(ctx context.Context) error {
-	for idx, url := range ip.Inputs {
-		input := url.URL
-		meas, err := ip.Experiment.MeasureWithContext(ctx, idx, input)
-		if err != nil {
-			return err
+// is always causing us to break out of the loop. You can choose
+// a different policy by setting OnError. (You can also configure
+// the Experiment, Submitter, and Saver fields to ignore errors.)
+// With policies other than InputProcessorAbortOnError we only
+// return an error when the context is done, in which case we
+// stop processing inputs.
+//
+// We return a result for each input we processed, in order.
+//
+// When Checkpoint is set, we start from the first input that a
+// previous run did not process, using the same order of such run,
+// and we update the checkpoint after processing each input. When
+// we have processed all inputs, we clear the checkpoint.
+func (ip InputProcessor) RunWithResults(
+	ctx context.Context) ([]InputProcessorResult, error) {
+	var start int
+	if ip.Checkpoint != nil {
+		var state InputCheckpointState
+		ip.Inputs, state = ip.Checkpoint.Load(ip.Inputs)
+		start = state.NextIdx
+	}
+	var (
+		results []InputProcessorResult
+		err     error
+	)
+	if ip.Parallelism > 1 {
+		results, err = ip.runParallel(ctx, start)
+	} else {
+		results, err = ip.runSequential(ctx, start)
+	}
+	if ip.History != nil {
+		// Note: we flush also when we are stopping because of an error
+		// since the inputs we have recorded have been measured anyway.
+		if flushErr := ip.History.Flush(); err == nil {
+			err = ip.maybeAbort(flushErr)
 		}
-		meas.AddAnnotations(ip.Annotations)
-		meas.Options = ip.Options
-		err = ip.Submitter.SubmitAndUpdateMeasurementContext(ctx, idx, meas)
+	}
+	if err == nil && ip.Checkpoint != nil {
+		err = ip.Checkpoint.Clear()
+	}
+	return results, err
+}
+
+// runSequential is the sequential implementation of Run.
+func (ip InputProcessor) runSequential(
+	ctx context.Context, start int) ([]InputProcessorResult, error) {
+	var results []InputProcessorResult
+	for idx := start; idx < len(ip.Inputs); idx++ {
+		if !ip.abortOnError() && ctx.Err() != nil {
+			return results, ctx.Err()
+		}
+		input := ip.Inputs[idx].URL
+		meas, err := ip.measure(ctx, idx, input)
+		result, err := ip.process(ctx, idx, input, meas, err)
+		results = append(results, result)
 		if err != nil {
-			return err
+			return results, err
 		}
-		// Note: must be after submission because submission modifies
-		// the measurement to include the report ID.
-		err = ip.Saver.SaveMeasurement(idx, meas)
+	}
+	return results, nil
+}
+
+// inputProcessorMeasurement is the result of measuring a single input.
+type inputProcessorMeasurement struct {
+	err  error
+	meas *model.Measurement
+}
+
+// runParallel is the parallel implementation of Run. We use a pool
+// of Parallelism workers to measure. Each worker posts its result
+// on the channel reserved to the input index, so that we can collect
+// results in order, regardless of when each measurement completes.
+func (ip InputProcessor) runParallel(
+	ctx context.Context, start int) ([]InputProcessorResult, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	measurements := make([]chan inputProcessorMeasurement, len(ip.Inputs))
+	for idx := range measurements {
+		measurements[idx] = make(chan inputProcessorMeasurement, 1) // do not block workers
+	}
+	indexes := make(chan int)
+	go func() {
+		defer close(indexes)
+		for idx := start; idx < len(ip.Inputs); idx++ {
+			select {
+			case indexes <- idx:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	wg := new(sync.WaitGroup)
+	for i := 0; i < ip.Parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range indexes {
+				meas, err := ip.measure(ctx, idx, ip.Inputs[idx].URL)
+				measurements[idx] <- inputProcessorMeasurement{err: err, meas: meas}
+			}
+		}()
+	}
+	// Make sure no worker is still using the experiment when we return,
+	// which is what the caller would expect in the sequential case.
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+	var results []InputProcessorResult
+	for idx := start; idx < len(ip.Inputs); idx++ {
+		var m inputProcessorMeasurement
+		select {
+		case m = <-measurements[idx]:
+		case <-ctx.Done():
+			return results, ctx.Err()
+		}
+		result, err := ip.process(ctx, idx, ip.Inputs[idx].URL, m.meas, m.err)
+		results = append(results, result)
 		if err != nil {
-			return err
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// measure measures the given input, retrying if needed.
+func (ip InputProcessor) measure(
+	ctx context.Context, idx int, input string) (*model.Measurement, error) {
+	var meas *model.Measurement
+	err := ip.retry(ctx, func() (err error) {
+		meas, err = ip.Experiment.MeasureWithContext(ctx, idx, input)
+		return
+	})
+	return meas, err
+}
+
+// process processes the result of measuring an input. That is, it adds
+// annotations and options to the measurement and then submits and saves
+// it. The returned error is non-nil only if we should stop. When the
+// measure stage fails but we have a measurement, which documents the
+// failure, and we are not aborting, we still submit and save it.
+func (ip InputProcessor) process(ctx context.Context, idx int, input string,
+	meas *model.Measurement, err error) (InputProcessorResult, error) {
+	result := InputProcessorResult{Idx: idx, Input: input}
+	if err != nil {
+		result.Stage, result.Err = InputProcessorStageMeasure, err
+		if err := ip.maybeAbort(err); err != nil {
+			return result, err
+		}
+		if meas == nil {
+			return result, ip.maybeAbort(ip.updateCheckpoint(idx, nil))
+		}
+	}
+	meas.AddAnnotations(ip.Annotations)
+	meas.Options = ip.Options
+	err = ip.retry(ctx, func() error {
+		return ip.Submitter.SubmitAndUpdateMeasurementContext(ctx, idx, meas)
+	})
+	if err != nil {
+		result.Stage, result.Err = InputProcessorStageSubmit, err
+		if err := ip.maybeAbort(err); err != nil {
+			return result, err
+		}
+	}
+	// Note: must be after submission because submission modifies
+	// the measurement to include the report ID.
+	err = ip.retry(ctx, func() error {
+		return ip.Saver.SaveMeasurement(idx, meas)
+	})
+	if err != nil && result.Err == nil {
+		result.Stage, result.Err = InputProcessorStageSave, err
+	}
+	if err := ip.maybeAbort(err); err != nil {
+		return result, err
+	}
+	if ip.Sink != nil {
+		err = ip.retry(ctx, func() error {
+			return ip.Sink.SaveMeasurement(idx, meas)
+		})
+		if err != nil && result.Err == nil {
+			result.Stage, result.Err = InputProcessorStageSink, err
+		}
+		if err := ip.maybeAbort(err); err != nil {
+			return result, err
+		}
+	}
+	// Note: we only record inputs that we have successfully measured,
+	// submitted and saved, so that we measure again the other ones.
+	if ip.History != nil && result.Err == nil {
+		if err := ip.maybeAbort(ip.History.Record(input)); err != nil {
+			return result, err
 		}
 	}
+	return result, ip.maybeAbort(ip.updateCheckpoint(idx, meas))
+}
+
+// updateCheckpoint records that we processed the input at idx.
+func (ip InputProcessor) updateCheckpoint(idx int, meas *model.Measurement) error {
+	if ip.Checkpoint == nil {
+		return nil
+	}
+	var reportID string
+	if meas != nil {
+		reportID = meas.ReportID
+	}
+	return ip.Checkpoint.Update(idx+1, reportID)
+}
+
+// abortOnError returns whether we should abort on error.
+func (ip InputProcessor) abortOnError() bool {
+	return ip.OnError == "" || ip.OnError == InputProcessorAbortOnError
+}
+
+// maybeAbort returns err if the policy is to abort and nil otherwise.
+func (ip InputProcessor) maybeAbort(err error) error {
+	if ip.abortOnError() {
+		return err
+	}
 	return nil
 }
+
+// retry calls fn once or, if the policy is InputProcessorRetryOnError,
+// until it succeeds, for at most 1+MaxRetries times.
+func (ip InputProcessor) retry(ctx context.Context, fn func() error) error {
+	err := fn()
+	if ip.OnError != InputProcessorRetryOnError {
+		return err
+	}
+	for i := 0; err != nil && i < ip.MaxRetries && ctx.Err() == nil; i++ {
+		err = fn()
+	}
+	return err
+}
@@ -3,7 +3,10 @@ package engine
 import (
 	"context"
 	"errors"
+	"fmt"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/ooni/probe-engine/model"
 )
@@ -11,10 +14,13 @@ import (
 type FakeInputProcessorExperiment struct {
 	Err error
 	M   []*model.Measurement
+	mu  sync.Mutex
 }
 
 func (fipe *FakeInputProcessorExperiment) MeasureWithContext(
 	ctx context.Context, input string) (*model.Measurement, error) {
+	fipe.mu.Lock()
+	defer fipe.mu.Unlock()
 	if fipe.Err != nil {
 		return nil, fipe.Err
 	}
@@ -164,3 +170,326 @@ func TestInputProcessorGood(t *testing.T) {
 		t.Fatal("invalid saver.M[1].Input")
 	}
 }
+
+type SlowInputProcessorExperiment struct {
+	mu      sync.Mutex
+	running int
+	maxrun  int
+}
+
+func (sipe *SlowInputProcessorExperiment) MeasureWithContext(
+	ctx context.Context, idx int, input string) (*model.Measurement, error) {
+	sipe.mu.Lock()
+	sipe.running++
+	if sipe.running > sipe.maxrun {
+		sipe.maxrun = sipe.running
+	}
+	sipe.mu.Unlock()
+	// Make earlier inputs slower than later inputs, so that we
+	// complete measurements in reverse order.
+	select {
+	case <-time.After(time.Duration(10-idx) * 5 * time.Millisecond):
+	case <-ctx.Done():
+	}
+	sipe.mu.Lock()
+	sipe.running--
+	sipe.mu.Unlock()
+	m := new(model.Measurement)
+	m.Input = model.MeasurementTarget(input)
+	return m, nil
+}
+
+func TestInputProcessorParallelGood(t *testing.T) {
+	sipe := &SlowInputProcessorExperiment{}
+	saver := &FakeInputProcessorSaver{Err: nil}
+	submitter := &FakeInputProcessorSubmitter{Err: nil}
+	var inputs []model.URLInfo
+	for i := 0; i < 10; i++ {
+		inputs = append(inputs, model.URLInfo{URL: fmt.Sprintf("https://x%d.org/", i)})
+	}
+	ip := InputProcessor{
+		Annotations: map[string]string{"foo": "bar"},
+		Experiment:  sipe,
+		Inputs:      inputs,
+		Options:     []string{"fake=true"},
+		Parallelism: 4,
+		Saver:       NewInputProcessorSaverWrapper(saver),
+		Submitter:   NewInputProcessorSubmitterWrapper(submitter),
+	}
+	ctx := context.Background()
+	if err := ip.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(saver.M) != 10 || len(submitter.M) != 10 {
+		t.Fatal("not all measurements saved")
+	}
+	for idx := 0; idx < 10; idx++ {
+		if submitter.M[idx].Input != model.MeasurementTarget(inputs[idx].URL) {
+			t.Fatal("invalid submitter.M order", idx)
+		}
+		if saver.M[idx].Input != model.MeasurementTarget(inputs[idx].URL) {
+			t.Fatal("invalid saver.M order", idx)
+		}
+		if saver.M[idx].Annotations["foo"] != "bar" {
+			t.Fatal("annotation not set", idx)
+		}
+	}
+	if sipe.maxrun < 2 || sipe.maxrun > 4 {
+		t.Fatal("unexpected parallelism", sipe.maxrun)
+	}
+}
+
+func TestInputProcessorParallelMeasurementFailed(t *testing.T) {
+	expected := errors.New("mocked error")
+	saver := &FakeInputProcessorSaver{Err: nil}
+	ip := InputProcessor{
+		Experiment: NewInputProcessorExperimentWrapper(
+			&FakeInputProcessorExperiment{Err: expected},
+		),
+		Inputs: []model.URLInfo{{
+			URL: "https://www.kernel.org/",
+		}, {
+			URL: "https://www.slashdot.org/",
+		}},
+		Parallelism: 2,
+		Saver:       NewInputProcessorSaverWrapper(saver),
+	}
+	ctx := context.Background()
+	if err := ip.Run(ctx); !errors.Is(err, expected) {
+		t.Fatalf("not the error we expected: %+v", err)
+	}
+	if len(saver.M) != 0 {
+		t.Fatal("saved measurements")
+	}
+}
+
+func TestInputProcessorParallelCancelled(t *testing.T) {
+	sipe := &SlowInputProcessorExperiment{}
+	saver := &FakeInputProcessorSaver{Err: nil}
+	submitter := &FakeInputProcessorSubmitter{Err: nil}
+	var inputs []model.URLInfo
+	for i := 0; i < 10; i++ {
+		inputs = append(inputs, model.URLInfo{URL: fmt.Sprintf("https://x%d.org/", i)})
+	}
+	ip := InputProcessor{
+		Experiment:  sipe,
+		Inputs:      inputs,
+		Parallelism: 2,
+		Saver:       NewInputProcessorSaverWrapper(saver),
+		Submitter:   NewInputProcessorSubmitterWrapper(submitter),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // fail immediately
+	if err := ip.Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("not the error we expected: %+v", err)
+	}
+	if len(saver.M) >= 10 {
+		t.Fatal("we did not stop early")
+	}
+}
+
+type FlakyInputProcessorSubmitter struct {
+	Failures int
+	M        []*model.Measurement
+}
+
+func (fips *FlakyInputProcessorSubmitter) SubmitAndUpdateMeasurementContext(
+	ctx context.Context, m *model.Measurement) error {
+	if fips.Failures > 0 {
+		fips.Failures--
+		return errors.New("mocked error")
+	}
+	fips.M = append(fips.M, m)
+	return nil
+}
+
+func TestInputProcessorContinueOnError(t *testing.T) {
+	expected := errors.New("mocked error")
+	saver := &FakeInputProcessorSaver{Err: nil}
+	submitter := &FakeInputProcessorSubmitter{Err: expected}
+	ip := InputProcessor{
+		Experiment: NewInputProcessorExperimentWrapper(
+			&FakeInputProcessorExperiment{},
+		),
+		Inputs: []model.URLInfo{{
+			URL: "https://www.kernel.org/",
+		}, {
+			URL: "https://www.slashdot.org/",
+		}},
+		OnError:   InputProcessorContinueOnError,
+		Saver:     NewInputProcessorSaverWrapper(saver),
+		Submitter: NewInputProcessorSubmitterWrapper(submitter),
+	}
+	results, err := ip.RunWithResults(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatal("unexpected number of results")
+	}
+	for idx, result := range results {
+		if result.Idx != idx || result.Input != ip.Inputs[idx].URL {
+			t.Fatal("unexpected result", idx)
+		}
+		if result.Stage != InputProcessorStageSubmit || !errors.Is(result.Err, expected) {
+			t.Fatal("unexpected failure", idx)
+		}
+	}
+	if len(saver.M) != 2 {
+		t.Fatal("we did not save after failing to submit")
+	}
+}
+
+func TestInputProcessorContinueOnMeasurementError(t *testing.T) {
+	expected := errors.New("mocked error")
+	saver := &FakeInputProcessorSaver{Err: nil}
+	ip := InputProcessor{
+		Experiment: NewInputProcessorExperimentWrapper(
+			&FakeInputProcessorExperiment{Err: expected},
+		),
+		Inputs: []model.URLInfo{{
+			URL: "https://www.kernel.org/",
+		}, {
+			URL: "https://www.slashdot.org/",
+		}},
+		OnError:     InputProcessorContinueOnError,
+		Parallelism: 2,
+		Saver:       NewInputProcessorSaverWrapper(saver),
+	}
+	results, err := ip.RunWithResults(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatal("unexpected number of results")
+	}
+	for _, result := range results {
+		if result.Stage != InputProcessorStageMeasure || !errors.Is(result.Err, expected) {
+			t.Fatal("unexpected failure")
+		}
+	}
+	if len(saver.M) != 0 {
+		t.Fatal("we saved measurements")
+	}
+}
+
+// FakeInputProcessorFailedExperiment returns a measurement
+// along with an error, like Experiment does when Run fails.
+type FakeInputProcessorFailedExperiment struct {
+	Err error
+}
+
+func (fipe *FakeInputProcessorFailedExperiment) MeasureWithContext(
+	ctx context.Context, input string) (*model.Measurement, error) {
+	m := new(model.Measurement)
+	m.Input = model.MeasurementTarget(input)
+	return m, fipe.Err
+}
+
+func TestInputProcessorContinueOnMeasurementErrorWithMeasurement(t *testing.T) {
+	expected := errors.New("mocked error")
+	saver := &FakeInputProcessorSaver{Err: nil}
+	submitter := &FakeInputProcessorSubmitter{Err: nil}
+	ip := InputProcessor{
+		Experiment: NewInputProcessorExperimentWrapper(
+			&FakeInputProcessorFailedExperiment{Err: expected},
+		),
+		Inputs: []model.URLInfo{{
+			URL: "https://www.kernel.org/",
+		}, {
+			URL: "https://www.slashdot.org/",
+		}},
+		OnError:   InputProcessorContinueOnError,
+		Saver:     NewInputProcessorSaverWrapper(saver),
+		Submitter: NewInputProcessorSubmitterWrapper(submitter),
+	}
+	results, err := ip.RunWithResults(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range results {
+		if result.Stage != InputProcessorStageMeasure || !errors.Is(result.Err, expected) {
+			t.Fatal("unexpected failure")
+		}
+	}
+	if len(submitter.M) != 2 || len(saver.M) != 2 {
+		t.Fatal("we did not submit and save the failed measurements")
+	}
+}
+
+func TestInputProcessorAbortOnMeasurementErrorWithMeasurement(t *testing.T) {
+	expected := errors.New("mocked error")
+	saver := &FakeInputProcessorSaver{Err: nil}
+	ip := InputProcessor{
+		Experiment: NewInputProcessorExperimentWrapper(
+			&FakeInputProcessorFailedExperiment{Err: expected},
+		),
+		Inputs: []model.URLInfo{{
+			URL: "https://www.kernel.org/",
+		}},
+		OnError:   InputProcessorAbortOnError,
+		Saver:     NewInputProcessorSaverWrapper(saver),
+		Submitter: NewInputProcessorSubmitterWrapper(&FakeInputProcessorSubmitter{}),
+	}
+	if err := ip.Run(context.Background()); !errors.Is(err, expected) {
+		t.Fatal("not the error we expected", err)
+	}
+	if len(saver.M) != 0 {
+		t.Fatal("we saved after aborting")
+	}
+}
+
+func TestInputProcessorRetryOnError(t *testing.T) {
+	saver := &FakeInputProcessorSaver{Err: nil}
+	submitter := &FlakyInputProcessorSubmitter{Failures: 3}
+	ip := InputProcessor{
+		Experiment: NewInputProcessorExperimentWrapper(
+			&FakeInputProcessorExperiment{},
+		),
+		Inputs: []model.URLInfo{{
+			URL: "https://www.kernel.org/",
+		}, {
+			URL: "https://www.slashdot.org/",
+		}},
+		MaxRetries: 2,
+		OnError:    InputProcessorRetryOnError,
+		Saver:      NewInputProcessorSaverWrapper(saver),
+		Submitter:  NewInputProcessorSubmitterWrapper(submitter),
+	}
+	results, err := ip.RunWithResults(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first input fails three times, which is more than the number of
+	// retries, while the second input fails once and then succeeds.
+	if results[0].Stage != InputProcessorStageSubmit || results[0].Err == nil {
+		t.Fatal("expected the first input to fail")
+	}
+	if results[1].Stage != "" || results[1].Err != nil {
+		t.Fatal("expected the second input to succeed")
+	}
+	if len(submitter.M) != 1 || len(saver.M) != 2 {
+		t.Fatal("unexpected number of submitted or saved measurements")
+	}
+}
+
+func TestInputProcessorContinueOnErrorCancelled(t *testing.T) {
+	ip := InputProcessor{
+		Experiment: NewInputProcessorExperimentWrapper(
+			&FakeInputProcessorExperiment{},
+		),
+		Inputs: []model.URLInfo{{
+			URL: "https://www.kernel.org/",
+		}},
+		OnError: InputProcessorContinueOnError,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results, err := ip.RunWithResults(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("not the error we expected: %+v", err)
+	}
+	if len(results) != 0 {
+		t.Fatal("we processed inputs")
+	}
+}
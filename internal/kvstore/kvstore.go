@@ -2,10 +2,15 @@
 package kvstore
 
 import (
-	"errors"
+	"fmt"
+	"os"
 	"sync"
 )
 
+// ErrNoSuchKey indicates that a key does not exist. It wraps
+// os.ErrNotExist, like the errors of a filesystem based store.
+var ErrNoSuchKey = fmt.Errorf("no such key: %w", os.ErrNotExist)
+
 // MemoryKeyValueStore is an in-memory key-value store
 type MemoryKeyValueStore struct {
 	m  map[string][]byte
@@ -30,7 +35,7 @@ func (kvs *MemoryKeyValueStore) Get(key string) ([]byte, error) {
 	defer kvs.mu.Unlock()
 	value, ok = kvs.m[key]
 	if !ok {
-		err = errors.New("no such key")
+		err = ErrNoSuchKey
 	}
 	return value, err
 }
@@ -1,12 +1,16 @@
 package kvstore
 
-import "testing"
+import (
+	"errors"
+	"os"
+	"testing"
+)
 
 func TestNoSuchKey(t *testing.T) {
 	kvs := NewMemoryKeyValueStore()
 	value, err := kvs.Get("nonexistent")
-	if err == nil {
-		t.Fatal("expected an error here")
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("not the error we expected: %+v", err)
 	}
 	if value != nil {
 		t.Fatal("expected empty string here")
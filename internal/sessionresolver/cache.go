@@ -0,0 +1,115 @@
+package sessionresolver
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/ooni/probe-engine/model"
+)
+
+// cacheKey is the key used to store the DNS cache.
+const cacheKey = "sessionresolver.cache"
+
+const (
+	// cacheMaxTTL is the maximum amount of time for which we
+	// consider fresh a cache entry, regardless of its TTL.
+	cacheMaxTTL = 24 * time.Hour
+
+	// cacheMaxStale is the maximum amount of time for which we keep
+	// using an expired cache entry when all the resolvers fail.
+	cacheMaxStale = 7 * 24 * time.Hour
+
+	// systemTTL is the TTL we use for the addresses returned by
+	// the system resolver, which does not tell us the TTL.
+	systemTTL = 5 * time.Minute
+)
+
+// cacheEntry is a cached lookup result.
+type cacheEntry struct {
+	Addrs   []string
+	Expires time.Time
+}
+
+// cache is a DNS cache that honours the TTL of the records and that
+// persists its content into the key-value store, if not nil.
+type cache struct {
+	entries map[string]cacheEntry
+	kvstore model.KeyValueStore
+	logger  model.Logger
+	mu      sync.Mutex
+	timeNow func() time.Time
+}
+
+// newCache creates a new cache and loads its content.
+func newCache(kvstore model.KeyValueStore, logger model.Logger) *cache {
+	c := &cache{
+		entries: make(map[string]cacheEntry),
+		kvstore: kvstore,
+		logger:  logger,
+		timeNow: time.Now,
+	}
+	if kvstore == nil {
+		return c
+	}
+	data, err := kvstore.Get(cacheKey)
+	if err != nil {
+		return c
+	}
+	if err := json.Unmarshal(data, &c.entries); err != nil {
+		logger.Warnf("sessionresolver: cannot parse cache: %s", err.Error())
+		c.entries = make(map[string]cacheEntry)
+	}
+	return c
+}
+
+// get returns the cached addresses for hostname. If stale is false, we
+// only return fresh entries. Otherwise, we also return entries that
+// have expired no more than cacheMaxStale ago.
+func (c *cache) get(hostname string, stale bool) ([]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, found := c.entries[hostname]
+	if !found || len(entry.Addrs) <= 0 {
+		return nil, false
+	}
+	expires := entry.Expires
+	if stale {
+		expires = expires.Add(cacheMaxStale)
+	}
+	if !c.timeNow().Before(expires) {
+		return nil, false
+	}
+	return entry.Addrs, true
+}
+
+// set caches the addresses of hostname for the given TTL. We never
+// cache empty results, which would otherwise shadow working lookups.
+func (c *cache) set(hostname string, addrs []string, ttl time.Duration) {
+	if len(addrs) <= 0 {
+		return
+	}
+	if ttl > cacheMaxTTL {
+		ttl = cacheMaxTTL
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.timeNow()
+	c.entries[hostname] = cacheEntry{Addrs: addrs, Expires: now.Add(ttl)}
+	for key, entry := range c.entries {
+		if !now.Before(entry.Expires.Add(cacheMaxStale)) {
+			delete(c.entries, key)
+		}
+	}
+	if c.kvstore == nil {
+		return
+	}
+	data, err := json.Marshal(c.entries)
+	if err != nil {
+		c.logger.Warnf("sessionresolver: cannot serialize cache: %s", err.Error())
+		return
+	}
+	if err := c.kvstore.Set(cacheKey, data); err != nil {
+		c.logger.Warnf("sessionresolver: cannot save cache: %s", err.Error())
+	}
+}
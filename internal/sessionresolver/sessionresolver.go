@@ -1,77 +1,447 @@
 // Package sessionresolver contains the resolver used by the session. This
-// resolver uses Powerdns DoH by default and falls back on the system
-// provided resolver if Powerdns DoH is not working.
+// resolver uses a list of candidate resolvers, by default Cloudflare DoH,
+// Google DoH and the system resolver, and tries them in order of rank
+// until one of them is working. We rank resolvers using their success
+// rate and latency, which we persist in the key-value store, so that
+// we do not waste time with resolvers that are blocked in this network.
+// Because a resolver may only be temporarily failing, its score decays
+// toward the initial score over time and we periodically query again
+// resolvers that we have not been using, to notice when they work again.
+//
+// We also cache the lookup results honouring their TTL and we persist
+// the cache in the key-value store. When all the resolvers fail, we use
+// expired cache entries, if any. This is fine because we only use this
+// resolver for the session's own traffic (e.g. the bouncer, the collector,
+// the test helpers), while experiments create their own resolvers to
+// measure their targets and those lookups always go to the wire.
 package sessionresolver
 
 import (
 	"context"
-	"fmt"
+	"encoding/json"
+	"errors"
+	"math"
+	"net"
+	"sort"
+	"sync"
 	"time"
 
-	"github.com/ooni/probe-engine/atomicx"
-	"github.com/ooni/probe-engine/internal/runtimex"
+	"github.com/miekg/dns"
+	"github.com/ooni/probe-engine/model"
 	"github.com/ooni/probe-engine/netx"
+	"github.com/ooni/probe-engine/netx/errorx"
+	"github.com/ooni/probe-engine/netx/trace"
 )
 
+// DefaultURLs contains the resolvers we use by default.
+var DefaultURLs = []string{
+	"https://cloudflare.com/dns-query",
+	"https://dns.google/dns-query",
+	"system:///",
+}
+
+// DefaultTimeout is the default timeout for each resolver. We use a
+// higher timeout than Firefox's timeout (1.5s) to be on the safe side
+// and therefore use DoH more often. See:
+// https://wiki.mozilla.org/Trusted_Recursive_Resolver#DNS-over-HTTPS_Prefs_in_Firefox
+const DefaultTimeout = 4 * time.Second
+
+// hostOverrides contains the HTTP Host header to use for specific URLs.
+var hostOverrides = map[string]string{
+	"https://cloudflare.com/dns-query": "dns.cloudflare.com",
+}
+
+// stateKey is the key used to store the resolvers state.
+const stateKey = "sessionresolver.state"
+
+// ewmaAlpha is the weight of the most recent lookup when we update
+// the success score and the latency of a resolver.
+const ewmaAlpha = 0.3
+
+// priorScore is the score of a resolver we know nothing about.
+const priorScore = 0.5
+
+// healthyScore is the score above which a resolver is healthy.
+const healthyScore = 0.9
+
+// scoreHalfLife is the time after which the difference between the
+// score of a resolver we are not using and priorScore halves.
+const scoreHalfLife = 6 * time.Hour
+
+// reprobeInterval is the time after which we query again a resolver
+// that we are not using because it does not have the best rank.
+const reprobeInterval = 30 * time.Minute
+
+// Config contains settings for New.
+type Config struct {
+	// HTTPConfig is the mandatory config we use to create the resolvers.
+	HTTPConfig netx.Config
+
+	// KVStore is the optional key-value store where we persist the
+	// state of the resolvers. If nil, we do not persist it.
+	KVStore model.KeyValueStore
+
+	// Logger is the optional logger. If nil, we don't log.
+	Logger model.Logger
+
+	// Timeout is the optional timeout for each resolver. If
+	// zero, we use DefaultTimeout.
+	Timeout time.Duration
+
+	// URLs contains the optional list of resolver URLs in the
+	// format accepted by netx.NewDNSClient. If empty, we use
+	// DefaultURLs. We break ranking ties using this order.
+	URLs []string
+}
+
+// Stats contains statistics about a resolver.
+type Stats struct {
+	// Failures is the number of failed lookups.
+	Failures int64
+
+	// Latency is the moving average of the latency of successful lookups.
+	Latency time.Duration
+
+	// Queries is the number of lookups.
+	Queries int64
+
+	// Score is the moving average of the success rate.
+	Score float64
+
+	// Updated is the time of the last lookup.
+	Updated time.Time
+
+	// URL is the resolver URL.
+	URL string
+}
+
+// decayedScore returns the score decayed toward priorScore according
+// to the time elapsed since the last lookup.
+func (st Stats) decayedScore(now time.Time) float64 {
+	elapsed := now.Sub(st.Updated)
+	if st.Updated.IsZero() || elapsed <= 0 {
+		return st.Score
+	}
+	weight := math.Exp2(-float64(elapsed) / float64(scoreHalfLife))
+	return priorScore + (st.Score-priorScore)*weight
+}
+
+// rank is the value we use to sort resolvers. Higher is better. Each
+// second of latency has the same weight of a 100% success score. We
+// consider all the resolvers whose score is at least healthyScore as
+// equally successful. Otherwise, a resolver that recovered from a
+// failure, which we query less often than the one we are using, could
+// never regain its position in the configured order.
+func (st Stats) rank(now time.Time) float64 {
+	score := st.decayedScore(now)
+	if score >= healthyScore {
+		score = 1
+	}
+	return score / (1 + st.Latency.Seconds())
+}
+
+// tier is the value we use to sort resolvers before considering their
+// rank. We do not know the latency of a resolver we have never queried,
+// so comparing its rank with the one of a resolver we have queried
+// would favour the former just because the latter has some latency. We
+// therefore prefer the resolvers that are not failing to the ones we
+// have never queried, and the latter to the ones that are failing.
+func (st Stats) tier(now time.Time) int {
+	switch {
+	case st.Queries <= 0:
+		return 1
+	case st.decayedScore(now) >= priorScore:
+		return 2
+	default:
+		return 0
+	}
+}
+
+// dnsclient is the interface of the resolvers we use.
+type dnsclient interface {
+	LookupHost(ctx context.Context, hostname string) ([]string, error)
+	LookupRecords(ctx context.Context, domain string, qtype uint16) ([]trace.DNSRecord, error)
+	CloseIdleConnections()
+	Network() string
+}
+
+// entry is a candidate resolver.
+type entry struct {
+	client dnsclient
+	stats  Stats
+}
+
 // Resolver is the session resolver.
 type Resolver struct {
-	Primary         netx.DNSClient
-	PrimaryFailure  *atomicx.Int64
-	PrimaryQuery    *atomicx.Int64
-	Fallback        netx.DNSClient
-	FallbackFailure *atomicx.Int64
-	FallbackQuery   *atomicx.Int64
+	cache   *cache
+	entries []*entry
+	kvstore model.KeyValueStore
+	logger  model.Logger
+	mu      sync.Mutex
+	timeNow func() time.Time
+	timeout time.Duration
 }
 
 // New creates a new session resolver.
-func New(config netx.Config) *Resolver {
-	primary, err := netx.NewDNSClientWithOverrides(config,
-		"https://cloudflare.com/dns-query", "dns.cloudflare.com", "")
-	runtimex.PanicOnError(err, "cannot create dns over https resolver")
-	fallback, err := netx.NewDNSClient(config, "system:///")
-	runtimex.PanicOnError(err, "cannot create system resolver")
-	return &Resolver{
-		Primary:         primary,
-		PrimaryFailure:  atomicx.NewInt64(),
-		PrimaryQuery:    atomicx.NewInt64(),
-		Fallback:        fallback,
-		FallbackFailure: atomicx.NewInt64(),
-		FallbackQuery:   atomicx.NewInt64(),
+func New(config Config) (*Resolver, error) {
+	r := &Resolver{
+		kvstore: config.KVStore,
+		logger:  config.Logger,
+		timeNow: time.Now,
+		timeout: config.Timeout,
+	}
+	if r.logger == nil {
+		r.logger = model.DiscardLogger
 	}
+	if r.timeout <= 0 {
+		r.timeout = DefaultTimeout
+	}
+	URLs := config.URLs
+	if len(URLs) <= 0 {
+		URLs = DefaultURLs
+	}
+	for _, URL := range URLs {
+		client, err := netx.NewDNSClientWithOverrides(
+			config.HTTPConfig, URL, hostOverrides[URL], "")
+		if err != nil {
+			r.CloseIdleConnections()
+			return nil, err
+		}
+		r.entries = append(r.entries, &entry{client: client, stats: Stats{URL: URL}})
+	}
+	r.load()
+	r.cache = newCache(config.KVStore, r.logger)
+	return r, nil
 }
 
 // CloseIdleConnections closes the idle connections, if any
 func (r *Resolver) CloseIdleConnections() {
-	r.Primary.CloseIdleConnections()
-	r.Fallback.CloseIdleConnections()
+	for _, e := range r.entries {
+		e.client.CloseIdleConnections()
+	}
 }
 
-// Stats returns stats about the session resolver.
-func (r *Resolver) Stats() string {
-	return fmt.Sprintf("sessionresolver: failure rate: primary: %d/%d; fallback: %d/%d",
-		r.PrimaryFailure.Load(), r.PrimaryQuery.Load(),
-		r.FallbackFailure.Load(), r.FallbackQuery.Load())
+// Stats returns stats about the resolvers, sorted by rank.
+func (r *Resolver) Stats() []Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []Stats
+	for _, e := range r.sortedLocked() {
+		out = append(out, e.stats)
+	}
+	return out
 }
 
-// LookupHost implements Resolver.LookupHost
+// LookupHost implements Resolver.LookupHost. We return the cached
+// addresses, if fresh. Otherwise, we try the resolvers in order of
+// rank and we return the first successful result. If all resolvers
+// fail, we return expired cached addresses, if any, or the error of
+// the last resolver we tried.
 func (r *Resolver) LookupHost(ctx context.Context, hostname string) ([]string, error) {
-	// Algorithm similar to Firefox TRR2 mode. See:
-	// https://wiki.mozilla.org/Trusted_Recursive_Resolver#DNS-over-HTTPS_Prefs_in_Firefox
-	// We use a higher timeout than Firefox's timeout (1.5s) to be on the safe side
-	// and therefore see to use DoH more often.
-	r.PrimaryQuery.Add(1)
-	trr2, cancel := context.WithTimeout(ctx, 4*time.Second)
+	if addrs, found := r.cache.get(hostname, false); found {
+		return addrs, nil
+	}
+	r.mu.Lock()
+	entries := r.reprobeLocked(r.sortedLocked())
+	r.mu.Unlock()
+	var err error
+	for _, e := range entries {
+		var (
+			addrs []string
+			ttl   time.Duration
+		)
+		addrs, ttl, err = r.lookupHost(ctx, e, hostname)
+		if err == nil {
+			r.cache.set(hostname, addrs, ttl)
+			return addrs, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err // no point in trying other resolvers
+		}
+	}
+	if addrs, found := r.cache.get(hostname, true); found {
+		r.logger.Warnf("sessionresolver: using expired cache entry for %s", hostname)
+		return addrs, nil
+	}
+	return nil, err
+}
+
+func (r *Resolver) lookupHost(
+	ctx context.Context, e *entry, hostname string) ([]string, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
 	defer cancel()
-	addrs, err := r.Primary.LookupHost(trr2, hostname)
-	if err != nil {
-		r.PrimaryFailure.Add(1)
-		r.FallbackQuery.Add(1)
-		addrs, err = r.Fallback.LookupHost(ctx, hostname)
+	start := r.timeNow()
+	addrs, ttl, err := lookupHostWithTTL(ctx, e.client, hostname)
+	elapsed := r.timeNow().Sub(start)
+	r.logger.Debugf("sessionresolver: %s: %+v, %+v in %s", e.stats.URL, addrs, err, elapsed)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e.stats.Queries++
+	score := e.stats.decayedScore(start)
+	switch {
+	case err == nil:
+		e.stats.Score = ewma(score, 1)
+		e.stats.Latency = time.Duration(ewma(float64(e.stats.Latency), float64(elapsed)))
+	case isNotFound(err):
+		// The resolver is working, even though the domain does not exist
+		// or it is being censored. So, we do not count a failure but we
+		// still try with the other resolvers.
+		e.stats.Score = ewma(score, 1)
+	default:
+		e.stats.Failures++
+		e.stats.Score = ewma(score, 0)
+	}
+	e.stats.Updated = start
+	r.saveLocked()
+	return addrs, ttl, err
+}
+
+// lookupHostWithTTL is like LookupHost but also returns the TTL of
+// the addresses, i.e., the minimum TTL of the A and AAAA records. As for
+// LookupHost, we only fail if both the A and the AAAA lookups fail. We
+// also fail with ErrOODNSNoAnswer if we did not find any address, which
+// happens, e.g., when one lookup fails and the other only returns a CNAME.
+func lookupHostWithTTL(
+	ctx context.Context, client dnsclient, hostname string) ([]string, time.Duration, error) {
+	if client.Network() == "system" {
+		addrs, err := client.LookupHost(ctx, hostname)
+		if err == nil && len(addrs) <= 0 {
+			err = errorx.ErrOODNSNoAnswer
+		}
+		return addrs, systemTTL, err
+	}
+	var (
+		addrs []string
+		errs  []error
+		ttl   uint32 = math.MaxUint32
+	)
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		records, err := client.LookupRecords(ctx, hostname, qtype)
 		if err != nil {
-			r.FallbackFailure.Add(1)
+			errs = append(errs, err)
+			continue
+		}
+		for _, record := range records {
+			if record.Type != "A" && record.Type != "AAAA" {
+				continue // e.g. CNAME
+			}
+			addrs = append(addrs, record.Value)
+			if record.TTL < ttl {
+				ttl = record.TTL
+			}
+		}
+	}
+	if len(errs) == 2 {
+		return nil, 0, errs[0]
+	}
+	if len(addrs) <= 0 {
+		return nil, 0, errorx.ErrOODNSNoAnswer
+	}
+	return addrs, time.Duration(ttl) * time.Second, nil
+}
+
+// ewma returns the exponentially weighted moving average of
+// the previous average and the current value.
+func ewma(avg, value float64) float64 {
+	return (1-ewmaAlpha)*avg + ewmaAlpha*value
+}
+
+// isNotFound returns whether err indicates that a domain does not exist.
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsNotFound
+	}
+	return errors.Is(err, errorx.ErrOODNSNoSuchHost) || errors.Is(err, errorx.ErrOODNSNoAnswer)
+}
+
+// sortedLocked returns the entries sorted by tier and then by rank.
+func (r *Resolver) sortedLocked() []*entry {
+	now := r.timeNow()
+	entries := append([]*entry{}, r.entries...)
+	sort.SliceStable(entries, func(i, j int) bool {
+		ti, tj := entries[i].stats.tier(now), entries[j].stats.tier(now)
+		if ti != tj {
+			return ti > tj
+		}
+		return entries[i].stats.rank(now) > entries[j].stats.rank(now)
+	})
+	return entries
+}
+
+// reprobeLocked takes the entries sorted by rank and moves to the front
+// the entry we have not queried for the longest time, if we have not
+// queried it for more than reprobeInterval. Without doing that, we would
+// never query again a resolver that failed while another one works, and
+// so we would not notice if the failure was only temporary. We do not
+// reprobe before we have queried the best resolver, so that we initially
+// query the resolvers in the configured order.
+func (r *Resolver) reprobeLocked(entries []*entry) []*entry {
+	if len(entries) <= 1 || entries[0].stats.Updated.IsZero() {
+		return entries
+	}
+	now, idx := r.timeNow(), 0
+	for i := 1; i < len(entries); i++ {
+		if now.Sub(entries[i].stats.Updated) < reprobeInterval {
+			continue
+		}
+		if idx == 0 || entries[i].stats.Updated.Before(entries[idx].stats.Updated) {
+			idx = i
 		}
 	}
-	return addrs, err
+	if idx == 0 {
+		return entries
+	}
+	r.logger.Debugf("sessionresolver: reprobing %s", entries[idx].stats.URL)
+	out := []*entry{entries[idx]}
+	out = append(out, entries[:idx]...)
+	return append(out, entries[idx+1:]...)
+}
+
+// load loads the state of the resolvers. When we cannot load it
+// or a resolver has no state, we start with priorScore, so that
+// we initially use the resolvers in the configured order.
+func (r *Resolver) load() {
+	for _, e := range r.entries {
+		e.stats.Score = priorScore
+	}
+	if r.kvstore == nil {
+		return
+	}
+	data, err := r.kvstore.Get(stateKey)
+	if err != nil {
+		return
+	}
+	var state map[string]Stats
+	if err := json.Unmarshal(data, &state); err != nil {
+		r.logger.Warnf("sessionresolver: cannot parse state: %s", err.Error())
+		return
+	}
+	for _, e := range r.entries {
+		if stats, found := state[e.stats.URL]; found {
+			stats.URL = e.stats.URL
+			e.stats = stats
+		}
+	}
+}
+
+// saveLocked saves the state of the resolvers.
+func (r *Resolver) saveLocked() {
+	if r.kvstore == nil {
+		return
+	}
+	state := make(map[string]Stats)
+	for _, e := range r.entries {
+		state[e.stats.URL] = e.stats
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		r.logger.Warnf("sessionresolver: cannot serialize state: %s", err.Error())
+		return
+	}
+	if err := r.kvstore.Set(stateKey, data); err != nil {
+		r.logger.Warnf("sessionresolver: cannot save state: %s", err.Error())
+	}
 }
 
 // Network implements Resolver.Network
@@ -0,0 +1,392 @@
+package sessionresolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/ooni/probe-engine/internal/kvstore"
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx/errorx"
+	"github.com/ooni/probe-engine/netx/trace"
+)
+
+type FakeDNSClient struct {
+	Addrs       []string
+	Closed      bool
+	Err         error
+	NetworkName string
+	Queries     int
+	TTL         uint32
+}
+
+func (c *FakeDNSClient) LookupHost(ctx context.Context, hostname string) ([]string, error) {
+	c.Queries++
+	return c.Addrs, c.Err
+}
+
+func (c *FakeDNSClient) LookupRecords(
+	ctx context.Context, domain string, qtype uint16) ([]trace.DNSRecord, error) {
+	c.Queries++
+	if c.Err != nil {
+		return nil, c.Err
+	}
+	var out []trace.DNSRecord
+	for _, addr := range c.Addrs {
+		isIPv6 := strings.Contains(addr, ":")
+		switch {
+		case qtype == dns.TypeA && !isIPv6:
+			out = append(out, trace.DNSRecord{Type: "A", Value: addr, TTL: c.TTL})
+		case qtype == dns.TypeAAAA && isIPv6:
+			out = append(out, trace.DNSRecord{Type: "AAAA", Value: addr, TTL: c.TTL})
+		}
+	}
+	if len(out) <= 0 {
+		return nil, errorx.ErrOODNSNoAnswer
+	}
+	return out, nil
+}
+
+func (c *FakeDNSClient) CloseIdleConnections() {
+	c.Closed = true
+}
+
+func (c *FakeDNSClient) Network() string {
+	return c.NetworkName
+}
+
+func newResolverWithFakes(kvs model.KeyValueStore, clients map[string]dnsclient, URLs ...string) *Resolver {
+	r := &Resolver{
+		kvstore: kvs,
+		logger:  model.DiscardLogger,
+		timeNow: time.Now,
+		timeout: DefaultTimeout,
+	}
+	for _, URL := range URLs {
+		r.entries = append(r.entries, &entry{client: clients[URL], stats: Stats{URL: URL}})
+	}
+	r.load()
+	r.cache = newCache(kvs, r.logger)
+	return r
+}
+
+func TestRankingPrefersWorkingResolvers(t *testing.T) {
+	kvs := kvstore.NewMemoryKeyValueStore()
+	blocked := &FakeDNSClient{Err: errors.New("generic_timeout_error")}
+	working := &FakeDNSClient{Addrs: []string{"1.1.1.1"}}
+	clients := map[string]dnsclient{"doh://blocked": blocked, "doh://working": working}
+	r := newResolverWithFakes(kvs, clients, "doh://blocked", "doh://working")
+	addrs, err := r.LookupHost(context.Background(), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 1 || addrs[0] != "1.1.1.1" {
+		t.Fatal("not the addrs we expected")
+	}
+	stats := r.Stats()
+	if stats[0].URL != "doh://working" || stats[1].URL != "doh://blocked" {
+		t.Fatal("not the ranking we expected", stats)
+	}
+	if stats[1].Failures != 1 || stats[1].Queries != 1 {
+		t.Fatal("unexpected stats for the blocked resolver")
+	}
+	if stats[0].Failures != 0 || stats[0].Queries != 1 {
+		t.Fatal("unexpected stats for the working resolver")
+	}
+	// The ranking must survive a new resolver using the same kvstore and
+	// we should not query again the blocked resolver before reprobeInterval.
+	r = newResolverWithFakes(kvs, clients, "doh://blocked", "doh://working")
+	if _, err := r.LookupHost(context.Background(), "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	stats = r.Stats()
+	if stats[0].URL != "doh://working" || stats[0].Queries != 2 {
+		t.Fatal("unexpected stats for the working resolver", stats)
+	}
+	if stats[1].Queries != 1 {
+		t.Fatal("we should not have queried the blocked resolver", stats)
+	}
+	r.CloseIdleConnections()
+	if !blocked.Closed || !working.Closed {
+		t.Fatal("we did not close idle connections")
+	}
+}
+
+func TestRankingReprobesResolversThatFailed(t *testing.T) {
+	kvs := kvstore.NewMemoryKeyValueStore()
+	flaky := &FakeDNSClient{Err: errors.New("generic_timeout_error")}
+	working := &FakeDNSClient{Addrs: []string{"1.1.1.1"}}
+	clients := map[string]dnsclient{"doh://flaky": flaky, "doh://working": working}
+	r := newResolverWithFakes(kvs, clients, "doh://flaky", "doh://working")
+	now := time.Now()
+	r.timeNow = func() time.Time { return now }
+	if _, err := r.LookupHost(context.Background(), "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	// The flaky resolver works again, but we do not query it until
+	// reprobeInterval has elapsed since we last queried it.
+	flaky.Err, flaky.Addrs = nil, []string{"1.1.1.1"}
+	if _, err := r.LookupHost(context.Background(), "example.org"); err != nil {
+		t.Fatal(err)
+	}
+	if r.entries[0].stats.Queries != 1 {
+		t.Fatal("we should not have queried the flaky resolver", r.Stats())
+	}
+	// After enough reprobes, the flaky resolver is healthy again and
+	// hence we use it again, because it comes first in the configuration.
+	for i := 0; i < 16 && r.Stats()[0].URL != "doh://flaky"; i++ {
+		now = now.Add(reprobeInterval)
+		domain := fmt.Sprintf("www%d.example.com", i)
+		if _, err := r.LookupHost(context.Background(), domain); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if r.entries[0].stats.Queries <= 1 {
+		t.Fatal("we did not reprobe the flaky resolver", r.Stats())
+	}
+	if stats := r.Stats(); stats[0].URL != "doh://flaky" {
+		t.Fatal("the flaky resolver did not recover its rank", stats)
+	}
+}
+
+func TestScoreDecaysTowardPrior(t *testing.T) {
+	now := time.Now()
+	st := Stats{Score: 0.1, Updated: now}
+	if st.decayedScore(now) != 0.1 {
+		t.Fatal("the score should not decay immediately")
+	}
+	decayed := st.decayedScore(now.Add(scoreHalfLife))
+	if decayed < 0.2999 || decayed > 0.3001 {
+		t.Fatal("unexpected decayed score", decayed)
+	}
+	if decayed := st.decayedScore(now.Add(100 * scoreHalfLife)); decayed < 0.4999 {
+		t.Fatal("the score should eventually go back to the prior", decayed)
+	}
+}
+
+func TestRankingConsidersLatency(t *testing.T) {
+	r := newResolverWithFakes(nil, map[string]dnsclient{
+		"doh://slow": &FakeDNSClient{Addrs: []string{"1.1.1.1"}},
+		"doh://fast": &FakeDNSClient{Addrs: []string{"1.1.1.1"}},
+	}, "doh://slow", "doh://fast")
+	for _, e := range r.entries {
+		e.stats.Score = 1
+	}
+	r.entries[0].stats.Latency = 2 * time.Second
+	r.entries[1].stats.Latency = 50 * time.Millisecond
+	stats := r.Stats()
+	if stats[0].URL != "doh://fast" {
+		t.Fatal("not the ranking we expected", stats)
+	}
+}
+
+func TestRankingKeepsSlowWorkingResolver(t *testing.T) {
+	r := newResolverWithFakes(nil, map[string]dnsclient{
+		"doh://slow":    &FakeDNSClient{Addrs: []string{"1.1.1.1"}},
+		"doh://untried": &FakeDNSClient{Addrs: []string{"1.1.1.1"}},
+	}, "doh://slow", "doh://untried")
+	now := time.Now()
+	r.timeNow = func() time.Time {
+		now = now.Add(2 * time.Second) // the lookup takes two seconds
+		return now
+	}
+	if _, err := r.LookupHost(context.Background(), "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if r.entries[1].stats.Queries != 0 {
+		t.Fatal("we should not have queried the untried resolver", r.Stats())
+	}
+	if stats := r.Stats(); stats[0].URL != "doh://slow" {
+		t.Fatal("we prefer a resolver we never queried", stats)
+	}
+}
+
+func TestNotFoundIsNotAFailure(t *testing.T) {
+	r := newResolverWithFakes(nil, map[string]dnsclient{
+		"doh://first":   &FakeDNSClient{Err: errorx.ErrOODNSNoSuchHost},
+		"system:///":    &FakeDNSClient{Err: &net.DNSError{IsNotFound: true, Err: "no such host"}},
+		"doh://unknown": &FakeDNSClient{Err: errors.New("mocked error")},
+	}, "doh://first", "system:///", "doh://unknown")
+	_, err := r.LookupHost(context.Background(), "antani.ooni.nu")
+	if err == nil || err.Error() != "mocked error" {
+		t.Fatal("not the error we expected", err)
+	}
+	for _, st := range r.Stats() {
+		if st.Queries != 1 {
+			t.Fatal("we did not query all resolvers")
+		}
+		if st.URL != "doh://unknown" && st.Failures != 0 {
+			t.Fatal("not found should not count as failure")
+		}
+	}
+}
+
+func TestLookupHostStopsWhenContextIsDone(t *testing.T) {
+	r := newResolverWithFakes(nil, map[string]dnsclient{
+		"doh://first":  &FakeDNSClient{Err: context.Canceled},
+		"doh://second": &FakeDNSClient{Addrs: []string{"1.1.1.1"}},
+	}, "doh://first", "doh://second")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := r.LookupHost(ctx, "example.com")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatal("not the error we expected", err)
+	}
+	if r.entries[1].stats.Queries != 0 {
+		t.Fatal("we should not have queried the second resolver")
+	}
+}
+
+func TestLoadWithInvalidState(t *testing.T) {
+	kvs := kvstore.NewMemoryKeyValueStore()
+	if err := kvs.Set(stateKey, []byte("{")); err != nil {
+		t.Fatal(err)
+	}
+	r := newResolverWithFakes(kvs, map[string]dnsclient{
+		"system:///": &FakeDNSClient{},
+	}, "system:///")
+	if r.entries[0].stats.Score != 0.5 {
+		t.Fatal("expected the default score")
+	}
+}
+
+func TestCacheHonoursTTL(t *testing.T) {
+	client := &FakeDNSClient{Addrs: []string{"1.1.1.1", "::1"}, TTL: 60}
+	r := newResolverWithFakes(nil, map[string]dnsclient{
+		"doh://first": client,
+	}, "doh://first")
+	now := time.Now()
+	r.cache.timeNow = func() time.Time { return now }
+	for i := 0; i < 2; i++ {
+		addrs, err := r.LookupHost(context.Background(), "example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(addrs) != 2 || addrs[0] != "1.1.1.1" || addrs[1] != "::1" {
+			t.Fatal("not the addrs we expected", addrs)
+		}
+	}
+	if client.Queries != 2 {
+		t.Fatal("expected the second lookup to use the cache", client.Queries)
+	}
+	r.cache.timeNow = func() time.Time { return now.Add(61 * time.Second) }
+	if _, err := r.LookupHost(context.Background(), "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if client.Queries != 4 {
+		t.Fatal("expected the cache entry to have expired", client.Queries)
+	}
+}
+
+func TestCacheUsesSystemTTL(t *testing.T) {
+	client := &FakeDNSClient{Addrs: []string{"1.1.1.1"}, NetworkName: "system"}
+	r := newResolverWithFakes(nil, map[string]dnsclient{
+		"system:///": client,
+	}, "system:///")
+	if _, err := r.LookupHost(context.Background(), "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := r.cache.get("example.com", false); !found {
+		t.Fatal("expected to find a fresh cache entry")
+	}
+	r.cache.timeNow = func() time.Time { return time.Now().Add(systemTTL) }
+	if _, found := r.cache.get("example.com", false); found {
+		t.Fatal("expected the cache entry to have expired")
+	}
+}
+
+func TestCacheIsPersistedAndUsedWhenAllResolversFail(t *testing.T) {
+	kvs := kvstore.NewMemoryKeyValueStore()
+	client := &FakeDNSClient{Addrs: []string{"1.1.1.1"}, TTL: 60}
+	clients := map[string]dnsclient{"doh://first": client}
+	r := newResolverWithFakes(kvs, clients, "doh://first")
+	if _, err := r.LookupHost(context.Background(), "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	// A new resolver using the same kvstore sees the expired entry and
+	// uses it because the resolver is now failing.
+	client.Err = errors.New("generic_timeout_error")
+	r = newResolverWithFakes(kvs, clients, "doh://first")
+	r.cache.timeNow = func() time.Time { return time.Now().Add(time.Hour) }
+	addrs, err := r.LookupHost(context.Background(), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 1 || addrs[0] != "1.1.1.1" {
+		t.Fatal("not the addrs we expected", addrs)
+	}
+	if r.entries[0].stats.Failures != 1 {
+		t.Fatal("expected to have queried the resolver")
+	}
+	// After cacheMaxStale we stop using the expired entry.
+	r.cache.timeNow = func() time.Time { return time.Now().Add(cacheMaxStale + time.Hour) }
+	if _, err := r.LookupHost(context.Background(), "example.com"); err == nil {
+		t.Fatal("expected an error here")
+	}
+}
+
+func TestCacheWithInvalidState(t *testing.T) {
+	kvs := kvstore.NewMemoryKeyValueStore()
+	if err := kvs.Set(cacheKey, []byte("{")); err != nil {
+		t.Fatal(err)
+	}
+	c := newCache(kvs, model.DiscardLogger)
+	if len(c.entries) != 0 {
+		t.Fatal("expected an empty cache")
+	}
+}
+
+type FakeCNAMEOnlyDNSClient struct {
+	FakeDNSClient
+}
+
+func (c *FakeCNAMEOnlyDNSClient) LookupRecords(
+	ctx context.Context, domain string, qtype uint16) ([]trace.DNSRecord, error) {
+	c.Queries++
+	if qtype == dns.TypeA {
+		return nil, errors.New("generic_timeout_error")
+	}
+	return []trace.DNSRecord{{Type: "CNAME", Value: "www.example.com.", TTL: 60}}, nil
+}
+
+func TestLookupWithoutAddressesFailsAndIsNotCached(t *testing.T) {
+	client := &FakeCNAMEOnlyDNSClient{}
+	r := newResolverWithFakes(nil, map[string]dnsclient{
+		"doh://first": client,
+	}, "doh://first")
+	addrs, err := r.LookupHost(context.Background(), "example.com")
+	if !errors.Is(err, errorx.ErrOODNSNoAnswer) {
+		t.Fatal("not the error we expected", err)
+	}
+	if len(addrs) != 0 {
+		t.Fatal("expected no addrs here", addrs)
+	}
+	if _, found := r.cache.get("example.com", true); found {
+		t.Fatal("we cached an empty result")
+	}
+}
+
+func TestSystemLookupWithoutAddressesFails(t *testing.T) {
+	r := newResolverWithFakes(nil, map[string]dnsclient{
+		"system:///": &FakeDNSClient{NetworkName: "system"},
+	}, "system:///")
+	if _, err := r.LookupHost(context.Background(), "example.com"); !errors.Is(err, errorx.ErrOODNSNoAnswer) {
+		t.Fatal("not the error we expected", err)
+	}
+	if _, found := r.cache.get("example.com", true); found {
+		t.Fatal("we cached an empty result")
+	}
+}
+
+func TestCacheIgnoresEmptyResults(t *testing.T) {
+	c := newCache(nil, model.DiscardLogger)
+	c.set("example.com", nil, time.Minute)
+	if _, found := c.get("example.com", true); found {
+		t.Fatal("we cached an empty result")
+	}
+}
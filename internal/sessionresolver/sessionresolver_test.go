@@ -5,12 +5,19 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/ooni/probe-engine/internal/kvstore"
 	"github.com/ooni/probe-engine/internal/sessionresolver"
 	"github.com/ooni/probe-engine/netx"
 )
 
 func TestFallbackWorks(t *testing.T) {
-	reso := sessionresolver.New(netx.Config{})
+	reso, err := sessionresolver.New(sessionresolver.Config{
+		HTTPConfig: netx.Config{},
+		KVStore:    kvstore.NewMemoryKeyValueStore(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
 	defer reso.CloseIdleConnections()
 	if reso.Network() != "sessionresolver" {
 		t.Fatal("unexpected Network")
@@ -25,7 +32,25 @@ func TestFallbackWorks(t *testing.T) {
 	if addrs != nil {
 		t.Fatal("expected nil addrs here")
 	}
-	if reso.PrimaryFailure.Load() != 1 || reso.FallbackFailure.Load() != 1 {
-		t.Fatal("not the counters we expected to see here")
+	stats := reso.Stats()
+	if len(stats) != len(sessionresolver.DefaultURLs) {
+		t.Fatal("unexpected number of stats")
+	}
+	for _, st := range stats {
+		if st.Queries != 1 {
+			t.Fatal("we did not try all the resolvers")
+		}
+	}
+}
+
+func TestNewWithInvalidURL(t *testing.T) {
+	reso, err := sessionresolver.New(sessionresolver.Config{
+		URLs: []string{"system:///", "antani:///"},
+	})
+	if err == nil || err.Error() != "unsupported resolver scheme" {
+		t.Fatal("not the error we expected", err)
+	}
+	if reso != nil {
+		t.Fatal("expected nil resolver here")
 	}
 }
@@ -30,6 +30,7 @@ import (
 	"github.com/ooni/probe-engine/internal/humanizex"
 	"github.com/ooni/probe-engine/model"
 	"github.com/ooni/probe-engine/netx/selfcensor"
+	"github.com/ooni/probe-engine/probeservices"
 	"github.com/ooni/probe-engine/version"
 	"github.com/pborman/getopt/v2"
 )
@@ -41,12 +42,26 @@ type Options struct {
 	HomeDir          string
 	Inputs           []string
 	InputFilePaths   []string
+	InputOrder       string
+	MaxPerCategory   int
+	MaxRetries       int
 	NoJSON           bool
 	NoCollector      bool
+	OnError          string
+	Parallelism      int
 	ProbeServicesURL string
 	Proxy            string
 	ReportFile       string
+	ReportFormat     string
+	RotateInterval   time.Duration
+	RotateSize       int64
+	RunID            string
+	Seed             int64
+	SelfCensorFile   string
 	SelfCensorSpec   string
+	SinkBatchSize    int
+	SinkURL          string
+	TestListsMaxAge  time.Duration
 	TorArgs          []string
 	TorBinary        string
 	Tunnel           string
@@ -73,7 +88,7 @@ func init() {
 	)
 	getopt.FlagLong(
 		&globalOptions.InputFilePaths, "input-file", 'f',
-		"Path to input file to supply test-dependent input. File must contain one input per line.", "PATH",
+		"Path to input file to supply test-dependent input (text, citizenlab CSV, or JSON; use - for stdin).", "PATH",
 	)
 	getopt.FlagLong(
 		&globalOptions.HomeDir, "home", 0,
@@ -83,12 +98,32 @@ func init() {
 		&globalOptions.Inputs, "input", 'i',
 		"Add test-dependent input to the test input", "INPUT",
 	)
+	getopt.FlagLong(
+		&globalOptions.InputOrder, "input-order", 0,
+		"Order inputs (one of `shuffle`, `least_recently_measured`)", "ORDER",
+	)
+	getopt.FlagLong(
+		&globalOptions.MaxPerCategory, "max-per-category", 0,
+		"Measure at most N inputs for each category code", "N",
+	)
+	getopt.FlagLong(
+		&globalOptions.MaxRetries, "max-retries", 0,
+		"Retry a failing stage up to N times with --on-error retry", "N",
+	)
 	getopt.FlagLong(
 		&globalOptions.NoJSON, "no-json", 'N', "Disable writing to disk",
 	)
 	getopt.FlagLong(
 		&globalOptions.NoCollector, "no-collector", 'n', "Don't use a collector",
 	)
+	getopt.FlagLong(
+		&globalOptions.OnError, "on-error", 0,
+		"What to do when an input fails (one of `abort`, `continue`, `retry`)", "POLICY",
+	)
+	getopt.FlagLong(
+		&globalOptions.Parallelism, "parallelism", 0,
+		"Number of inputs to measure in parallel", "N",
+	)
 	getopt.FlagLong(
 		&globalOptions.ProbeServicesURL, "probe-services", 0,
 		"Set the URL of the probe-services instance you want to use", "URL",
@@ -100,10 +135,46 @@ func init() {
 		&globalOptions.ReportFile, "reportfile", 'o',
 		"Set the report file path", "PATH",
 	)
+	getopt.FlagLong(
+		&globalOptions.ReportFormat, "report-format", 0,
+		"Set the report format (one of `jsonl`, `jsonl.gz`, `dir`)", "FORMAT",
+	)
+	getopt.FlagLong(
+		&globalOptions.RotateInterval, "rotate-interval", 0,
+		"Rotate the report file after the given amount of time", "DURATION",
+	)
+	getopt.FlagLong(
+		&globalOptions.RotateSize, "rotate-size", 0,
+		"Rotate the report file before it exceeds the given size", "BYTES",
+	)
+	getopt.FlagLong(
+		&globalOptions.RunID, "run-id", 0,
+		"Checkpoint the run progress under ID and resume it if interrupted", "ID",
+	)
+	getopt.FlagLong(
+		&globalOptions.Seed, "seed", 0,
+		"Seed for shuffling inputs with --input-order shuffle", "N",
+	)
+	getopt.FlagLong(
+		&globalOptions.SelfCensorFile, "self-censor-file", 0,
+		"Enable and configure self censorship using a JSON or YAML file", "FILE",
+	)
 	getopt.FlagLong(
 		&globalOptions.SelfCensorSpec, "self-censor-spec", 0,
 		"Enable and configure self censorship", "JSON",
 	)
+	getopt.FlagLong(
+		&globalOptions.SinkBatchSize, "sink-batch-size", 0,
+		"Number of measurements to send to the sink together", "N",
+	)
+	getopt.FlagLong(
+		&globalOptions.SinkURL, "sink", 0,
+		"Also stream measurements to the given http(s) or unix URL", "URL",
+	)
+	getopt.FlagLong(
+		&globalOptions.TestListsMaxAge, "test-lists-max-age", 0,
+		"Use the cached test lists if younger than the given age", "DURATION",
+	)
 	getopt.FlagLong(
 		&globalOptions.TorArgs, "tor-args", 0,
 		"Extra args for tor binary (may be specified multiple times)",
@@ -230,13 +301,32 @@ func MainWithConfiguration(experimentName string, currentOptions Options) {
 
 	err := selfcensor.MaybeEnable(currentOptions.SelfCensorSpec)
 	fatalOnError(err, "cannot parse --self-censor-spec argument")
+	err = selfcensor.MaybeEnableFromFile(currentOptions.SelfCensorFile)
+	fatalOnError(err, "cannot load --self-censor-file argument")
+
+	onError := engine.InputProcessorErrorPolicy(currentOptions.OnError)
+	switch onError {
+	case "":
+		onError = engine.InputProcessorContinueOnError
+	case engine.InputProcessorAbortOnError, engine.InputProcessorContinueOnError,
+		engine.InputProcessorRetryOnError:
+	default:
+		fatalWithString("invalid --on-error argument")
+	}
 
 	logger := &log.Logger{Level: log.InfoLevel, Handler: &logHandler{Writer: os.Stderr}}
 	if currentOptions.Verbose {
 		logger.Level = log.DebugLevel
 	}
 	if currentOptions.ReportFile == "" {
-		currentOptions.ReportFile = "report.jsonl"
+		switch engine.SaverBackend(currentOptions.ReportFormat) {
+		case engine.SaverBackendGzipJSONL:
+			currentOptions.ReportFile = "report.jsonl.gz"
+		case engine.SaverBackendDirectory:
+			currentOptions.ReportFile = "report.d"
+		default:
+			currentOptions.ReportFile = "report.jsonl"
+		}
 	}
 	log.Log = logger
 
@@ -301,15 +391,41 @@ func MainWithConfiguration(experimentName string, currentOptions Options) {
 	log.Infof("- resolver's network: %s (%s)", sess.ResolverNetworkName(),
 		sess.ResolverASNString())
 
+	submitQueue := engine.NewSubmitQueue(engine.SubmitQueueConfig{
+		KVStore: kvstore,
+		Logger:  log.Log,
+	})
+	if !currentOptions.NoCollector {
+		flushSubmitQueue(ctx, sess, submitQueue)
+	}
+
 	builder, err := sess.NewExperimentBuilder(experimentName)
 	fatalOnError(err, "cannot create experiment builder")
+	parallelism := currentOptions.Parallelism
+	if parallelism > 1 && builder.Sequential() {
+		log.Warnf("%s: cannot measure in parallel; ignoring --parallelism", experimentName)
+		parallelism = 1
+	}
 
+	inputHistory := engine.NewInputHistory(engine.InputHistoryConfig{
+		ExperimentName: experimentName,
+		KVStore:        sess.KeyValueStore(),
+		Logger:         log.Log,
+	})
 	inputLoader := engine.NewInputLoader(engine.InputLoaderConfig{
-		StaticInputs: currentOptions.Inputs,
-		SourceFiles:  currentOptions.InputFilePaths,
-		InputPolicy:  builder.InputPolicy(),
-		Session:      sess,
-		URLLimit:     17,
+		StaticInputs:    currentOptions.Inputs,
+		SourceFiles:     currentOptions.InputFilePaths,
+		History:         inputHistory,
+		InputPolicy:     builder.InputPolicy(),
+		InputShape:      builder.InputShape(),
+		KVStore:         sess.KeyValueStore(),
+		Logger:          log.Log,
+		MaxPerCategory:  currentOptions.MaxPerCategory,
+		Ordering:        engine.InputOrdering(currentOptions.InputOrder),
+		Seed:            currentOptions.Seed,
+		Session:         sess,
+		TestListsMaxAge: currentOptions.TestListsMaxAge,
+		URLLimit:        17,
 	})
 	inputs, err := inputLoader.Load(context.Background())
 	fatalOnError(err, "cannot load inputs")
@@ -325,36 +441,110 @@ func MainWithConfiguration(experimentName string, currentOptions Options) {
 		)
 	}()
 
+	var checkpoint *engine.InputCheckpoint
+	if currentOptions.RunID != "" {
+		checkpoint = engine.NewInputCheckpoint(engine.InputCheckpointConfig{
+			KVStore: kvstore,
+			Logger:  log.Log,
+			RunID:   currentOptions.RunID,
+		})
+		_, state := checkpoint.Load(inputs)
+		if state.NextIdx > 0 {
+			log.Infof("resuming run %s from input %d", currentOptions.RunID, state.NextIdx+1)
+		}
+		if state.NextIdx > 0 && state.ReportID != "" && !currentOptions.NoCollector {
+			err := experiment.ResumeReportContext(ctx, state.ReportID)
+			warnOnError(err, "cannot resume report; will open a new one")
+		}
+	}
+
 	submitter, err := engine.NewSubmitter(ctx, engine.SubmitterConfig{
 		Enabled:    currentOptions.NoCollector == false,
 		Experiment: experiment,
 		Logger:     log.Log,
+		Queue:      submitQueue,
 	})
 	fatalOnError(err, "cannot create submitter")
 
 	saver, err := engine.NewSaver(engine.SaverConfig{
-		Enabled:    currentOptions.NoJSON == false,
-		Experiment: experiment,
-		FilePath:   currentOptions.ReportFile,
-		Logger:     log.Log,
+		Backend:        engine.SaverBackend(currentOptions.ReportFormat),
+		Enabled:        currentOptions.NoJSON == false,
+		Experiment:     experiment,
+		FilePath:       currentOptions.ReportFile,
+		Logger:         log.Log,
+		RotateInterval: currentOptions.RotateInterval,
+		RotateSize:     currentOptions.RotateSize,
 	})
 	fatalOnError(err, "cannot create saver")
 
+	var sink engine.InputProcessorSaverWrapper
+	if currentOptions.SinkURL != "" {
+		s, err := engine.NewSink(engine.SinkConfig{
+			BatchSize: currentOptions.SinkBatchSize,
+			Logger:    log.Log,
+			URL:       currentOptions.SinkURL,
+		})
+		fatalOnError(err, "cannot create sink")
+		defer func() {
+			warnOnError(s.Close(), "cannot stream measurements to sink")
+		}()
+		sink = engine.NewInputProcessorSaverWrapper(s)
+	}
+
 	inputProcessor := engine.InputProcessor{
 		Annotations: annotations,
+		Checkpoint:  checkpoint,
 		Experiment: &experimentWrapper{
 			child: engine.NewInputProcessorExperimentWrapper(experiment),
 			total: len(inputs),
 		},
-		Inputs:  inputs,
-		Options: currentOptions.ExtraOptions,
-		Saver:   engine.NewInputProcessorSaverWrapper(saver),
+		History:     inputHistory,
+		Inputs:      inputs,
+		MaxRetries:  currentOptions.MaxRetries,
+		OnError:     onError,
+		Options:     currentOptions.ExtraOptions,
+		Parallelism: parallelism,
+		Saver:       engine.NewInputProcessorSaverWrapper(saver),
+		Sink:        sink,
 		Submitter: submitterWrapper{
 			child: engine.NewInputProcessorSubmitterWrapper(submitter),
 		},
 	}
-	err = inputProcessor.Run(ctx)
+	results, err := inputProcessor.RunWithResults(ctx)
 	fatalOnError(err, "inputProcessor.Run failed")
+	var failed int
+	for _, result := range results {
+		if result.Err != nil {
+			log.Warnf("[%d/%d] %s: %s failed: %s", result.Idx+1, len(inputs),
+				result.Input, result.Stage, result.Err.Error())
+			failed++
+		}
+	}
+	log.Infof("processed %d inputs; %d failed", len(results), failed)
+}
+
+// flushSubmitQueue attempts to submit the measurements that we
+// could not submit during previous runs.
+func flushSubmitQueue(ctx context.Context, sess *engine.Session, queue *engine.SubmitQueue) {
+	entries, err := queue.List()
+	if err != nil {
+		warnOnError(err, "cannot read the submit queue")
+		return
+	}
+	if len(entries) <= 0 {
+		return
+	}
+	log.Info("Submitting previously queued measurements; please be patient...")
+	client, err := sess.NewProbeServicesClient(ctx)
+	if err != nil {
+		warnOnError(err, "cannot create probe services client")
+		return
+	}
+	submitter := probeservices.NewSubmitter(client)
+	defer submitter.Close(ctx)
+	count, err := queue.Flush(ctx, submitter, false)
+	warnOnError(err, "cannot flush the submit queue")
+	log.Infof("submitted %d out of %d queued measurements", count, len(entries))
 }
 
 type experimentWrapper struct {
@@ -369,8 +559,8 @@ func (ew *experimentWrapper) MeasureWithContext(
 	}
 	measurement, err := ew.child.MeasureWithContext(ctx, idx, input)
 	warnOnError(err, "measurement failed")
-	// policy: we do not stop the loop if the measurement fails
-	return measurement, nil
+	// policy: the InputProcessor decides what to do according to --on-error
+	return measurement, err
 }
 
 type submitterWrapper struct {
@@ -381,6 +571,6 @@ func (sw submitterWrapper) SubmitAndUpdateMeasurementContext(
 	ctx context.Context, idx int, m *model.Measurement) error {
 	err := sw.child.SubmitAndUpdateMeasurementContext(ctx, idx, m)
 	warnOnError(err, "submitting measurement failed")
-	// policy: we do not stop the loop if measurement submission fails
-	return nil
+	// policy: the InputProcessor decides what to do according to --on-error
+	return err
 }
@@ -12,3 +12,12 @@ func TestSimple(t *testing.T) {
 	}
 	libminiooni.MainWithConfiguration("example", libminiooni.Options{})
 }
+
+func TestInvalidOnError(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a panic here")
+		}
+	}()
+	libminiooni.MainWithConfiguration("example", libminiooni.Options{OnError: "antani"})
+}
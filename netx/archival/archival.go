@@ -20,6 +20,8 @@ import (
 	"github.com/ooni/probe-engine/model"
 	"github.com/ooni/probe-engine/netx/errorx"
 	"github.com/ooni/probe-engine/netx/trace"
+	"golang.org/x/crypto/ocsp"
+	"golang.org/x/net/idna"
 )
 
 // ExtSpec describes a data format extension
@@ -377,35 +379,60 @@ type DNSAnswerEntry struct {
 	IPv4       string  `json:"ipv4,omitempty"`
 	IPv6       string  `json:"ipv6,omitempty"`
 	TTL        *uint32 `json:"ttl"`
+	Value      string  `json:"value,omitempty"`
 }
 
 // DNSQueryEntry is a DNS query with possibly an answer
 type DNSQueryEntry struct {
-	Answers          []DNSAnswerEntry `json:"answers"`
-	DialID           int64            `json:"dial_id,omitempty"`
-	Engine           string           `json:"engine"`
-	Failure          *string          `json:"failure"`
-	Hostname         string           `json:"hostname"`
-	QueryType        string           `json:"query_type"`
-	ResolverHostname *string          `json:"resolver_hostname"`
-	ResolverPort     *string          `json:"resolver_port"`
-	ResolverAddress  string           `json:"resolver_address"`
-	T                float64          `json:"t"`
-	TransactionID    int64            `json:"transaction_id,omitempty"`
+	Answers           []DNSAnswerEntry `json:"answers"`
+	AuthenticatedData bool             `json:"authenticated_data,omitempty"`
+	Authority         []DNSAnswerEntry `json:"authority,omitempty"`
+	DialID            int64            `json:"dial_id,omitempty"`
+	Engine            string           `json:"engine"`
+	Failure           *string          `json:"failure"`
+	Hostname          string           `json:"hostname"`
+	QueryType         string           `json:"query_type"`
+	Rcode             string           `json:"rcode,omitempty"`
+	ResolverHostname  *string          `json:"resolver_hostname"`
+	ResolverPort      *string          `json:"resolver_port"`
+	ResolverAddress   string           `json:"resolver_address"`
+	T                 float64          `json:"t"`
+	TransactionID     int64            `json:"transaction_id,omitempty"`
 }
 
 type dnsQueryType string
 
-// NewDNSQueriesList returns a list of DNS queries.
+// NewDNSQueriesList returns a list of DNS queries. When the events
+// include the DNS round trips, which happens when we're not using the
+// system resolver, we use the decoded replies to also include the CNAME
+// chain, the TTLs, the rcode, the AD bit, and the authority section.
 func NewDNSQueriesList(begin time.Time, events []trace.Event, dbpath string) []DNSQueryEntry {
-	// TODO(bassosimone): add support for CNAME lookups.
 	var out []DNSQueryEntry
+	replies := make(map[string]trace.Event)
 	for _, ev := range events {
+		if ev.Name == "dns_round_trip_done" && ev.DNSQueryType != "" {
+			replies[dnsReplyKey(ev.Hostname, ev.DNSQueryType)] = ev
+			continue
+		}
 		if ev.Name != "resolve_done" {
 			continue
 		}
+		if ev.DNSQueryType != "" {
+			out = append(out, makequeryentryforrecords(begin, ev, replies, dbpath))
+			continue
+		}
 		for _, qtype := range []dnsQueryType{"A", "AAAA"} {
 			entry := qtype.makequeryentry(begin, ev)
+			key := dnsReplyKey(ev.Hostname, string(qtype))
+			if reply, found := replies[key]; found {
+				delete(replies, key)
+				entry.fillfromreply(reply, dbpath)
+				if len(entry.Answers) <= 0 && entry.Failure == nil {
+					continue // as explained below
+				}
+				out = append(out, entry)
+				continue
+			}
 			for _, addr := range ev.Addresses {
 				if qtype.ipoftype(addr) {
 					entry.Answers = append(
@@ -428,6 +455,76 @@ func NewDNSQueriesList(begin time.Time, events []trace.Event, dbpath string) []D
 	return out
 }
 
+// makequeryentryforrecords creates the entry for a lookup of a
+// specific query type (e.g. "HTTPS"). As for addresses lookups, we
+// prefer using the DNS reply, if we have one. Otherwise, we use the
+// records returned by the resolver, whose TTL is not known when we
+// are using the system resolver.
+func makequeryentryforrecords(begin time.Time, ev trace.Event,
+	replies map[string]trace.Event, dbpath string) DNSQueryEntry {
+	entry := dnsQueryType(ev.DNSQueryType).makequeryentry(begin, ev)
+	key := dnsReplyKey(ev.Hostname, ev.DNSQueryType)
+	if reply, found := replies[key]; found {
+		delete(replies, key)
+		entry.fillfromreply(reply, dbpath)
+		return entry
+	}
+	for _, record := range ev.DNSAnswers {
+		answer := makeanswerentryfromrecord(record, dbpath)
+		if record.TTL == 0 {
+			answer.TTL = nil
+		}
+		entry.Answers = append(entry.Answers, answer)
+	}
+	return entry
+}
+
+// dnsReplyKey returns the key we use to match a DNS reply
+// with the lookup that caused us to send the query. Because the
+// reply contains the punycode name, while the lookup contains
+// the name passed by the caller, we convert both to ASCII.
+func dnsReplyKey(hostname, qtype string) string {
+	if ascii, err := idna.ToASCII(hostname); err == nil {
+		hostname = ascii
+	}
+	return strings.ToLower(strings.TrimSuffix(hostname, ".")) + " " + qtype
+}
+
+// fillfromreply fills the entry using the DNS reply saved when
+// performing the DNS round trip. When the rcode indicates an error,
+// we use it as the failure, because it is more specific than the
+// failure of the whole lookup, which we have by default.
+func (entry *DNSQueryEntry) fillfromreply(reply trace.Event, dbpath string) {
+	entry.AuthenticatedData = reply.DNSAuthData
+	entry.Rcode = reply.DNSRcode
+	entry.Answers = nil
+	for _, record := range reply.DNSAnswers {
+		entry.Answers = append(entry.Answers, makeanswerentryfromrecord(record, dbpath))
+	}
+	for _, record := range reply.DNSAuthority {
+		entry.Authority = append(entry.Authority, makeanswerentryfromrecord(record, dbpath))
+	}
+	if err := errorx.NewDNSRcodeError(reply.DNSRcode); err != nil {
+		entry.Failure = NewFailure(err)
+	}
+}
+
+func makeanswerentryfromrecord(record trace.DNSRecord, dbpath string) DNSAnswerEntry {
+	ttl := record.TTL
+	answer := DNSAnswerEntry{AnswerType: record.Type, TTL: &ttl}
+	switch record.Type {
+	case "A", "AAAA":
+		entry := dnsQueryType(record.Type).makeanswerentry(record.Value, dbpath)
+		entry.TTL = &ttl
+		return entry
+	case "CNAME", "NS":
+		answer.Hostname = record.Value
+	default:
+		answer.Value = record.Value
+	}
+	return answer
+}
+
 func (qtype dnsQueryType) ipoftype(addr string) bool {
 	switch qtype {
 	case "A":
@@ -519,39 +616,150 @@ func NewNetworkEventsList(begin time.Time, events []trace.Event) []NetworkEvent
 
 // TLSHandshake contains TLS handshake data
 type TLSHandshake struct {
+	Address            string             `json:"address,omitempty"`
 	CipherSuite        string             `json:"cipher_suite"`
 	ConnID             int64              `json:"conn_id,omitempty"`
+	ECHAccepted        *bool              `json:"ech_accepted,omitempty"`
+	ECHConfigList      string             `json:"ech_config_list,omitempty"`
+	ECHRetryConfigs    string             `json:"ech_retry_configs,omitempty"`
 	Failure            *string            `json:"failure"`
+	Fingerprint        string             `json:"fingerprint,omitempty"`
 	NegotiatedProtocol string             `json:"negotiated_protocol"`
 	NoTLSVerify        bool               `json:"no_tls_verify"`
+	OCSPStapled        *bool              `json:"ocsp_stapled,omitempty"`
+	OCSPStatus         string             `json:"ocsp_status,omitempty"`
 	PeerCertificates   []MaybeBinaryValue `json:"peer_certificates"`
+	Proto              string             `json:"proto,omitempty"`
 	ServerName         string             `json:"server_name"`
 	T                  float64            `json:"t"`
 	TLSVersion         string             `json:"tls_version"`
 	TransactionID      int64              `json:"transaction_id,omitempty"`
+	VerificationError  *TLSVerification   `json:"verification_error,omitempty"`
+}
+
+// TLSVerification explains why the certificate chain presented by the
+// server is not valid. Reason is one of "expired", "not_yet_valid",
+// "name_mismatch", "untrusted_root", and "invalid". IssuerCommonName is
+// the common name of the issuer of the offending certificate, which, in
+// case of "untrusted_root", is the name of the unknown authority.
+type TLSVerification struct {
+	Detail           string `json:"detail"`
+	IssuerCommonName string `json:"issuer_common_name"`
+	Reason           string `json:"reason"`
 }
 
-// NewTLSHandshakesList creates a new TLSHandshakesList
+// NewTLSHandshakesList creates a new TLSHandshakesList. When we offered
+// Encrypted ClientHello, we also include whether the server accepted it
+// and the base64 encoded ECHConfigList and retry configs, if any. When
+// the handshake succeeds, we also include whether the server stapled an
+// OCSP response and its status. When the certificate chain is not valid,
+// we include the details of the verification error.
+//
+// We also include QUIC handshakes (e.g. DoQ and HTTP/3), for which
+// we set Proto to "quic" and Address to the server endpoint.
 func NewTLSHandshakesList(begin time.Time, events []trace.Event) []TLSHandshake {
 	var out []TLSHandshake
 	for _, ev := range events {
-		if ev.Name != "tls_handshake_done" {
+		if ev.Name != "tls_handshake_done" && ev.Name != "quic_handshake_done" {
 			continue
 		}
 		out = append(out, TLSHandshake{
+			Address:            ev.Address,
 			CipherSuite:        ev.TLSCipherSuite,
+			ECHAccepted:        newECHAccepted(ev),
+			ECHConfigList:      base64.StdEncoding.EncodeToString(ev.TLSECHConfigList),
+			ECHRetryConfigs:    base64.StdEncoding.EncodeToString(ev.TLSECHRetryConfigs),
 			Failure:            NewFailure(ev.Err),
+			Fingerprint:        ev.TLSFingerprint,
 			NegotiatedProtocol: ev.TLSNegotiatedProto,
 			NoTLSVerify:        ev.NoTLSVerify,
+			OCSPStapled:        newOCSPStapled(ev),
+			OCSPStatus:         newOCSPStatus(ev),
 			PeerCertificates:   makePeerCerts(ev.TLSPeerCerts),
+			Proto:              ev.Proto,
 			ServerName:         ev.TLSServerName,
 			T:                  ev.Time.Sub(begin).Seconds(),
 			TLSVersion:         ev.TLSVersion,
+			VerificationError:  newTLSVerification(ev),
 		})
 	}
 	return out
 }
 
+func newECHAccepted(ev trace.Event) *bool {
+	if len(ev.TLSECHConfigList) <= 0 {
+		return nil // we did not offer ECH
+	}
+	accepted := ev.TLSECHAccepted
+	return &accepted
+}
+
+func newOCSPStapled(ev trace.Event) *bool {
+	if ev.Err != nil {
+		return nil // we don't know whether the server would have stapled
+	}
+	stapled := len(ev.TLSOCSPResponse) > 0
+	return &stapled
+}
+
+func newOCSPStatus(ev trace.Event) string {
+	if len(ev.TLSOCSPResponse) <= 0 {
+		return ""
+	}
+	var issuer *x509.Certificate
+	if len(ev.TLSPeerCerts) > 1 {
+		issuer = ev.TLSPeerCerts[1]
+	}
+	resp, err := ocsp.ParseResponse(ev.TLSOCSPResponse, issuer)
+	if err != nil {
+		return "invalid"
+	}
+	switch resp.Status {
+	case ocsp.Good:
+		return "good"
+	case ocsp.Revoked:
+		return "revoked"
+	default:
+		return "unknown"
+	}
+}
+
+func newTLSVerification(ev trace.Event) *TLSVerification {
+	var (
+		cert   *x509.Certificate
+		reason string
+	)
+	var x509HostnameError x509.HostnameError
+	var x509UnknownAuthorityError x509.UnknownAuthorityError
+	var x509CertificateInvalidError x509.CertificateInvalidError
+	switch {
+	case errors.As(ev.Err, &x509HostnameError):
+		cert, reason = x509HostnameError.Certificate, "name_mismatch"
+	case errors.As(ev.Err, &x509UnknownAuthorityError):
+		cert, reason = x509UnknownAuthorityError.Cert, "untrusted_root"
+	case errors.As(ev.Err, &x509CertificateInvalidError):
+		cert, reason = x509CertificateInvalidError.Cert, "invalid"
+		if x509CertificateInvalidError.Reason == x509.Expired && cert != nil {
+			reason = "expired"
+			if ev.Time.Before(cert.NotBefore) {
+				reason = "not_yet_valid"
+			}
+		}
+	default:
+		return nil
+	}
+	out := &TLSVerification{Reason: reason}
+	if cert != nil {
+		out.IssuerCommonName = cert.Issuer.CommonName
+	}
+	out.Detail = ev.Err.Error()
+	var errWrapper *errorx.ErrWrapper
+	if errors.As(ev.Err, &errWrapper) && errWrapper.WrappedErr != nil {
+		out.Detail = errWrapper.WrappedErr.Error()
+	}
+	return out
+}
+
 func makePeerCerts(in []*x509.Certificate) (out []MaybeBinaryValue) {
 	for _, e := range in {
 		out = append(out, MaybeBinaryValue{Value: string(e.Raw)})
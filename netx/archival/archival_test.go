@@ -3,6 +3,7 @@ package archival_test
 import (
 	"context"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"errors"
 	"io"
 	"net/http"
@@ -433,6 +434,121 @@ func TestNewDNSQueriesList(t *testing.T) {
 	}
 }
 
+func TestNewDNSQueriesListWithDNSReplies(t *testing.T) {
+	begin := time.Now()
+	events := []trace.Event{{
+		DNSAnswers: []trace.DNSRecord{{
+			Name:  "www.example.com",
+			TTL:   300,
+			Type:  "CNAME",
+			Value: "cdn.example.net",
+		}, {
+			Name:  "cdn.example.net",
+			TTL:   60,
+			Type:  "A",
+			Value: "192.0.2.1",
+		}},
+		DNSAuthData:  true,
+		DNSQueryType: "A",
+		DNSRcode:     "NOERROR",
+		Hostname:     "www.example.com",
+		Name:         "dns_round_trip_done",
+	}, {
+		DNSAuthority: []trace.DNSRecord{{
+			Name:  "example.net",
+			TTL:   3600,
+			Type:  "SOA",
+			Value: "ns1.example.net. admin.example.net. 1 7200 3600 86400 60",
+		}},
+		DNSQueryType: "AAAA",
+		DNSRcode:     "SERVFAIL",
+		Hostname:     "www.example.com",
+		Name:         "dns_round_trip_done",
+	}, {
+		Address:   "1.1.1.1:53",
+		Addresses: []string{"192.0.2.1"},
+		Hostname:  "www.example.com",
+		Name:      "resolve_done",
+		Proto:     "udp",
+		Time:      begin.Add(100 * time.Millisecond),
+	}}
+	ttl := func(v uint32) *uint32 {
+		return &v
+	}
+	want := []archival.DNSQueryEntry{{
+		Answers: []archival.DNSAnswerEntry{{
+			AnswerType: "CNAME",
+			Hostname:   "cdn.example.net",
+			TTL:        ttl(300),
+		}, {
+			AnswerType: "A",
+			IPv4:       "192.0.2.1",
+			TTL:        ttl(60),
+		}},
+		AuthenticatedData: true,
+		Engine:            "udp",
+		Hostname:          "www.example.com",
+		QueryType:         "A",
+		Rcode:             "NOERROR",
+		ResolverAddress:   "1.1.1.1:53",
+		T:                 0.1,
+	}, {
+		Authority: []archival.DNSAnswerEntry{{
+			AnswerType: "SOA",
+			TTL:        ttl(3600),
+			Value:      "ns1.example.net. admin.example.net. 1 7200 3600 86400 60",
+		}},
+		Engine:          "udp",
+		Failure:         archival.NewFailure(errorx.ErrOODNSServfail),
+		Hostname:        "www.example.com",
+		QueryType:       "AAAA",
+		Rcode:           "SERVFAIL",
+		ResolverAddress: "1.1.1.1:53",
+		T:               0.1,
+	}}
+	got := archival.NewDNSQueriesList(begin, events, "")
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatal(diff)
+	}
+	if *got[1].Failure != errorx.FailureDNSServfailError {
+		t.Fatal("unexpected failure", *got[1].Failure)
+	}
+}
+
+func TestNewDNSQueriesListWithIDNAHostname(t *testing.T) {
+	begin := time.Now()
+	events := []trace.Event{{
+		DNSAnswers: []trace.DNSRecord{{
+			Name:  "xn--bcher-kva.example.",
+			TTL:   300,
+			Type:  "A",
+			Value: "192.0.2.1",
+		}},
+		DNSQueryType: "A",
+		DNSRcode:     "NOERROR",
+		Hostname:     "xn--bcher-kva.example.",
+		Name:         "dns_round_trip_done",
+	}, {
+		Address:   "1.1.1.1:53",
+		Addresses: []string{"192.0.2.1"},
+		Hostname:  "Bücher.example",
+		Name:      "resolve_done",
+		Proto:     "udp",
+		Time:      begin.Add(100 * time.Millisecond),
+	}}
+	got := archival.NewDNSQueriesList(begin, events, "")
+	if len(got) != 1 {
+		t.Fatal("unexpected number of entries", len(got))
+	}
+	if got[0].Rcode != "NOERROR" {
+		t.Fatal("we did not use the DNS reply")
+	}
+	if len(got[0].Answers) != 1 || got[0].Answers[0].TTL == nil ||
+		*got[0].Answers[0].TTL != 300 {
+		t.Fatal("unexpected answers", got[0].Answers)
+	}
+}
+
 func TestNewNetworkEventsList(t *testing.T) {
 	begin := time.Now()
 	type args struct {
@@ -507,8 +623,15 @@ func TestNewNetworkEventsList(t *testing.T) {
 	}
 }
 
+var interceptionCert = &x509.Certificate{
+	Issuer:    pkix.Name{CommonName: "Interception CA"},
+	NotBefore: time.Now().Add(-time.Hour),
+	Raw:       []byte("deadbeef"),
+}
+
 func TestNewTLSHandshakesList(t *testing.T) {
 	begin := time.Now()
+	ocspStapled := true
 	type args struct {
 		begin  time.Time
 		events []trace.Event
@@ -537,6 +660,7 @@ func TestNewTLSHandshakesList(t *testing.T) {
 				Err:                io.EOF,
 				NoTLSVerify:        false,
 				TLSCipherSuite:     "SUITE",
+				TLSFingerprint:     "chrome",
 				TLSNegotiatedProto: "h2",
 				TLSPeerCerts: []*x509.Certificate{{
 					Raw: []byte("deadbeef"),
@@ -551,6 +675,7 @@ func TestNewTLSHandshakesList(t *testing.T) {
 		want: []archival.TLSHandshake{{
 			CipherSuite:        "SUITE",
 			Failure:            archival.NewFailure(io.EOF),
+			Fingerprint:        "chrome",
 			NegotiatedProtocol: "h2",
 			NoTLSVerify:        false,
 			PeerCertificates: []archival.MaybeBinaryValue{{
@@ -562,6 +687,180 @@ func TestNewTLSHandshakesList(t *testing.T) {
 			T:          0.055,
 			TLSVersion: "TLSv1.3",
 		}},
+	}, {
+		name: "with QUIC",
+		args: args{
+			begin: begin,
+			events: []trace.Event{{
+				Address:       "8.8.8.8:853",
+				Name:          "quic_handshake_start",
+				Proto:         "quic",
+				TLSServerName: "dns.google",
+				Time:          begin.Add(10 * time.Millisecond),
+			}, {
+				Address:            "8.8.8.8:853",
+				Name:               "quic_handshake_done",
+				Proto:              "quic",
+				TLSCipherSuite:     "TLS_AES_128_GCM_SHA256",
+				TLSNegotiatedProto: "doq",
+				TLSServerName:      "dns.google",
+				TLSVersion:         "TLSv1.3",
+				Time:               begin.Add(55 * time.Millisecond),
+			}},
+		},
+		want: []archival.TLSHandshake{{
+			Address:            "8.8.8.8:853",
+			CipherSuite:        "TLS_AES_128_GCM_SHA256",
+			NegotiatedProtocol: "doq",
+			OCSPStapled:        new(bool),
+			Proto:              "quic",
+			ServerName:         "dns.google",
+			T:                  0.055,
+			TLSVersion:         "TLSv1.3",
+		}},
+	}, {
+		name: "with ECH",
+		args: args{
+			begin: begin,
+			events: []trace.Event{{
+				Name:               "tls_handshake_done",
+				Err:                errors.New("tls: server rejected ECH"),
+				TLSECHConfigList:   []byte("deadbeef"),
+				TLSECHRetryConfigs: []byte("abad1dea"),
+				TLSServerName:      "x.org",
+				Time:               begin.Add(55 * time.Millisecond),
+			}},
+		},
+		want: []archival.TLSHandshake{{
+			ECHAccepted:     new(bool),
+			ECHConfigList:   "ZGVhZGJlZWY=",
+			ECHRetryConfigs: "YWJhZDFkZWE=",
+			Failure:         archival.NewFailure(errors.New("tls: server rejected ECH")),
+			ServerName:      "x.org",
+			T:               0.055,
+		}},
+	}, {
+		name: "with OCSP stapling",
+		args: args{
+			begin: begin,
+			events: []trace.Event{{
+				Name:            "tls_handshake_done",
+				TLSOCSPResponse: []byte("deadbeef"),
+				TLSServerName:   "x.org",
+				Time:            begin.Add(55 * time.Millisecond),
+			}, {
+				Name:          "tls_handshake_done",
+				TLSServerName: "x.org",
+				Time:          begin.Add(55 * time.Millisecond),
+			}},
+		},
+		want: []archival.TLSHandshake{{
+			OCSPStapled: &ocspStapled,
+			OCSPStatus:  "invalid",
+			ServerName:  "x.org",
+			T:           0.055,
+		}, {
+			OCSPStapled: new(bool),
+			ServerName:  "x.org",
+			T:           0.055,
+		}},
+	}, {
+		name: "with verification errors",
+		args: args{
+			begin: begin,
+			events: []trace.Event{{
+				Name: "tls_handshake_done",
+				Err: x509.UnknownAuthorityError{
+					Cert: interceptionCert,
+				},
+				TLSPeerCerts: []*x509.Certificate{interceptionCert},
+				Time:         begin.Add(55 * time.Millisecond),
+			}, {
+				Name: "tls_handshake_done",
+				Err: x509.HostnameError{
+					Certificate: interceptionCert,
+					Host:        "x.org",
+				},
+				Time: begin.Add(55 * time.Millisecond),
+			}, {
+				Name: "tls_handshake_done",
+				Err: x509.CertificateInvalidError{
+					Cert:   interceptionCert,
+					Reason: x509.Expired,
+				},
+				Time: begin.Add(55 * time.Millisecond),
+			}, {
+				Name: "tls_handshake_done",
+				Err: x509.CertificateInvalidError{
+					Cert:   interceptionCert,
+					Reason: x509.Expired,
+				},
+				Time: interceptionCert.NotBefore.Add(-time.Hour),
+			}, {
+				Name: "tls_handshake_done",
+				Err: x509.CertificateInvalidError{
+					Cert:   interceptionCert,
+					Reason: x509.NotAuthorizedToSign,
+				},
+				Time: begin.Add(55 * time.Millisecond),
+			}},
+		},
+		want: []archival.TLSHandshake{{
+			Failure: archival.NewFailure(x509.UnknownAuthorityError{
+				Cert: interceptionCert,
+			}),
+			PeerCertificates: []archival.MaybeBinaryValue{{Value: "deadbeef"}},
+			T:                0.055,
+			VerificationError: &archival.TLSVerification{
+				Detail:           "x509: certificate signed by unknown authority",
+				IssuerCommonName: "Interception CA",
+				Reason:           "untrusted_root",
+			},
+		}, {
+			Failure: archival.NewFailure(x509.HostnameError{
+				Certificate: interceptionCert,
+				Host:        "x.org",
+			}),
+			T: 0.055,
+			VerificationError: &archival.TLSVerification{
+				Detail:           "x509: certificate is not valid for any names, but wanted to match x.org",
+				IssuerCommonName: "Interception CA",
+				Reason:           "name_mismatch",
+			},
+		}, {
+			Failure: archival.NewFailure(x509.CertificateInvalidError{
+				Cert:   interceptionCert,
+				Reason: x509.Expired,
+			}),
+			T: 0.055,
+			VerificationError: &archival.TLSVerification{
+				Detail:           "x509: certificate has expired or is not yet valid: ",
+				IssuerCommonName: "Interception CA",
+				Reason:           "expired",
+			},
+		}, {
+			Failure: archival.NewFailure(x509.CertificateInvalidError{
+				Cert:   interceptionCert,
+				Reason: x509.Expired,
+			}),
+			T: interceptionCert.NotBefore.Add(-time.Hour).Sub(begin).Seconds(),
+			VerificationError: &archival.TLSVerification{
+				Detail:           "x509: certificate has expired or is not yet valid: ",
+				IssuerCommonName: "Interception CA",
+				Reason:           "not_yet_valid",
+			},
+		}, {
+			Failure: archival.NewFailure(x509.CertificateInvalidError{
+				Cert:   interceptionCert,
+				Reason: x509.NotAuthorizedToSign,
+			}),
+			T: 0.055,
+			VerificationError: &archival.TLSVerification{
+				Detail:           "x509: certificate is not authorized to sign other certificates",
+				IssuerCommonName: "Interception CA",
+				Reason:           "invalid",
+			},
+		}},
 	}}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -1066,3 +1365,70 @@ func TestNewFailedOperation(t *testing.T) {
 		})
 	}
 }
+
+func TestNewDNSQueriesListWithRecordsLookup(t *testing.T) {
+	begin := time.Now()
+	events := []trace.Event{{
+		DNSAnswers: []trace.DNSRecord{{
+			Name:  "example.com",
+			TTL:   300,
+			Type:  "HTTPS",
+			Value: "1 . alpn=h3,h2",
+		}},
+		DNSQueryType: "HTTPS",
+		DNSRcode:     "NOERROR",
+		Hostname:     "example.com",
+		Name:         "dns_round_trip_done",
+	}, {
+		Address: "1.1.1.1:53",
+		DNSAnswers: []trace.DNSRecord{{
+			Name:  "example.com",
+			TTL:   300,
+			Type:  "HTTPS",
+			Value: "1 . alpn=h3,h2",
+		}},
+		DNSQueryType: "HTTPS",
+		Hostname:     "example.com",
+		Name:         "resolve_done",
+		Proto:        "udp",
+		Time:         begin.Add(100 * time.Millisecond),
+	}, {
+		DNSAnswers: []trace.DNSRecord{{
+			Name:  "example.com",
+			Type:  "TXT",
+			Value: "v=spf1 -all",
+		}},
+		DNSQueryType: "TXT",
+		Hostname:     "example.com",
+		Name:         "resolve_done",
+		Proto:        "system",
+		Time:         begin.Add(200 * time.Millisecond),
+	}}
+	ttl := uint32(300)
+	want := []archival.DNSQueryEntry{{
+		Answers: []archival.DNSAnswerEntry{{
+			AnswerType: "HTTPS",
+			TTL:        &ttl,
+			Value:      "1 . alpn=h3,h2",
+		}},
+		Engine:          "udp",
+		Hostname:        "example.com",
+		QueryType:       "HTTPS",
+		Rcode:           "NOERROR",
+		ResolverAddress: "1.1.1.1:53",
+		T:               0.1,
+	}, {
+		Answers: []archival.DNSAnswerEntry{{
+			AnswerType: "TXT",
+			Value:      "v=spf1 -all",
+		}},
+		Engine:    "system",
+		Hostname:  "example.com",
+		QueryType: "TXT",
+		T:         0.2,
+	}}
+	got := archival.NewDNSQueriesList(begin, events, "")
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatal(diff)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"net"
 	"strings"
+	"time"
 
 	"github.com/ooni/probe-engine/legacy/netx/dialid"
 	"github.com/ooni/probe-engine/netx/errorx"
@@ -12,9 +13,21 @@ import (
 
 // DNSDialer is a dialer that uses the configured Resolver to resolver a
 // domain name to IP addresses, and the configured Dialer to connect.
+//
+// By default, we try the addresses sequentially, in the order returned
+// by the Resolver, which is what measurements usually want. When the
+// HappyEyeballs flag is set, we instead use RFC8305 Happy Eyeballs.
 type DNSDialer struct {
 	Dialer
 	Resolver Resolver
+
+	// HappyEyeballs enables RFC8305 Happy Eyeballs, i.e., staggered
+	// parallel connection attempts with interleaved address families.
+	HappyEyeballs bool
+
+	// HappyEyeballsDelay is the delay between starting two connection
+	// attempts. If zero, we use DefaultHappyEyeballsDelay.
+	HappyEyeballsDelay time.Duration
 }
 
 // DialContext implements Dialer.DialContext.
@@ -29,6 +42,9 @@ func (d DNSDialer) DialContext(ctx context.Context, network, address string) (ne
 	if err != nil {
 		return nil, err
 	}
+	if d.HappyEyeballs && len(addrs) > 1 {
+		return d.dialHappyEyeballs(ctx, network, addrs, onlyport)
+	}
 	var errorslist []error
 	for _, addr := range addrs {
 		target := net.JoinHostPort(addr, onlyport)
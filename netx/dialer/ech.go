@@ -0,0 +1,61 @@
+package dialer
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/ooni/probe-engine/netx/errorx"
+)
+
+// ErrECHNotSupported indicates that the Go version we have been compiled
+// with does not support Encrypted ClientHello (ECH).
+var ErrECHNotSupported = errors.New("dialer: ECH not supported by this Go version")
+
+// ECHTLSHandshaker is a TLSHandshaker that offers the Encrypted ClientHello
+// (ECH) extension. We use ConfigList, if not empty. Otherwise, we use
+// LookupConfigList, which typically queries the HTTPS record, to obtain the
+// ECHConfigList of config.ServerName. We fail with errorx.ErrECHConfigNotFound
+// if we cannot find an ECHConfigList.
+//
+// We add the ECHConfigList to a copy of config and we pass it to the
+// underlying TLSHandshaker, which must eventually use crypto/tls, so
+// that, e.g., SaverTLSHandshaker can record the ECHConfigList we used,
+// whether the server accepted ECH and the retry configs sent by the
+// server when rejecting ECH. Because of that, you should wrap the
+// SaverTLSHandshaker with this handshaker and not the other way around.
+//
+// Encrypted ClientHello requires Go >= 1.23. When compiled with an older
+// version of Go, this handshaker always fails with ErrECHNotSupported.
+type ECHTLSHandshaker struct {
+	TLSHandshaker
+	ConfigList       []byte
+	LookupConfigList func(ctx context.Context, domain string) ([]byte, error)
+}
+
+// Handshake implements Handshaker.Handshake
+func (h ECHTLSHandshaker) Handshake(
+	ctx context.Context, conn net.Conn, config *tls.Config,
+) (net.Conn, tls.ConnectionState, error) {
+	configList := h.ConfigList
+	if len(configList) <= 0 && h.LookupConfigList != nil {
+		var err error
+		configList, err = h.LookupConfigList(ctx, config.ServerName)
+		if err != nil {
+			return nil, tls.ConnectionState{}, err
+		}
+	}
+	if len(configList) <= 0 {
+		return nil, tls.ConnectionState{}, fmt.Errorf(
+			"%w: %s", errorx.ErrECHConfigNotFound, config.ServerName)
+	}
+	config, err := withECHConfigList(config, configList)
+	if err != nil {
+		return nil, tls.ConnectionState{}, err
+	}
+	return h.TLSHandshaker.Handshake(ctx, conn, config)
+}
+
+var _ TLSHandshaker = ECHTLSHandshaker{}
@@ -0,0 +1,33 @@
+// +build !go1.23
+
+package dialer
+
+import "crypto/tls"
+
+// ECHSupported indicates whether this Go version supports Encrypted
+// ClientHello (ECH). This Go version does not support it.
+const ECHSupported = false
+
+// withECHConfigList always fails because this Go version does
+// not support Encrypted ClientHello.
+func withECHConfigList(config *tls.Config, configList []byte) (*tls.Config, error) {
+	return nil, ErrECHNotSupported
+}
+
+// echConfigList always returns nil because this Go version does
+// not support Encrypted ClientHello.
+func echConfigList(config *tls.Config) []byte {
+	return nil
+}
+
+// echAccepted always returns false because this Go version does
+// not support Encrypted ClientHello.
+func echAccepted(state tls.ConnectionState) bool {
+	return false
+}
+
+// echRetryConfigs always returns nil because this Go version does
+// not support Encrypted ClientHello.
+func echRetryConfigs(err error) []byte {
+	return nil
+}
@@ -0,0 +1,39 @@
+// +build go1.23
+
+package dialer
+
+import (
+	"crypto/tls"
+	"errors"
+)
+
+// ECHSupported indicates whether this Go version supports Encrypted
+// ClientHello (ECH). This Go version supports it.
+const ECHSupported = true
+
+// withECHConfigList returns a copy of config using configList.
+func withECHConfigList(config *tls.Config, configList []byte) (*tls.Config, error) {
+	config = config.Clone()
+	config.EncryptedClientHelloConfigList = configList
+	return config, nil
+}
+
+// echConfigList returns the ECHConfigList used by config, if any.
+func echConfigList(config *tls.Config) []byte {
+	return config.EncryptedClientHelloConfigList
+}
+
+// echAccepted returns whether the server accepted ECH.
+func echAccepted(state tls.ConnectionState) bool {
+	return state.ECHAccepted
+}
+
+// echRetryConfigs returns the retry configs sent by the server
+// when rejecting ECH, if any.
+func echRetryConfigs(err error) []byte {
+	var rejection *tls.ECHRejectionError
+	if errors.As(err, &rejection) {
+		return rejection.RetryConfigList
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+// +build go1.24
+
+package dialer_test
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ooni/probe-engine/netx/dialer"
+	"github.com/ooni/probe-engine/netx/errorx"
+	"github.com/ooni/probe-engine/netx/trace"
+)
+
+// newECHKey creates a new X25519 ECH key and returns it along with
+// the ECHConfigList that clients should use. See draft-ietf-tls-esni.
+func newECHKey(t *testing.T, configID uint8, publicName string) (tls.EncryptedClientHelloKey, []byte) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey := key.PublicKey().Bytes()
+	var contents []byte
+	contents = append(contents, configID, 0x00, 0x20) // DHKEM(X25519, HKDF-SHA256)
+	contents = append(contents, byte(len(publicKey)>>8), byte(len(publicKey)))
+	contents = append(contents, publicKey...)
+	contents = append(contents, 0x00, 0x04, 0x00, 0x01, 0x00, 0x01) // HKDF-SHA256, AES-128-GCM
+	contents = append(contents, 0)                                  // maximum_name_length
+	contents = append(contents, byte(len(publicName)))
+	contents = append(contents, publicName...)
+	contents = append(contents, 0x00, 0x00) // extensions
+	config := []byte{0xfe, 0x0d, byte(len(contents) >> 8), byte(len(contents))}
+	config = append(config, contents...)
+	configList := append([]byte{byte(len(config) >> 8), byte(len(config))}, config...)
+	return tls.EncryptedClientHelloKey{
+		Config:      config,
+		PrivateKey:  key.Bytes(),
+		SendAsRetry: true,
+	}, configList
+}
+
+func echHandshake(t *testing.T, server *httptest.Server, configList []byte) (*trace.Saver, error) {
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	saver := &trace.Saver{}
+	h := dialer.ECHTLSHandshaker{
+		TLSHandshaker: dialer.SaverTLSHandshaker{
+			TLSHandshaker: dialer.SystemTLSHandshaker{},
+			Saver:         saver,
+		},
+		ConfigList: configList,
+	}
+	// When rejecting ECH, we always verify the certificate for the
+	// public name, even when InsecureSkipVerify is true.
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+	tlsconn, _, err := h.Handshake(context.Background(), conn, &tls.Config{
+		RootCAs:    pool,
+		ServerName: "example.com",
+	})
+	if err == nil {
+		tlsconn.Close()
+	}
+	return saver, err
+}
+
+func TestECHTLSHandshakerAccepted(t *testing.T) {
+	key, configList := newECHKey(t, 1, "example.com")
+	server := httptest.NewUnstartedServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{EncryptedClientHelloKeys: []tls.EncryptedClientHelloKey{key}}
+	server.StartTLS()
+	defer server.Close()
+	saver, err := echHandshake(t, server, configList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := saver.Read()
+	if len(events) != 2 {
+		t.Fatal("unexpected number of events")
+	}
+	if !events[1].TLSECHAccepted {
+		t.Fatal("the server did not accept ECH")
+	}
+	if string(events[1].TLSECHConfigList) != string(configList) {
+		t.Fatal("we did not save the ECHConfigList")
+	}
+}
+
+func TestECHTLSHandshakerRejectedWithRetryConfigs(t *testing.T) {
+	key, retryConfigList := newECHKey(t, 1, "example.com")
+	_, configList := newECHKey(t, 2, "example.com")
+	server := httptest.NewUnstartedServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{EncryptedClientHelloKeys: []tls.EncryptedClientHelloKey{key}}
+	server.StartTLS()
+	defer server.Close()
+	saver, err := echHandshake(t, server, configList)
+	if errorx.Classify(err) != errorx.FailureSSLECHRejected {
+		t.Fatal("not the error we expected", err)
+	}
+	events := saver.Read()
+	if len(events) != 2 {
+		t.Fatal("unexpected number of events")
+	}
+	if events[1].TLSECHAccepted {
+		t.Fatal("the server should have rejected ECH")
+	}
+	if string(events[1].TLSECHRetryConfigs) != string(retryConfigList) {
+		t.Fatal("we did not save the retry configs")
+	}
+}
+
+func TestECHTLSHandshakerRejectedByServerWithoutECH(t *testing.T) {
+	_, configList := newECHKey(t, 1, "example.com")
+	server := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	saver, err := echHandshake(t, server, configList)
+	if errorx.Classify(err) != errorx.FailureSSLECHRejected {
+		t.Fatal("not the error we expected", err)
+	}
+	events := saver.Read()
+	if len(events[1].TLSECHRetryConfigs) != 0 {
+		t.Fatal("we did not expect retry configs")
+	}
+}
@@ -0,0 +1,75 @@
+package dialer_test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/ooni/probe-engine/netx/dialer"
+	"github.com/ooni/probe-engine/netx/errorx"
+)
+
+// ECHConfigRecorder is a TLSHandshaker that records the config
+// it receives and always fails with EOF.
+type ECHConfigRecorder struct {
+	Config *tls.Config
+}
+
+func (h *ECHConfigRecorder) Handshake(
+	ctx context.Context, conn net.Conn, config *tls.Config,
+) (net.Conn, tls.ConnectionState, error) {
+	h.Config = config
+	return nil, tls.ConnectionState{}, io.EOF
+}
+
+func TestECHTLSHandshakerConfigNotFound(t *testing.T) {
+	child := &ECHConfigRecorder{}
+	h := dialer.ECHTLSHandshaker{
+		TLSHandshaker: child,
+		LookupConfigList: func(ctx context.Context, domain string) ([]byte, error) {
+			return nil, nil // the HTTPS record does not contain ech
+		},
+	}
+	conn, _, err := h.Handshake(context.Background(), dialer.EOFConn{}, &tls.Config{
+		ServerName: "example.com",
+	})
+	if !errors.Is(err, errorx.ErrECHConfigNotFound) {
+		t.Fatal("not the error we expected", err)
+	}
+	if errorx.Classify(err) != errorx.FailureECHConfigNotFound {
+		t.Fatal("unexpected failure")
+	}
+	if conn != nil {
+		t.Fatal("expected nil conn here")
+	}
+	if child.Config != nil {
+		t.Fatal("we should not have called the underlying handshaker")
+	}
+}
+
+func TestECHTLSHandshakerLookupFailure(t *testing.T) {
+	expected := errors.New("mocked error")
+	var domain string
+	h := dialer.ECHTLSHandshaker{
+		TLSHandshaker: &ECHConfigRecorder{},
+		LookupConfigList: func(ctx context.Context, d string) ([]byte, error) {
+			domain = d
+			return nil, expected
+		},
+	}
+	conn, _, err := h.Handshake(context.Background(), dialer.EOFConn{}, &tls.Config{
+		ServerName: "example.com",
+	})
+	if !errors.Is(err, expected) {
+		t.Fatal("not the error we expected", err)
+	}
+	if conn != nil {
+		t.Fatal("expected nil conn here")
+	}
+	if domain != "example.com" {
+		t.Fatal("we did not lookup the ServerName")
+	}
+}
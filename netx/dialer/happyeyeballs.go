@@ -0,0 +1,120 @@
+package dialer
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// DefaultHappyEyeballsDelay is the default delay between starting two
+// consecutive connection attempts. See RFC8305 Sect. 5.
+const DefaultHappyEyeballsDelay = 250 * time.Millisecond
+
+// happyEyeballsResult is the result of a single connection attempt.
+type happyEyeballsResult struct {
+	conn  net.Conn
+	err   error
+	index int
+}
+
+// dialHappyEyeballs implements RFC8305 Happy Eyeballs. We start connecting
+// to the first address and, if we don't succeed within the configured
+// delay or the attempt fails, we start connecting to the next one, and so
+// on, without cancelling the attempts in progress. The first attempt
+// that succeeds wins. Because each attempt goes through d.Dialer, the
+// savers record all of them, including the ones we cancel because
+// another attempt succeeded before. We wait for the cancelled attempts
+// to complete before returning, so that we always save all of them.
+func (d DNSDialer) dialHappyEyeballs(
+	ctx context.Context, network string, addrs []string, port string) (net.Conn, error) {
+	delay := d.HappyEyeballsDelay
+	if delay <= 0 {
+		delay = DefaultHappyEyeballsDelay
+	}
+	addrs = interleaveAddresses(addrs)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan happyEyeballsResult, len(addrs))
+	errorslist := make([]error, len(addrs))
+	var (
+		conn    net.Conn
+		next    int
+		pending int
+	)
+	startNext := func() {
+		index := next
+		next++
+		pending++
+		target := net.JoinHostPort(addrs[index], port)
+		go func() {
+			conn, err := d.Dialer.DialContext(ctx, network, target)
+			results <- happyEyeballsResult{conn: conn, err: err, index: index}
+		}()
+	}
+	startNext()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if conn == nil && next < len(addrs) {
+				startNext()
+				timer.Reset(delay)
+			}
+		case res := <-results:
+			pending--
+			switch {
+			case res.err != nil:
+				errorslist[res.index] = res.err
+				if conn == nil && next < len(addrs) && ctx.Err() == nil {
+					startNext() // don't wait for the timer after a failure
+					if !timer.Stop() {
+						<-timer.C
+					}
+					timer.Reset(delay)
+				}
+			case conn == nil:
+				conn = res.conn
+				cancel() // interrupt the other attempts
+			default:
+				res.conn.Close() // we already have a connection
+			}
+		}
+	}
+	if conn != nil {
+		return conn, nil
+	}
+	var errs []error
+	for _, err := range errorslist {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return nil, reduceErrors(errs)
+}
+
+// interleaveAddresses sorts the addresses such that IPv6 and IPv4
+// addresses alternate, starting with IPv6, while preserving the relative
+// order of addresses of the same family. See RFC8305 Sect. 4.
+func interleaveAddresses(addrs []string) []string {
+	var ipv4, ipv6 []string
+	for _, addr := range addrs {
+		if ip := net.ParseIP(addr); ip != nil && ip.To4() == nil {
+			ipv6 = append(ipv6, addr)
+			continue
+		}
+		ipv4 = append(ipv4, addr)
+	}
+	out := make([]string, 0, len(addrs))
+	for len(ipv4) > 0 || len(ipv6) > 0 {
+		if len(ipv6) > 0 {
+			out = append(out, ipv6[0])
+			ipv6 = ipv6[1:]
+		}
+		if len(ipv4) > 0 {
+			out = append(out, ipv4[0])
+			ipv4 = ipv4[1:]
+		}
+	}
+	return out
+}
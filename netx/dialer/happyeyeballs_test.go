@@ -0,0 +1,185 @@
+package dialer_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ooni/probe-engine/netx/dialer"
+	"github.com/ooni/probe-engine/netx/trace"
+)
+
+// HappyEyeballsDialer is a dialer that blocks until the context is done
+// when dialing Blackholed addresses, fails when dialing Failing ones, and
+// otherwise succeeds. It records the addresses it has dialed.
+type HappyEyeballsDialer struct {
+	Blackholed map[string]bool
+	Failing    map[string]bool
+	dialed     []string
+	mu         sync.Mutex
+}
+
+func (d *HappyEyeballsDialer) DialContext(
+	ctx context.Context, network, address string) (net.Conn, error) {
+	d.mu.Lock()
+	d.dialed = append(d.dialed, address)
+	d.mu.Unlock()
+	switch {
+	case d.Blackholed[address]:
+		<-ctx.Done()
+		return nil, ctx.Err()
+	case d.Failing[address]:
+		return nil, io.EOF
+	default:
+		return dialer.EOFConn{}, nil
+	}
+}
+
+func (d *HappyEyeballsDialer) Dialed() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string{}, d.dialed...)
+}
+
+func TestDNSDialerHappyEyeballsWithBlackholedIPv6(t *testing.T) {
+	child := &HappyEyeballsDialer{
+		Blackholed: map[string]bool{"[2001:4860:4860::8888]:853": true},
+	}
+	saver := &trace.Saver{}
+	d := dialer.DNSDialer{
+		Dialer: dialer.SaverDialer{Dialer: child, Saver: saver},
+		Resolver: MockableResolver{
+			Addresses: []string{"8.8.8.8", "2001:4860:4860::8888"},
+		},
+		HappyEyeballs:      true,
+		HappyEyeballsDelay: 10 * time.Millisecond,
+	}
+	conn, err := d.DialContext(context.Background(), "tcp", "dns.google:853")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	expected := []string{"[2001:4860:4860::8888]:853", "8.8.8.8:853"}
+	if diff := cmp.Diff(expected, child.Dialed()); diff != "" {
+		t.Fatal(diff)
+	}
+	// We must have saved both attempts, including the one we interrupted.
+	events := saver.Read()
+	if len(events) != 2 {
+		t.Fatal("unexpected number of events")
+	}
+	for _, ev := range events {
+		switch ev.Address {
+		case "8.8.8.8:853":
+			if ev.Err != nil {
+				t.Fatal("unexpected error for IPv4", ev.Err)
+			}
+		case "[2001:4860:4860::8888]:853":
+			if !errors.Is(ev.Err, context.Canceled) {
+				t.Fatal("unexpected error for IPv6", ev.Err)
+			}
+		default:
+			t.Fatal("unexpected address", ev.Address)
+		}
+	}
+}
+
+func TestDNSDialerHappyEyeballsDoesNotWaitAfterFailure(t *testing.T) {
+	child := &HappyEyeballsDialer{
+		Failing: map[string]bool{"[::1]:853": true},
+	}
+	d := dialer.DNSDialer{
+		Dialer: child,
+		Resolver: MockableResolver{
+			Addresses: []string{"127.0.0.1", "::1"},
+		},
+		HappyEyeballs:      true,
+		HappyEyeballsDelay: time.Hour,
+	}
+	conn, err := d.DialContext(context.Background(), "tcp", "localhost:853")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if len(child.Dialed()) != 2 {
+		t.Fatal("unexpected number of attempts")
+	}
+}
+
+func TestDNSDialerHappyEyeballsWhenAllAttemptsFail(t *testing.T) {
+	child := &HappyEyeballsDialer{
+		Failing: map[string]bool{
+			"1.1.1.1:853": true, "1.0.0.1:853": true, "[2606:4700::1111]:853": true,
+		},
+	}
+	d := dialer.DNSDialer{
+		Dialer: child,
+		Resolver: MockableResolver{
+			Addresses: []string{"1.1.1.1", "1.0.0.1", "2606:4700::1111"},
+		},
+		HappyEyeballs: true,
+	}
+	conn, err := d.DialContext(context.Background(), "tcp", "dns.cloudflare:853")
+	if !errors.Is(err, io.EOF) {
+		t.Fatal("not the error we expected", err)
+	}
+	if conn != nil {
+		t.Fatal("expected nil conn")
+	}
+	expected := []string{"[2606:4700::1111]:853", "1.1.1.1:853", "1.0.0.1:853"}
+	if diff := cmp.Diff(expected, child.Dialed()); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestDNSDialerHappyEyeballsWithCancelledContext(t *testing.T) {
+	child := &HappyEyeballsDialer{
+		Blackholed: map[string]bool{"1.1.1.1:853": true, "1.0.0.1:853": true},
+	}
+	d := dialer.DNSDialer{
+		Dialer: child,
+		Resolver: MockableResolver{
+			Addresses: []string{"1.1.1.1", "1.0.0.1"},
+		},
+		HappyEyeballs:      true,
+		HappyEyeballsDelay: time.Hour,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	conn, err := d.DialContext(ctx, "tcp", "dns.cloudflare:853")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatal("not the error we expected", err)
+	}
+	if conn != nil {
+		t.Fatal("expected nil conn")
+	}
+	if len(child.Dialed()) != 1 {
+		t.Fatal("we should not have started other attempts")
+	}
+}
+
+func TestDNSDialerSequentialByDefault(t *testing.T) {
+	child := &HappyEyeballsDialer{
+		Failing: map[string]bool{"8.8.8.8:853": true},
+	}
+	d := dialer.DNSDialer{
+		Dialer: child,
+		Resolver: MockableResolver{
+			Addresses: []string{"8.8.8.8", "8.8.4.4", "2001:4860:4860::8888"},
+		},
+	}
+	conn, err := d.DialContext(context.Background(), "tcp", "dns.google:853")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	expected := []string{"8.8.8.8:853", "8.8.4.4:853"}
+	if diff := cmp.Diff(expected, child.Dialed()); diff != "" {
+		t.Fatal(diff)
+	}
+}
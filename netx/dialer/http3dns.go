@@ -8,6 +8,7 @@ import (
 
 	"github.com/lucas-clemente/quic-go"
 	"github.com/ooni/probe-engine/legacy/netx/dialid"
+	"github.com/ooni/probe-engine/netx/selfcensor"
 )
 
 // HTTP3DNSDialer is a dialer that uses the configured Resolver to resolve a
@@ -41,7 +42,7 @@ func (d HTTP3DNSDialer) DialContext(ctx context.Context, network, host string, t
 	for _, addr := range addrs {
 		ip := net.ParseIP(addr)
 		udpAddr := &net.UDPAddr{IP: ip, Port: port, Zone: ""}
-		udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+		udpConn, err := selfcensor.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
 		if err != nil {
 			// TODO(bassosimone,kelmenhorst): we're not currently testing this
 			// case, which is quite unlikely to happen, though.
@@ -0,0 +1,347 @@
+package dialer
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Impairment describes the network impairments that ImpairmentDialer
+// applies to connections. The zero value means no impairment. We apply
+// the rate limit, the stalls and the forced close independently to each
+// connection, therefore they are not a model of a shared bottleneck.
+type Impairment struct {
+	// Burst is the size of the token bucket in bytes. If zero and
+	// Rate is positive, we use one tenth of Rate (or 1 if Rate
+	// is smaller than ten bytes per second).
+	Burst int64
+
+	// CloseAfterBytes, if positive, is the number of bytes, counting
+	// both directions, after which we forcibly close the connection and
+	// fail the current operation with ECONNRESET.
+	CloseAfterBytes int64
+
+	// Jitter is the maximum random delay we add to Latency.
+	Jitter time.Duration
+
+	// Latency is the delay we add before connecting and before
+	// every read. It roughly emulates a longer path.
+	Latency time.Duration
+
+	// Rate, if positive, is the maximum number of bytes per second
+	// that we read and that we write, enforced using a token bucket.
+	Rate int64
+
+	// Seed is the seed of the random number generator we use for
+	// jitter and stalls. If zero, we seed using the current time.
+	Seed int64
+
+	// StallDuration is the duration of a stall.
+	StallDuration time.Duration
+
+	// StallProbability is the probability that a read or a write
+	// stalls for StallDuration before proceeding.
+	StallProbability float64
+}
+
+// ImpairmentDialer is a dialer that impairs the connections that it
+// creates, to emulate a slow or flaky network in tests without needing
+// jafar or root privileges. If Impairment is nil, this dialer is a
+// passthrough for the next Dialer in chain.
+//
+// As for ProxyDialer, you can use the WithImpairment function to store
+// an impairment into the context. This will take precedence over any
+// otherwise configured impairment.
+//
+// The impairments honour the connection deadlines: if a deadline expires
+// while we're delaying a read or a write, the operation fails with a
+// timeout error, just like it would happen with a slow network.
+type ImpairmentDialer struct {
+	Dialer
+	Impairment *Impairment
+}
+
+type impairmentKey struct{}
+
+// ContextImpairment retrieves the impairment from the context.
+func ContextImpairment(ctx context.Context) *Impairment {
+	impairment, _ := ctx.Value(impairmentKey{}).(*Impairment)
+	return impairment
+}
+
+// WithImpairment assigns the impairment to the context.
+func WithImpairment(ctx context.Context, impairment *Impairment) context.Context {
+	return context.WithValue(ctx, impairmentKey{}, impairment)
+}
+
+// DialContext implements Dialer.DialContext
+func (d ImpairmentDialer) DialContext(
+	ctx context.Context, network, address string) (net.Conn, error) {
+	impairment := ContextImpairment(ctx) // context takes precedence
+	if impairment == nil {
+		impairment = d.Impairment
+	}
+	if impairment == nil {
+		return d.Dialer.DialContext(ctx, network, address)
+	}
+	seed := impairment.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	rng := rand.New(rand.NewSource(seed))
+	if delay := impairmentLatency(impairment, rng); delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+	conn, err := d.Dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	return newImpairedConn(conn, impairment, rng), nil
+}
+
+func impairmentLatency(impairment *Impairment, rng *rand.Rand) time.Duration {
+	delay := impairment.Latency
+	if impairment.Jitter > 0 {
+		delay += time.Duration(rng.Int63n(int64(impairment.Jitter)))
+	}
+	return delay
+}
+
+// errImpairedConnClosed is the error returned when the connection
+// is closed while we're delaying a read or a write.
+var errImpairedConnClosed = errors.New("use of closed network connection")
+
+// impairedConnTimeoutError is the error returned when the deadline
+// expires while we're delaying a read or a write.
+type impairedConnTimeoutError struct{}
+
+func (impairedConnTimeoutError) Error() string   { return "i/o timeout" }
+func (impairedConnTimeoutError) Timeout() bool   { return true }
+func (impairedConnTimeoutError) Temporary() bool { return true }
+
+// tokenBucket is a token bucket where each token is a byte.
+type tokenBucket struct {
+	burst  float64
+	last   time.Time
+	rate   float64
+	tokens float64
+}
+
+func newTokenBucket(impairment *Impairment) *tokenBucket {
+	if impairment.Rate <= 0 {
+		return nil
+	}
+	burst := impairment.Burst
+	if burst <= 0 {
+		burst = impairment.Rate / 10
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+	return &tokenBucket{
+		burst:  float64(burst),
+		last:   time.Now(),
+		rate:   float64(impairment.Rate),
+		tokens: float64(burst),
+	}
+}
+
+// take takes count tokens from the bucket and returns how long
+// we should wait for the bucket to contain enough tokens.
+func (tb *tokenBucket) take(count int) time.Duration {
+	now := time.Now()
+	tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+	if tb.tokens > tb.burst {
+		tb.tokens = tb.burst
+	}
+	tb.last = now
+	tb.tokens -= float64(count)
+	if tb.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-tb.tokens / tb.rate * float64(time.Second))
+}
+
+type impairedConn struct {
+	net.Conn
+	closeOnce     sync.Once
+	closed        chan struct{}
+	count         int64
+	impairment    *Impairment
+	mu            sync.Mutex
+	readBucket    *tokenBucket
+	readDeadline  time.Time
+	rng           *rand.Rand
+	writeBucket   *tokenBucket
+	writeDeadline time.Time
+}
+
+func newImpairedConn(conn net.Conn, impairment *Impairment, rng *rand.Rand) *impairedConn {
+	return &impairedConn{
+		Conn:        conn,
+		closed:      make(chan struct{}),
+		impairment:  impairment,
+		readBucket:  newTokenBucket(impairment),
+		rng:         rng,
+		writeBucket: newTokenBucket(impairment),
+	}
+}
+
+// Read implements net.Conn.Read. We delay the read by the latency and
+// by the stall, if any, then we read at most a burst worth of bytes and
+// we pay for them by waiting until the token bucket allows that.
+func (c *impairedConn) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	delay := impairmentLatency(c.impairment, c.rng) + c.stallLocked()
+	deadline := c.readDeadline
+	c.mu.Unlock()
+	if err := c.sleep(delay, deadline); err != nil {
+		return 0, err
+	}
+	p, err := c.limit(p, c.readBucket)
+	if err != nil {
+		return 0, err
+	}
+	count, err := c.Conn.Read(p)
+	if count <= 0 {
+		return count, err
+	}
+	c.mu.Lock()
+	c.count += int64(count)
+	delay = c.takeLocked(c.readBucket, count)
+	c.mu.Unlock()
+	if sleepErr := c.sleep(delay, time.Time{}); sleepErr != nil {
+		return 0, sleepErr
+	}
+	return count, err
+}
+
+// Write implements net.Conn.Write. We split the write into writes of
+// at most a burst worth of bytes and, before each write, we wait for
+// the stall, if any, and until the token bucket allows the write.
+func (c *impairedConn) Write(p []byte) (int, error) {
+	var total int
+	for len(p) > 0 {
+		chunk, err := c.limit(p, c.writeBucket)
+		if err != nil {
+			return total, err
+		}
+		c.mu.Lock()
+		delay := c.stallLocked() + c.takeLocked(c.writeBucket, len(chunk))
+		deadline := c.writeDeadline
+		c.mu.Unlock()
+		if err := c.sleep(delay, deadline); err != nil {
+			return total, err
+		}
+		count, err := c.Conn.Write(chunk)
+		total += count
+		c.mu.Lock()
+		c.count += int64(count)
+		c.mu.Unlock()
+		if err != nil {
+			return total, err
+		}
+		p = p[count:]
+	}
+	return total, nil
+}
+
+// limit truncates p to the bytes we can still transfer before the
+// forced close and to the token bucket burst. It forcibly closes the
+// connection if we have already transferred all the allowed bytes.
+func (c *impairedConn) limit(p []byte, tb *tokenBucket) ([]byte, error) {
+	if tb != nil && int64(len(p)) > int64(tb.burst) {
+		p = p[:int64(tb.burst)]
+	}
+	if c.impairment.CloseAfterBytes <= 0 {
+		return p, nil
+	}
+	c.mu.Lock()
+	remaining := c.impairment.CloseAfterBytes - c.count
+	c.mu.Unlock()
+	if remaining <= 0 {
+		c.Close()
+		return nil, syscall.ECONNRESET
+	}
+	if int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	return p, nil
+}
+
+func (c *impairedConn) stallLocked() time.Duration {
+	if c.impairment.StallProbability > 0 &&
+		c.rng.Float64() < c.impairment.StallProbability {
+		return c.impairment.StallDuration
+	}
+	return 0
+}
+
+func (c *impairedConn) takeLocked(tb *tokenBucket, count int) time.Duration {
+	if tb == nil {
+		return 0
+	}
+	return tb.take(count)
+}
+
+// sleep sleeps for the given delay. It fails if the connection is
+// closed or the deadline, if not zero, expires in the meanwhile.
+func (c *impairedConn) sleep(delay time.Duration, deadline time.Time) error {
+	if delay <= 0 {
+		return nil
+	}
+	var err error
+	if !deadline.IsZero() {
+		if untilDeadline := time.Until(deadline); untilDeadline < delay {
+			delay, err = untilDeadline, impairedConnTimeoutError{}
+		}
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-c.closed:
+		return errImpairedConnClosed
+	case <-timer.C:
+		return err
+	}
+}
+
+func (c *impairedConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() { close(c.closed) })
+	return err
+}
+
+func (c *impairedConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline, c.writeDeadline = t, t
+	c.mu.Unlock()
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *impairedConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline = t
+	c.mu.Unlock()
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *impairedConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.writeDeadline = t
+	c.mu.Unlock()
+	return c.Conn.SetWriteDeadline(t)
+}
+
+var _ Dialer = ImpairmentDialer{}
+var _ net.Conn = &impairedConn{}
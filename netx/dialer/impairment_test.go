@@ -0,0 +1,182 @@
+package dialer_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ooni/probe-engine/netx/dialer"
+	"github.com/ooni/probe-engine/netx/errorx"
+)
+
+// PipeDialer is a dialer returning one end of a net.Pipe. The other
+// end is available in the Peer field after a successful dial.
+type PipeDialer struct {
+	Peer net.Conn
+}
+
+func (d *PipeDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, peer := net.Pipe()
+	d.Peer = peer
+	return conn, nil
+}
+
+func TestImpairmentDialerWithoutImpairment(t *testing.T) {
+	child := &PipeDialer{}
+	d := dialer.ImpairmentDialer{Dialer: child}
+	conn, err := d.DialContext(context.Background(), "tcp", "8.8.8.8:853")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.LocalAddr().Network() != "pipe" {
+		t.Fatal("expected the unwrapped conn")
+	}
+}
+
+func TestImpairmentDialerLatencyAndContextPrecedence(t *testing.T) {
+	child := &PipeDialer{}
+	d := dialer.ImpairmentDialer{Dialer: child, Impairment: &dialer.Impairment{
+		Latency: time.Hour, // should be overridden by the context
+	}}
+	ctx := dialer.WithImpairment(context.Background(), &dialer.Impairment{
+		Latency: 50 * time.Millisecond,
+		Jitter:  10 * time.Millisecond,
+		Seed:    4,
+	})
+	start := time.Now()
+	conn, err := d.DialContext(ctx, "tcp", "8.8.8.8:853")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if time.Since(start) < 50*time.Millisecond {
+		t.Fatal("we did not add latency when dialing")
+	}
+	go child.Peer.Write([]byte("abc"))
+	start = time.Now()
+	buf := make([]byte, 8)
+	count, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:count]) != "abc" {
+		t.Fatal("unexpected data")
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Fatal("we did not add latency when reading")
+	}
+}
+
+func TestImpairmentDialerContextCanceledDuringLatency(t *testing.T) {
+	d := dialer.ImpairmentDialer{Dialer: &PipeDialer{}, Impairment: &dialer.Impairment{
+		Latency: time.Hour,
+	}}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	conn, err := d.DialContext(ctx, "tcp", "8.8.8.8:853")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("not the error we expected", err)
+	}
+	if conn != nil {
+		t.Fatal("expected nil conn")
+	}
+}
+
+func TestImpairmentDialerRateLimit(t *testing.T) {
+	child := &PipeDialer{}
+	d := dialer.ImpairmentDialer{Dialer: child, Impairment: &dialer.Impairment{
+		Rate:  10000,
+		Burst: 1000,
+	}}
+	conn, err := d.DialContext(context.Background(), "tcp", "8.8.8.8:853")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go io.Copy(ioutil.Discard, child.Peer)
+	start := time.Now()
+	count, err := conn.Write(make([]byte, 5000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 5000 {
+		t.Fatal("unexpected count")
+	}
+	// The first 1000 bytes are free, the other 4000 take 400ms.
+	if elapsed := time.Since(start); elapsed < 350*time.Millisecond {
+		t.Fatal("we did not limit the rate", elapsed)
+	}
+}
+
+func TestImpairmentDialerHonoursDeadline(t *testing.T) {
+	d := dialer.ImpairmentDialer{Dialer: &PipeDialer{}, Impairment: &dialer.Impairment{
+		StallDuration:    time.Hour,
+		StallProbability: 1,
+	}}
+	conn, err := d.DialContext(context.Background(), "tcp", "8.8.8.8:853")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(20 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	count, err := conn.Read(make([]byte, 8))
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatal("not the error we expected", err)
+	}
+	if count != 0 {
+		t.Fatal("unexpected count")
+	}
+	if errorx.Classify(err) != errorx.FailureGenericTimeoutError {
+		t.Fatal("unexpected failure")
+	}
+}
+
+func TestImpairmentDialerCloseInterruptsStall(t *testing.T) {
+	d := dialer.ImpairmentDialer{Dialer: &PipeDialer{}, Impairment: &dialer.Impairment{
+		StallDuration:    time.Hour,
+		StallProbability: 1,
+	}}
+	conn, err := d.DialContext(context.Background(), "tcp", "8.8.8.8:853")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		conn.Close()
+	}()
+	if _, err := conn.Write([]byte("abc")); err == nil {
+		t.Fatal("expected an error here")
+	}
+}
+
+func TestImpairmentDialerCloseAfterBytes(t *testing.T) {
+	child := &PipeDialer{}
+	d := dialer.ImpairmentDialer{Dialer: child, Impairment: &dialer.Impairment{
+		CloseAfterBytes: 10,
+	}}
+	conn, err := d.DialContext(context.Background(), "tcp", "8.8.8.8:853")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go io.Copy(ioutil.Discard, child.Peer)
+	count, err := conn.Write(make([]byte, 20))
+	if !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatal("not the error we expected", err)
+	}
+	if count != 10 {
+		t.Fatal("unexpected count", count)
+	}
+	if _, err := conn.Read(make([]byte, 8)); !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatal("not the error we expected", err)
+	}
+}
@@ -0,0 +1,69 @@
+package dialer
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"net"
+
+	"github.com/ooni/probe-engine/netx/errorx"
+)
+
+// SPKIPinningTLSHandshaker is a TLSHandshaker that requires the verified
+// chain presented by the server to contain at least a certificate whose
+// SubjectPublicKeyInfo matches one of the pins of config.ServerName. The
+// SPKIPins field maps a server name to its pins. Each pin is the base64
+// encoded SHA256 of a certificate's RawSubjectPublicKeyInfo. We do not
+// pin server names that are not inside SPKIPins.
+//
+// We verify the chain again rather than using the state returned by the
+// underlying handshaker, since SaverTLSHandshaker may have taken over the
+// verification. As pinning implies verification, we also fail when the
+// chain is not valid and config.InsecureSkipVerify is true.
+type SPKIPinningTLSHandshaker struct {
+	TLSHandshaker
+	SPKIPins map[string][]string
+}
+
+// Handshake implements Handshaker.Handshake
+func (h SPKIPinningTLSHandshaker) Handshake(
+	ctx context.Context, conn net.Conn, config *tls.Config,
+) (net.Conn, tls.ConnectionState, error) {
+	pins, found := h.SPKIPins[config.ServerName]
+	if !found {
+		return h.TLSHandshaker.Handshake(ctx, conn, config)
+	}
+	tlsconn, state, err := h.TLSHandshaker.Handshake(ctx, conn, config)
+	if err != nil {
+		return nil, tls.ConnectionState{}, err
+	}
+	if err := verifySPKIPins(state, config, pins); err != nil {
+		tlsconn.Close()
+		return nil, tls.ConnectionState{}, err
+	}
+	return tlsconn, state, nil
+}
+
+// verifySPKIPins returns nil if a certificate in any of the verified
+// chains matches one of pins, and an error otherwise.
+func verifySPKIPins(state tls.ConnectionState, config *tls.Config, pins []string) error {
+	chains, err := verifyChain(state.PeerCertificates, config)
+	if err != nil {
+		return err
+	}
+	for _, chain := range chains {
+		for _, cert := range chain {
+			sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			encoded := base64.StdEncoding.EncodeToString(sum[:])
+			for _, pin := range pins {
+				if pin == encoded {
+					return nil
+				}
+			}
+		}
+	}
+	return errorx.ErrSPKIPinMismatch
+}
+
+var _ TLSHandshaker = SPKIPinningTLSHandshaker{}
@@ -0,0 +1,93 @@
+package dialer_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/ooni/probe-engine/netx/dialer"
+	"github.com/ooni/probe-engine/netx/errorx"
+)
+
+func spkiPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func TestSPKIPinningTLSHandshaker(t *testing.T) {
+	server := newChainServer(t, nil)
+	defer server.listener.Close()
+	tests := []struct {
+		name    string
+		config  *tls.Config
+		pins    map[string][]string
+		wantErr error
+	}{{
+		name:   "with matching pin",
+		config: &tls.Config{RootCAs: server.roots(), ServerName: "example.com"},
+		pins:   map[string][]string{"example.com": {"AAAA", spkiPin(server.ca)}},
+	}, {
+		name:    "with mismatching pin",
+		config:  &tls.Config{RootCAs: server.roots(), ServerName: "example.com"},
+		pins:    map[string][]string{"example.com": {"AAAA"}},
+		wantErr: errorx.ErrSPKIPinMismatch,
+	}, {
+		name:   "with server name without pins",
+		config: &tls.Config{RootCAs: server.roots(), ServerName: "example.com"},
+		pins:   map[string][]string{"example.org": {"AAAA"}},
+	}, {
+		name: "with matching pin but invalid chain",
+		config: &tls.Config{
+			InsecureSkipVerify: true,
+			RootCAs:            x509.NewCertPool(),
+			ServerName:         "example.com",
+		},
+		pins:    map[string][]string{"example.com": {spkiPin(server.ca)}},
+		wantErr: x509.UnknownAuthorityError{},
+	}}
+	for name, th := range verifyHandshakers {
+		for _, tt := range tests {
+			t.Run(name+" "+tt.name, func(t *testing.T) {
+				h := dialer.SPKIPinningTLSHandshaker{TLSHandshaker: th, SPKIPins: tt.pins}
+				_, ev, err := server.handshake(t, h, tt.config)
+				switch wantErr := tt.wantErr.(type) {
+				case nil:
+					if err != nil {
+						t.Fatal(err)
+					}
+				case x509.UnknownAuthorityError:
+					if !errors.As(err, &wantErr) {
+						t.Fatal("not the error we expected", err)
+					}
+				default:
+					if !errors.Is(err, wantErr) {
+						t.Fatal("not the error we expected", err)
+					}
+				}
+				if len(ev.TLSPeerCerts) < 1 {
+					t.Fatal("expected at least a certificate here")
+				}
+			})
+		}
+	}
+}
+
+func TestSPKIPinningTLSHandshakerFailure(t *testing.T) {
+	h := dialer.SPKIPinningTLSHandshaker{
+		TLSHandshaker: dialer.SystemTLSHandshaker{},
+		SPKIPins:      map[string][]string{"x.org": {"AAAA"}},
+	}
+	conn, _, err := h.Handshake(context.Background(), dialer.EOFConn{}, &tls.Config{
+		ServerName: "x.org",
+	})
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+	if conn != nil {
+		t.Fatal("expected nil conn here")
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"net"
 	"time"
 
+	"github.com/lucas-clemente/quic-go"
 	"github.com/ooni/probe-engine/internal/tlsx"
 	"github.com/ooni/probe-engine/netx/errorx"
 	"github.com/ooni/probe-engine/netx/trace"
@@ -35,10 +36,18 @@ func (d SaverDialer) DialContext(ctx context.Context, network, address string) (
 	return conn, err
 }
 
-// SaverTLSHandshaker saves events occurring during the handshake
+// SaverTLSHandshaker saves events occurring during the handshake. Set
+// Fingerprint to the name of the parrot used by the underlying handshaker,
+// if any, so that we record the fingerprint along with the handshake.
+//
+// Unless config disables verification, SaverTLSHandshaker verifies the
+// certificate chain on behalf of the underlying handshaker. This allows
+// us to save the full chain presented by the server also when it is not
+// valid, which is the case we care about for detecting interception.
 type SaverTLSHandshaker struct {
 	TLSHandshaker
-	Saver *trace.Saver
+	Fingerprint string
+	Saver       *trace.Saver
 }
 
 // Handshake implements TLSHandshaker.Handshake
@@ -53,7 +62,11 @@ func (h SaverTLSHandshaker) Handshake(
 		TLSServerName: config.ServerName,
 		Time:          start,
 	})
-	tlsconn, state, err := h.TLSHandshaker.Handshake(ctx, conn, config)
+	verifier := &chainVerifier{config: config}
+	tlsconn, state, err := h.TLSHandshaker.Handshake(ctx, conn, verifier.newConfig())
+	if err == nil && len(state.VerifiedChains) <= 0 {
+		state.VerifiedChains = verifier.verified
+	}
 	stop := time.Now()
 	h.Saver.Write(trace.Event{
 		Duration:           stop.Sub(start),
@@ -61,9 +74,14 @@ func (h SaverTLSHandshaker) Handshake(
 		Name:               "tls_handshake_done",
 		NoTLSVerify:        config.InsecureSkipVerify,
 		TLSCipherSuite:     tlsx.CipherSuiteString(state.CipherSuite),
+		TLSECHAccepted:     echAccepted(state),
+		TLSECHConfigList:   echConfigList(config),
+		TLSECHRetryConfigs: echRetryConfigs(err),
+		TLSFingerprint:     h.Fingerprint,
 		TLSNegotiatedProto: state.NegotiatedProtocol,
 		TLSNextProtos:      config.NextProtos,
-		TLSPeerCerts:       peerCerts(state, err),
+		TLSOCSPResponse:    state.OCSPResponse,
+		TLSPeerCerts:       peerCerts(state, verifier.chain, err),
 		TLSServerName:      config.ServerName,
 		TLSVersion:         tlsx.VersionString(state.Version),
 		Time:               stop,
@@ -71,6 +89,86 @@ func (h SaverTLSHandshaker) Handshake(
 	return tlsconn, state, err
 }
 
+// SaverQUICHandshaker saves events occurring during the QUIC handshake. Use
+// its DialEarlyContext method as the HTTP3DNSDialer.DialEarlyContext.
+type SaverQUICHandshaker struct {
+	DialEarly func(context.Context, net.PacketConn, net.Addr, string, *tls.Config, *quic.Config) (quic.EarlySession, error) // default: quic.DialEarlyContext
+	Saver     *trace.Saver
+}
+
+// DialEarlyContext performs the QUIC handshake using quic.DialEarlyContext
+// or the configured DialEarly function and saves events.
+func (h SaverQUICHandshaker) DialEarlyContext(ctx context.Context,
+	pconn net.PacketConn, remoteAddr net.Addr, host string,
+	tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlySession, error) {
+	dialEarlyContext := h.DialEarly
+	if dialEarlyContext == nil {
+		dialEarlyContext = quic.DialEarlyContext
+	}
+	var (
+		nextProtos []string
+		noVerify   bool
+		serverName = host
+	)
+	if tlsCfg != nil {
+		nextProtos = tlsCfg.NextProtos
+		noVerify = tlsCfg.InsecureSkipVerify
+		if tlsCfg.ServerName != "" {
+			serverName = tlsCfg.ServerName
+		}
+	}
+	if hostname, _, err := net.SplitHostPort(serverName); err == nil {
+		serverName = hostname
+	}
+	start := time.Now()
+	h.Saver.Write(trace.Event{
+		Address:       remoteAddr.String(),
+		Name:          "quic_handshake_start",
+		NoTLSVerify:   noVerify,
+		Proto:         "quic",
+		TLSNextProtos: nextProtos,
+		TLSServerName: serverName,
+		Time:          start,
+	})
+	sess, err := dialEarlyContext(ctx, pconn, remoteAddr, host, tlsCfg, cfg)
+	stop := time.Now()
+	var state tls.ConnectionState
+	if err == nil {
+		state = quicConnectionState(sess)
+	}
+	h.Saver.Write(trace.Event{
+		Address:            remoteAddr.String(),
+		Duration:           stop.Sub(start),
+		Err:                err,
+		Name:               "quic_handshake_done",
+		NoTLSVerify:        noVerify,
+		Proto:              "quic",
+		TLSCipherSuite:     tlsx.CipherSuiteString(state.CipherSuite),
+		TLSNegotiatedProto: state.NegotiatedProtocol,
+		TLSNextProtos:      nextProtos,
+		TLSOCSPResponse:    state.OCSPResponse,
+		TLSPeerCerts:       peerCerts(state, nil, err),
+		TLSServerName:      serverName,
+		TLSVersion:         tlsx.VersionString(state.Version),
+		Time:               stop,
+	})
+	return sess, err
+}
+
+// quicConnectionState returns the TLS connection state of sess. The
+// TLS state of quic-go embeds a crypto/tls compatible state, whose
+// fields we copy here, because it is not a tls.ConnectionState.
+func quicConnectionState(sess quic.EarlySession) tls.ConnectionState {
+	state := sess.ConnectionState().TLS
+	return tls.ConnectionState{
+		CipherSuite:        state.CipherSuite,
+		NegotiatedProtocol: state.NegotiatedProtocol,
+		OCSPResponse:       state.OCSPResponse,
+		PeerCertificates:   state.PeerCertificates,
+		Version:            state.Version,
+	}
+}
+
 // SaverConnDialer wraps the returned connection such that we
 // collect all the read/write events that occur.
 type SaverConnDialer struct {
@@ -123,8 +221,12 @@ func (c saverConn) Write(p []byte) (int, error) {
 }
 
 // peerCerts returns the certificates presented by the peer regardless
-// of whether the TLS handshake was successful
-func peerCerts(state tls.ConnectionState, err error) []*x509.Certificate {
+// of whether the TLS handshake was successful. We prefer the chain saved
+// while verifying, if any, because x509 errors only contain one certificate.
+func peerCerts(state tls.ConnectionState, chain []*x509.Certificate, err error) []*x509.Certificate {
+	if len(chain) > 0 {
+		return chain
+	}
 	var x509HostnameError x509.HostnameError
 	if errors.As(err, &x509HostnameError) {
 		// Test case: https://wrong.host.badssl.com/
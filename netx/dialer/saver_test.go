@@ -3,12 +3,14 @@ package dialer_test
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"net"
 	"reflect"
 	"testing"
 	"time"
 
+	"github.com/lucas-clemente/quic-go"
 	"github.com/ooni/probe-engine/netx/dialer"
 	"github.com/ooni/probe-engine/netx/errorx"
 	"github.com/ooni/probe-engine/netx/trace"
@@ -369,3 +371,104 @@ func TestSaverTLSHandshakerNoTLSVerify(t *testing.T) {
 		}
 	}
 }
+
+func TestSaverQUICHandshakerFailure(t *testing.T) {
+	expected := errors.New("mocked error")
+	saver := &trace.Saver{}
+	handshaker := dialer.SaverQUICHandshaker{
+		DialEarly: func(context.Context, net.PacketConn, net.Addr, string,
+			*tls.Config, *quic.Config) (quic.EarlySession, error) {
+			return nil, expected
+		},
+		Saver: saver,
+	}
+	remoteAddr := &net.UDPAddr{IP: net.IPv4(8, 8, 8, 8), Port: 443}
+	sess, err := handshaker.DialEarlyContext(
+		context.Background(), nil, remoteAddr, "dns.google:443",
+		&tls.Config{NextProtos: []string{"h3-29"}}, &quic.Config{})
+	if !errors.Is(err, expected) {
+		t.Fatal("not the error we expected")
+	}
+	if sess != nil {
+		t.Fatal("expected nil session here")
+	}
+	ev := saver.Read()
+	if len(ev) != 2 {
+		t.Fatal("expected number of events")
+	}
+	for idx, name := range []string{"quic_handshake_start", "quic_handshake_done"} {
+		if ev[idx].Name != name {
+			t.Fatal("unexpected Name")
+		}
+		if ev[idx].Address != "8.8.8.8:443" {
+			t.Fatal("unexpected Address")
+		}
+		if ev[idx].Proto != "quic" {
+			t.Fatal("unexpected Proto")
+		}
+		if ev[idx].TLSServerName != "dns.google" {
+			t.Fatal("unexpected TLSServerName")
+		}
+		if !reflect.DeepEqual(ev[idx].TLSNextProtos, []string{"h3-29"}) {
+			t.Fatal("unexpected TLSNextProtos")
+		}
+	}
+	if !errors.Is(ev[1].Err, expected) {
+		t.Fatal("unexpected Err")
+	}
+	if ev[1].Duration <= 0 {
+		t.Fatal("unexpected Duration")
+	}
+}
+
+type fakeQUICSession struct {
+	quic.EarlySession
+	state quic.ConnectionState
+}
+
+func (s fakeQUICSession) ConnectionState() quic.ConnectionState {
+	return s.state
+}
+
+func TestSaverQUICHandshakerSuccess(t *testing.T) {
+	cert := &x509.Certificate{Raw: []byte("deadbeef")}
+	var state quic.ConnectionState
+	state.TLS.CipherSuite = tls.TLS_AES_128_GCM_SHA256
+	state.TLS.NegotiatedProtocol = "doq"
+	state.TLS.PeerCertificates = []*x509.Certificate{cert}
+	state.TLS.Version = tls.VersionTLS13
+	saver := &trace.Saver{}
+	handshaker := dialer.SaverQUICHandshaker{
+		DialEarly: func(context.Context, net.PacketConn, net.Addr, string,
+			*tls.Config, *quic.Config) (quic.EarlySession, error) {
+			return fakeQUICSession{state: state}, nil
+		},
+		Saver: saver,
+	}
+	remoteAddr := &net.UDPAddr{IP: net.IPv4(8, 8, 8, 8), Port: 853}
+	sess, err := handshaker.DialEarlyContext(
+		context.Background(), nil, remoteAddr, "dns.google:853",
+		&tls.Config{NextProtos: []string{"doq"}}, &quic.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sess == nil {
+		t.Fatal("expected non-nil session here")
+	}
+	ev := saver.Read()
+	if len(ev) != 2 {
+		t.Fatal("expected number of events")
+	}
+	if ev[1].TLSCipherSuite != "TLS_AES_128_GCM_SHA256" {
+		t.Fatal("unexpected TLSCipherSuite")
+	}
+	if ev[1].TLSNegotiatedProto != "doq" {
+		t.Fatal("unexpected TLSNegotiatedProto")
+	}
+	if len(ev[1].TLSPeerCerts) != 1 || ev[1].TLSPeerCerts[0] != cert {
+		t.Fatal("unexpected TLSPeerCerts")
+	}
+	if ev[1].TLSVersion != "TLSv1.3" {
+		t.Fatal("unexpected TLSVersion")
+	}
+}
@@ -0,0 +1,130 @@
+package dialer
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+
+	utls "gitlab.com/yawning/utls.git"
+)
+
+// ErrUnknownTLSParrot indicates that we don't know the requested parrot.
+var ErrUnknownTLSParrot = errors.New("dialer: unknown TLS parrot")
+
+// TLSParrots maps the name of each parrot we support to the uTLS
+// ClientHelloID that mimics the corresponding TLS client.
+var TLSParrots = map[string]utls.ClientHelloID{
+	"chrome":     utls.HelloChrome_Auto,
+	"firefox":    utls.HelloFirefox_Auto,
+	"ios":        utls.HelloIOS_Auto,
+	"randomized": utls.HelloRandomized,
+}
+
+// UTLSHandshaker is a TLSHandshaker that uses uTLS to send a ClientHello
+// mimicking the one of the TLS client named by Parrot, which should be a
+// key of TLSParrots. Go's crypto/tls ClientHello is easy to fingerprint
+// and some middleboxes treat it differently from the ones of browsers.
+//
+// We override the ALPN advertised by the parrot with config.NextProtos,
+// when not empty, because the caller must be able to speak the protocol
+// that the server will choose. Note that net/http only speaks HTTP/2
+// over a *tls.Conn, hence netx only advertises http/1.1 when parroting.
+//
+// If PaddingLen is positive, the ClientHello will contain a padding
+// extension (RFC7685) of exactly PaddingLen bytes, which we add if the
+// parrot does not already include such an extension.
+type UTLSHandshaker struct {
+	PaddingLen int
+	Parrot     string
+}
+
+// Handshake implements Handshaker.Handshake
+func (h UTLSHandshaker) Handshake(
+	ctx context.Context, conn net.Conn, config *tls.Config,
+) (net.Conn, tls.ConnectionState, error) {
+	id, found := TLSParrots[h.Parrot]
+	if !found {
+		return nil, tls.ConnectionState{}, fmt.Errorf("%w: %s", ErrUnknownTLSParrot, h.Parrot)
+	}
+	uconn := utls.UClient(conn, &utls.Config{
+		DynamicRecordSizingDisabled: config.DynamicRecordSizingDisabled,
+		InsecureSkipVerify:          config.InsecureSkipVerify,
+		MaxVersion:                  config.MaxVersion,
+		MinVersion:                  config.MinVersion,
+		NextProtos:                  config.NextProtos,
+		RootCAs:                     config.RootCAs,
+		ServerName:                  config.ServerName,
+		VerifyPeerCertificate:       config.VerifyPeerCertificate,
+	}, id)
+	if err := uconn.BuildHandshakeState(); err != nil {
+		return nil, tls.ConnectionState{}, err
+	}
+	h.customize(uconn, config)
+	// Handshake will apply the modified extensions again.
+	if err := uconn.Handshake(); err != nil {
+		return nil, tls.ConnectionState{}, err
+	}
+	return uconn, newConnectionStateFromUTLS(uconn.ConnectionState()), nil
+}
+
+// customize modifies the extensions of the parrot according to
+// config and to the settings of the handshaker.
+//
+// We also make sure that GREASE extensions have distinct values. Our
+// uTLS version only checks that the random seeds of the two GREASE
+// extensions differ, so they collide once every sixteen handshakes,
+// and servers reject a ClientHello containing duplicate extensions.
+func (h UTLSHandshaker) customize(uconn *utls.UConn, config *tls.Config) {
+	var (
+		grease  *utls.UtlsGREASEExtension
+		padding *utls.UtlsPaddingExtension
+	)
+	for _, ext := range uconn.Extensions {
+		switch ext := ext.(type) {
+		case *utls.ALPNExtension:
+			if len(config.NextProtos) > 0 {
+				ext.AlpnProtocols = config.NextProtos
+			}
+		case *utls.UtlsGREASEExtension:
+			if grease != nil && grease.Value == ext.Value {
+				ext.Value ^= 0x1010 // still a valid GREASE value
+			}
+			grease = ext
+		case *utls.UtlsPaddingExtension:
+			padding = ext
+		}
+	}
+	if h.PaddingLen <= 0 {
+		return
+	}
+	if padding == nil {
+		padding = &utls.UtlsPaddingExtension{}
+		uconn.Extensions = append(uconn.Extensions, padding)
+	}
+	padding.GetPaddingLen = nil // otherwise it would override PaddingLen
+	padding.PaddingLen = h.PaddingLen
+	padding.WillPad = true
+}
+
+// newConnectionStateFromUTLS converts the uTLS connection state to
+// the equivalent crypto/tls connection state.
+func newConnectionStateFromUTLS(state utls.ConnectionState) tls.ConnectionState {
+	return tls.ConnectionState{
+		CipherSuite:                 state.CipherSuite,
+		DidResume:                   state.DidResume,
+		HandshakeComplete:           state.HandshakeComplete,
+		NegotiatedProtocol:          state.NegotiatedProtocol,
+		NegotiatedProtocolIsMutual:  state.NegotiatedProtocolIsMutual,
+		OCSPResponse:                state.OCSPResponse,
+		PeerCertificates:            state.PeerCertificates,
+		ServerName:                  state.ServerName,
+		SignedCertificateTimestamps: state.SignedCertificateTimestamps,
+		TLSUnique:                   state.TLSUnique,
+		VerifiedChains:              state.VerifiedChains,
+		Version:                     state.Version,
+	}
+}
+
+var _ TLSHandshaker = UTLSHandshaker{}
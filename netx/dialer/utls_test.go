@@ -0,0 +1,170 @@
+package dialer_test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ooni/probe-engine/netx/dialer"
+	"github.com/ooni/probe-engine/netx/errorx"
+	"github.com/ooni/probe-engine/netx/trace"
+)
+
+func TestUTLSHandshakerWithAllParrots(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{NextProtos: []string{"h2", "http/1.1"}}
+	server.StartTLS()
+	defer server.Close()
+	for parrot := range dialer.TLSParrots {
+		t.Run(parrot, func(t *testing.T) {
+			conn, err := net.Dial("tcp", server.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			h := dialer.UTLSHandshaker{Parrot: parrot}
+			tlsconn, state, err := h.Handshake(context.Background(), conn, &tls.Config{
+				InsecureSkipVerify: true,
+				NextProtos:         []string{"http/1.1"},
+				ServerName:         "example.com",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tlsconn.Close()
+			if !state.HandshakeComplete {
+				t.Fatal("the handshake is not complete")
+			}
+			if len(state.PeerCertificates) < 1 {
+				t.Fatal("we did not get the peer certificates")
+			}
+			// The server supports h2 but we must use our NextProtos.
+			if parrot != "randomized" && state.NegotiatedProtocol != "http/1.1" {
+				t.Fatal("unexpected negotiated protocol", state.NegotiatedProtocol)
+			}
+		})
+	}
+}
+
+func TestUTLSHandshakerWithUnknownParrot(t *testing.T) {
+	h := dialer.UTLSHandshaker{Parrot: "netscape"}
+	conn, _, err := h.Handshake(context.Background(), dialer.EOFConn{}, &tls.Config{
+		ServerName: "x.org",
+	})
+	if !errors.Is(err, dialer.ErrUnknownTLSParrot) {
+		t.Fatal("not the error we expected", err)
+	}
+	if conn != nil {
+		t.Fatal("expected nil conn here")
+	}
+}
+
+func TestUTLSHandshakerVerifiesCertificates(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	saver := &trace.Saver{}
+	h := dialer.SaverTLSHandshaker{
+		TLSHandshaker: dialer.UTLSHandshaker{Parrot: "chrome"},
+		Fingerprint:   "chrome",
+		Saver:         saver,
+	}
+	tlsconn, _, err := h.Handshake(context.Background(), conn, &tls.Config{
+		ServerName: "example.com",
+	})
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+	if tlsconn != nil {
+		t.Fatal("expected nil conn here")
+	}
+	if failure := errorx.Classify(err); failure != errorx.FailureSSLUnknownAuthority {
+		t.Fatal("unexpected failure", failure)
+	}
+	events := saver.Read()
+	if len(events) != 2 {
+		t.Fatal("unexpected number of events")
+	}
+	if events[1].TLSFingerprint != "chrome" {
+		t.Fatal("we did not save the fingerprint")
+	}
+}
+
+func TestUTLSHandshakerWithDistinctGREASEValues(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	// With colliding GREASE values, one handshake every sixteen would
+	// fail because the server sees duplicate extensions.
+	for i := 0; i < 64; i++ {
+		conn, err := net.Dial("tcp", server.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := dialer.UTLSHandshaker{Parrot: "chrome"}
+		tlsconn, _, err := h.Handshake(context.Background(), conn, &tls.Config{
+			InsecureSkipVerify: true,
+			ServerName:         "example.com",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tlsconn.Close()
+	}
+}
+
+type clientHelloRecorder struct {
+	net.Conn
+	first []byte
+}
+
+func (c *clientHelloRecorder) Write(b []byte) (int, error) {
+	if c.first == nil {
+		c.first = append([]byte{}, b...)
+	}
+	return c.Conn.Write(b)
+}
+
+func TestUTLSHandshakerWithPadding(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	handshake := func(h dialer.UTLSHandshaker) []byte {
+		conn, err := net.Dial("tcp", server.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		recorder := &clientHelloRecorder{Conn: conn}
+		tlsconn, _, err := h.Handshake(context.Background(), recorder, &tls.Config{
+			InsecureSkipVerify: true,
+			ServerName:         "example.com",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tlsconn.Close()
+		return recorder.first
+	}
+	// ios does not include a padding extension, chrome does.
+	for _, parrot := range []string{"chrome", "ios"} {
+		t.Run(parrot, func(t *testing.T) {
+			small := handshake(dialer.UTLSHandshaker{PaddingLen: 1, Parrot: parrot})
+			large := handshake(dialer.UTLSHandshaker{PaddingLen: 2001, Parrot: parrot})
+			// Other extensions (e.g. GREASE) may change size slightly.
+			if diff := len(large) - len(small); diff < 1900 || diff > 2100 {
+				t.Fatal("unexpected size difference", diff)
+			}
+		})
+	}
+}
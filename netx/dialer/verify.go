@@ -0,0 +1,69 @@
+package dialer
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"time"
+)
+
+// chainVerifier verifies the certificate chain presented by the server
+// in lieu of crypto/tls, such that we can record the whole chain even
+// when it is not valid. Knowing which chain a server presented when we
+// see a certificate failure allows us to detect TLS interception.
+type chainVerifier struct {
+	chain    []*x509.Certificate
+	config   *tls.Config
+	verified [][]*x509.Certificate
+}
+
+// newConfig returns the config to use for the handshake. We only take
+// over the verification when we can verify exactly like crypto/tls, and
+// otherwise we return the original config.
+func (v *chainVerifier) newConfig() *tls.Config {
+	if v.config.InsecureSkipVerify || v.config.ServerName == "" ||
+		v.config.ClientSessionCache != nil || v.config.VerifyPeerCertificate != nil {
+		return v.config
+	}
+	config := v.config.Clone()
+	config.InsecureSkipVerify = true
+	config.VerifyPeerCertificate = v.verifyPeerCertificate
+	return config
+}
+
+// verifyPeerCertificate saves the chain presented by the server and then
+// verifies it like crypto/tls would do. Note that we return x509 errors
+// without wrapping them, so errorx classifies them as before.
+func (v *chainVerifier) verifyPeerCertificate(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	for _, rawCert := range rawCerts {
+		cert, err := x509.ParseCertificate(rawCert)
+		if err != nil {
+			return errors.New("tls: failed to parse certificate from server: " + err.Error())
+		}
+		v.chain = append(v.chain, cert)
+	}
+	chains, err := verifyChain(v.chain, v.config)
+	v.verified = chains
+	return err
+}
+
+// verifyChain verifies the chain presented by the server using the
+// same options that crypto/tls would use with config.
+func verifyChain(chain []*x509.Certificate, config *tls.Config) ([][]*x509.Certificate, error) {
+	if len(chain) < 1 {
+		return nil, errors.New("tls: server did not send any certificate")
+	}
+	opts := x509.VerifyOptions{
+		CurrentTime:   time.Now(),
+		DNSName:       config.ServerName,
+		Intermediates: x509.NewCertPool(),
+		Roots:         config.RootCAs,
+	}
+	if config.Time != nil {
+		opts.CurrentTime = config.Time()
+	}
+	for _, cert := range chain[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	return chain[0].Verify(opts)
+}
@@ -0,0 +1,279 @@
+package dialer_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ooni/probe-engine/netx/dialer"
+	"github.com/ooni/probe-engine/netx/errorx"
+	"github.com/ooni/probe-engine/netx/trace"
+)
+
+// chainServer is a TLS server presenting a leaf certificate for
+// example.com followed by the certificate of the CA that issued it.
+type chainServer struct {
+	ca       *x509.Certificate
+	listener net.Listener
+}
+
+// chainOptions allows to build invalid chains. With forgeSignature, the
+// leaf is signed by a CA having the same name of the CA we present but
+// another key, hence the leaf signature cannot be verified. With
+// extKeyUsage, we override the leaf extended key usage.
+type chainOptions struct {
+	extKeyUsage    []x509.ExtKeyUsage
+	forgeSignature bool
+	ocspStaple     []byte
+}
+
+func newChainServer(t *testing.T, ocspStaple []byte) *chainServer {
+	return newChainServerWithOptions(t, chainOptions{ocspStaple: ocspStaple})
+}
+
+func newChainServerWithOptions(t *testing.T, options chainOptions) *chainServer {
+	newKey := func() *ecdsa.PrivateKey {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+	caKey, leafKey := newKey(), newKey()
+	caTemplate := &x509.Certificate{
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		NotAfter:              time.Now().Add(time.Hour),
+		NotBefore:             time.Now().Add(-time.Hour),
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Interception CA"},
+	}
+	caDER, err := x509.CreateCertificate(
+		rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	extKeyUsage := []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	if options.extKeyUsage != nil {
+		extKeyUsage = options.extKeyUsage
+	}
+	signer, signerKey := ca, caKey
+	if options.forgeSignature {
+		signerKey = newKey()
+		signerDER, err := x509.CreateCertificate(
+			rand.Reader, caTemplate, caTemplate, &signerKey.PublicKey, signerKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if signer, err = x509.ParseCertificate(signerDER); err != nil {
+			t.Fatal(err)
+		}
+	}
+	leafTemplate := &x509.Certificate{
+		DNSNames:     []string{"example.com"},
+		ExtKeyUsage:  extKeyUsage,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		NotAfter:     time.Now().Add(time.Hour),
+		NotBefore:    time.Now().Add(-time.Hour),
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "example.com"},
+	}
+	leafDER, err := x509.CreateCertificate(
+		rand.Reader, leafTemplate, signer, &leafKey.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{leafDER, caDER},
+			OCSPStaple:  options.ocspStaple,
+			PrivateKey:  leafKey,
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+	return &chainServer{ca: ca, listener: listener}
+}
+
+func (s *chainServer) handshake(
+	t *testing.T, th dialer.TLSHandshaker, config *tls.Config,
+) (tls.ConnectionState, trace.Event, error) {
+	conn, err := net.Dial("tcp", s.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	saver := &trace.Saver{}
+	h := dialer.SaverTLSHandshaker{TLSHandshaker: th, Saver: saver}
+	tlsconn, state, err := h.Handshake(context.Background(), conn, config)
+	if tlsconn != nil {
+		tlsconn.Close()
+	}
+	events := saver.Read()
+	if len(events) != 2 {
+		t.Fatal("unexpected number of events")
+	}
+	return state, events[1], err
+}
+
+func (s *chainServer) roots() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(s.ca)
+	return pool
+}
+
+var verifyHandshakers = map[string]dialer.TLSHandshaker{
+	"system": dialer.SystemTLSHandshaker{},
+	"utls":   dialer.UTLSHandshaker{Parrot: "chrome"},
+}
+
+func TestSaverTLSHandshakerVerifySuccess(t *testing.T) {
+	server := newChainServer(t, []byte("deadbeef"))
+	defer server.listener.Close()
+	for name, th := range verifyHandshakers {
+		t.Run(name, func(t *testing.T) {
+			state, ev, err := server.handshake(t, th, &tls.Config{
+				RootCAs:    server.roots(),
+				ServerName: "example.com",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(state.VerifiedChains) != 1 {
+				t.Fatal("expected a verified chain")
+			}
+			if len(ev.TLSPeerCerts) != 2 {
+				t.Fatal("expected the whole chain")
+			}
+			if string(ev.TLSOCSPResponse) != "deadbeef" {
+				t.Fatal("expected the stapled OCSP response")
+			}
+		})
+	}
+}
+
+func TestSaverTLSHandshakerVerifyFailure(t *testing.T) {
+	server := newChainServer(t, nil)
+	defer server.listener.Close()
+	tests := []struct {
+		name    string
+		config  *tls.Config
+		failure string
+	}{{
+		name:    "unknown authority",
+		config:  &tls.Config{RootCAs: x509.NewCertPool(), ServerName: "example.com"},
+		failure: errorx.FailureSSLUnknownAuthority,
+	}, {
+		name:    "hostname mismatch",
+		config:  &tls.Config{RootCAs: server.roots(), ServerName: "example.org"},
+		failure: errorx.FailureSSLInvalidHostname,
+	}, {
+		name: "expired",
+		config: &tls.Config{
+			RootCAs:    server.roots(),
+			ServerName: "example.com",
+			Time: func() time.Time {
+				return time.Now().Add(24 * time.Hour)
+			},
+		},
+		failure: errorx.FailureSSLInvalidCertificate,
+	}}
+	for name, th := range verifyHandshakers {
+		for _, tt := range tests {
+			t.Run(name+" with "+tt.name, func(t *testing.T) {
+				_, ev, err := server.handshake(t, th, tt.config)
+				if failure := errorx.Classify(err); failure != tt.failure {
+					t.Fatal("unexpected failure", failure)
+				}
+				if len(ev.TLSPeerCerts) != 2 {
+					t.Fatal("expected the whole chain")
+				}
+				if ev.TLSPeerCerts[1].Subject.CommonName != "Interception CA" {
+					t.Fatal("not the chain we expected")
+				}
+			})
+		}
+	}
+}
+
+// Because we set InsecureSkipVerify when we take over the verification,
+// make sure that a bad chain still fails with both handshakers.
+func TestSaverTLSHandshakerVerifyBadChain(t *testing.T) {
+	tests := []struct {
+		name    string
+		options chainOptions
+		failure string
+	}{{
+		name:    "forged signature",
+		options: chainOptions{forgeSignature: true},
+		failure: errorx.FailureSSLUnknownAuthority,
+	}, {
+		name: "not for server auth",
+		options: chainOptions{
+			extKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		},
+		failure: errorx.FailureSSLInvalidCertificate,
+	}}
+	for _, tt := range tests {
+		server := newChainServerWithOptions(t, tt.options)
+		defer server.listener.Close()
+		for name, th := range verifyHandshakers {
+			t.Run(name+" with "+tt.name, func(t *testing.T) {
+				state, ev, err := server.handshake(t, th, &tls.Config{
+					RootCAs:    server.roots(),
+					ServerName: "example.com",
+				})
+				if failure := errorx.Classify(err); failure != tt.failure {
+					t.Fatal("unexpected failure", failure, err)
+				}
+				if len(state.VerifiedChains) != 0 {
+					t.Fatal("expected no verified chains")
+				}
+				if len(ev.TLSPeerCerts) != 2 {
+					t.Fatal("expected the whole chain")
+				}
+			})
+		}
+	}
+}
+
+func TestSaverTLSHandshakerVerifyNoTLSVerify(t *testing.T) {
+	server := newChainServer(t, nil)
+	defer server.listener.Close()
+	state, ev, err := server.handshake(t, dialer.SystemTLSHandshaker{}, &tls.Config{
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.VerifiedChains) != 0 {
+		t.Fatal("expected no verified chains")
+	}
+	if len(ev.TLSPeerCerts) != 2 {
+		t.Fatal("expected the whole chain")
+	}
+}
@@ -0,0 +1,306 @@
+package errorx
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Classifier maps an error to an OONI failure string. It returns
+// the empty string when it does not know how to classify the error.
+type Classifier func(err error) string
+
+// Classifiers is the ordered table of classifiers used by Classify. The
+// first classifier returning a nonempty string wins. We do not use
+// this table for errors that we have already wrapped. You may append your
+// own classifiers to this table during the program initialization. Doing
+// so later would be racy, because we do not protect it with a mutex.
+var Classifiers = []Classifier{
+	classifySentinel,
+	classifyX509,
+	classifyProxy,
+	classifyTLSAlert,
+	classifyQUIC,
+	classifyErrno,
+	classifySuffix,
+}
+
+// Classify returns the OONI failure string for err. The returned string is
+// either one of the FailureXXX strings, a string returned by one of the
+// Classifiers, or `unknown_failure ...` for errors we have not mapped
+// yet. In the latter case, we scrub IP addresses from the string. This
+// function returns the empty string if err is nil.
+//
+// You should use this function, or SafeErrWrapperBuilder, whenever you
+// need a failure string, so that we always use the same vocabulary.
+func Classify(err error) string {
+	if err == nil {
+		return ""
+	}
+	return toFailureString(err)
+}
+
+func toFailureString(err error) string {
+	// The list returned here matches the values used by MK unless
+	// explicitly noted otherwise in the classifiers.
+
+	var errwrapper *ErrWrapper
+	if errors.As(err, &errwrapper) {
+		return errwrapper.Error() // we've already wrapped it
+	}
+	for _, classifier := range Classifiers {
+		if failure := classifier(err); failure != "" {
+			return failure
+		}
+	}
+	formatted := fmt.Sprintf("unknown_failure: %s", err.Error())
+	return Scrub(formatted) // scrub IP addresses in the error
+}
+
+// sentinelFailures maps sentinel errors to failures. Apart from
+// NXDOMAIN and context.Canceled, these failures are not in MK.
+var sentinelFailures = []struct {
+	err     error
+	failure string
+}{
+	{ErrDNSBogon, FailureDNSBogonError},
+	{ErrECHConfigNotFound, FailureECHConfigNotFound},
+	{ErrOODNSNoSuchHost, FailureDNSNXDOMAINError},
+	{ErrOODNSFormat, FailureDNSFormatError},
+	{ErrOODNSServfail, FailureDNSServfailError},
+	{ErrOODNSNotImplemented, FailureDNSNotImplementedError},
+	{ErrOODNSRefused, FailureDNSRefusedError},
+	{ErrOODNSMisbehaving, FailureDNSServerMisbehaving},
+	{ErrOODNSNoAnswer, FailureDNSNoAnswer},
+	{ErrSPKIPinMismatch, FailureSSLPinMismatch},
+	{context.Canceled, FailureInterrupted},
+}
+
+func classifySentinel(err error) string {
+	for _, entry := range sentinelFailures {
+		if errors.Is(err, entry.err) {
+			return entry.failure
+		}
+	}
+	return ""
+}
+
+func classifyX509(err error) string {
+	var x509HostnameError x509.HostnameError
+	if errors.As(err, &x509HostnameError) {
+		// Test case: https://wrong.host.badssl.com/
+		return FailureSSLInvalidHostname
+	}
+	var x509UnknownAuthorityError x509.UnknownAuthorityError
+	if errors.As(err, &x509UnknownAuthorityError) {
+		// Test case: https://self-signed.badssl.com/. This error has
+		// never been among the ones returned by MK.
+		return FailureSSLUnknownAuthority
+	}
+	var x509CertificateInvalidError x509.CertificateInvalidError
+	if errors.As(err, &x509CertificateInvalidError) {
+		// Test case: https://expired.badssl.com/
+		return FailureSSLInvalidCertificate
+	}
+	return ""
+}
+
+// socksReplyFailures maps the SOCKS5 replies, as formatted by
+// golang.org/x/net/internal/socks, to failures.
+var socksReplyFailures = map[string]string{
+	"general SOCKS server failure":      FailureProxyGeneralFailure,
+	"connection not allowed by ruleset": FailureProxyConnectionNotAllowed,
+	"network unreachable":               FailureProxyNetworkUnreachable,
+	"host unreachable":                  FailureProxyHostUnreachable,
+	"connection refused":                FailureProxyConnectionRefused,
+	"TTL expired":                       FailureProxyTTLExpired,
+}
+
+func classifyProxy(err error) string {
+	// Both net/http and golang.org/x/net/proxy wrap the errors occurring
+	// when connecting to, or speaking with, the proxy using a *net.OpError
+	// whose Op is "proxyconnect" and "socks connect" respectively.
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) {
+		return ""
+	}
+	if opErr.Op != "proxyconnect" && !strings.HasPrefix(opErr.Op, "socks ") {
+		return ""
+	}
+	if opErr.Err == nil {
+		return FailureProxyError
+	}
+	s := opErr.Err.Error()
+	if reply := strings.TrimPrefix(s, "unknown error "); reply != s {
+		if failure, found := socksReplyFailures[reply]; found {
+			return failure
+		}
+		return FailureProxyError
+	}
+	if opErr.Timeout() {
+		return FailureGenericTimeoutError
+	}
+	return FailureProxyError
+}
+
+// TLSAlert describes a TLS alert. See RFC8446 Sect. 6.
+type TLSAlert struct {
+	// Code is the alert code.
+	Code uint8
+
+	// Description is the alert description used by crypto/tls.
+	Description string
+
+	// Failure is the corresponding failure string.
+	Failure string
+}
+
+// TLSAlerts contains the TLS alerts we know about. We map the alerts
+// sent by the server, which are the ones that may be used to signal
+// censorship, to failures. None of these failures are in MK.
+var TLSAlerts = []TLSAlert{
+	{0, "close notify", "ssl_alert_close_notify"},
+	{10, "unexpected message", "ssl_alert_unexpected_message"},
+	{20, "bad record MAC", "ssl_alert_bad_record_mac"},
+	{22, "record overflow", "ssl_alert_record_overflow"},
+	{40, "handshake failure", "ssl_alert_handshake_failure"},
+	{42, "bad certificate", "ssl_alert_bad_certificate"},
+	{43, "unsupported certificate", "ssl_alert_unsupported_certificate"},
+	{44, "revoked certificate", "ssl_alert_certificate_revoked"},
+	{45, "expired certificate", "ssl_alert_certificate_expired"},
+	{46, "unknown certificate", "ssl_alert_certificate_unknown"},
+	{47, "illegal parameter", "ssl_alert_illegal_parameter"},
+	{48, "unknown certificate authority", "ssl_alert_unknown_ca"},
+	{49, "access denied", "ssl_alert_access_denied"},
+	{50, "error decoding message", "ssl_alert_decode_error"},
+	{51, "error decrypting message", "ssl_alert_decrypt_error"},
+	{70, "protocol version not supported", "ssl_alert_protocol_version"},
+	{71, "insufficient security level", "ssl_alert_insufficient_security"},
+	{80, "internal error", "ssl_alert_internal_error"},
+	{86, "inappropriate fallback", "ssl_alert_inappropriate_fallback"},
+	{90, "user canceled", "ssl_alert_user_canceled"},
+	{109, "missing extension", "ssl_alert_missing_extension"},
+	{110, "unsupported extension", "ssl_alert_unsupported_extension"},
+	{112, "unrecognized name", "ssl_alert_unrecognized_name"},
+	{116, "certificate required", "ssl_alert_certificate_required"},
+	{120, "no application protocol", "ssl_alert_no_application_protocol"},
+}
+
+// TLSAlertFailure returns the failure string for the TLS alert with
+// the given code, or the empty string if we don't know the alert.
+func TLSAlertFailure(code uint8) string {
+	for _, alert := range TLSAlerts {
+		if alert.Code == code {
+			return alert.Failure
+		}
+	}
+	return ""
+}
+
+func classifyTLSAlert(err error) string {
+	// crypto/tls wraps the alerts sent by the server into a *net.OpError
+	// whose Op is "remote error". The alert type is private, so we
+	// need to use its description to know which alert it is.
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) || opErr.Op != "remote error" || opErr.Err == nil {
+		return ""
+	}
+	s := opErr.Err.Error()
+	for _, alert := range TLSAlerts {
+		if s == "tls: "+alert.Description {
+			return alert.Failure
+		}
+	}
+	return ""
+}
+
+// quicCryptoErrorRe matches the QUIC CRYPTO_ERROR codes, which
+// contain the TLS alert code. See RFC9000 Sect. 20.1.
+var quicCryptoErrorRe = regexp.MustCompile(`CRYPTO_ERROR \(0x1([0-9a-fA-F]{2})\)`)
+
+func classifyQUIC(err error) string {
+	// The quic-go errors are not stable across versions, therefore
+	// we need to match the error strings, as the quic-go tests do.
+	s := err.Error()
+	if m := quicCryptoErrorRe.FindStringSubmatch(s); m != nil {
+		code, _ := strconv.ParseUint(m[1], 16, 8) // cannot fail
+		if failure := TLSAlertFailure(uint8(code)); failure != "" {
+			return failure
+		}
+		return FailureQUICCryptoError
+	}
+	if strings.Contains(s, "No compatible QUIC version found") {
+		return FailureQUICIncompatibleVersion
+	}
+	if strings.Contains(s, "Handshake did not complete in time") ||
+		strings.Contains(s, "No recent network activity") {
+		return FailureGenericTimeoutError
+	}
+	return ""
+}
+
+// errnoFailures maps errno values to failures. We complete this
+// table with platformErrnoFailures for platforms where the errors
+// returned by the network stack are not the syscall.Exxx ones.
+var errnoFailures = map[syscall.Errno]string{
+	syscall.EADDRINUSE:   FailureAddressInUse,
+	syscall.ECONNABORTED: FailureConnectionAborted,
+	syscall.ECONNREFUSED: FailureConnectionRefused,
+	syscall.ECONNRESET:   FailureConnectionReset,
+	syscall.EHOSTUNREACH: FailureHostUnreachable,
+	syscall.ENETUNREACH:  FailureNetworkUnreachable,
+	syscall.ETIMEDOUT:    FailureGenericTimeoutError,
+}
+
+func classifyErrno(err error) string {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return ""
+	}
+	if failure, found := errnoFailures[errno]; found {
+		return failure
+	}
+	return platformErrnoFailures[errno]
+}
+
+// suffixFailures maps error string suffixes to failures. We use
+// this table for errors that do not have a specific type.
+var suffixFailures = []struct {
+	suffix  string
+	failure string
+}{
+	{"operation was canceled", FailureInterrupted},
+	{"EOF", FailureEOFError},
+	{"connection refused", FailureConnectionRefused},
+	{"connection reset by peer", FailureConnectionReset},
+	{"context deadline exceeded", FailureGenericTimeoutError},
+	{"transaction is timed out", FailureGenericTimeoutError},
+	{"i/o timeout", FailureGenericTimeoutError},
+	{"TLS handshake timeout", FailureGenericTimeoutError},
+	// This is the error returned by crypto/tls when the server
+	// does not accept the Encrypted ClientHello.
+	{"tls: server rejected ECH", FailureSSLECHRejected},
+	// This is dns_lookup_error in MK but such error is used as a
+	// generic "hey, the lookup failed" error. Instead, this error
+	// that we return here is significantly more specific.
+	{"no such host", FailureDNSNXDOMAINError},
+	// This is what the Go resolver returns for SERVFAIL and
+	// REFUSED. This failure is not in MK.
+	{"server misbehaving", FailureDNSServerMisbehaving},
+}
+
+func classifySuffix(err error) string {
+	s := err.Error()
+	for _, entry := range suffixFailures {
+		if strings.HasSuffix(s, entry.suffix) {
+			return entry.failure
+		}
+	}
+	return ""
+}
@@ -0,0 +1,138 @@
+package errorx
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+)
+
+type fakeTimeoutError struct{}
+
+func (fakeTimeoutError) Error() string   { return "i/o timeout" }
+func (fakeTimeoutError) Timeout() bool   { return true }
+func (fakeTimeoutError) Temporary() bool { return true }
+
+func TestClassify(t *testing.T) {
+	var tests = []struct {
+		name     string
+		err      error
+		expected string
+	}{{
+		name:     "for nil error",
+		err:      nil,
+		expected: "",
+	}, {
+		name: "for wrapped errno",
+		err: &net.OpError{Op: "dial", Err: &os.SyscallError{
+			Syscall: "connect", Err: syscall.EHOSTUNREACH,
+		}},
+		expected: FailureHostUnreachable,
+	}, {
+		name:     "for ENETUNREACH",
+		err:      syscall.ENETUNREACH,
+		expected: FailureNetworkUnreachable,
+	}, {
+		name:     "for ECONNABORTED",
+		err:      syscall.ECONNABORTED,
+		expected: FailureConnectionAborted,
+	}, {
+		name:     "for EADDRINUSE",
+		err:      syscall.EADDRINUSE,
+		expected: FailureAddressInUse,
+	}, {
+		name:     "for ETIMEDOUT",
+		err:      syscall.ETIMEDOUT,
+		expected: FailureGenericTimeoutError,
+	}, {
+		name:     "for TLS alert",
+		err:      &net.OpError{Op: "remote error", Err: errors.New("tls: unrecognized name")},
+		expected: "ssl_alert_unrecognized_name",
+	}, {
+		name:     "for unknown TLS alert",
+		err:      &net.OpError{Op: "remote error", Err: errors.New("tls: antani")},
+		expected: "unknown_failure: remote error: tls: antani",
+	}, {
+		name:     "for QUIC CRYPTO_ERROR with known alert",
+		err:      errors.New("CRYPTO_ERROR (0x128): tls: handshake failure"),
+		expected: "ssl_alert_handshake_failure",
+	}, {
+		name:     "for QUIC CRYPTO_ERROR with unknown alert",
+		err:      errors.New("CRYPTO_ERROR (0x1ff)"),
+		expected: FailureQUICCryptoError,
+	}, {
+		name:     "for QUIC incompatible version",
+		err:      errors.New("No compatible QUIC version found."),
+		expected: FailureQUICIncompatibleVersion,
+	}, {
+		name:     "for QUIC handshake timeout",
+		err:      errors.New("timeout: Handshake did not complete in time"),
+		expected: FailureGenericTimeoutError,
+	}, {
+		name: "for SOCKS reply",
+		err: &net.OpError{Op: "socks connect", Err: errors.New(
+			"unknown error connection not allowed by ruleset")},
+		expected: FailureProxyConnectionNotAllowed,
+	}, {
+		name:     "for unknown SOCKS reply",
+		err:      &net.OpError{Op: "socks connect", Err: errors.New("unknown error unknown code: 17")},
+		expected: FailureProxyError,
+	}, {
+		name:     "for failure connecting to the SOCKS proxy",
+		err:      &net.OpError{Op: "socks connect", Err: syscall.ECONNREFUSED},
+		expected: FailureProxyError,
+	}, {
+		name:     "for timeout connecting to the HTTP proxy",
+		err:      &net.OpError{Op: "proxyconnect", Err: fakeTimeoutError{}},
+		expected: FailureGenericTimeoutError,
+	}, {
+		name:     "for proxy error without wrapped error",
+		err:      &net.OpError{Op: "proxyconnect"},
+		expected: FailureProxyError,
+	}, {
+		name:     "for Go resolver SERVFAIL or REFUSED",
+		err:      &net.DNSError{Err: "server misbehaving"},
+		expected: FailureDNSServerMisbehaving,
+	}, {
+		name:     "for ECH rejected by the server",
+		err:      errors.New("tls: server rejected ECH"),
+		expected: FailureSSLECHRejected,
+	}, {
+		name:     "for ECHConfigList not found",
+		err:      fmt.Errorf("%w: example.com", ErrECHConfigNotFound),
+		expected: FailureECHConfigNotFound,
+	}, {
+		name:     "for SPKI pin mismatch",
+		err:      ErrSPKIPinMismatch,
+		expected: FailureSSLPinMismatch,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if out := Classify(tt.err); out != tt.expected {
+				t.Fatalf("expected %s, got %s", tt.expected, out)
+			}
+		})
+	}
+}
+
+func TestClassifyWithCustomClassifier(t *testing.T) {
+	saved := Classifiers
+	defer func() { Classifiers = saved }()
+	Classifiers = append([]Classifier{func(err error) string {
+		return "antani"
+	}}, Classifiers...)
+	if out := Classify(errors.New("mocked error")); out != "antani" {
+		t.Fatal("unexpected result", out)
+	}
+}
+
+func TestTLSAlertFailure(t *testing.T) {
+	if TLSAlertFailure(112) != "ssl_alert_unrecognized_name" {
+		t.Fatal("unexpected result")
+	}
+	if TLSAlertFailure(255) != "" {
+		t.Fatal("unexpected result")
+	}
+}
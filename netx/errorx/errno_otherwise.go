@@ -0,0 +1,9 @@
+// +build !windows
+
+package errorx
+
+import "syscall"
+
+// platformErrnoFailures is empty because on this platform the
+// network stack returns the syscall.Exxx errors.
+var platformErrnoFailures = map[syscall.Errno]string{}
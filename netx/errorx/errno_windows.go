@@ -0,0 +1,16 @@
+package errorx
+
+import "syscall"
+
+// platformErrnoFailures maps the Winsock errors, which are the
+// errors returned on Windows instead of the syscall.Exxx ones.
+// See https://docs.microsoft.com/en-us/windows/win32/winsock/windows-sockets-error-codes-2.
+var platformErrnoFailures = map[syscall.Errno]string{
+	10048: FailureAddressInUse,        // WSAEADDRINUSE
+	10051: FailureNetworkUnreachable,  // WSAENETUNREACH
+	10053: FailureConnectionAborted,   // WSAECONNABORTED
+	10054: FailureConnectionReset,     // WSAECONNRESET
+	10060: FailureGenericTimeoutError, // WSAETIMEDOUT
+	10061: FailureConnectionRefused,   // WSAECONNREFUSED
+	10065: FailureHostUnreachable,     // WSAEHOSTUNREACH
+}
@@ -2,14 +2,17 @@
 package errorx
 
 import (
-	"context"
-	"crypto/x509"
 	"errors"
 	"fmt"
-	"strings"
 )
 
 const (
+	// FailureAddressInUse means EADDRINUSE.
+	FailureAddressInUse = "address_in_use"
+
+	// FailureConnectionAborted means ECONNABORTED.
+	FailureConnectionAborted = "connection_aborted"
+
 	// FailureConnectionRefused means ECONNREFUSED.
 	FailureConnectionRefused = "connection_refused"
 
@@ -19,18 +22,93 @@ const (
 	// FailureDNSBogonError means we detected bogon in DNS reply.
 	FailureDNSBogonError = "dns_bogon_error"
 
+	// FailureDNSFormatError means we got FORMERR in DNS reply.
+	FailureDNSFormatError = "dns_format_error"
+
+	// FailureDNSNoAnswer means the DNS reply did not contain any
+	// record of the type we were asking for.
+	FailureDNSNoAnswer = "dns_no_answer"
+
+	// FailureDNSNotImplementedError means we got NOTIMP in DNS reply.
+	FailureDNSNotImplementedError = "dns_not_implemented_error"
+
 	// FailureDNSNXDOMAINError means we got NXDOMAIN in DNS reply.
 	FailureDNSNXDOMAINError = "dns_nxdomain_error"
 
+	// FailureDNSRefusedError means we got REFUSED in DNS reply.
+	FailureDNSRefusedError = "dns_refused_error"
+
+	// FailureDNSServerMisbehaving means we got a DNS reply with
+	// an rcode that does not have a more specific failure.
+	FailureDNSServerMisbehaving = "dns_server_misbehaving"
+
+	// FailureDNSServfailError means we got SERVFAIL in DNS reply.
+	FailureDNSServfailError = "dns_servfail_error"
+
+	// FailureECHConfigNotFound means that we could not find the
+	// ECHConfigList required to use Encrypted ClientHello.
+	FailureECHConfigNotFound = "ech_config_not_found"
+
 	// FailureEOFError means we got unexpected EOF on connection.
 	FailureEOFError = "eof_error"
 
 	// FailureGenericTimeoutError means we got some timer has expired.
 	FailureGenericTimeoutError = "generic_timeout_error"
 
+	// FailureHostUnreachable means EHOSTUNREACH.
+	FailureHostUnreachable = "host_unreachable"
+
 	// FailureInterrupted means that the user interrupted us.
 	FailureInterrupted = "interrupted"
 
+	// FailureNetworkUnreachable means ENETUNREACH.
+	FailureNetworkUnreachable = "network_unreachable"
+
+	// FailureProxyConnectionNotAllowed means the SOCKS proxy told
+	// us that its ruleset does not allow the connection.
+	FailureProxyConnectionNotAllowed = "proxy_connection_not_allowed"
+
+	// FailureProxyConnectionRefused means the SOCKS proxy told us
+	// that the destination refused the connection.
+	FailureProxyConnectionRefused = "proxy_connection_refused"
+
+	// FailureProxyError means we failed to connect or talk to the
+	// proxy, or that the proxy failed in a way that does not have a
+	// more specific failure string.
+	FailureProxyError = "proxy_error"
+
+	// FailureProxyGeneralFailure means the SOCKS proxy told us that
+	// it suffered from a general failure.
+	FailureProxyGeneralFailure = "proxy_general_failure"
+
+	// FailureProxyHostUnreachable means the SOCKS proxy told us that
+	// the destination host is unreachable.
+	FailureProxyHostUnreachable = "proxy_host_unreachable"
+
+	// FailureProxyNetworkUnreachable means the SOCKS proxy told us that
+	// the destination network is unreachable.
+	FailureProxyNetworkUnreachable = "proxy_network_unreachable"
+
+	// FailureProxyTTLExpired means the SOCKS proxy told us that the
+	// TTL expired while connecting to the destination.
+	FailureProxyTTLExpired = "proxy_ttl_expired"
+
+	// FailureQUICCryptoError means the QUIC handshake failed with
+	// a CRYPTO_ERROR that does not map to a known TLS alert.
+	FailureQUICCryptoError = "quic_crypto_error"
+
+	// FailureQUICIncompatibleVersion means that we could not agree
+	// with the server on the QUIC version to use.
+	FailureQUICIncompatibleVersion = "quic_incompatible_version"
+
+	// FailureSSLECHRejected means that the server rejected the
+	// Encrypted ClientHello we offered.
+	FailureSSLECHRejected = "ssl_ech_rejected"
+
+	// FailureSSLPinMismatch means that no certificate in the chain
+	// matches the SPKI pins we configured for the server.
+	FailureSSLPinMismatch = "ssl_pin_mismatch"
+
 	// FailureSSLInvalidHostname means we got certificate is not valid for SNI.
 	FailureSSLInvalidHostname = "ssl_invalid_hostname"
 
@@ -80,6 +158,61 @@ const (
 // to tell this library to return an error when a bogon is found.
 var ErrDNSBogon = errors.New("dns: detected bogon address")
 
+// ErrECHConfigNotFound indicates that we could not find the
+// ECHConfigList for the domain we're connecting to.
+var ErrECHConfigNotFound = errors.New("tls: ECHConfigList not found")
+
+// ErrSPKIPinMismatch indicates that no certificate in the chain
+// matches the SPKI pins we configured for the server.
+var ErrSPKIPinMismatch = errors.New("tls: SPKI pin mismatch")
+
+// These errors are returned by our DNS resolver when the DNS
+// reply does not contain the addresses we asked for.
+var (
+	// ErrOODNSNoSuchHost means we got NXDOMAIN.
+	ErrOODNSNoSuchHost = errors.New("ooniresolver: no such host")
+
+	// ErrOODNSFormat means we got FORMERR.
+	ErrOODNSFormat = errors.New("ooniresolver: format error")
+
+	// ErrOODNSServfail means we got SERVFAIL.
+	ErrOODNSServfail = errors.New("ooniresolver: server failure")
+
+	// ErrOODNSNotImplemented means we got NOTIMP.
+	ErrOODNSNotImplemented = errors.New("ooniresolver: not implemented")
+
+	// ErrOODNSRefused means we got REFUSED.
+	ErrOODNSRefused = errors.New("ooniresolver: query refused")
+
+	// ErrOODNSMisbehaving means we got any other error rcode.
+	ErrOODNSMisbehaving = errors.New("ooniresolver: query failed")
+
+	// ErrOODNSNoAnswer means that the reply was successful but
+	// it did not contain any record of the type we wanted.
+	ErrOODNSNoAnswer = errors.New("ooniresolver: no response returned")
+)
+
+// NewDNSRcodeError returns the error corresponding to the given
+// DNS rcode name (e.g. "SERVFAIL"). It returns nil for "NOERROR".
+func NewDNSRcodeError(rcode string) error {
+	switch rcode {
+	case "NOERROR":
+		return nil
+	case "FORMERR":
+		return ErrOODNSFormat
+	case "SERVFAIL":
+		return ErrOODNSServfail
+	case "NXDOMAIN":
+		return ErrOODNSNoSuchHost
+	case "NOTIMP":
+		return ErrOODNSNotImplemented
+	case "REFUSED":
+		return ErrOODNSRefused
+	default:
+		return fmt.Errorf("%w: %s", ErrOODNSMisbehaving, rcode)
+	}
+}
+
 // ErrWrapper is our error wrapper for Go errors. The key objective of
 // this structure is to properly set Failure, which is also returned by
 // the Error() method, so be one of the OONI defined strings.
@@ -174,75 +307,6 @@ func (b SafeErrWrapperBuilder) MaybeBuild() (err error) {
 	return
 }
 
-func toFailureString(err error) string {
-	// The list returned here matches the values used by MK unless
-	// explicitly noted otherwise with a comment.
-
-	var errwrapper *ErrWrapper
-	if errors.As(err, &errwrapper) {
-		return errwrapper.Error() // we've already wrapped it
-	}
-
-	if errors.Is(err, ErrDNSBogon) {
-		return FailureDNSBogonError // not in MK
-	}
-	if errors.Is(err, context.Canceled) {
-		return FailureInterrupted
-	}
-
-	var x509HostnameError x509.HostnameError
-	if errors.As(err, &x509HostnameError) {
-		// Test case: https://wrong.host.badssl.com/
-		return FailureSSLInvalidHostname
-	}
-	var x509UnknownAuthorityError x509.UnknownAuthorityError
-	if errors.As(err, &x509UnknownAuthorityError) {
-		// Test case: https://self-signed.badssl.com/. This error has
-		// never been among the ones returned by MK.
-		return FailureSSLUnknownAuthority
-	}
-	var x509CertificateInvalidError x509.CertificateInvalidError
-	if errors.As(err, &x509CertificateInvalidError) {
-		// Test case: https://expired.badssl.com/
-		return FailureSSLInvalidCertificate
-	}
-
-	s := err.Error()
-	if strings.HasSuffix(s, "operation was canceled") {
-		return FailureInterrupted
-	}
-	if strings.HasSuffix(s, "EOF") {
-		return FailureEOFError
-	}
-	if strings.HasSuffix(s, "connection refused") {
-		return FailureConnectionRefused
-	}
-	if strings.HasSuffix(s, "connection reset by peer") {
-		return FailureConnectionReset
-	}
-	if strings.HasSuffix(s, "context deadline exceeded") {
-		return FailureGenericTimeoutError
-	}
-	if strings.HasSuffix(s, "transaction is timed out") {
-		return FailureGenericTimeoutError
-	}
-	if strings.HasSuffix(s, "i/o timeout") {
-		return FailureGenericTimeoutError
-	}
-	if strings.HasSuffix(s, "TLS handshake timeout") {
-		return FailureGenericTimeoutError
-	}
-	if strings.HasSuffix(s, "no such host") {
-		// This is dns_lookup_error in MK but such error is used as a
-		// generic "hey, the lookup failed" error. Instead, this error
-		// that we return here is significantly more specific.
-		return FailureDNSNXDOMAINError
-	}
-
-	formatted := fmt.Sprintf("unknown_failure: %s", s)
-	return Scrub(formatted) // scrub IP addresses in the error
-}
-
 func toOperationString(err error, operation string) string {
 	var errwrapper *ErrWrapper
 	if errors.As(err, &errwrapper) {
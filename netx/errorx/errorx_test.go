@@ -53,6 +53,28 @@ func TestToFailureString(t *testing.T) {
 			t.Fatal("unexpected result")
 		}
 	})
+	t.Run("for DNS rcode errors", func(t *testing.T) {
+		for rcode, expected := range map[string]string{
+			"FORMERR":  FailureDNSFormatError,
+			"SERVFAIL": FailureDNSServfailError,
+			"NXDOMAIN": FailureDNSNXDOMAINError,
+			"NOTIMP":   FailureDNSNotImplementedError,
+			"REFUSED":  FailureDNSRefusedError,
+			"YXDOMAIN": FailureDNSServerMisbehaving,
+		} {
+			if out := toFailureString(NewDNSRcodeError(rcode)); out != expected {
+				t.Fatal("unexpected result", rcode, out)
+			}
+		}
+		if NewDNSRcodeError("NOERROR") != nil {
+			t.Fatal("expected nil error for NOERROR")
+		}
+	})
+	t.Run("for ErrOODNSNoAnswer", func(t *testing.T) {
+		if toFailureString(ErrOODNSNoAnswer) != FailureDNSNoAnswer {
+			t.Fatal("unexpected result")
+		}
+	})
 	t.Run("for context.Canceled", func(t *testing.T) {
 		if toFailureString(context.Canceled) != FailureInterrupted {
 			t.Fatal("unexpected result")
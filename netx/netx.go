@@ -26,9 +26,11 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
+	"fmt"
 	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 
 	"github.com/lucas-clemente/quic-go"
 	"github.com/ooni/probe-engine/internal/runtimex"
@@ -68,6 +70,11 @@ type HTTPRoundTripper interface {
 	CloseIdleConnections()
 }
 
+// QUICDialer is the definition of a QUIC dialer assumed by this package.
+type QUICDialer interface {
+	DialContext(ctx context.Context, network, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlySession, error)
+}
+
 // Resolver is the interface we expect from a resolver
 type Resolver interface {
 	LookupHost(ctx context.Context, hostname string) (addrs []string, err error)
@@ -90,10 +97,14 @@ type Config struct {
 	DNSCache            map[string][]string  // default: cache is empty
 	DialSaver           *trace.Saver         // default: not saving dials
 	Dialer              Dialer               // default: dialer.DNSDialer
+	ECHConfigList       []byte               // default: use the HTTPS record
+	ECHEnabled          bool                 // default: no Encrypted ClientHello
 	FullResolver        Resolver             // default: base resolver + goodies
 	HTTP3Dialer         HTTP3Dialer          // default: dialer.HTTP3DNSDialer
 	HTTP3Enabled        bool                 // default: disabled
 	HTTPSaver           *trace.Saver         // default: not saving HTTP
+	HappyEyeballs       bool                 // default: sequential dialing
+	Impairment          *dialer.Impairment   // default: no impairment
 	Logger              Logger               // default: no logging
 	NoTLSVerify         bool                 // default: perform TLS verify
 	ProxyURL            *url.URL             // default: no proxy
@@ -101,6 +112,9 @@ type Config struct {
 	ResolveSaver        *trace.Saver         // default: not saving resolves
 	TLSConfig           *tls.Config          // default: attempt using h2
 	TLSDialer           TLSDialer            // default: dialer.TLSDialer
+	TLSPaddingLen       int                  // default: use the parrot's padding
+	TLSParrot           string               // default: use crypto/tls (ignored with ECHEnabled)
+	TLSSPKIPins         map[string][]string  // default: no SPKI pinning
 	TLSSaver            *trace.Saver         // default: not saving TLS
 }
 
@@ -119,6 +133,33 @@ func NewDefaultCertPool() *x509.CertPool {
 
 var defaultCertPool *x509.CertPool = NewDefaultCertPool()
 
+// ErrNoCertificates indicates that a PEM bundle does not
+// contain any valid certificate.
+var ErrNoCertificates = errors.New("netx: no certificates in PEM bundle")
+
+// NewDefaultCertPoolWithPEMs returns a copy of the default x509 certificate
+// pool to which we have added the certificates inside the PEM bundles. We
+// fail if any bundle does not contain at least a valid certificate.
+func NewDefaultCertPoolWithPEMs(bundles ...[]byte) (*x509.CertPool, error) {
+	return appendPEMs(NewDefaultCertPool(), bundles...)
+}
+
+// NewCertPoolWithPEMs is like NewDefaultCertPoolWithPEMs except that it
+// uses the certificates in bundle, e.g., a more recent bundle downloaded
+// as a resource, rather than the vendored gocertifi bundle.
+func NewCertPoolWithPEMs(bundle []byte, extra ...[]byte) (*x509.CertPool, error) {
+	return appendPEMs(x509.NewCertPool(), append([][]byte{bundle}, extra...)...)
+}
+
+func appendPEMs(pool *x509.CertPool, bundles ...[]byte) (*x509.CertPool, error) {
+	for _, bundle := range bundles {
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, ErrNoCertificates
+		}
+	}
+	return pool, nil
+}
+
 // NewResolver creates a new resolver from the specified config
 func NewResolver(config Config) Resolver {
 	if config.BaseResolver == nil {
@@ -155,6 +196,7 @@ func NewDialer(config Config) Dialer {
 		config.FullResolver = NewResolver(config)
 	}
 	var d Dialer = selfcensor.SystemDialer{}
+	d = dialer.ImpairmentDialer{Dialer: d, Impairment: config.Impairment}
 	d = dialer.TimeoutDialer{Dialer: d}
 	d = dialer.ErrorWrapperDialer{Dialer: d}
 	if config.Logger != nil {
@@ -166,7 +208,8 @@ func NewDialer(config Config) Dialer {
 	if config.ReadWriteSaver != nil {
 		d = dialer.SaverConnDialer{Dialer: d, Saver: config.ReadWriteSaver}
 	}
-	d = dialer.DNSDialer{Resolver: config.FullResolver, Dialer: d}
+	d = dialer.DNSDialer{
+		Resolver: config.FullResolver, Dialer: d, HappyEyeballs: config.HappyEyeballs}
 	d = dialer.ProxyDialer{ProxyURL: config.ProxyURL, Dialer: d}
 	if config.ContextByteCounting {
 		d = dialer.ByteCounterDialer{Dialer: d}
@@ -177,12 +220,21 @@ func NewDialer(config Config) Dialer {
 
 // NewHTTP3Dialer creates a new DNS Dialer for HTTP3 transport, with the resolver from the specified config
 func NewHTTP3Dialer(config Config) HTTP3Dialer {
+	var dialer HTTP3Dialer = &httptransport.HTTP3WrapperDialer{Dialer: NewQUICDialer(config)}
+	return dialer
+}
+
+// NewQUICDialer creates a new DNS Dialer for QUIC, with the resolver from the specified
+// config. If config.TLSSaver is not nil, we also save the QUIC handshake events.
+func NewQUICDialer(config Config) QUICDialer {
 	if config.FullResolver == nil {
 		config.FullResolver = NewResolver(config)
 	}
 	d := &dialer.HTTP3DNSDialer{Resolver: config.FullResolver}
-	var dialer HTTP3Dialer = &httptransport.HTTP3WrapperDialer{Dialer: d}
-	return dialer
+	if config.TLSSaver != nil {
+		d.DialEarlyContext = dialer.SaverQUICHandshaker{Saver: config.TLSSaver}.DialEarlyContext
+	}
+	return d
 }
 
 // NewTLSDialer creates a new TLSDialer from the specified config
@@ -191,13 +243,35 @@ func NewTLSDialer(config Config) TLSDialer {
 		config.Dialer = NewDialer(config)
 	}
 	var h tlsHandshaker = dialer.SystemTLSHandshaker{}
+	parrot := config.TLSParrot
+	if config.ECHEnabled && parrot != "" {
+		if config.Logger != nil {
+			config.Logger.Debugf(
+				"netx: ignoring TLSParrot %s because ECH requires crypto/tls", parrot)
+		}
+		parrot = "" // ECH requires crypto/tls
+	}
+	if parrot != "" {
+		h = dialer.UTLSHandshaker{PaddingLen: config.TLSPaddingLen, Parrot: parrot}
+	}
+	if len(config.TLSSPKIPins) > 0 {
+		h = dialer.SPKIPinningTLSHandshaker{TLSHandshaker: h, SPKIPins: config.TLSSPKIPins}
+	}
 	h = dialer.TimeoutTLSHandshaker{TLSHandshaker: h}
 	h = dialer.ErrorWrapperTLSHandshaker{TLSHandshaker: h}
 	if config.Logger != nil {
 		h = dialer.LoggingTLSHandshaker{Logger: config.Logger, TLSHandshaker: h}
 	}
 	if config.TLSSaver != nil {
-		h = dialer.SaverTLSHandshaker{TLSHandshaker: h, Saver: config.TLSSaver}
+		h = dialer.SaverTLSHandshaker{
+			TLSHandshaker: h, Fingerprint: parrot, Saver: config.TLSSaver}
+	}
+	if config.ECHEnabled {
+		h = dialer.ECHTLSHandshaker{
+			TLSHandshaker:    h,
+			ConfigList:       config.ECHConfigList,
+			LookupConfigList: newECHConfigListLookup(config),
+		}
 	}
 	if config.TLSConfig == nil {
 		config.TLSConfig = &tls.Config{NextProtos: []string{"h2", "http/1.1"}}
@@ -214,6 +288,37 @@ func NewTLSDialer(config Config) TLSDialer {
 	}
 }
 
+// newECHConfigListLookup returns a function that looks up the
+// ECHConfigList of a domain using its HTTPS record.
+func newECHConfigListLookup(config Config) func(context.Context, string) ([]byte, error) {
+	if config.FullResolver == nil {
+		config.FullResolver = NewResolver(config)
+	}
+	return func(ctx context.Context, domain string) ([]byte, error) {
+		svc, err := resolver.LookupHTTPS(ctx, config.FullResolver, domain)
+		if err != nil {
+			return nil, err
+		}
+		return svc.ECHConfig, nil
+	}
+}
+
+// newHTTPTLSDialerConfig returns the config for creating the TLSDialer
+// used by NewHTTPTransport. Because net/http only speaks HTTP/2 over a
+// *tls.Conn, when we're parroting we only advertise http/1.1.
+func newHTTPTLSDialerConfig(config Config) Config {
+	if config.TLSParrot == "" {
+		return config
+	}
+	if config.TLSConfig == nil {
+		config.TLSConfig = new(tls.Config)
+	} else {
+		config.TLSConfig = config.TLSConfig.Clone()
+	}
+	config.TLSConfig.NextProtos = []string{"http/1.1"}
+	return config
+}
+
 // NewHTTPTransport creates a new HTTPRoundTripper. You can further extend the returned
 // HTTPRoundTripper before wrapping it into an http.Client.
 func NewHTTPTransport(config Config) HTTPRoundTripper {
@@ -221,7 +326,7 @@ func NewHTTPTransport(config Config) HTTPRoundTripper {
 		config.Dialer = NewDialer(config)
 	}
 	if config.TLSDialer == nil {
-		config.TLSDialer = NewTLSDialer(config)
+		config.TLSDialer = NewTLSDialer(newHTTPTLSDialerConfig(config))
 	}
 	if config.HTTP3Dialer == nil {
 		config.HTTP3Dialer = NewHTTP3Dialer(config)
@@ -286,6 +391,14 @@ func (c DNSClient) CloseIdleConnections() {
 	}
 }
 
+// LookupRecords looks up the records of the given qtype. It fails
+// with resolver.ErrLookupNotSupported if the underlying resolver does
+// not know how to look up records other than A and AAAA.
+func (c DNSClient) LookupRecords(
+	ctx context.Context, domain string, qtype uint16) ([]trace.DNSRecord, error) {
+	return resolver.LookupRecords(ctx, c.Resolver, domain, qtype)
+}
+
 // NewDNSClient creates a new DNS client. The config argument is used to
 // create the underlying Dialer and/or HTTP transport, if needed. The URL
 // argument describes the kind of client that we want to make:
@@ -299,6 +412,21 @@ func (c DNSClient) CloseIdleConnections() {
 // - if the URL starts with `udp://`, then we create a client using
 // a resolver that uses the specified UDP endpoint.
 //
+// - if the URL starts with `dot://`, `doq://` or `tcp://`, then we create a
+// client using a resolver that uses the specified DoT, DoQ or TCP endpoint.
+//
+// - if the URL starts with `h3://`, then we create a DoH client using
+// HTTP/3 for the DoH URL obtained by replacing `h3` with `https`.
+//
+// Note that our QUIC implementation does not speak QUIC v1 yet, hence
+// DoQ and HTTP/3 only work with servers accepting QUIC drafts (see the
+// documentation of resolver.DNSOverQUIC for more details).
+//
+// By default, the resolver sends the A query and then the AAAA query. If
+// the URL query string contains `parallel=true` (e.g. `dot://1.1.1.1?parallel=true`),
+// the resolver instead sends both queries at the same time. We remove this
+// key from the URL before using it, so DoH servers never see it.
+//
 // We return error if the URL does not parse or the URL scheme does not
 // fall into one of the cases described above.
 //
@@ -326,6 +454,14 @@ func NewDNSClientWithOverrides(config Config, URL, hostOverride, SNIOverride str
 	if err != nil {
 		return c, err
 	}
+	rawQuery := resolverURL.RawQuery
+	parallel, err := maybeRemoveParallel(resolverURL)
+	if err != nil {
+		return c, err
+	}
+	if resolverURL.RawQuery != rawQuery {
+		URL = resolverURL.String()
+	}
 	config.TLSConfig = &tls.Config{ServerName: SNIOverride}
 	switch resolverURL.Scheme {
 	case "system":
@@ -342,7 +478,21 @@ func NewDNSClientWithOverrides(config Config, URL, hostOverride, SNIOverride str
 				Saver:        config.ResolveSaver,
 			}
 		}
-		c.Resolver = resolver.NewSerialResolver(txp)
+		c.Resolver = newSerialOrParallelResolver(txp, parallel)
+		return c, nil
+	case "h3":
+		config.HTTP3Enabled = true
+		c.httpClient = &http.Client{Transport: NewHTTPTransport(config)}
+		resolverURL.Scheme = "https"
+		var txp resolver.RoundTripper = resolver.NewDNSOverHTTPSWithHostOverride(
+			c.httpClient, resolverURL.String(), hostOverride)
+		if config.ResolveSaver != nil {
+			txp = resolver.SaverDNSTransport{
+				RoundTripper: txp,
+				Saver:        config.ResolveSaver,
+			}
+		}
+		c.Resolver = newSerialOrParallelResolver(txp, parallel)
 		return c, nil
 	case "udp":
 		dialer := NewDialer(config)
@@ -357,7 +507,7 @@ func NewDNSClientWithOverrides(config Config, URL, hostOverride, SNIOverride str
 				Saver:        config.ResolveSaver,
 			}
 		}
-		c.Resolver = resolver.NewSerialResolver(txp)
+		c.Resolver = newSerialOrParallelResolver(txp, parallel)
 		return c, nil
 	case "dot":
 		config.TLSConfig.NextProtos = []string{"dot"}
@@ -374,7 +524,23 @@ func NewDNSClientWithOverrides(config Config, URL, hostOverride, SNIOverride str
 				Saver:        config.ResolveSaver,
 			}
 		}
-		c.Resolver = resolver.NewSerialResolver(txp)
+		c.Resolver = newSerialOrParallelResolver(txp, parallel)
+		return c, nil
+	case "doq":
+		quicDialer := NewQUICDialer(config)
+		endpoint, err := makeValidEndpoint(resolverURL)
+		if err != nil {
+			return c, err
+		}
+		var txp resolver.RoundTripper = resolver.NewDNSOverQUIC(
+			quicDialer.DialContext, endpoint, config.TLSConfig)
+		if config.ResolveSaver != nil {
+			txp = resolver.SaverDNSTransport{
+				RoundTripper: txp,
+				Saver:        config.ResolveSaver,
+			}
+		}
+		c.Resolver = newSerialOrParallelResolver(txp, parallel)
 		return c, nil
 	case "tcp":
 		dialer := NewDialer(config)
@@ -390,18 +556,47 @@ func NewDNSClientWithOverrides(config Config, URL, hostOverride, SNIOverride str
 				Saver:        config.ResolveSaver,
 			}
 		}
-		c.Resolver = resolver.NewSerialResolver(txp)
+		c.Resolver = newSerialOrParallelResolver(txp, parallel)
 		return c, nil
 	default:
 		return c, errors.New("unsupported resolver scheme")
 	}
 }
 
+// parallelQueryKey is the URL query string key that allows to
+// select the resolver.ParallelResolver in NewDNSClient.
+const parallelQueryKey = "parallel"
+
+// maybeRemoveParallel removes the parallelQueryKey from URL, if
+// present, and returns whether we should use a parallel resolver.
+func maybeRemoveParallel(URL *url.URL) (bool, error) {
+	query := URL.Query()
+	if _, found := query[parallelQueryKey]; !found {
+		return false, nil
+	}
+	parallel, err := strconv.ParseBool(query.Get(parallelQueryKey))
+	if err != nil {
+		return false, fmt.Errorf("invalid %s value: %w", parallelQueryKey, err)
+	}
+	query.Del(parallelQueryKey)
+	URL.RawQuery = query.Encode()
+	return parallel, nil
+}
+
+// newSerialOrParallelResolver creates the resolver that
+// uses txp to perform A and AAAA lookups.
+func newSerialOrParallelResolver(txp resolver.RoundTripper, parallel bool) Resolver {
+	if parallel {
+		return resolver.NewParallelResolver(txp)
+	}
+	return resolver.NewSerialResolver(txp)
+}
+
 // makeValidEndpoint makes a valid endpoint for DoT and Do53 given the
 // input URL representing such endpoint. Specifically, we are
 // concerned with the case where the port is missing. In such a
 // case, we ensure that we are using the default port 853 for DoT
-// and default port 53 for TCP and UDP.
+// and DoQ and default port 53 for TCP and UDP.
 func makeValidEndpoint(URL *url.URL) (string, error) {
 	// Implementation note: when we're using a quoted IPv6
 	// address, URL.Host contains the quotes but instead the
@@ -435,7 +630,7 @@ func makeValidEndpoint(URL *url.URL) (string, error) {
 	// For this reason we check again whether we can split it using
 	// net.SplitHostPort. If we cannot, we were in case four.
 	host := URL.Host
-	if URL.Scheme == "dot" {
+	if URL.Scheme == "dot" || URL.Scheme == "doq" {
 		host += ":853"
 	} else {
 		host += ":53"
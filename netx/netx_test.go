@@ -2,8 +2,11 @@ package netx_test
 
 import (
 	"crypto/tls"
+	"encoding/pem"
 	"errors"
+	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 
@@ -244,7 +247,11 @@ func TestNewDialerVanilla(t *testing.T) {
 	if !ok {
 		t.Fatal("not the dialer we expected")
 	}
-	if _, ok := td.Dialer.(selfcensor.SystemDialer); !ok {
+	imd, ok := td.Dialer.(dialer.ImpairmentDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	if _, ok := imd.Dialer.(selfcensor.SystemDialer); !ok {
 		t.Fatal("not the dialer we expected")
 	}
 }
@@ -284,9 +291,64 @@ func TestNewDialerWithResolver(t *testing.T) {
 	if !ok {
 		t.Fatal("not the dialer we expected")
 	}
-	if _, ok := td.Dialer.(selfcensor.SystemDialer); !ok {
+	imd, ok := td.Dialer.(dialer.ImpairmentDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	if _, ok := imd.Dialer.(selfcensor.SystemDialer); !ok {
+		t.Fatal("not the dialer we expected")
+	}
+}
+
+func TestNewDialerWithHappyEyeballs(t *testing.T) {
+	d := netx.NewDialer(netx.Config{HappyEyeballs: true})
+	sd, ok := d.(dialer.ShapingDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	pd, ok := sd.Dialer.(dialer.ProxyDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	dnsd, ok := pd.Dialer.(dialer.DNSDialer)
+	if !ok {
 		t.Fatal("not the dialer we expected")
 	}
+	if !dnsd.HappyEyeballs {
+		t.Fatal("Happy Eyeballs should be enabled")
+	}
+}
+
+func TestNewDialerWithImpairment(t *testing.T) {
+	impairment := &dialer.Impairment{Rate: 1000}
+	d := netx.NewDialer(netx.Config{Impairment: impairment})
+	sd, ok := d.(dialer.ShapingDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	pd, ok := sd.Dialer.(dialer.ProxyDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	dnsd, ok := pd.Dialer.(dialer.DNSDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	ewd, ok := dnsd.Dialer.(dialer.ErrorWrapperDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	td, ok := ewd.Dialer.(dialer.TimeoutDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	imd, ok := td.Dialer.(dialer.ImpairmentDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	if imd.Impairment != impairment {
+		t.Fatal("not the impairment we expected")
+	}
 }
 
 func TestNewDialerWithLogger(t *testing.T) {
@@ -333,7 +395,11 @@ func TestNewDialerWithLogger(t *testing.T) {
 	if !ok {
 		t.Fatal("not the dialer we expected")
 	}
-	if _, ok := td.Dialer.(selfcensor.SystemDialer); !ok {
+	imd, ok := td.Dialer.(dialer.ImpairmentDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	if _, ok := imd.Dialer.(selfcensor.SystemDialer); !ok {
 		t.Fatal("not the dialer we expected")
 	}
 }
@@ -383,7 +449,11 @@ func TestNewDialerWithDialSaver(t *testing.T) {
 	if !ok {
 		t.Fatal("not the dialer we expected")
 	}
-	if _, ok := td.Dialer.(selfcensor.SystemDialer); !ok {
+	imd, ok := td.Dialer.(dialer.ImpairmentDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	if _, ok := imd.Dialer.(selfcensor.SystemDialer); !ok {
 		t.Fatal("not the dialer we expected")
 	}
 }
@@ -433,7 +503,11 @@ func TestNewDialerWithReadWriteSaver(t *testing.T) {
 	if !ok {
 		t.Fatal("not the dialer we expected")
 	}
-	if _, ok := td.Dialer.(selfcensor.SystemDialer); !ok {
+	imd, ok := td.Dialer.(dialer.ImpairmentDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	if _, ok := imd.Dialer.(selfcensor.SystemDialer); !ok {
 		t.Fatal("not the dialer we expected")
 	}
 }
@@ -479,7 +553,11 @@ func TestNewDialerWithContextByteCounting(t *testing.T) {
 	if !ok {
 		t.Fatal("not the dialer we expected")
 	}
-	if _, ok := td.Dialer.(selfcensor.SystemDialer); !ok {
+	imd, ok := td.Dialer.(dialer.ImpairmentDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	if _, ok := imd.Dialer.(selfcensor.SystemDialer); !ok {
 		t.Fatal("not the dialer we expected")
 	}
 }
@@ -666,6 +744,182 @@ func TestNewTLSDialerWithSaver(t *testing.T) {
 	}
 }
 
+func TestNewTLSDialerWithTLSParrot(t *testing.T) {
+	saver := new(trace.Saver)
+	td := netx.NewTLSDialer(netx.Config{
+		TLSPaddingLen: 1024,
+		TLSParrot:     "chrome",
+		TLSSaver:      saver,
+	})
+	rtd, ok := td.(dialer.TLSDialer)
+	if !ok {
+		t.Fatal("not the TLSDialer we expected")
+	}
+	if len(rtd.Config.NextProtos) != 2 {
+		t.Fatal("invalid len(config.NextProtos)")
+	}
+	sth, ok := rtd.TLSHandshaker.(dialer.SaverTLSHandshaker)
+	if !ok {
+		t.Fatal("not the TLSHandshaker we expected")
+	}
+	if sth.Fingerprint != "chrome" {
+		t.Fatal("not the Fingerprint we expected")
+	}
+	ewth, ok := sth.TLSHandshaker.(dialer.ErrorWrapperTLSHandshaker)
+	if !ok {
+		t.Fatal("not the TLSHandshaker we expected")
+	}
+	tth, ok := ewth.TLSHandshaker.(dialer.TimeoutTLSHandshaker)
+	if !ok {
+		t.Fatal("not the TLSHandshaker we expected")
+	}
+	uth, ok := tth.TLSHandshaker.(dialer.UTLSHandshaker)
+	if !ok {
+		t.Fatal("not the TLSHandshaker we expected")
+	}
+	if uth.Parrot != "chrome" {
+		t.Fatal("not the Parrot we expected")
+	}
+	if uth.PaddingLen != 1024 {
+		t.Fatal("not the PaddingLen we expected")
+	}
+}
+
+func TestNewTLSDialerWithSPKIPins(t *testing.T) {
+	pins := map[string][]string{"x.org": {"AAAA"}}
+	td := netx.NewTLSDialer(netx.Config{TLSSPKIPins: pins})
+	rtd, ok := td.(dialer.TLSDialer)
+	if !ok {
+		t.Fatal("not the TLSDialer we expected")
+	}
+	ewth, ok := rtd.TLSHandshaker.(dialer.ErrorWrapperTLSHandshaker)
+	if !ok {
+		t.Fatal("not the TLSHandshaker we expected")
+	}
+	tth, ok := ewth.TLSHandshaker.(dialer.TimeoutTLSHandshaker)
+	if !ok {
+		t.Fatal("not the TLSHandshaker we expected")
+	}
+	pth, ok := tth.TLSHandshaker.(dialer.SPKIPinningTLSHandshaker)
+	if !ok {
+		t.Fatal("not the TLSHandshaker we expected")
+	}
+	if len(pth.SPKIPins["x.org"]) != 1 {
+		t.Fatal("not the SPKIPins we expected")
+	}
+	if _, ok := pth.TLSHandshaker.(dialer.SystemTLSHandshaker); !ok {
+		t.Fatal("not the TLSHandshaker we expected")
+	}
+}
+
+func TestNewTLSDialerWithECH(t *testing.T) {
+	saver := new(trace.Saver)
+	td := netx.NewTLSDialer(netx.Config{
+		ECHConfigList: []byte("deadbeef"),
+		ECHEnabled:    true,
+		TLSParrot:     "chrome",
+		TLSSaver:      saver,
+	})
+	rtd, ok := td.(dialer.TLSDialer)
+	if !ok {
+		t.Fatal("not the TLSDialer we expected")
+	}
+	eth, ok := rtd.TLSHandshaker.(dialer.ECHTLSHandshaker)
+	if !ok {
+		t.Fatal("not the TLSHandshaker we expected")
+	}
+	if string(eth.ConfigList) != "deadbeef" {
+		t.Fatal("not the ConfigList we expected")
+	}
+	if eth.LookupConfigList == nil {
+		t.Fatal("expected non-nil LookupConfigList")
+	}
+	sth, ok := eth.TLSHandshaker.(dialer.SaverTLSHandshaker)
+	if !ok {
+		t.Fatal("not the TLSHandshaker we expected")
+	}
+	if sth.Fingerprint != "" {
+		t.Fatal("ECH should take precedence over the parrot")
+	}
+	ewth, ok := sth.TLSHandshaker.(dialer.ErrorWrapperTLSHandshaker)
+	if !ok {
+		t.Fatal("not the TLSHandshaker we expected")
+	}
+	tth, ok := ewth.TLSHandshaker.(dialer.TimeoutTLSHandshaker)
+	if !ok {
+		t.Fatal("not the TLSHandshaker we expected")
+	}
+	if _, ok := tth.TLSHandshaker.(dialer.SystemTLSHandshaker); !ok {
+		t.Fatal("not the TLSHandshaker we expected")
+	}
+}
+
+type parrotLogger struct {
+	messages []string
+}
+
+func (l *parrotLogger) Debugf(format string, v ...interface{}) {
+	l.messages = append(l.messages, fmt.Sprintf(format, v...))
+}
+
+func (l *parrotLogger) Debug(message string) {
+	l.messages = append(l.messages, message)
+}
+
+func TestNewTLSDialerWithECHLogsIgnoredParrot(t *testing.T) {
+	logger := &parrotLogger{}
+	netx.NewTLSDialer(netx.Config{
+		ECHEnabled: true,
+		Logger:     logger,
+		TLSParrot:  "chrome",
+	})
+	var found bool
+	for _, message := range logger.messages {
+		found = found || strings.Contains(message, "ignoring TLSParrot chrome")
+	}
+	if !found {
+		t.Fatal("we did not log that we ignored the parrot", logger.messages)
+	}
+}
+
+func TestNewHTTPTransportWithTLSParrot(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{NextProtos: []string{"h2", "http/1.1"}}
+	server.StartTLS()
+	defer server.Close()
+	saver := new(trace.Saver)
+	txp := netx.NewHTTPTransport(netx.Config{
+		NoTLSVerify: true,
+		TLSParrot:   "firefox",
+		TLSSaver:    saver,
+	})
+	client := &http.Client{Transport: txp}
+	defer client.CloseIdleConnections()
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	var found bool
+	for _, ev := range saver.Read() {
+		if ev.Name != "tls_handshake_done" {
+			continue
+		}
+		found = true
+		if ev.TLSFingerprint != "firefox" {
+			t.Fatal("not the fingerprint we expected")
+		}
+		// net/http cannot use HTTP/2 over a uTLS conn.
+		if ev.TLSNegotiatedProto != "http/1.1" {
+			t.Fatal("not the negotiated protocol we expected")
+		}
+	}
+	if !found {
+		t.Fatal("we did not save the TLS handshake")
+	}
+}
+
 func TestNewTLSDialerWithNoTLSVerifyAndConfig(t *testing.T) {
 	td := netx.NewTLSDialer(netx.Config{
 		TLSConfig:   new(tls.Config),
@@ -1161,6 +1415,140 @@ func TestNewDNSCLientUDPWithoutPort(t *testing.T) {
 	}
 }
 
+func TestNewDNSClientParallelDoH(t *testing.T) {
+	dnsclient, err := netx.NewDNSClient(
+		netx.Config{}, "https://dns.google/dns-query?parallel=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, ok := dnsclient.Resolver.(resolver.ParallelResolver)
+	if !ok {
+		t.Fatal("not the resolver we expected")
+	}
+	txp, ok := r.Transport().(resolver.DNSOverHTTPS)
+	if !ok {
+		t.Fatal("not the transport we expected")
+	}
+	if txp.URL != "https://dns.google/dns-query" {
+		t.Fatal("we did not remove the parallel key", txp.URL)
+	}
+	dnsclient.CloseIdleConnections()
+}
+
+func TestNewDNSClientParallelUDP(t *testing.T) {
+	dnsclient, err := netx.NewDNSClient(
+		netx.Config{}, "udp://8.8.8.8:53?parallel=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, ok := dnsclient.Resolver.(resolver.ParallelResolver)
+	if !ok {
+		t.Fatal("not the resolver we expected")
+	}
+	if _, ok := r.Transport().(resolver.DNSOverUDP); !ok {
+		t.Fatal("not the transport we expected")
+	}
+	dnsclient.CloseIdleConnections()
+}
+
+func TestNewDNSClientParallelFalse(t *testing.T) {
+	dnsclient, err := netx.NewDNSClient(
+		netx.Config{}, "dot://8.8.8.8:853?parallel=false")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := dnsclient.Resolver.(resolver.SerialResolver); !ok {
+		t.Fatal("not the resolver we expected")
+	}
+	dnsclient.CloseIdleConnections()
+}
+
+func TestNewDNSClientInvalidParallel(t *testing.T) {
+	_, err := netx.NewDNSClient(
+		netx.Config{}, "dot://8.8.8.8:853?parallel=antani")
+	if err == nil || !strings.HasPrefix(err.Error(), "invalid parallel value") {
+		t.Fatal("not the error we expected", err)
+	}
+}
+
+func TestNewDNSClientDoQ(t *testing.T) {
+	dnsclient, err := netx.NewDNSClient(
+		netx.Config{}, "doq://dns.adguard.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, ok := dnsclient.Resolver.(resolver.SerialResolver)
+	if !ok {
+		t.Fatal("not the resolver we expected")
+	}
+	txp, ok := r.Transport().(resolver.DNSOverQUIC)
+	if !ok {
+		t.Fatal("not the transport we expected")
+	}
+	if txp.Address() != "dns.adguard.com:853" {
+		t.Fatal("expected default port to be added")
+	}
+	dnsclient.CloseIdleConnections()
+}
+
+func TestNewDNSClientDoQDNSSaver(t *testing.T) {
+	saver := new(trace.Saver)
+	dnsclient, err := netx.NewDNSClient(
+		netx.Config{ResolveSaver: saver}, "doq://94.140.14.14:784")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, ok := dnsclient.Resolver.(resolver.SerialResolver)
+	if !ok {
+		t.Fatal("not the resolver we expected")
+	}
+	txp, ok := r.Transport().(resolver.SaverDNSTransport)
+	if !ok {
+		t.Fatal("not the transport we expected")
+	}
+	if _, ok := txp.RoundTripper.(resolver.DNSOverQUIC); !ok {
+		t.Fatal("not the transport we expected")
+	}
+	if txp.Address() != "94.140.14.14:784" {
+		t.Fatal("not the address we expected")
+	}
+	dnsclient.CloseIdleConnections()
+}
+
+func TestNewDNSClientHTTP3(t *testing.T) {
+	dnsclient, err := netx.NewDNSClient(
+		netx.Config{}, "h3://dns.google/dns-query")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, ok := dnsclient.Resolver.(resolver.SerialResolver)
+	if !ok {
+		t.Fatal("not the resolver we expected")
+	}
+	txp, ok := r.Transport().(resolver.DNSOverHTTPS)
+	if !ok {
+		t.Fatal("not the transport we expected")
+	}
+	if txp.URL != "https://dns.google/dns-query" {
+		t.Fatal("not the URL we expected", txp.URL)
+	}
+	dnsclient.CloseIdleConnections()
+}
+
+func TestNewQUICDialerWithTLSSaver(t *testing.T) {
+	d := netx.NewQUICDialer(netx.Config{TLSSaver: new(trace.Saver)})
+	hd, ok := d.(*dialer.HTTP3DNSDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	if hd.DialEarlyContext == nil {
+		t.Fatal("expected to see a DialEarlyContext function")
+	}
+	if hd.Resolver == nil {
+		t.Fatal("expected to see a resolver")
+	}
+}
+
 func TestNewDNSClientBadDoTEndpoint(t *testing.T) {
 	_, err := netx.NewDNSClient(
 		netx.Config{}, "dot://bad:endpoint:53")
@@ -1184,3 +1572,67 @@ func TestNewDNSClientBadUDPEndpoint(t *testing.T) {
 		t.Fatal("expected error with bad endpoint")
 	}
 }
+
+func newServerPEM(server *httptest.Server) []byte {
+	return pem.EncodeToMemory(&pem.Block{
+		Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+}
+
+func TestNewDefaultCertPoolWithPEMs(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	pool, err := netx.NewDefaultCertPoolWithPEMs(newServerPEM(server))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: netx.NewHTTPTransport(netx.Config{CertPool: pool})}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
+
+func TestNewDefaultCertPoolWithPEMsFailure(t *testing.T) {
+	pool, err := netx.NewDefaultCertPoolWithPEMs([]byte("not a PEM"))
+	if !errors.Is(err, netx.ErrNoCertificates) {
+		t.Fatal("not the error we expected", err)
+	}
+	if pool != nil {
+		t.Fatal("expected nil pool here")
+	}
+}
+
+func TestNewCertPoolWithPEMs(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	pool, err := netx.NewCertPoolWithPEMs(newServerPEM(server))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pool.Subjects()) != 1 {
+		t.Fatal("the pool should only contain the bundle")
+	}
+	client := &http.Client{Transport: netx.NewHTTPTransport(netx.Config{CertPool: pool})}
+	defer client.CloseIdleConnections()
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
+
+func TestNewCertPoolWithPEMsFailure(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	pool, err := netx.NewCertPoolWithPEMs(newServerPEM(server), []byte("not a PEM"))
+	if !errors.Is(err, netx.ErrNoCertificates) {
+		t.Fatal("not the error we expected", err)
+	}
+	if pool != nil {
+		t.Fatal("expected nil pool here")
+	}
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/ooni/probe-engine/internal/runtimex"
 	"github.com/ooni/probe-engine/netx/errorx"
+	"github.com/ooni/probe-engine/netx/trace"
 )
 
 var privateIPBlocks []*net.IPNet
@@ -68,4 +69,16 @@ func (r BogonResolver) LookupHost(ctx context.Context, hostname string) ([]strin
 	return addrs, err
 }
 
-var _ Resolver = BogonResolver{}
+// LookupRecords implements RecordsResolver.LookupRecords
+func (r BogonResolver) LookupRecords(
+	ctx context.Context, domain string, qtype uint16) ([]trace.DNSRecord, error) {
+	records, err := LookupRecords(ctx, r.Resolver, domain, qtype)
+	for _, record := range records {
+		if (record.Type == "A" || record.Type == "AAAA") && IsBogon(record.Value) {
+			return records, errorx.ErrDNSBogon
+		}
+	}
+	return records, err
+}
+
+var _ RecordsResolver = BogonResolver{}
@@ -3,6 +3,8 @@ package resolver
 import (
 	"context"
 	"sync"
+
+	"github.com/ooni/probe-engine/netx/trace"
 )
 
 // CacheResolver is a resolver that caches successful replies.
@@ -29,6 +31,13 @@ func (r *CacheResolver) LookupHost(
 	return entry, nil
 }
 
+// LookupRecords implements RecordsResolver.LookupRecords. We
+// only cache addresses, so we always forward the lookup.
+func (r *CacheResolver) LookupRecords(
+	ctx context.Context, domain string, qtype uint16) ([]trace.DNSRecord, error) {
+	return LookupRecords(ctx, r.Resolver, domain, qtype)
+}
+
 // Get gets the currently configured entry for domain, or nil
 func (r *CacheResolver) Get(domain string) []string {
 	r.mu.Lock()
@@ -45,3 +54,5 @@ func (r *CacheResolver) Set(domain string, addresses []string) {
 	r.cache[domain] = addresses
 	r.mu.Unlock()
 }
+
+var _ RecordsResolver = &CacheResolver{}
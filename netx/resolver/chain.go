@@ -2,6 +2,8 @@ package resolver
 
 import (
 	"context"
+
+	"github.com/ooni/probe-engine/netx/trace"
 )
 
 // ChainResolver is a chain resolver. The primary resolver is used first and, if that
@@ -20,6 +22,16 @@ func (c ChainResolver) LookupHost(ctx context.Context, hostname string) ([]strin
 	return addrs, err
 }
 
+// LookupRecords implements RecordsResolver.LookupRecords
+func (c ChainResolver) LookupRecords(
+	ctx context.Context, domain string, qtype uint16) ([]trace.DNSRecord, error) {
+	records, err := LookupRecords(ctx, c.Primary, domain, qtype)
+	if err != nil {
+		records, err = LookupRecords(ctx, c.Secondary, domain, qtype)
+	}
+	return records, err
+}
+
 // Network implements Resolver.Network
 func (c ChainResolver) Network() string {
 	return "chain"
@@ -30,4 +42,4 @@ func (c ChainResolver) Address() string {
 	return ""
 }
 
-var _ Resolver = ChainResolver{}
+var _ RecordsResolver = ChainResolver{}
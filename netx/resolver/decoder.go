@@ -1,9 +1,12 @@
 package resolver
 
 import (
-	"errors"
+	"fmt"
+	"strings"
 
 	"github.com/miekg/dns"
+	"github.com/ooni/probe-engine/netx/errorx"
+	"github.com/ooni/probe-engine/netx/trace"
 )
 
 // The Decoder decodes a DNS reply into A or AAAA entries. It will use the
@@ -13,42 +16,144 @@ type Decoder interface {
 	Decode(qtype uint16, data []byte) ([]string, error)
 }
 
+// DNSReply is a decoded DNS reply.
+type DNSReply struct {
+	// Answers contains the records inside the answer section, in
+	// the order in which the server sent them. When the server
+	// follows a CNAME chain, the chain is also here.
+	Answers []trace.DNSRecord
+
+	// AuthenticatedData is the AD bit.
+	AuthenticatedData bool
+
+	// Authority contains the NS and SOA records inside the
+	// authority section, if any.
+	Authority []trace.DNSRecord
+
+	// Name is the name we queried for (e.g. "www.example.com").
+	Name string
+
+	// QueryType is the query type (e.g. "A").
+	QueryType string
+
+	// Rcode is the response code (e.g. "NOERROR", "SERVFAIL").
+	Rcode string
+}
+
+// Err returns the error corresponding to the rcode, if any.
+func (r *DNSReply) Err() error {
+	return errorx.NewDNSRcodeError(r.Rcode)
+}
+
+// Addresses returns the addresses inside the answer
+// section that correspond to the given qtype.
+func (r *DNSReply) Addresses(qtype uint16) []string {
+	var addrs []string
+	if qtype != dns.TypeA && qtype != dns.TypeAAAA {
+		return nil
+	}
+	for _, answer := range r.Records(qtype) {
+		addrs = append(addrs, answer.Value)
+	}
+	return addrs
+}
+
+// Records returns the records inside the answer section that
+// correspond to the given qtype. Because CNAME records are always
+// inside the answer section when the server follows a CNAME chain,
+// when qtype is CNAME we return the whole chain.
+func (r *DNSReply) Records(qtype uint16) []trace.DNSRecord {
+	var records []trace.DNSRecord
+	for _, answer := range r.Answers {
+		if answer.Type == QueryTypeString(qtype) {
+			records = append(records, answer)
+		}
+	}
+	return records
+}
+
 // MiekgDecoder uses github.com/miekg/dns to implement the Decoder.
 type MiekgDecoder struct{}
 
 // Decode implements Decoder.Decode.
 func (d MiekgDecoder) Decode(qtype uint16, data []byte) ([]string, error) {
-	reply := new(dns.Msg)
-	if err := reply.Unpack(data); err != nil {
+	reply, err := d.DecodeReply(data)
+	if err != nil {
 		return nil, err
 	}
-	// TODO(bassosimone): map more errors to net.DNSError names
-	switch reply.Rcode {
-	case dns.RcodeSuccess:
-	case dns.RcodeNameError:
-		return nil, errors.New("ooniresolver: no such host")
-	default:
-		return nil, errors.New("ooniresolver: query failed")
-	}
-	var addrs []string
-	for _, answer := range reply.Answer {
-		switch qtype {
-		case dns.TypeA:
-			if rra, ok := answer.(*dns.A); ok {
-				ip := rra.A
-				addrs = append(addrs, ip.String())
-			}
-		case dns.TypeAAAA:
-			if rra, ok := answer.(*dns.AAAA); ok {
-				ip := rra.AAAA
-				addrs = append(addrs, ip.String())
-			}
-		}
+	if err := reply.Err(); err != nil {
+		return nil, err
 	}
+	addrs := reply.Addresses(qtype)
 	if len(addrs) <= 0 {
-		return nil, errors.New("ooniresolver: no response returned")
+		return nil, errorx.ErrOODNSNoAnswer
 	}
 	return addrs, nil
 }
 
+// DecodeReply decodes the whole DNS reply. It only fails if the
+// reply is not a valid DNS message. It is the caller's job to
+// check the rcode, e.g., using DNSReply.Err.
+func (d MiekgDecoder) DecodeReply(data []byte) (*DNSReply, error) {
+	msg := new(dns.Msg)
+	if err := msg.Unpack(data); err != nil {
+		return nil, err
+	}
+	reply := &DNSReply{
+		AuthenticatedData: msg.AuthenticatedData,
+		Rcode:             dns.RcodeToString[msg.Rcode],
+	}
+	if len(msg.Question) > 0 {
+		reply.Name = strings.TrimSuffix(msg.Question[0].Name, ".")
+		reply.QueryType = QueryTypeString(msg.Question[0].Qtype)
+	}
+	for _, rr := range msg.Answer {
+		reply.Answers = append(reply.Answers, newDNSRecord(rr))
+	}
+	for _, rr := range msg.Ns {
+		switch rr.(type) {
+		case *dns.NS, *dns.SOA:
+			reply.Authority = append(reply.Authority, newDNSRecord(rr))
+		}
+	}
+	return reply, nil
+}
+
+// newDNSRecord converts a miekg/dns resource record to a trace.DNSRecord.
+func newDNSRecord(rr dns.RR) trace.DNSRecord {
+	header := rr.Header()
+	record := trace.DNSRecord{
+		Name: strings.TrimSuffix(header.Name, "."),
+		TTL:  header.Ttl,
+		Type: QueryTypeString(header.Rrtype),
+	}
+	if header.Rrtype == TypeSVCB || header.Rrtype == TypeHTTPS {
+		value, err := svcbRecordValue(rr)
+		if err == nil {
+			record.Value = value
+			return record
+		}
+	}
+	switch v := rr.(type) {
+	case *dns.A:
+		record.Value = v.A.String()
+	case *dns.AAAA:
+		record.Value = v.AAAA.String()
+	case *dns.CNAME:
+		record.Value = strings.TrimSuffix(v.Target, ".")
+	case *dns.NS:
+		record.Value = strings.TrimSuffix(v.Ns, ".")
+	case *dns.MX:
+		record.Value = fmt.Sprintf("%d %s", v.Preference, strings.TrimSuffix(v.Mx, "."))
+	case *dns.TXT:
+		record.Value = strings.Join(v.Txt, "")
+	default:
+		// The presentation format is the header followed by the
+		// record data; fields are separated by tabs.
+		data := strings.TrimPrefix(rr.String(), header.String())
+		record.Value = strings.Join(strings.Fields(data), " ")
+	}
+	return record
+}
+
 var _ Decoder = MiekgDecoder{}
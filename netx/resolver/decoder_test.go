@@ -1,11 +1,15 @@
 package resolver_test
 
 import (
+	"errors"
 	"strings"
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
 	"github.com/miekg/dns"
+	"github.com/ooni/probe-engine/netx/errorx"
 	"github.com/ooni/probe-engine/netx/resolver"
+	"github.com/ooni/probe-engine/netx/trace"
 )
 
 func TestDecoderUnpackError(t *testing.T) {
@@ -32,15 +36,108 @@ func TestDecoderNXDOMAIN(t *testing.T) {
 
 func TestDecoderOtherError(t *testing.T) {
 	d := resolver.MiekgDecoder{}
-	data, err := d.Decode(dns.TypeA, resolver.GenReplyError(t, dns.RcodeRefused))
-	if err == nil || !strings.HasSuffix(err.Error(), "query failed") {
-		t.Fatal("not the error we expected")
+	data, err := d.Decode(dns.TypeA, resolver.GenReplyError(t, dns.RcodeYXDomain))
+	if err == nil || !strings.HasSuffix(err.Error(), "query failed: YXDOMAIN") {
+		t.Fatal("not the error we expected", err)
 	}
 	if data != nil {
 		t.Fatal("expected nil data here")
 	}
 }
 
+func TestDecoderRcodeErrors(t *testing.T) {
+	d := resolver.MiekgDecoder{}
+	for rcode, expected := range map[int]error{
+		dns.RcodeFormatError:    errorx.ErrOODNSFormat,
+		dns.RcodeServerFailure:  errorx.ErrOODNSServfail,
+		dns.RcodeNotImplemented: errorx.ErrOODNSNotImplemented,
+		dns.RcodeRefused:        errorx.ErrOODNSRefused,
+		dns.RcodeNotAuth:        errorx.ErrOODNSMisbehaving,
+	} {
+		data, err := d.Decode(dns.TypeA, resolver.GenReplyError(t, rcode))
+		if !errors.Is(err, expected) {
+			t.Fatalf("%d: not the error we expected: %+v", rcode, err)
+		}
+		if data != nil {
+			t.Fatal("expected nil data here")
+		}
+	}
+}
+
+func TestDecoderDecodeReply(t *testing.T) {
+	query := new(dns.Msg)
+	query.SetQuestion("www.example.com.", dns.TypeA)
+	reply := new(dns.Msg)
+	reply.SetReply(query)
+	reply.AuthenticatedData = true
+	for _, rr := range []string{
+		"www.example.com. 300 IN CNAME cdn.example.net.",
+		"cdn.example.net. 60 IN A 192.0.2.1",
+	} {
+		parsed, err := dns.NewRR(rr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reply.Answer = append(reply.Answer, parsed)
+	}
+	for _, rr := range []string{
+		"example.net. 3600 IN SOA ns1.example.net. admin.example.net. 1 7200 3600 86400 60",
+		"example.net. 3600 IN NS ns1.example.net.",
+	} {
+		parsed, err := dns.NewRR(rr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reply.Ns = append(reply.Ns, parsed)
+	}
+	data, err := reply.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := resolver.MiekgDecoder{}.DecodeReply(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &resolver.DNSReply{
+		Answers: []trace.DNSRecord{{
+			Name:  "www.example.com",
+			TTL:   300,
+			Type:  "CNAME",
+			Value: "cdn.example.net",
+		}, {
+			Name:  "cdn.example.net",
+			TTL:   60,
+			Type:  "A",
+			Value: "192.0.2.1",
+		}},
+		AuthenticatedData: true,
+		Authority: []trace.DNSRecord{{
+			Name:  "example.net",
+			TTL:   3600,
+			Type:  "SOA",
+			Value: "ns1.example.net. admin.example.net. 1 7200 3600 86400 60",
+		}, {
+			Name:  "example.net",
+			TTL:   3600,
+			Type:  "NS",
+			Value: "ns1.example.net",
+		}},
+		Name:      "www.example.com",
+		QueryType: "A",
+		Rcode:     "NOERROR",
+	}
+	if diff := cmp.Diff(expected, decoded); diff != "" {
+		t.Fatal(diff)
+	}
+	if decoded.Err() != nil {
+		t.Fatal("unexpected error")
+	}
+	addrs := decoded.Addresses(dns.TypeA)
+	if len(addrs) != 1 || addrs[0] != "192.0.2.1" {
+		t.Fatal("unexpected addresses", addrs)
+	}
+}
+
 func TestDecoderNoAddress(t *testing.T) {
 	d := resolver.MiekgDecoder{}
 	data, err := d.Decode(dns.TypeA, resolver.GenReplySuccess(t, dns.TypeA))
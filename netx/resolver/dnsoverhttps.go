@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/ooni/probe-engine/internal/httpheader"
+	"github.com/ooni/probe-engine/netx/selfcensor"
 )
 
 // DNSOverHTTPS is a DNS over HTTPS RoundTripper. Requests are submitted over
@@ -33,6 +34,9 @@ func NewDNSOverHTTPSWithHostOverride(client *http.Client, URL, hostOverride stri
 
 // RoundTrip implements RoundTripper.RoundTrip.
 func (t DNSOverHTTPS) RoundTrip(ctx context.Context, query []byte) ([]byte, error) {
+	if reply, injected, err := selfcensor.InjectDNSReply(query); injected {
+		return reply, err
+	}
 	ctx, cancel := context.WithTimeout(ctx, 45*time.Second)
 	defer cancel()
 	req, err := http.NewRequest("POST", t.URL, bytes.NewReader(query))
@@ -0,0 +1,110 @@
+package resolver
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"math"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/ooni/probe-engine/netx/selfcensor"
+)
+
+// QUICDialContextFunc is a generic function for establishing a QUIC session.
+type QUICDialContextFunc func(ctx context.Context, network, address string,
+	tlsConfig *tls.Config, quicConfig *quic.Config) (quic.EarlySession, error)
+
+// DNSOverQUIC is a DNS over QUIC RoundTripper. See RFC9250.
+//
+// As a known bug, like DNSOverTCP, this implementation always creates
+// a new session for each incoming query, thus increasing the response delay.
+//
+// As a known limitation, the version of quic-go we use (v0.19.3) only
+// speaks QUIC drafts 29, 32 and 34, not QUIC v1 (RFC9000). Servers that
+// implement RFC9250 usually only accept QUIC v1, in which case the QUIC
+// handshake fails during version negotiation. Newer quic-go releases,
+// which speak QUIC v1, require a newer Go than the one we support.
+type DNSOverQUIC struct {
+	dial      QUICDialContextFunc
+	address   string
+	tlsConfig *tls.Config
+}
+
+// NewDNSOverQUIC creates a new DNSOverQUIC transport. The tlsConfig
+// argument may be nil. We always override its NextProtos.
+func NewDNSOverQUIC(dial QUICDialContextFunc, address string, tlsConfig *tls.Config) DNSOverQUIC {
+	return DNSOverQUIC{dial: dial, address: address, tlsConfig: tlsConfig}
+}
+
+// RoundTrip implements RoundTripper.RoundTrip.
+func (t DNSOverQUIC) RoundTrip(ctx context.Context, query []byte) ([]byte, error) {
+	if reply, injected, err := selfcensor.InjectDNSReply(query); injected {
+		return reply, err
+	}
+	if len(query) > math.MaxUint16 {
+		return nil, errors.New("query too long")
+	}
+	if len(query) < 2 {
+		return nil, errors.New("query too short")
+	}
+	config := &tls.Config{}
+	if t.tlsConfig != nil {
+		config = t.tlsConfig.Clone()
+	}
+	config.NextProtos = []string{"doq"}
+	sess, err := t.dial(ctx, "udp", t.address, config, &quic.Config{})
+	if err != nil {
+		return nil, err
+	}
+	defer sess.CloseWithError(0, "")
+	stream, err := sess.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err = stream.SetDeadline(time.Now().Add(10 * time.Second)); err != nil {
+		return nil, err
+	}
+	// Write request. As mandated by RFC9250 Sect. 4.2.1, the
+	// message ID must be zero and we must close the stream for
+	// writing after we have sent the query.
+	buf := []byte{byte(len(query) >> 8)}
+	buf = append(buf, byte(len(query)))
+	buf = append(buf, 0, 0)
+	buf = append(buf, query[2:]...)
+	if _, err = stream.Write(buf); err != nil {
+		return nil, err
+	}
+	if err = stream.Close(); err != nil {
+		return nil, err
+	}
+	// Read response
+	header := make([]byte, 2)
+	if _, err = io.ReadFull(stream, header); err != nil {
+		return nil, err
+	}
+	length := int(header[0])<<8 | int(header[1])
+	reply := make([]byte, length)
+	if _, err = io.ReadFull(stream, reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// RequiresPadding returns true for DoQ according to RFC9250.
+func (t DNSOverQUIC) RequiresPadding() bool {
+	return true
+}
+
+// Network returns the transport network, i.e., "doq".
+func (t DNSOverQUIC) Network() string {
+	return "doq"
+}
+
+// Address returns the upstream server address.
+func (t DNSOverQUIC) Address() string {
+	return t.address
+}
+
+var _ RoundTripper = DNSOverQUIC{}
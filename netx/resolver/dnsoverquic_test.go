@@ -0,0 +1,132 @@
+package resolver_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/lucas-clemente/quic-go"
+	"github.com/ooni/probe-engine/netx/resolver"
+)
+
+func newFakeQUICDialer(
+	sess quic.EarlySession, err error, tlsConfigs *[]*tls.Config) resolver.QUICDialContextFunc {
+	return func(ctx context.Context, network, address string,
+		tlsConfig *tls.Config, quicConfig *quic.Config) (quic.EarlySession, error) {
+		*tlsConfigs = append(*tlsConfigs, tlsConfig)
+		return sess, err
+	}
+}
+
+func TestDNSOverQUICTransportQueryTooLarge(t *testing.T) {
+	txp := resolver.NewDNSOverQUIC(nil, "94.140.14.14:853", nil)
+	reply, err := txp.RoundTrip(context.Background(), make([]byte, 1<<18))
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+	if reply != nil {
+		t.Fatal("expected nil reply here")
+	}
+}
+
+func TestDNSOverQUICTransportDialFailure(t *testing.T) {
+	mocked := errors.New("mocked error")
+	var configs []*tls.Config
+	tlsConfig := &tls.Config{ServerName: "dns.adguard.com"}
+	txp := resolver.NewDNSOverQUIC(
+		newFakeQUICDialer(nil, mocked, &configs), "94.140.14.14:853", tlsConfig)
+	reply, err := txp.RoundTrip(context.Background(), make([]byte, 1<<11))
+	if !errors.Is(err, mocked) {
+		t.Fatal("not the error we expected")
+	}
+	if reply != nil {
+		t.Fatal("expected nil reply here")
+	}
+	if len(configs) != 1 || configs[0].ServerName != "dns.adguard.com" {
+		t.Fatal("not the TLS config we expected")
+	}
+	if diff := cmp.Diff([]string{"doq"}, configs[0].NextProtos); diff != "" {
+		t.Fatal(diff)
+	}
+	if tlsConfig.NextProtos != nil {
+		t.Fatal("we should not modify the original TLS config")
+	}
+}
+
+func TestDNSOverQUICTransportOpenStreamFailure(t *testing.T) {
+	mocked := errors.New("mocked error")
+	sess := &resolver.FakeQUICSession{OpenError: mocked}
+	var configs []*tls.Config
+	txp := resolver.NewDNSOverQUIC(
+		newFakeQUICDialer(sess, nil, &configs), "94.140.14.14:853", nil)
+	_, err := txp.RoundTrip(context.Background(), make([]byte, 1<<11))
+	if !errors.Is(err, mocked) {
+		t.Fatal("not the error we expected")
+	}
+	if !sess.Closed {
+		t.Fatal("we did not close the session")
+	}
+}
+
+func TestDNSOverQUICTransportWriteFailure(t *testing.T) {
+	mocked := errors.New("mocked error")
+	sess := &resolver.FakeQUICSession{Stream: &resolver.FakeQUICStream{WriteError: mocked}}
+	var configs []*tls.Config
+	txp := resolver.NewDNSOverQUIC(
+		newFakeQUICDialer(sess, nil, &configs), "94.140.14.14:853", nil)
+	_, err := txp.RoundTrip(context.Background(), make([]byte, 1<<11))
+	if !errors.Is(err, mocked) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestDNSOverQUICTransportShortReply(t *testing.T) {
+	stream := &resolver.FakeQUICStream{Reader: bytes.NewReader([]byte{0, 4, 1})}
+	sess := &resolver.FakeQUICSession{Stream: stream}
+	var configs []*tls.Config
+	txp := resolver.NewDNSOverQUIC(
+		newFakeQUICDialer(sess, nil, &configs), "94.140.14.14:853", nil)
+	_, err := txp.RoundTrip(context.Background(), []byte{0xab, 0xcd, 1})
+	if err == nil || err.Error() != "unexpected EOF" {
+		t.Fatal("not the error we expected", err)
+	}
+}
+
+func TestDNSOverQUICTransportSuccess(t *testing.T) {
+	stream := &resolver.FakeQUICStream{Reader: bytes.NewReader([]byte{0, 3, 1, 2, 3})}
+	sess := &resolver.FakeQUICSession{Stream: stream}
+	var configs []*tls.Config
+	txp := resolver.NewDNSOverQUIC(
+		newFakeQUICDialer(sess, nil, &configs), "94.140.14.14:853", nil)
+	reply, err := txp.RoundTrip(context.Background(), []byte{0xab, 0xcd, 1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]byte{1, 2, 3}, reply); diff != "" {
+		t.Fatal(diff)
+	}
+	// We expect the length prefix and the query with zero ID
+	if diff := cmp.Diff([]byte{0, 4, 0, 0, 1, 2}, stream.Written); diff != "" {
+		t.Fatal(diff)
+	}
+	if !stream.Closed || !sess.Closed {
+		t.Fatal("we did not close the stream or the session")
+	}
+}
+
+func TestDNSOverQUICTransportOK(t *testing.T) {
+	const address = "94.140.14.14:853"
+	txp := resolver.NewDNSOverQUIC(nil, address, nil)
+	if txp.RequiresPadding() != true {
+		t.Fatal("invalid RequiresPadding")
+	}
+	if txp.Network() != "doq" {
+		t.Fatal("invalid Network")
+	}
+	if txp.Address() != address {
+		t.Fatal("invalid Address")
+	}
+}
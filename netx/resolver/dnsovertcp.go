@@ -7,6 +7,8 @@ import (
 	"math"
 	"net"
 	"time"
+
+	"github.com/ooni/probe-engine/netx/selfcensor"
 )
 
 // DialContextFunc is a generic function for dialing a connection.
@@ -47,6 +49,9 @@ func NewDNSOverTLS(dial DialContextFunc, address string) DNSOverTCP {
 
 // RoundTrip implements RoundTripper.RoundTrip.
 func (t DNSOverTCP) RoundTrip(ctx context.Context, query []byte) ([]byte, error) {
+	if reply, injected, err := selfcensor.InjectDNSReply(query); injected {
+		return reply, err
+	}
 	if len(query) > math.MaxUint16 {
 		return nil, errors.New("query too long")
 	}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"net"
 	"time"
+
+	"github.com/ooni/probe-engine/netx/selfcensor"
 )
 
 // Dialer is the network dialer interface assumed by this package.
@@ -24,6 +26,9 @@ func NewDNSOverUDP(dialer Dialer, address string) DNSOverUDP {
 
 // RoundTrip implements RoundTripper.RoundTrip.
 func (t DNSOverUDP) RoundTrip(ctx context.Context, query []byte) ([]byte, error) {
+	if reply, injected, err := selfcensor.InjectDNSReply(query); injected {
+		return reply, err
+	}
 	conn, err := t.dialer.DialContext(ctx, "udp", t.address)
 	if err != nil {
 		return nil, err
@@ -6,6 +6,7 @@ import (
 	"github.com/ooni/probe-engine/legacy/netx/dialid"
 	"github.com/ooni/probe-engine/legacy/netx/transactionid"
 	"github.com/ooni/probe-engine/netx/errorx"
+	"github.com/ooni/probe-engine/netx/trace"
 )
 
 // ErrorWrapperResolver is a Resolver that knows about wrapping errors.
@@ -27,4 +28,19 @@ func (r ErrorWrapperResolver) LookupHost(ctx context.Context, hostname string) (
 	return addrs, err
 }
 
-var _ Resolver = ErrorWrapperResolver{}
+// LookupRecords implements RecordsResolver.LookupRecords
+func (r ErrorWrapperResolver) LookupRecords(
+	ctx context.Context, domain string, qtype uint16) ([]trace.DNSRecord, error) {
+	dialID := dialid.ContextDialID(ctx)
+	txID := transactionid.ContextTransactionID(ctx)
+	records, err := LookupRecords(ctx, r.Resolver, domain, qtype)
+	err = errorx.SafeErrWrapperBuilder{
+		DialID:        dialID,
+		Error:         err,
+		Operation:     errorx.ResolveOperation,
+		TransactionID: txID,
+	}.MaybeBuild()
+	return records, err
+}
+
+var _ RecordsResolver = ErrorWrapperResolver{}
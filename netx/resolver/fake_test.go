@@ -6,6 +6,7 @@ import (
 	"net"
 	"time"
 
+	"github.com/lucas-clemente/quic-go"
 	"github.com/ooni/probe-engine/atomicx"
 )
 
@@ -140,3 +141,49 @@ func (c FakeResolver) Address() string {
 }
 
 var _ Resolver = FakeResolver{}
+
+type FakeQUICSession struct {
+	quic.EarlySession
+	Closed    bool
+	OpenError error
+	Stream    quic.Stream
+}
+
+func (s *FakeQUICSession) OpenStreamSync(ctx context.Context) (quic.Stream, error) {
+	return s.Stream, s.OpenError
+}
+
+func (s *FakeQUICSession) CloseWithError(quic.ErrorCode, string) error {
+	s.Closed = true
+	return nil
+}
+
+type FakeQUICStream struct {
+	quic.Stream
+	Closed           bool
+	Reader           io.Reader
+	SetDeadlineError error
+	WriteError       error
+	Written          []byte
+}
+
+func (s *FakeQUICStream) Read(b []byte) (int, error) {
+	return s.Reader.Read(b)
+}
+
+func (s *FakeQUICStream) Write(b []byte) (int, error) {
+	if s.WriteError != nil {
+		return 0, s.WriteError
+	}
+	s.Written = append(s.Written, b...)
+	return len(b), nil
+}
+
+func (s *FakeQUICStream) Close() error {
+	s.Closed = true
+	return nil
+}
+
+func (s *FakeQUICStream) SetDeadline(t time.Time) error {
+	return s.SetDeadlineError
+}
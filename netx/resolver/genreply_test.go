@@ -74,3 +74,18 @@ func GenReplySuccess(t *testing.T, qtype uint16, ips ...string) []byte {
 	}
 	return data
 }
+
+func GenReplyWithRecords(t *testing.T, qtype uint16, rrs ...dns.RR) []byte {
+	query := new(dns.Msg)
+	query.SetQuestion(dns.Fqdn("x.org"), qtype)
+	reply := new(dns.Msg)
+	reply.Compress = true
+	reply.MsgHdr.RecursionAvailable = true
+	reply.SetReply(query)
+	reply.Answer = rrs
+	data, err := reply.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -3,6 +3,7 @@ package resolver
 import (
 	"context"
 
+	"github.com/ooni/probe-engine/netx/trace"
 	"golang.org/x/net/idna"
 )
 
@@ -21,6 +22,16 @@ func (r IDNAResolver) LookupHost(ctx context.Context, hostname string) ([]string
 	return r.Resolver.LookupHost(ctx, host)
 }
 
+// LookupRecords implements RecordsResolver.LookupRecords
+func (r IDNAResolver) LookupRecords(
+	ctx context.Context, domain string, qtype uint16) ([]trace.DNSRecord, error) {
+	host, err := idna.ToASCII(domain)
+	if err != nil {
+		return nil, err
+	}
+	return LookupRecords(ctx, r.Resolver, host, qtype)
+}
+
 // Network implements Resolver.Network.
 func (r IDNAResolver) Network() string {
 	return "idna"
@@ -31,4 +42,4 @@ func (r IDNAResolver) Address() string {
 	return ""
 }
 
-var _ Resolver = IDNAResolver{}
+var _ RecordsResolver = IDNAResolver{}
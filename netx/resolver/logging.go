@@ -3,6 +3,8 @@ package resolver
 import (
 	"context"
 	"time"
+
+	"github.com/ooni/probe-engine/netx/trace"
 )
 
 // Logger is the logger assumed by this package
@@ -27,4 +29,16 @@ func (r LoggingResolver) LookupHost(ctx context.Context, hostname string) ([]str
 	return addrs, err
 }
 
-var _ Resolver = LoggingResolver{}
+// LookupRecords implements RecordsResolver.LookupRecords
+func (r LoggingResolver) LookupRecords(
+	ctx context.Context, domain string, qtype uint16) ([]trace.DNSRecord, error) {
+	r.Logger.Debugf("resolve %s %s...", QueryTypeString(qtype), domain)
+	start := time.Now()
+	records, err := LookupRecords(ctx, r.Resolver, domain, qtype)
+	stop := time.Now()
+	r.Logger.Debugf("resolve %s %s... (%+v, %+v) in %s",
+		QueryTypeString(qtype), domain, records, err, stop.Sub(start))
+	return records, err
+}
+
+var _ RecordsResolver = LoggingResolver{}
@@ -0,0 +1,254 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/ooni/probe-engine/netx/errorx"
+	"github.com/ooni/probe-engine/netx/trace"
+)
+
+// Query types that the version of github.com/miekg/dns we use
+// may not know about. See RFC9460.
+const (
+	TypeSVCB  = 64
+	TypeHTTPS = 65
+)
+
+// ErrLookupNotSupported indicates that a resolver does not
+// support looking up the records of the requested type.
+var ErrLookupNotSupported = errors.New("resolver: lookup not supported")
+
+// RecordsResolver is a Resolver that also knows how to look up
+// records of types other than A and AAAA. All the resolvers in
+// this package implement RecordsResolver. Wrappers forward the
+// lookup to the Resolver they wrap using LookupRecords.
+type RecordsResolver interface {
+	Resolver
+
+	// LookupRecords returns the records of the given qtype for
+	// domain. When qtype is CNAME, we return the whole CNAME chain.
+	LookupRecords(ctx context.Context, domain string, qtype uint16) ([]trace.DNSRecord, error)
+}
+
+// LookupRecords looks up the records of the given qtype using r. It
+// fails with ErrLookupNotSupported if r is not a RecordsResolver.
+func LookupRecords(
+	ctx context.Context, r Resolver, domain string, qtype uint16) ([]trace.DNSRecord, error) {
+	switch rr := r.(type) {
+	case RecordsResolver:
+		return rr.LookupRecords(ctx, domain, qtype)
+	case SystemResolver, *SystemResolver:
+		return systemLookupRecords(ctx, domain, qtype)
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrLookupNotSupported, r)
+	}
+}
+
+// ParseQueryType converts the name of a query type (e.g. "HTTPS")
+// to the corresponding qtype.
+func ParseQueryType(name string) (uint16, error) {
+	switch name = strings.ToUpper(name); name {
+	case "SVCB":
+		return TypeSVCB, nil
+	case "HTTPS":
+		return TypeHTTPS, nil
+	}
+	if qtype, found := dns.StringToType[name]; found {
+		return qtype, nil
+	}
+	return 0, fmt.Errorf("resolver: unknown query type: %s", name)
+}
+
+// QueryTypeString returns the name of the given qtype.
+func QueryTypeString(qtype uint16) string {
+	switch qtype {
+	case TypeSVCB:
+		return "SVCB"
+	case TypeHTTPS:
+		return "HTTPS"
+	}
+	if name, found := dns.TypeToString[qtype]; found {
+		return name
+	}
+	return fmt.Sprintf("TYPE%d", qtype)
+}
+
+// HTTPSSvc contains the information inside the HTTPS records
+// of a domain. See RFC9460 for more information.
+type HTTPSSvc struct {
+	// ALPN contains the ALPN protocols (e.g. "h3", "h2").
+	ALPN []string
+
+	// ECHConfig contains the ECHConfigList, if any.
+	ECHConfig []byte
+
+	// IPv4 contains the IPv4 hints.
+	IPv4 []string
+
+	// IPv6 contains the IPv6 hints.
+	IPv6 []string
+
+	// Port is the alternative port, if any, or zero.
+	Port uint16
+}
+
+// LookupHTTPS looks up the HTTPS records of domain using r and merges
+// the information they contain, preferring records with lower priority.
+func LookupHTTPS(ctx context.Context, r Resolver, domain string) (*HTTPSSvc, error) {
+	records, err := LookupRecords(ctx, r, domain, TypeHTTPS)
+	if err != nil {
+		return nil, err
+	}
+	svc := &HTTPSSvc{}
+	alpns := make(map[string]bool)
+	for _, record := range sortSVCBRecords(records) {
+		if record.params["alpn"] != "" {
+			for _, alpn := range strings.Split(record.params["alpn"], ",") {
+				if !alpns[alpn] {
+					alpns[alpn] = true
+					svc.ALPN = append(svc.ALPN, alpn)
+				}
+			}
+		}
+		if record.params["ipv4hint"] != "" {
+			svc.IPv4 = append(svc.IPv4, strings.Split(record.params["ipv4hint"], ",")...)
+		}
+		if record.params["ipv6hint"] != "" {
+			svc.IPv6 = append(svc.IPv6, strings.Split(record.params["ipv6hint"], ",")...)
+		}
+		if svc.Port == 0 && record.params["port"] != "" {
+			port, _ := strconv.ParseUint(record.params["port"], 10, 16)
+			svc.Port = uint16(port)
+		}
+		if svc.ECHConfig == nil && record.ech != nil {
+			svc.ECHConfig = record.ech
+		}
+	}
+	return svc, nil
+}
+
+// LookupTXT looks up the TXT records of domain using r.
+func LookupTXT(ctx context.Context, r Resolver, domain string) ([]string, error) {
+	records, err := LookupRecords(ctx, r, domain, dns.TypeTXT)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, record := range records {
+		out = append(out, record.Value)
+	}
+	return out, nil
+}
+
+// LookupMX looks up the MX records of domain using r.
+func LookupMX(ctx context.Context, r Resolver, domain string) ([]*net.MX, error) {
+	records, err := LookupRecords(ctx, r, domain, dns.TypeMX)
+	if err != nil {
+		return nil, err
+	}
+	var out []*net.MX
+	for _, record := range records {
+		var mx net.MX
+		if _, err := fmt.Sscanf(record.Value, "%d %s", &mx.Pref, &mx.Host); err != nil {
+			return nil, fmt.Errorf("resolver: invalid MX record: %s", record.Value)
+		}
+		out = append(out, &mx)
+	}
+	return out, nil
+}
+
+// LookupNS looks up the NS records of domain using r.
+func LookupNS(ctx context.Context, r Resolver, domain string) ([]*net.NS, error) {
+	records, err := LookupRecords(ctx, r, domain, dns.TypeNS)
+	if err != nil {
+		return nil, err
+	}
+	var out []*net.NS
+	for _, record := range records {
+		out = append(out, &net.NS{Host: record.Value})
+	}
+	return out, nil
+}
+
+// LookupCNAME returns the canonical name of domain using r, i.e.,
+// the target of the last CNAME record inside the CNAME chain.
+func LookupCNAME(ctx context.Context, r Resolver, domain string) (string, error) {
+	records, err := LookupRecords(ctx, r, domain, dns.TypeCNAME)
+	if err != nil {
+		return "", err
+	}
+	return records[len(records)-1].Value, nil
+}
+
+// systemLookupRecords implements LookupRecords for the system
+// resolver, which only supports the query types for which the
+// standard library has a lookup function. Because the standard
+// library does not tell us the TTL, we always set it to zero.
+func systemLookupRecords(
+	ctx context.Context, domain string, qtype uint16) ([]trace.DNSRecord, error) {
+	var (
+		records []trace.DNSRecord
+		values  []string
+	)
+	switch qtype {
+	case dns.TypeA, dns.TypeAAAA:
+		addrs, err := SystemResolver{}.LookupHost(ctx, domain)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			if strings.Contains(addr, ":") == (qtype == dns.TypeAAAA) {
+				values = append(values, addr)
+			}
+		}
+	case dns.TypeCNAME:
+		cname, err := net.DefaultResolver.LookupCNAME(ctx, domain)
+		if err != nil {
+			return nil, err
+		}
+		if cname = strings.TrimSuffix(cname, "."); cname != strings.TrimSuffix(domain, ".") {
+			values = append(values, cname)
+		}
+	case dns.TypeMX:
+		mxs, err := net.DefaultResolver.LookupMX(ctx, domain)
+		if err != nil {
+			return nil, err
+		}
+		for _, mx := range mxs {
+			values = append(values, fmt.Sprintf("%d %s", mx.Pref, strings.TrimSuffix(mx.Host, ".")))
+		}
+	case dns.TypeNS:
+		nss, err := net.DefaultResolver.LookupNS(ctx, domain)
+		if err != nil {
+			return nil, err
+		}
+		for _, ns := range nss {
+			values = append(values, strings.TrimSuffix(ns.Host, "."))
+		}
+	case dns.TypeTXT:
+		txts, err := net.DefaultResolver.LookupTXT(ctx, domain)
+		if err != nil {
+			return nil, err
+		}
+		values = txts
+	default:
+		return nil, fmt.Errorf("%w: system: %s", ErrLookupNotSupported, QueryTypeString(qtype))
+	}
+	for _, value := range values {
+		records = append(records, trace.DNSRecord{
+			Name:  strings.TrimSuffix(domain, "."),
+			Type:  QueryTypeString(qtype),
+			Value: value,
+		})
+	}
+	if len(records) <= 0 {
+		return nil, errorx.ErrOODNSNoAnswer
+	}
+	return records, nil
+}
@@ -0,0 +1,255 @@
+package resolver_test
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/miekg/dns"
+	"github.com/ooni/probe-engine/netx/errorx"
+	"github.com/ooni/probe-engine/netx/resolver"
+	"github.com/ooni/probe-engine/netx/trace"
+)
+
+func newHeader(name string, rrtype uint16) dns.RR_Header {
+	return dns.RR_Header{
+		Name:   dns.Fqdn(name),
+		Rrtype: rrtype,
+		Class:  dns.ClassINET,
+		Ttl:    300,
+	}
+}
+
+func newHTTPSRecord(priority byte, params string) dns.RR {
+	// priority, root target, params (already in wire format)
+	return &dns.RFC3597{
+		Hdr:   newHeader("x.org", resolver.TypeHTTPS),
+		Rdata: hex.EncodeToString([]byte{0, priority, 0}) + params,
+	}
+}
+
+func newRecordsResolver(t *testing.T, qtype uint16, rrs ...dns.RR) resolver.Resolver {
+	txp := resolver.FakeTransport{Data: resolver.GenReplyWithRecords(t, qtype, rrs...)}
+	var r resolver.Resolver = resolver.NewSerialResolver(txp)
+	r = resolver.ErrorWrapperResolver{Resolver: r}
+	r = resolver.AddressResolver{Resolver: r}
+	return resolver.IDNAResolver{Resolver: r}
+}
+
+func TestParseQueryType(t *testing.T) {
+	for name, expect := range map[string]uint16{
+		"A":     dns.TypeA,
+		"https": resolver.TypeHTTPS,
+		"SVCB":  resolver.TypeSVCB,
+		"txt":   dns.TypeTXT,
+	} {
+		qtype, err := resolver.ParseQueryType(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if qtype != expect {
+			t.Fatal("unexpected qtype for", name)
+		}
+	}
+	if _, err := resolver.ParseQueryType("antani"); err == nil {
+		t.Fatal("expected an error here")
+	}
+}
+
+func TestQueryTypeString(t *testing.T) {
+	for qtype, expect := range map[uint16]string{
+		dns.TypeMX:         "MX",
+		resolver.TypeHTTPS: "HTTPS",
+		resolver.TypeSVCB:  "SVCB",
+		4000:               "TYPE4000",
+	} {
+		if s := resolver.QueryTypeString(qtype); s != expect {
+			t.Fatal("unexpected name", s)
+		}
+	}
+}
+
+func TestLookupRecordsNotSupported(t *testing.T) {
+	r := resolver.NewFakeResolverWithResult([]string{"1.1.1.1"})
+	_, err := resolver.LookupRecords(context.Background(), r, "x.org", dns.TypeTXT)
+	if !errors.Is(err, resolver.ErrLookupNotSupported) {
+		t.Fatal("not the error we expected", err)
+	}
+}
+
+func TestLookupRecordsNoAnswer(t *testing.T) {
+	r := newRecordsResolver(t, dns.TypeTXT, &dns.A{
+		Hdr: newHeader("x.org", dns.TypeA),
+		A:   net.IPv4(1, 1, 1, 1),
+	})
+	_, err := resolver.LookupTXT(context.Background(), r, "x.org")
+	if !errors.Is(err, errorx.ErrOODNSNoAnswer) {
+		t.Fatal("not the error we expected", err)
+	}
+}
+
+func TestLookupRecordsRcodeError(t *testing.T) {
+	txp := resolver.FakeTransport{Data: resolver.GenReplyError(t, dns.RcodeServerFailure)}
+	r := resolver.NewSerialResolver(txp)
+	_, err := r.LookupRecords(context.Background(), "x.org", dns.TypeTXT)
+	if err == nil || !strings.HasSuffix(err.Error(), "server failure") {
+		t.Fatal("not the error we expected", err)
+	}
+}
+
+func TestLookupHTTPS(t *testing.T) {
+	r := newRecordsResolver(t, resolver.TypeHTTPS,
+		newHTTPSRecord(2, "0001000302683200040004010203040006001020010db8000000000000000000000001"),
+		newHTTPSRecord(1, "00010006026833026832000300020d0500050003010203"),
+	)
+	svc, err := resolver.LookupHTTPS(context.Background(), r, "x.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := &resolver.HTTPSSvc{
+		ALPN:      []string{"h3", "h2"},
+		ECHConfig: []byte{1, 2, 3},
+		IPv4:      []string{"1.2.3.4"},
+		IPv6:      []string{"2001:db8::1"},
+		Port:      3333,
+	}
+	if diff := cmp.Diff(expect, svc); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestLookupHTTPSRecords(t *testing.T) {
+	r := newRecordsResolver(t, resolver.TypeHTTPS,
+		newHTTPSRecord(1, "00010006026833026832000400040102030400050003010203"),
+	)
+	records, err := resolver.LookupRecords(
+		context.Background(), r, "x.org", resolver.TypeHTTPS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []trace.DNSRecord{{
+		Name:  "x.org",
+		TTL:   300,
+		Type:  "HTTPS",
+		Value: "1 . alpn=h3,h2 ipv4hint=1.2.3.4 ech=AQID",
+	}}
+	if diff := cmp.Diff(expect, records); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestLookupTXT(t *testing.T) {
+	r := newRecordsResolver(t, dns.TypeTXT, &dns.TXT{
+		Hdr: newHeader("x.org", dns.TypeTXT),
+		Txt: []string{"v=spf1 ", "-all"},
+	})
+	txts, err := resolver.LookupTXT(context.Background(), r, "x.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"v=spf1 -all"}, txts); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestLookupMX(t *testing.T) {
+	r := newRecordsResolver(t, dns.TypeMX, &dns.MX{
+		Hdr:        newHeader("x.org", dns.TypeMX),
+		Preference: 10,
+		Mx:         "mx.x.org.",
+	})
+	mxs, err := resolver.LookupMX(context.Background(), r, "x.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]*net.MX{{Host: "mx.x.org", Pref: 10}}, mxs); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestLookupNS(t *testing.T) {
+	r := newRecordsResolver(t, dns.TypeNS, &dns.NS{
+		Hdr: newHeader("x.org", dns.TypeNS),
+		Ns:  "ns1.x.org.",
+	})
+	nss, err := resolver.LookupNS(context.Background(), r, "x.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]*net.NS{{Host: "ns1.x.org"}}, nss); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestLookupCNAME(t *testing.T) {
+	r := newRecordsResolver(t, dns.TypeCNAME, &dns.CNAME{
+		Hdr:    newHeader("x.org", dns.TypeCNAME),
+		Target: "y.org.",
+	}, &dns.CNAME{
+		Hdr:    newHeader("y.org", dns.TypeCNAME),
+		Target: "z.org.",
+	})
+	cname, err := resolver.LookupCNAME(context.Background(), r, "x.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cname != "z.org" {
+		t.Fatal("unexpected cname", cname)
+	}
+}
+
+func TestLookupRecordsChainFallback(t *testing.T) {
+	r := resolver.ChainResolver{
+		Primary: resolver.NewSerialResolver(resolver.FakeTransport{Err: errors.New("mocked")}),
+		Secondary: newRecordsResolver(t, dns.TypeNS, &dns.NS{
+			Hdr: newHeader("x.org", dns.TypeNS),
+			Ns:  "ns1.x.org.",
+		}),
+	}
+	nss, err := resolver.LookupNS(context.Background(), r, "x.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nss) != 1 || nss[0].Host != "ns1.x.org" {
+		t.Fatal("unexpected result")
+	}
+}
+
+func TestLookupRecordsBogon(t *testing.T) {
+	r := resolver.BogonResolver{Resolver: newRecordsResolver(t, dns.TypeA, &dns.A{
+		Hdr: newHeader("x.org", dns.TypeA),
+		A:   net.IPv4(10, 0, 0, 1),
+	})}
+	records, err := resolver.LookupRecords(context.Background(), r, "x.org", dns.TypeA)
+	if !errors.Is(err, errorx.ErrDNSBogon) {
+		t.Fatal("not the error we expected", err)
+	}
+	if len(records) != 1 {
+		t.Fatal("expected to see the records")
+	}
+}
+
+func TestLookupRecordsSystemUnsupported(t *testing.T) {
+	_, err := resolver.LookupRecords(
+		context.Background(), resolver.SystemResolver{}, "x.org", resolver.TypeHTTPS)
+	if !errors.Is(err, resolver.ErrLookupNotSupported) {
+		t.Fatal("not the error we expected", err)
+	}
+}
+
+func TestLookupRecordsSystemTXT(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skip test in short mode")
+	}
+	txts, err := resolver.LookupTXT(context.Background(), resolver.SystemResolver{}, "google.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txts) <= 0 {
+		t.Fatal("expected some TXT records")
+	}
+}
@@ -0,0 +1,51 @@
+package resolver
+
+import (
+	"context"
+
+	"github.com/miekg/dns"
+)
+
+// ParallelResolver is a resolver that issues the A and the AAAA query
+// for the requested domain at the same time, using the same transport,
+// and then merges the results. Apart from LookupHost, it behaves
+// exactly like the SerialResolver it is built upon.
+type ParallelResolver struct {
+	SerialResolver
+}
+
+// NewParallelResolver creates a new ParallelResolver instance.
+func NewParallelResolver(t RoundTripper) ParallelResolver {
+	return ParallelResolver{SerialResolver: NewSerialResolver(t)}
+}
+
+// parallelResult is the result of a single query.
+type parallelResult struct {
+	addrs []string
+	err   error
+}
+
+// LookupHost implements Resolver.LookupHost.
+func (r ParallelResolver) LookupHost(ctx context.Context, hostname string) ([]string, error) {
+	resA := make(chan parallelResult)
+	resAAAA := make(chan parallelResult)
+	go r.lookup(ctx, hostname, dns.TypeA, resA)
+	go r.lookup(ctx, hostname, dns.TypeAAAA, resAAAA)
+	A := <-resA
+	AAAA := <-resAAAA
+	if A.err != nil && AAAA.err != nil {
+		return nil, A.err
+	}
+	var addrs []string
+	addrs = append(addrs, A.addrs...)
+	addrs = append(addrs, AAAA.addrs...)
+	return addrs, nil
+}
+
+func (r ParallelResolver) lookup(
+	ctx context.Context, hostname string, qtype uint16, out chan<- parallelResult) {
+	addrs, err := r.roundTripWithRetry(ctx, hostname, qtype)
+	out <- parallelResult{addrs: addrs, err: err}
+}
+
+var _ RecordsResolver = ParallelResolver{}
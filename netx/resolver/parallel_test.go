@@ -0,0 +1,85 @@
+package resolver_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/ooni/probe-engine/netx/resolver"
+)
+
+// ByQtypeTransport replies to queries depending on their qtype.
+type ByQtypeTransport struct {
+	resolver.FakeTransport
+	Replies map[uint16][]byte
+	Errors  map[uint16]error
+
+	mu     sync.Mutex
+	qtypes []uint16
+}
+
+func (txp *ByQtypeTransport) RoundTrip(ctx context.Context, query []byte) ([]byte, error) {
+	msg := new(dns.Msg)
+	if err := msg.Unpack(query); err != nil {
+		return nil, err
+	}
+	qtype := msg.Question[0].Qtype
+	txp.mu.Lock()
+	txp.qtypes = append(txp.qtypes, qtype)
+	txp.mu.Unlock()
+	return txp.Replies[qtype], txp.Errors[qtype]
+}
+
+func TestParallelResolverSuccess(t *testing.T) {
+	txp := &ByQtypeTransport{Replies: map[uint16][]byte{
+		dns.TypeA:    resolver.GenReplySuccess(t, dns.TypeA, "8.8.8.8"),
+		dns.TypeAAAA: resolver.GenReplySuccess(t, dns.TypeAAAA, "2001:4860:4860::8888"),
+	}}
+	r := resolver.NewParallelResolver(txp)
+	addrs, err := r.LookupHost(context.Background(), "dns.google")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(addrs, []string{"8.8.8.8", "2001:4860:4860::8888"}) {
+		t.Fatal("not the addresses we expected", addrs)
+	}
+	if len(txp.qtypes) != 2 {
+		t.Fatal("expected two queries")
+	}
+}
+
+func TestParallelResolverOneQueryFails(t *testing.T) {
+	txp := &ByQtypeTransport{
+		Errors: map[uint16]error{dns.TypeAAAA: errors.New("mocked error")},
+		Replies: map[uint16][]byte{
+			dns.TypeA: resolver.GenReplySuccess(t, dns.TypeA, "8.8.8.8"),
+		},
+	}
+	r := resolver.NewParallelResolver(txp)
+	addrs, err := r.LookupHost(context.Background(), "dns.google")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(addrs, []string{"8.8.8.8"}) {
+		t.Fatal("not the addresses we expected", addrs)
+	}
+}
+
+func TestParallelResolverBothQueriesFail(t *testing.T) {
+	errA := errors.New("mocked error for A")
+	txp := &ByQtypeTransport{Errors: map[uint16]error{
+		dns.TypeA:    errA,
+		dns.TypeAAAA: errors.New("mocked error for AAAA"),
+	}}
+	r := resolver.NewParallelResolver(txp)
+	addrs, err := r.LookupHost(context.Background(), "dns.google")
+	if !errors.Is(err, errA) {
+		t.Fatal("not the error we expected", err)
+	}
+	if addrs != nil {
+		t.Fatal("expected nil addrs here")
+	}
+}
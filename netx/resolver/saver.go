@@ -38,6 +38,34 @@ func (r SaverResolver) LookupHost(ctx context.Context, hostname string) ([]strin
 	return addrs, err
 }
 
+// LookupRecords implements RecordsResolver.LookupRecords
+func (r SaverResolver) LookupRecords(
+	ctx context.Context, domain string, qtype uint16) ([]trace.DNSRecord, error) {
+	start := time.Now()
+	r.Saver.Write(trace.Event{
+		Address:      r.Resolver.Address(),
+		DNSQueryType: QueryTypeString(qtype),
+		Hostname:     domain,
+		Name:         "resolve_start",
+		Proto:        r.Resolver.Network(),
+		Time:         start,
+	})
+	records, err := LookupRecords(ctx, r.Resolver, domain, qtype)
+	stop := time.Now()
+	r.Saver.Write(trace.Event{
+		Address:      r.Resolver.Address(),
+		DNSAnswers:   records,
+		DNSQueryType: QueryTypeString(qtype),
+		Duration:     stop.Sub(start),
+		Err:          err,
+		Hostname:     domain,
+		Name:         "resolve_done",
+		Proto:        r.Resolver.Network(),
+		Time:         stop,
+	})
+	return records, err
+}
+
 // SaverDNSTransport is a DNS transport that saves events
 type SaverDNSTransport struct {
 	RoundTripper
@@ -56,7 +84,7 @@ func (txp SaverDNSTransport) RoundTrip(ctx context.Context, query []byte) ([]byt
 	})
 	reply, err := txp.RoundTripper.RoundTrip(ctx, query)
 	stop := time.Now()
-	txp.Saver.Write(trace.Event{
+	ev := trace.Event{
 		Address:  txp.Address(),
 		DNSQuery: query,
 		DNSReply: reply,
@@ -65,9 +93,20 @@ func (txp SaverDNSTransport) RoundTrip(ctx context.Context, query []byte) ([]byt
 		Name:     "dns_round_trip_done",
 		Proto:    txp.Network(),
 		Time:     stop,
-	})
+	}
+	if err == nil {
+		if decoded, err := (MiekgDecoder{}).DecodeReply(reply); err == nil {
+			ev.DNSAnswers = decoded.Answers
+			ev.DNSAuthData = decoded.AuthenticatedData
+			ev.DNSAuthority = decoded.Authority
+			ev.DNSQueryType = decoded.QueryType
+			ev.DNSRcode = decoded.Rcode
+			ev.Hostname = decoded.Name
+		}
+	}
+	txp.Saver.Write(ev)
 	return reply, err
 }
 
-var _ Resolver = SaverResolver{}
+var _ RecordsResolver = SaverResolver{}
 var _ RoundTripper = SaverDNSTransport{}
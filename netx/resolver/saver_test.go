@@ -8,6 +8,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/miekg/dns"
 	"github.com/ooni/probe-engine/netx/resolver"
 	"github.com/ooni/probe-engine/netx/trace"
 )
@@ -110,6 +111,44 @@ func TestSaverResolverSuccess(t *testing.T) {
 	}
 }
 
+func TestSaverResolverLookupRecords(t *testing.T) {
+	saver := &trace.Saver{}
+	reso := resolver.SaverResolver{
+		Resolver: resolver.NewSerialResolver(resolver.FakeTransport{
+			Data: resolver.GenReplyWithRecords(t, dns.TypeTXT, &dns.TXT{
+				Hdr: dns.RR_Header{
+					Name:   "x.org.",
+					Rrtype: dns.TypeTXT,
+					Class:  dns.ClassINET,
+					Ttl:    60,
+				},
+				Txt: []string{"antani"},
+			}),
+		}),
+		Saver: saver,
+	}
+	records, err := reso.LookupRecords(context.Background(), "x.org", dns.TypeTXT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := saver.Read()
+	if len(ev) != 2 {
+		t.Fatal("expected number of events")
+	}
+	if ev[0].Name != "resolve_start" || ev[0].DNSQueryType != "TXT" {
+		t.Fatal("unexpected resolve_start event")
+	}
+	if ev[1].Name != "resolve_done" || ev[1].DNSQueryType != "TXT" {
+		t.Fatal("unexpected resolve_done event")
+	}
+	if !reflect.DeepEqual(ev[1].DNSAnswers, records) {
+		t.Fatal("unexpected DNSAnswers")
+	}
+	if ev[1].Hostname != "x.org" || ev[1].Err != nil {
+		t.Fatal("unexpected resolve_done event")
+	}
+}
+
 func TestSaverDNSTransportFailure(t *testing.T) {
 	expected := errors.New("no such host")
 	saver := &trace.Saver{}
@@ -209,3 +248,26 @@ func TestSaverDNSTransportSuccess(t *testing.T) {
 		t.Fatal("the saved time is wrong")
 	}
 }
+
+func TestSaverDNSTransportDecodesReply(t *testing.T) {
+	saver := &trace.Saver{}
+	txp := resolver.SaverDNSTransport{
+		RoundTripper: resolver.FakeTransport{
+			Data: resolver.GenReplySuccess(t, dns.TypeA, "1.1.1.1"),
+		},
+		Saver: saver,
+	}
+	if _, err := txp.RoundTrip(context.Background(), []byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	ev := saver.Read()
+	if len(ev) != 2 {
+		t.Fatal("expected number of events")
+	}
+	if ev[1].Hostname != "x.org" || ev[1].DNSQueryType != "A" || ev[1].DNSRcode != "NOERROR" {
+		t.Fatal("unexpected decoded reply", ev[1])
+	}
+	if len(ev[1].DNSAnswers) != 1 || ev[1].DNSAnswers[0].Value != "1.1.1.1" {
+		t.Fatal("unexpected answers", ev[1].DNSAnswers)
+	}
+}
@@ -7,6 +7,8 @@ import (
 
 	"github.com/miekg/dns"
 	"github.com/ooni/probe-engine/atomicx"
+	"github.com/ooni/probe-engine/netx/errorx"
+	"github.com/ooni/probe-engine/netx/trace"
 )
 
 // RoundTripper represents an abstract DNS transport.
@@ -71,13 +73,35 @@ func (r SerialResolver) LookupHost(ctx context.Context, hostname string) ([]stri
 	return addrs, nil
 }
 
+// LookupRecords implements RecordsResolver.LookupRecords.
+func (r SerialResolver) LookupRecords(
+	ctx context.Context, domain string, qtype uint16) ([]trace.DNSRecord, error) {
+	var records []trace.DNSRecord
+	err := r.withRetry(func() (err error) {
+		records, err = r.roundTripRecords(ctx, domain, qtype)
+		return
+	})
+	return records, err
+}
+
 func (r SerialResolver) roundTripWithRetry(
 	ctx context.Context, hostname string, qtype uint16) ([]string, error) {
+	var addrs []string
+	err := r.withRetry(func() (err error) {
+		addrs, err = r.roundTrip(ctx, hostname, qtype)
+		return
+	})
+	return addrs, err
+}
+
+// withRetry calls fn until it succeeds or fails with a non
+// timeout error, for at most three times.
+func (r SerialResolver) withRetry(fn func() error) error {
 	var errorslist []error
 	for i := 0; i < 3; i++ {
-		replies, err := r.roundTrip(ctx, hostname, qtype)
+		err := fn()
 		if err == nil {
-			return replies, nil
+			return nil
 		}
 		errorslist = append(errorslist, err)
 		var operr *net.OpError
@@ -94,7 +118,7 @@ func (r SerialResolver) roundTripWithRetry(
 	// bugfix: we MUST return one of the errors otherwise we confuse the
 	// mechanism in errwrap that classifies the root cause operation, since
 	// it would not be able to find a child with a major operation error
-	return nil, errorslist[0]
+	return errorslist[0]
 }
 
 func (r SerialResolver) roundTrip(
@@ -110,4 +134,30 @@ func (r SerialResolver) roundTrip(
 	return r.Decoder.Decode(qtype, replydata)
 }
 
-var _ Resolver = SerialResolver{}
+func (r SerialResolver) roundTripRecords(
+	ctx context.Context, domain string, qtype uint16) ([]trace.DNSRecord, error) {
+	querydata, err := r.Encoder.Encode(domain, qtype, r.Txp.RequiresPadding())
+	if err != nil {
+		return nil, err
+	}
+	replydata, err := r.Txp.RoundTrip(ctx, querydata)
+	if err != nil {
+		return nil, err
+	}
+	// Note: we always use MiekgDecoder here because the Decoder
+	// interface only knows about addresses.
+	reply, err := MiekgDecoder{}.DecodeReply(replydata)
+	if err != nil {
+		return nil, err
+	}
+	if err := reply.Err(); err != nil {
+		return nil, err
+	}
+	records := reply.Records(qtype)
+	if len(records) <= 0 {
+		return nil, errorx.ErrOODNSNoAnswer
+	}
+	return records, nil
+}
+
+var _ RecordsResolver = SerialResolver{}
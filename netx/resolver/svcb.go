@@ -0,0 +1,187 @@
+package resolver
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/ooni/probe-engine/netx/trace"
+)
+
+// errInvalidSVCB indicates that the SVCB rdata is not valid.
+var errInvalidSVCB = errors.New("resolver: invalid SVCB record")
+
+// svcbKeys maps the SvcParamKeys we know about to their names. See
+// RFC9460 Sect. 14.3.2 and draft-ietf-tls-esni for the "ech" key.
+var svcbKeys = map[uint16]string{
+	0: "mandatory",
+	1: "alpn",
+	2: "no-default-alpn",
+	3: "port",
+	4: "ipv4hint",
+	5: "ech",
+	6: "ipv6hint",
+}
+
+// svcbRecordValue returns the presentation format of a SVCB or HTTPS
+// record (e.g. "1 . alpn=h3,h2 ipv4hint=104.16.132.229"). We parse the
+// wire format ourselves because the version of github.com/miekg/dns
+// we use may not know about these record types.
+func svcbRecordValue(rr dns.RR) (string, error) {
+	buf := make([]byte, dns.MaxMsgSize)
+	end, err := dns.PackRR(rr, buf, 0, nil, false)
+	if err != nil {
+		return "", err
+	}
+	_, off, err := dns.UnpackDomainName(buf, 0)
+	if err != nil {
+		return "", err
+	}
+	off += 10 // type, class, TTL and rdlength
+	if off > end {
+		return "", errInvalidSVCB
+	}
+	return svcbParseRdata(buf[off:end])
+}
+
+// svcbParseRdata parses the SVCB rdata in wire format.
+func svcbParseRdata(rdata []byte) (string, error) {
+	if len(rdata) < 2 {
+		return "", errInvalidSVCB
+	}
+	priority := binary.BigEndian.Uint16(rdata)
+	target, off, err := dns.UnpackDomainName(rdata, 2)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", errInvalidSVCB, err.Error())
+	}
+	fields := []string{strconv.Itoa(int(priority)), target}
+	for off < len(rdata) {
+		if len(rdata)-off < 4 {
+			return "", errInvalidSVCB
+		}
+		key := binary.BigEndian.Uint16(rdata[off:])
+		length := int(binary.BigEndian.Uint16(rdata[off+2:]))
+		off += 4
+		if len(rdata)-off < length {
+			return "", errInvalidSVCB
+		}
+		value, err := svcbParamValue(key, rdata[off:off+length])
+		if err != nil {
+			return "", err
+		}
+		off += length
+		name, found := svcbKeys[key]
+		if !found {
+			name = fmt.Sprintf("key%d", key)
+		}
+		if value != "" {
+			name += "=" + value
+		}
+		fields = append(fields, name)
+	}
+	return strings.Join(fields, " "), nil
+}
+
+// svcbParamValue returns the presentation format of a SvcParamValue.
+func svcbParamValue(key uint16, value []byte) (string, error) {
+	var out []string
+	switch key {
+	case 0: // mandatory
+		if len(value)%2 != 0 {
+			return "", errInvalidSVCB
+		}
+		for ; len(value) > 0; value = value[2:] {
+			k := binary.BigEndian.Uint16(value)
+			if name, found := svcbKeys[k]; found {
+				out = append(out, name)
+			} else {
+				out = append(out, fmt.Sprintf("key%d", k))
+			}
+		}
+	case 1: // alpn
+		for len(value) > 0 {
+			length := int(value[0])
+			if len(value) < 1+length {
+				return "", errInvalidSVCB
+			}
+			out = append(out, string(value[1:1+length]))
+			value = value[1+length:]
+		}
+	case 3: // port
+		if len(value) != 2 {
+			return "", errInvalidSVCB
+		}
+		out = append(out, strconv.Itoa(int(binary.BigEndian.Uint16(value))))
+	case 4, 6: // ipv4hint, ipv6hint
+		size := net.IPv4len
+		if key == 6 {
+			size = net.IPv6len
+		}
+		if len(value)%size != 0 {
+			return "", errInvalidSVCB
+		}
+		for ; len(value) > 0; value = value[size:] {
+			out = append(out, net.IP(value[:size]).String())
+		}
+	case 2: // no-default-alpn
+		// nothing
+	default: // ech and unknown keys
+		out = append(out, base64.StdEncoding.EncodeToString(value))
+	}
+	return strings.Join(out, ","), nil
+}
+
+// svcbRecord is a SVCB or HTTPS record parsed from the
+// presentation format generated by svcbRecordValue.
+type svcbRecord struct {
+	ech      []byte
+	params   map[string]string
+	priority int
+	target   string
+}
+
+// sortSVCBRecords parses records and sorts them by priority. It
+// ignores the records that are not SVCB or HTTPS records.
+func sortSVCBRecords(records []trace.DNSRecord) []svcbRecord {
+	var out []svcbRecord
+	for _, record := range records {
+		if record.Type != "HTTPS" && record.Type != "SVCB" {
+			continue
+		}
+		fields := strings.Fields(record.Value)
+		if len(fields) < 2 {
+			continue
+		}
+		priority, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+		svcb := svcbRecord{
+			params:   make(map[string]string),
+			priority: priority,
+			target:   fields[1],
+		}
+		for _, field := range fields[2:] {
+			v := strings.SplitN(field, "=", 2)
+			if len(v) == 2 {
+				svcb.params[v[0]] = v[1]
+			} else {
+				svcb.params[v[0]] = ""
+			}
+		}
+		if svcb.params["ech"] != "" {
+			svcb.ech, _ = base64.StdEncoding.DecodeString(svcb.params["ech"])
+		}
+		out = append(out, svcb)
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].priority < out[j].priority
+	})
+	return out
+}
@@ -0,0 +1,51 @@
+package resolver
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestSVCBParseRdata(t *testing.T) {
+	for _, tc := range []struct {
+		rdata  string
+		expect string
+		err    error
+	}{{
+		rdata: "0000",
+		err:   errInvalidSVCB,
+	}, {
+		rdata: "00",
+		err:   errInvalidSVCB,
+	}, {
+		rdata:  "000100",
+		expect: "1 .",
+	}, {
+		rdata:  "000100000000040001000300020000",
+		expect: "1 . mandatory=alpn,port no-default-alpn",
+	}, {
+		rdata:  "000100000300020d05",
+		expect: "1 . port=3333",
+	}, {
+		rdata: "0001000300020d",
+		err:   errInvalidSVCB,
+	}, {
+		rdata: "000100000100020268",
+		err:   errInvalidSVCB,
+	}, {
+		rdata: "00010000040003010203",
+		err:   errInvalidSVCB,
+	}, {
+		rdata:  "000100ffff0001ff",
+		expect: "1 . key65535=/w==",
+	}} {
+		rdata, _ := hex.DecodeString(tc.rdata)
+		value, err := svcbParseRdata(rdata)
+		if !errors.Is(err, tc.err) {
+			t.Fatal(tc.rdata, "not the error we expected", err)
+		}
+		if value != tc.expect {
+			t.Fatal(tc.rdata, "unexpected value", value)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package selfcensor
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// errReset indicates that the connection has been reset.
+var errReset = errors.New("connection reset by peer")
+
+// tlsHandshakeFailureAlert is a TLSv1.2 record containing a fatal
+// handshake_failure alert. See RFC8446 Sect. 6.
+var tlsHandshakeFailureAlert = []byte{21, 3, 3, 0, 2, 2, 40}
+
+// needsConnWrapper returns whether we need to wrap a connection
+// towards address to apply the censorship described by s.
+func needsConnWrapper(s *Spec, address string) bool {
+	return s.BlockedFingerprints != nil || s.BlockedSNIs != nil ||
+		s.HTTPBlockpages != nil || s.ThrottledEndpoints[address] > 0
+}
+
+// connWrapper is a connection that applies censorship. When we
+// censor by injecting a response, we stop forwarding writes and
+// we return the injected response followed by EOF.
+type connWrapper struct {
+	net.Conn
+	blockpages   map[string]string
+	fingerprints map[string]string
+	injected     []byte
+	injecting    bool
+	mu           sync.Mutex
+	rate         int64
+	snis         map[string]string
+}
+
+func newConnWrapper(conn net.Conn, s *Spec, address string) *connWrapper {
+	rate := s.ThrottledEndpoints[address]
+	if rate > 0 {
+		hit("ThrottledEndpoints", address)
+	}
+	return &connWrapper{
+		Conn:         conn,
+		blockpages:   s.HTTPBlockpages,
+		fingerprints: s.BlockedFingerprints,
+		rate:         rate,
+		snis:         s.BlockedSNIs,
+	}
+}
+
+func (c *connWrapper) Read(p []byte) (int, error) {
+	if count, ok, err := c.readInjected(p); ok {
+		return count, err
+	}
+	count, err := c.Conn.Read(p)
+	if err != nil {
+		// We may have interrupted the read to inject a response.
+		if count, ok, err := c.readInjected(p); ok {
+			return count, err
+		}
+	}
+	if count > 0 && c.rate > 0 {
+		time.Sleep(time.Duration(count) * time.Second / time.Duration(c.rate))
+	}
+	return count, err
+}
+
+// readInjected reads from the injected response, if any. The
+// returned bool indicates whether we are injecting a response.
+func (c *connWrapper) readInjected(p []byte) (int, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.injecting {
+		return 0, false, nil
+	}
+	if len(c.injected) <= 0 {
+		return 0, true, io.EOF
+	}
+	count := copy(p, c.injected)
+	c.injected = c.injected[count:]
+	return count, true, nil
+}
+
+// inject arranges for the following reads to return data.
+func (c *connWrapper) inject(data []byte) {
+	c.mu.Lock()
+	c.injected = data
+	c.injecting = true
+	c.mu.Unlock()
+	// Interrupt any pending read. Because we have swallowed the
+	// request, the server is not going to send us anything.
+	c.Conn.SetReadDeadline(time.Now())
+}
+
+func (c *connWrapper) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	injecting := c.injecting
+	c.mu.Unlock()
+	if injecting {
+		return len(p), nil // we are talking with the censor now
+	}
+	// TODO(bassosimone): implement reassembly to workaround the
+	// splitting of the ClientHello message.
+	if _, err := c.match(p, len(p)); err != nil {
+		return 0, err
+	}
+	if sni, found := parseClientHelloSNI(p); found && c.snis != nil {
+		if action, found := c.snis[sni]; found {
+			hit("BlockedSNIs", sni)
+			switch action {
+			case "ALERT":
+				c.inject(tlsHandshakeFailureAlert)
+				return len(p), nil
+			case "TIMEOUT":
+				return 0, errTimeout
+			default:
+				return 0, errReset
+			}
+		}
+	}
+	if host, found := parseHTTPRequestHost(p); found && c.blockpages != nil {
+		if body, found := c.blockpages[host]; found {
+			hit("HTTPBlockpages", host)
+			c.inject([]byte(fmt.Sprintf(
+				"HTTP/1.1 200 OK\r\nContent-Type: text/html\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s",
+				len(body), body)))
+			return len(p), nil
+		}
+	}
+	return c.Conn.Write(p)
+}
+
+func (c *connWrapper) match(p []byte, n int) (int, error) {
+	p = p[:n] // trim
+	for key, value := range c.fingerprints {
+		if bytes.Index(p, []byte(key)) != -1 {
+			hit("BlockedFingerprints", key)
+			if value == "TIMEOUT" {
+				return 0, errTimeout
+			}
+			return 0, errReset
+		}
+	}
+	return n, nil
+}
+
+// parseHTTPRequestHost returns the host, without port, of the
+// plaintext HTTP request inside p, if any.
+func parseHTTPRequestHost(p []byte) (string, bool) {
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(p)))
+	if err != nil {
+		return "", false
+	}
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return host, true
+}
+
+// parseClientHelloSNI returns the SNI of the ClientHello inside p, if
+// any. We assume that p starts with the first record of the handshake,
+// which is what crypto/tls does. See RFC8446 Sect. 4.1.2 and RFC6066.
+func parseClientHelloSNI(p []byte) (string, bool) {
+	// Record header: type (handshake), version, length.
+	if len(p) < 5 || p[0] != 22 {
+		return "", false
+	}
+	p = p[5:]
+	// Handshake header: type (ClientHello), length.
+	if len(p) < 4 || p[0] != 1 {
+		return "", false
+	}
+	p = p[4:]
+	// Version and random.
+	if len(p) < 34 {
+		return "", false
+	}
+	p = p[34:]
+	var ok bool
+	for _, lengthSize := range []int{1, 2, 1} { // session ID, ciphers, compression
+		if p, ok = skipVector(p, lengthSize); !ok {
+			return "", false
+		}
+	}
+	extensions, ok := readVector(p, 2)
+	if !ok {
+		return "", false
+	}
+	for len(extensions) >= 4 {
+		extType := int(extensions[0])<<8 | int(extensions[1])
+		data, ok := readVector(extensions[2:], 2)
+		if !ok {
+			return "", false
+		}
+		extensions = extensions[4+len(data):]
+		if extType != 0 { // server_name
+			continue
+		}
+		names, ok := readVector(data, 2)
+		if !ok {
+			return "", false
+		}
+		for len(names) >= 3 {
+			name, ok := readVector(names[1:], 2)
+			if !ok {
+				return "", false
+			}
+			if names[0] == 0 { // host_name
+				return string(name), true
+			}
+			names = names[3+len(name):]
+		}
+	}
+	return "", false
+}
+
+// readVector reads a vector whose length is encoded using lengthSize bytes.
+func readVector(p []byte, lengthSize int) ([]byte, bool) {
+	if len(p) < lengthSize {
+		return nil, false
+	}
+	var length int
+	for i := 0; i < lengthSize; i++ {
+		length = length<<8 | int(p[i])
+	}
+	p = p[lengthSize:]
+	if len(p) < length {
+		return nil, false
+	}
+	return p[:length], true
+}
+
+// skipVector skips a vector whose length is encoded using lengthSize bytes.
+func skipVector(p []byte, lengthSize int) ([]byte, bool) {
+	vector, ok := readVector(p, lengthSize)
+	if !ok {
+		return nil, false
+	}
+	return p[lengthSize+len(vector):], true
+}
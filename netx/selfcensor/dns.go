@@ -0,0 +1,79 @@
+package selfcensor
+
+import (
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// InjectDNSReply returns the DNS reply to inject in response to the
+// given DNS query, if any. The returned bool indicates whether we are
+// injecting a reply, in which case the caller should return the reply
+// and the error to its caller, without sending the query. The DNS
+// transports of netx/resolver call this function before sending.
+func InjectDNSReply(query []byte) ([]byte, bool, error) {
+	if enabled.Load() == 0 { // jumps not taken by default
+		return nil, false, nil
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	attempts.Add(1)
+	if spec.InjectDNS == nil {
+		return nil, false, nil
+	}
+	msg := new(dns.Msg)
+	if err := msg.Unpack(query); err != nil || len(msg.Question) != 1 {
+		return nil, false, nil
+	}
+	question := msg.Question[0]
+	domain := strings.TrimSuffix(question.Name, ".")
+	values := spec.InjectDNS[domain]
+	if len(values) <= 0 {
+		return nil, false, nil
+	}
+	hit("InjectDNS", domain)
+	reply := new(dns.Msg)
+	reply.SetReply(msg)
+	switch {
+	case len(values) == 1 && values[0] == "TIMEOUT":
+		return nil, true, errTimeout
+	case len(values) == 1 && values[0] == "NXDOMAIN":
+		reply.Rcode = dns.RcodeNameError
+	case len(values) == 1 && values[0] == "SERVFAIL":
+		reply.Rcode = dns.RcodeServerFailure
+	case len(values) == 1 && values[0] == "REFUSED":
+		reply.Rcode = dns.RcodeRefused
+	default:
+		for _, value := range values {
+			if rr := newInjectedRR(question, value); rr != nil {
+				reply.Answer = append(reply.Answer, rr)
+			}
+		}
+	}
+	data, err := reply.Pack()
+	return data, true, err
+}
+
+// newInjectedRR returns the record to inject for the given question
+// and IP address, or nil if the address does not match the query type.
+func newInjectedRR(question dns.Question, value string) dns.RR {
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil
+	}
+	header := dns.RR_Header{
+		Name:   question.Name,
+		Rrtype: question.Qtype,
+		Class:  dns.ClassINET,
+		Ttl:    60,
+	}
+	switch {
+	case question.Qtype == dns.TypeA && ip.To4() != nil:
+		return &dns.A{Hdr: header, A: ip.To4()}
+	case question.Qtype == dns.TypeAAAA && ip.To4() == nil:
+		return &dns.AAAA{Hdr: header, AAAA: ip}
+	default:
+		return nil
+	}
+}
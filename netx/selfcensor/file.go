@@ -0,0 +1,69 @@
+package selfcensor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// EnableFromFile is like Enable except that it reads the Spec from the
+// given file. If the file extension is `.yaml` or `.yml`, we parse
+// the file as YAML, otherwise we parse it as JSON. In both cases, the
+// keys are the names of the Spec fields, as in the JSON case.
+func EnableFromFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if data, err = yamlToJSON(data); err != nil {
+			return err
+		}
+	}
+	return Enable(string(data))
+}
+
+// MaybeEnableFromFile is like EnableFromFile except that it does
+// nothing in case the path provided as argument is an empty string.
+func MaybeEnableFromFile(path string) (err error) {
+	if path != "" {
+		err = EnableFromFile(path)
+	}
+	return
+}
+
+// yamlToJSON converts YAML to JSON, so that we can use the same
+// rules for unmarshalling the Spec both from YAML and from JSON.
+func yamlToJSON(data []byte) ([]byte, error) {
+	var value interface{}
+	if err := yaml.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	return json.Marshal(yamlToJSONValue(value))
+}
+
+// yamlToJSONValue converts the maps returned by the YAML parser, which
+// have interface{} keys, to maps with string keys.
+func yamlToJSONValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{})
+		for key, entry := range v {
+			out[fmt.Sprintf("%v", key)] = yamlToJSONValue(entry)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, 0, len(v))
+		for _, entry := range v {
+			out = append(out, yamlToJSONValue(entry))
+		}
+		return out
+	default:
+		return v
+	}
+}
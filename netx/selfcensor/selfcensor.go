@@ -16,13 +16,25 @@
 //
 //     selfcensor.Enable(`{"BlockedFingerprints":{"dns.google":"RST"}}`)
 //
+// The following example causes a TLS alert when the SNI is `dns.google`:
+//
+//     selfcensor.Enable(`{"BlockedSNIs":{"dns.google":"ALERT"}}`)
+//
+// The following example injects NXDOMAIN for `dns.google` when using the
+// udp, tcp, dot, doh and doq resolvers:
+//
+//     selfcensor.Enable(`{"InjectDNS":{"dns.google":["NXDOMAIN"]}}`)
+//
 // The documentation of the Spec structure contains further information on
 // how to populate the JSON. Miniooni uses the `--self-censor-spec flag` to
-// which you are supposed to pass a serialized JSON.
+// which you are supposed to pass a serialized JSON. You can also load the
+// Spec from a JSON or YAML file using EnableFromFile.
+//
+// We count how many times each rule is triggered. Use Hits to get
+// the counters, which we reset every time the Spec changes.
 package selfcensor
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -48,8 +60,10 @@ type Spec struct {
 	// `IP:port` to block. The format is the same of net.JoinHostPort. If
 	// the value is "REJECT", then the connection attempt will fail with
 	// ECONNREFUSED. If the value is "TIMEOUT", then the connector will return
-	// claiming "i/o timeout". If the value is anything else, we will
-	// perform a "REJECT".
+	// claiming "i/o timeout". If the value is "DROP" and we are using UDP,
+	// then we will silently drop all the datagrams sent to the endpoint,
+	// which also applies to QUIC. If the value is anything else, we will
+	// perform a "REJECT" for TCP and nothing for UDP.
 	BlockedEndpoints map[string]string
 
 	// BlockedFingerprints allows you to block packets whose body contains
@@ -58,11 +72,40 @@ type Spec struct {
 	// is "TIMEOUT", then the code will return claiming "i/o timeout". If
 	// the value is anything else, we will perform a "RST".
 	BlockedFingerprints map[string]string
+
+	// BlockedSNIs allows you to block TLS handshakes whose ClientHello
+	// contains specific SNIs. The key is the SNI. If the value is "ALERT",
+	// then we will respond with a handshake_failure alert. If the value
+	// is "TIMEOUT", then the code will return claiming "i/o timeout". If
+	// the value is anything else, we will perform a "RST".
+	BlockedSNIs map[string]string
+
+	// HTTPBlockpages allows you to respond to plaintext HTTP requests
+	// for specific hosts with a blockpage. The key is the host, without
+	// the port, and the value is the body of the blockpage, which we
+	// send as a 200 response and then close the connection.
+	HTTPBlockpages map[string]string
+
+	// InjectDNS allows you to inject DNS replies for queries sent using
+	// the udp, tcp, dot, doh and doq resolvers. The keys are the domains,
+	// and the values are the IP addresses to return. If you set the values
+	// for a domain to `[]string{"NXDOMAIN"}`, `[]string{"SERVFAIL"}` or
+	// `[]string{"REFUSED"}`, we reply with such rcode. If you set the
+	// values for a domain to `[]string{"TIMEOUT"}`, the resolver will
+	// return "i/o timeout".
+	InjectDNS map[string][]string
+
+	// ThrottledEndpoints allows you to throttle the connections towards
+	// specific IP endpoints. The key is `IP:port` and the value is the
+	// maximum number of bytes per second we will read.
+	ThrottledEndpoints map[string]int64
 }
 
 var (
 	attempts *atomicx.Int64 = atomicx.NewInt64()
 	enabled  *atomicx.Int64 = atomicx.NewInt64()
+	hits     map[string]int64
+	hitsMu   sync.Mutex
 	mu       sync.Mutex
 	spec     *Spec
 )
@@ -80,20 +123,48 @@ func Attempts() int64 {
 	return attempts.Load()
 }
 
+// Hits returns how many times each rule has been triggered since we
+// have last changed the Spec. The key is the name of the Spec field
+// followed by a colon and by the rule key (e.g., `BlockedSNIs:dns.google`).
+func Hits() map[string]int64 {
+	hitsMu.Lock()
+	defer hitsMu.Unlock()
+	out := make(map[string]int64)
+	for key, value := range hits {
+		out[key] = value
+	}
+	return out
+}
+
+// hit records that the rule identified by field and key has been triggered.
+func hit(field, key string) {
+	hitsMu.Lock()
+	defer hitsMu.Unlock()
+	hits[field+":"+key]++
+}
+
 // Enable turns on the self censorship engine. This function returns
 // an error if we cannot parse a Spec from the serialized JSON inside
 // data. Each time you call Enable you overwrite the previous spec.
 func Enable(data string) error {
-	mu.Lock()
-	defer mu.Unlock()
 	s := new(Spec)
 	if err := json.Unmarshal([]byte(data), s); err != nil {
 		return err
 	}
+	enableSpec(s)
+	return nil
+}
+
+// enableSpec enables self censorship using the given spec.
+func enableSpec(s *Spec) {
+	mu.Lock()
+	defer mu.Unlock()
+	hitsMu.Lock()
+	hits = make(map[string]int64)
+	hitsMu.Unlock()
 	spec = s
 	enabled.Add(1)
 	log.Printf("selfcensor: spec %+v", *spec)
-	return nil
 }
 
 // MaybeEnable is like enable except that it does nothing in case
@@ -120,6 +191,9 @@ func (r SystemResolver) LookupHost(ctx context.Context, hostname string) ([]stri
 		attempts.Add(1)
 		if spec.PoisonSystemDNS != nil {
 			values := spec.PoisonSystemDNS[hostname]
+			if len(values) > 0 {
+				hit("PoisonSystemDNS", hostname)
+			}
 			if len(values) == 1 && values[0] == "NXDOMAIN" {
 				return nil, errors.New("no such host")
 			}
@@ -168,6 +242,9 @@ func (d SystemDialer) DialContext(
 		attempts.Add(1)
 		if spec.BlockedEndpoints != nil {
 			action, ok := spec.BlockedEndpoints[address]
+			if ok {
+				hit("BlockedEndpoints", address)
+			}
 			if ok && action == "TIMEOUT" {
 				return nil, errTimeout
 			}
@@ -175,56 +252,23 @@ func (d SystemDialer) DialContext(
 				switch network {
 				case "tcp", "tcp4", "tcp6":
 					return nil, errors.New("connection refused")
+				case "udp", "udp4", "udp6":
+					if action == "DROP" {
+						return dialDroppingUDP(ctx, network, address)
+					}
 				default:
 					// not applicable
 				}
 			}
 		}
-		if spec.BlockedFingerprints != nil {
+		if needsConnWrapper(spec, address) {
 			conn, err := defaultNetDialer.DialContext(ctx, network, address)
 			if err != nil {
 				return nil, err
 			}
-			return connWrapper{Conn: conn, closed: make(chan interface{}, 128),
-				fingerprints: spec.BlockedFingerprints}, nil
+			return newConnWrapper(conn, spec, address), nil
 		}
 		// FALLTHROUGH
 	}
 	return defaultNetDialer.DialContext(ctx, network, address)
 }
-
-type connWrapper struct {
-	net.Conn
-	closed       chan interface{}
-	fingerprints map[string]string
-}
-
-func (c connWrapper) Write(p []byte) (int, error) {
-	// TODO(bassosimone): implement reassembly to workaround the
-	// splitting of the ClientHello message.
-	if _, err := c.match(p, len(p)); err != nil {
-		return 0, err
-	}
-	return c.Conn.Write(p)
-}
-
-func (c connWrapper) match(p []byte, n int) (int, error) {
-	p = p[:n] // trim
-	for key, value := range c.fingerprints {
-		if bytes.Index(p, []byte(key)) != -1 {
-			if value == "TIMEOUT" {
-				return 0, errTimeout
-			}
-			return 0, errors.New("connection reset by peer")
-		}
-	}
-	return n, nil
-}
-
-func (c connWrapper) Close() error {
-	// Implementation note: we will block here if we attempt to close
-	// too many times and noone's reading. Because we have a large buffer,
-	// and because this is integration testing code, that's fine.
-	c.closed <- true
-	return c.Conn.Close()
-}
@@ -0,0 +1,371 @@
+package selfcensor_test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/ooni/probe-engine/netx/errorx"
+	"github.com/ooni/probe-engine/netx/resolver"
+	"github.com/ooni/probe-engine/netx/selfcensor"
+)
+
+func TestBlockedSNIsAlert(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	err := selfcensor.MaybeEnable(`{"BlockedSNIs":{"example.com":"ALERT"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := selfcensor.SystemDialer{}.DialContext(
+		context.Background(), "tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	tlsconn := tls.Client(conn, &tls.Config{
+		InsecureSkipVerify: true,
+		ServerName:         "example.com",
+	})
+	err = tlsconn.Handshake()
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+	if failure := errorx.Classify(err); failure != errorx.TLSAlertFailure(40) {
+		t.Fatal("unexpected failure", failure)
+	}
+	if selfcensor.Hits()["BlockedSNIs:example.com"] != 1 {
+		t.Fatal("we did not record the hit")
+	}
+}
+
+func TestBlockedSNIsNoMatch(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	err := selfcensor.MaybeEnable(`{"BlockedSNIs":{"ooni.io":"RST"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := selfcensor.SystemDialer{}.DialContext(
+		context.Background(), "tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	tlsconn := tls.Client(conn, &tls.Config{
+		InsecureSkipVerify: true,
+		ServerName:         "example.com",
+	})
+	if err := tlsconn.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if len(selfcensor.Hits()) != 0 {
+		t.Fatal("we should not have recorded any hit")
+	}
+}
+
+func TestHTTPBlockpages(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("the real page"))
+		}))
+	defer server.Close()
+	err := selfcensor.MaybeEnable(
+		`{"HTTPBlockpages":{"example.com":"<html>blocked</html>"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: selfcensor.SystemDialer{}.DialContext,
+	}}
+	defer client.CloseIdleConnections()
+	req, err := http.NewRequest("GET", server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Host = "example.com"
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "<html>blocked</html>" {
+		t.Fatal("unexpected body", string(data))
+	}
+	if selfcensor.Hits()["HTTPBlockpages:example.com"] != 1 {
+		t.Fatal("we did not record the hit")
+	}
+}
+
+func TestThrottledEndpoints(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		conn.Write(make([]byte, 2000))
+		conn.Close()
+	}()
+	address := listener.Addr().String()
+	err = selfcensor.MaybeEnable(fmt.Sprintf(`{"ThrottledEndpoints":{"%s":10000}}`, address))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := selfcensor.SystemDialer{}.DialContext(context.Background(), "tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	start := time.Now()
+	data, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 2000 {
+		t.Fatal("unexpected data length")
+	}
+	// Reading 2000 bytes at 10000 bytes/s takes 200ms.
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatal("we did not throttle", elapsed)
+	}
+}
+
+func TestListenUDPWithDROP(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	address := server.LocalAddr().String()
+	err = selfcensor.MaybeEnable(fmt.Sprintf(`{"BlockedEndpoints":{"%s":"DROP"}}`, address))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := selfcensor.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.WriteTo([]byte("abc"), server.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	server.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, _, err = server.ReadFrom(make([]byte, 8))
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatal("not the error we expected", err)
+	}
+	if selfcensor.Hits()["BlockedEndpoints:"+address] != 1 {
+		t.Fatal("we did not record the hit")
+	}
+}
+
+func TestDialUDPWithDROP(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	address := server.LocalAddr().String()
+	err = selfcensor.MaybeEnable(fmt.Sprintf(`{"BlockedEndpoints":{"%s":"DROP"}}`, address))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := selfcensor.SystemDialer{}.DialContext(context.Background(), "udp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	server.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, _, err = server.ReadFrom(make([]byte, 8))
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatal("not the error we expected", err)
+	}
+}
+
+func newQuery(t *testing.T, domain string, qtype uint16) []byte {
+	query := new(dns.Msg)
+	query.SetQuestion(dns.Fqdn(domain), qtype)
+	data, err := query.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestInjectDNSReplyWithIPs(t *testing.T) {
+	err := selfcensor.MaybeEnable(
+		`{"InjectDNS":{"dns.google":["10.0.0.7","fe80::1"]}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, injected, err := selfcensor.InjectDNSReply(newQuery(t, "dns.google", dns.TypeA))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !injected {
+		t.Fatal("expected to inject a reply")
+	}
+	reply := new(dns.Msg)
+	if err := reply.Unpack(data); err != nil {
+		t.Fatal(err)
+	}
+	if len(reply.Answer) != 1 {
+		t.Fatal("unexpected number of answers")
+	}
+	record, ok := reply.Answer[0].(*dns.A)
+	if !ok || record.A.String() != "10.0.0.7" {
+		t.Fatal("unexpected answer")
+	}
+	if selfcensor.Hits()["InjectDNS:dns.google"] != 1 {
+		t.Fatal("we did not record the hit")
+	}
+}
+
+func TestInjectDNSReplyWithRcodes(t *testing.T) {
+	err := selfcensor.MaybeEnable(
+		`{"InjectDNS":{"a.example.com":["NXDOMAIN"],"b.example.com":["SERVFAIL"],"c.example.com":["REFUSED"]}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]int{
+		"a.example.com": dns.RcodeNameError,
+		"b.example.com": dns.RcodeServerFailure,
+		"c.example.com": dns.RcodeRefused,
+	}
+	for domain, rcode := range expect {
+		data, injected, err := selfcensor.InjectDNSReply(newQuery(t, domain, dns.TypeA))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !injected {
+			t.Fatal("expected to inject a reply")
+		}
+		reply := new(dns.Msg)
+		if err := reply.Unpack(data); err != nil {
+			t.Fatal(err)
+		}
+		if reply.Rcode != rcode {
+			t.Fatal("unexpected rcode for", domain)
+		}
+	}
+}
+
+func TestInjectDNSReplyNoMatch(t *testing.T) {
+	err := selfcensor.MaybeEnable(`{"InjectDNS":{"dns.google":["NXDOMAIN"]}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, injected, err := selfcensor.InjectDNSReply(newQuery(t, "ooni.io", dns.TypeA))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if injected || data != nil {
+		t.Fatal("we should not inject a reply")
+	}
+}
+
+func TestInjectDNSWithResolverTransport(t *testing.T) {
+	err := selfcensor.MaybeEnable(`{"InjectDNS":{"dns.google":["NXDOMAIN"]}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Because we inject before sending, the endpoint does not matter.
+	reso := resolver.NewSerialResolver(
+		resolver.NewDNSOverUDP(selfcensor.SystemDialer{}, "127.0.0.1:1"))
+	addrs, err := reso.LookupHost(context.Background(), "dns.google")
+	if err == nil || !strings.HasSuffix(err.Error(), "no such host") {
+		t.Fatal("not the error we expected", err)
+	}
+	if addrs != nil {
+		t.Fatal("expected nil addrs here")
+	}
+}
+
+func TestInjectDNSCauseTimeout(t *testing.T) {
+	err := selfcensor.MaybeEnable(`{"InjectDNS":{"dns.google":["TIMEOUT"]}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reso := resolver.NewSerialResolver(
+		resolver.NewDNSOverTCP(selfcensor.SystemDialer{}.DialContext, "127.0.0.1:1"))
+	addrs, err := reso.LookupHost(context.Background(), "dns.google")
+	if err == nil || err.Error() != "i/o timeout" {
+		t.Fatal("not the error we expected", err)
+	}
+	if addrs != nil {
+		t.Fatal("expected nil addrs here")
+	}
+}
+
+func TestEnableFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "selfcensor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"spec.yaml": "InjectDNS:\n  dns.google:\n    - NXDOMAIN\n",
+		"spec.json": `{"InjectDNS":{"dns.google":["NXDOMAIN"]}}`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := selfcensor.MaybeEnableFromFile(path); err != nil {
+			t.Fatal(err)
+		}
+		_, injected, err := selfcensor.InjectDNSReply(newQuery(t, "dns.google", dns.TypeA))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !injected {
+			t.Fatal("the spec was not loaded from", name)
+		}
+	}
+}
+
+func TestEnableFromFileErrors(t *testing.T) {
+	if err := selfcensor.EnableFromFile("/nonexistent.yaml"); err == nil {
+		t.Fatal("expected an error here")
+	}
+	dir, err := ioutil.TempDir("", "selfcensor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "spec.yml")
+	if err := ioutil.WriteFile(path, []byte("\t:"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := selfcensor.EnableFromFile(path); err == nil {
+		t.Fatal("expected an error here")
+	}
+}
@@ -0,0 +1,60 @@
+package selfcensor
+
+import (
+	"context"
+	"net"
+)
+
+// ListenUDP is like net.ListenUDP except that, when self censorship
+// is enabled, the returned connection silently drops the datagrams sent
+// to the endpoints blocked with "DROP". Use this function to create
+// the sockets used by QUIC, so that we can censor QUIC.
+func ListenUDP(network string, laddr *net.UDPAddr) (net.PacketConn, error) {
+	conn, err := net.ListenUDP(network, laddr)
+	if err != nil {
+		return nil, err
+	}
+	if enabled.Load() != 0 { // jumps not taken by default
+		mu.Lock()
+		defer mu.Unlock()
+		attempts.Add(1)
+		if spec.BlockedEndpoints != nil {
+			return droppingPacketConn{PacketConn: conn, endpoints: spec.BlockedEndpoints}, nil
+		}
+	}
+	return conn, nil
+}
+
+// droppingPacketConn is a net.PacketConn that drops the datagrams
+// sent to endpoints blocked with "DROP".
+type droppingPacketConn struct {
+	net.PacketConn
+	endpoints map[string]string
+}
+
+func (c droppingPacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if c.endpoints[addr.String()] == "DROP" {
+		hit("BlockedEndpoints", addr.String())
+		return len(p), nil
+	}
+	return c.PacketConn.WriteTo(p, addr)
+}
+
+// dialDroppingUDP creates a UDP connection that drops all the
+// datagrams it sends, so reads will block until the deadline.
+func dialDroppingUDP(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := defaultNetDialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	return droppingConn{Conn: conn}, nil
+}
+
+// droppingConn is a net.Conn that drops all the datagrams it sends.
+type droppingConn struct {
+	net.Conn
+}
+
+func (c droppingConn) Write(p []byte) (int, error) {
+	return len(p), nil
+}
@@ -10,7 +10,12 @@ import (
 type Event struct {
 	Addresses          []string            `json:",omitempty"`
 	Address            string              `json:",omitempty"`
+	DNSAnswers         []DNSRecord         `json:",omitempty"`
+	DNSAuthData        bool                `json:",omitempty"`
+	DNSAuthority       []DNSRecord         `json:",omitempty"`
 	DNSQuery           []byte              `json:",omitempty"`
+	DNSQueryType       string              `json:",omitempty"`
+	DNSRcode           string              `json:",omitempty"`
 	DNSReply           []byte              `json:",omitempty"`
 	DataIsTruncated    bool                `json:",omitempty"`
 	Data               []byte              `json:",omitempty"`
@@ -27,10 +32,32 @@ type Event struct {
 	Proto              string              `json:",omitempty"`
 	TLSServerName      string              `json:",omitempty"`
 	TLSCipherSuite     string              `json:",omitempty"`
+	TLSECHAccepted     bool                `json:",omitempty"`
+	TLSECHConfigList   []byte              `json:",omitempty"`
+	TLSECHRetryConfigs []byte              `json:",omitempty"`
+	TLSFingerprint     string              `json:",omitempty"`
 	TLSNegotiatedProto string              `json:",omitempty"`
 	TLSNextProtos      []string            `json:",omitempty"`
+	TLSOCSPResponse    []byte              `json:",omitempty"`
 	TLSPeerCerts       []*x509.Certificate `json:",omitempty"`
 	TLSVersion         string              `json:",omitempty"`
 	Time               time.Time           `json:",omitempty"`
 	Transport          string              `json:",omitempty"`
 }
+
+// DNSRecord is a resource record inside a DNS reply
+type DNSRecord struct {
+	// Name is the name of the record (e.g. "www.example.com")
+	Name string
+
+	// TTL is the record time to live in seconds
+	TTL uint32
+
+	// Type is the record type (e.g. "A", "CNAME")
+	Type string
+
+	// Value is the record value. For A and AAAA records, it is the
+	// IP address. For CNAME and NS records, it is the domain name. For
+	// other records, it is the record data in presentation format.
+	Value string
+}
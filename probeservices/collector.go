@@ -32,6 +32,10 @@ var (
 	// ErrJSONFormatNotSupported indicates that the collector we're using
 	// does not support the JSON report format.
 	ErrJSONFormatNotSupported = errors.New("JSON format not supported")
+
+	// ErrReportNotFound indicates that the collector does not know
+	// about the report that we would like to resume.
+	ErrReportNotFound = errors.New("Report not found")
 )
 
 // ReportTemplate is the template for opening a report
@@ -120,6 +124,26 @@ func (c Client) OpenReport(ctx context.Context, rt ReportTemplate) (*Report, err
 	return nil, ErrJSONFormatNotSupported
 }
 
+// ResumeReport returns a Report for submitting more measurements into
+// an existing report, provided that the collector knows about such report.
+func (c Client) ResumeReport(
+	ctx context.Context, rt ReportTemplate, reportID string) (*Report, error) {
+	if rt.DataFormatVersion != DefaultDataFormatVersion {
+		return nil, ErrUnsupportedDataFormatVersion
+	}
+	if rt.Format != DefaultFormat {
+		return nil, ErrUnsupportedFormat
+	}
+	found, err := c.CheckReportID(ctx, reportID)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrReportNotFound
+	}
+	return &Report{ID: reportID, client: c, tmpl: rt}, nil
+}
+
 type collectorUpdateRequest struct {
 	// Format is the data format
 	Format string `json:"format"`
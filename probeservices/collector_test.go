@@ -460,3 +460,96 @@ func TestSubmitterCannotOpenNewChannel(t *testing.T) {
 		t.Fatal("unexpected number of channels")
 	}
 }
+
+func newResumeReportTemplate() probeservices.ReportTemplate {
+	return probeservices.ReportTemplate{
+		DataFormatVersion: probeservices.DefaultDataFormatVersion,
+		Format:            probeservices.DefaultFormat,
+		ProbeASN:          "AS0",
+		ProbeCC:           "ZZ",
+		SoftwareName:      "ooniprobe-engine",
+		SoftwareVersion:   "0.1.0",
+		TestName:          "dummy",
+		TestStartTime:     "2019-10-28 12:51:06",
+		TestVersion:       "0.1.0",
+	}
+}
+
+func newResumeReportServer(found bool) *httptest.Server {
+	return httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/api/_/check_report_id" || r.URL.Query().Get("report_id") != "_id" {
+				w.WriteHeader(404)
+				return
+			}
+			if found {
+				w.Write([]byte(`{"found":true}`))
+				return
+			}
+			w.Write([]byte(`{"found":false}`))
+		}),
+	)
+}
+
+func TestResumeReportFound(t *testing.T) {
+	server := newResumeReportServer(true)
+	defer server.Close()
+	client := newclient()
+	client.BaseURL = server.URL
+	report, err := client.ResumeReport(context.Background(), newResumeReportTemplate(), "_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.ID != "_id" {
+		t.Fatal("not the report ID we expected")
+	}
+	m := makeMeasurement(newResumeReportTemplate(), "")
+	if !report.CanSubmit(&m) {
+		t.Fatal("we should be able to submit into the resumed report")
+	}
+}
+
+func TestResumeReportNotFound(t *testing.T) {
+	// This is also what happens when the report has expired.
+	server := newResumeReportServer(false)
+	defer server.Close()
+	client := newclient()
+	client.BaseURL = server.URL
+	report, err := client.ResumeReport(context.Background(), newResumeReportTemplate(), "_id")
+	if !errors.Is(err, probeservices.ErrReportNotFound) {
+		t.Fatal("not the error we expected", err)
+	}
+	if report != nil {
+		t.Fatal("expected a nil report here")
+	}
+}
+
+func TestResumeReportTransportError(t *testing.T) {
+	server := newResumeReportServer(true)
+	server.Close() // so we fail to connect
+	client := newclient()
+	client.BaseURL = server.URL
+	report, err := client.ResumeReport(context.Background(), newResumeReportTemplate(), "_id")
+	if err == nil || errors.Is(err, probeservices.ErrReportNotFound) {
+		t.Fatal("not the error we expected", err)
+	}
+	if report != nil {
+		t.Fatal("expected a nil report here")
+	}
+}
+
+func TestResumeReportUnsupportedTemplate(t *testing.T) {
+	client := newclient()
+	template := newResumeReportTemplate()
+	template.Format = "yaml"
+	if _, err := client.ResumeReport(
+		context.Background(), template, "_id"); !errors.Is(err, probeservices.ErrUnsupportedFormat) {
+		t.Fatal("not the error we expected", err)
+	}
+	template = newResumeReportTemplate()
+	template.DataFormatVersion = "0.1.0"
+	if _, err := client.ResumeReport(
+		context.Background(), template, "_id"); !errors.Is(err, probeservices.ErrUnsupportedDataFormatVersion) {
+		t.Fatal("not the error we expected", err)
+	}
+}
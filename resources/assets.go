@@ -10,6 +10,12 @@ const (
 	// CountryDatabaseName is country-DB file name
 	CountryDatabaseName = "country.mmdb"
 
+	// CABundleName is the CA bundle file name. When it is among the
+	// assets, it replaces the bundle vendored at build time. We do not
+	// publish it yet, because the bundle in probe-assets is older than
+	// the vendored one; add it to All once there is a newer release.
+	CABundleName = "ca-bundle.pem"
+
 	// BaseURL is the asset's repository base URL
 	BaseURL = "https://github.com/"
 )
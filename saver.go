@@ -2,6 +2,9 @@ package engine
 
 import (
 	"errors"
+	"fmt"
+	"os"
+	"time"
 
 	"github.com/ooni/probe-engine/model"
 )
@@ -13,6 +16,10 @@ type Saver interface {
 
 // SaverConfig is the configuration for creating a new Saver.
 type SaverConfig struct {
+	// Backend selects how to save measurements. If empty, we
+	// use the SaverBackendJSONL backend.
+	Backend SaverBackend
+
 	// Enabled is true if saving is enabled.
 	Enabled bool
 
@@ -20,11 +27,22 @@ type SaverConfig struct {
 	Experiment SaverExperiment
 
 	// FilePath is the filepath where to append the measurement as a
-	// serialized JSON followed by a newline character.
+	// serialized JSON followed by a newline character. With the
+	// SaverBackendDirectory backend, this is instead the directory
+	// where to save measurements, which we create if needed.
 	FilePath string
 
 	// Logger is the logger used by the saver.
 	Logger model.Logger
+
+	// RotateInterval is the optional maximum amount of time during which
+	// we keep appending to the same file. When this time has elapsed, we
+	// rename the file and start over. Ignored by SaverBackendDirectory.
+	RotateInterval time.Duration
+
+	// RotateSize is like RotateInterval but rotates the file when
+	// appending to it would cause it to exceed this size in bytes.
+	RotateSize int64
 }
 
 // SaverExperiment is an experiment according to the Saver.
@@ -40,11 +58,38 @@ func NewSaver(config SaverConfig) (Saver, error) {
 	if config.FilePath == "" {
 		return nil, errors.New("saver: passed an empty filepath")
 	}
-	return realSaver{
-		Experiment: config.Experiment,
-		FilePath:   config.FilePath,
-		Logger:     config.Logger,
-	}, nil
+	rotate := config.RotateInterval > 0 || config.RotateSize > 0
+	switch config.Backend {
+	case "", SaverBackendJSONL:
+		if rotate {
+			return newFileSaver(config, false), nil
+		}
+		return realSaver{
+			Experiment: config.Experiment,
+			FilePath:   config.FilePath,
+			Logger:     config.Logger,
+		}, nil
+	case SaverBackendGzipJSONL:
+		return newFileSaver(config, true), nil
+	case SaverBackendDirectory:
+		if err := os.MkdirAll(config.FilePath, 0700); err != nil {
+			return nil, err
+		}
+		return &dirSaver{dirPath: config.FilePath, logger: config.Logger}, nil
+	default:
+		return nil, fmt.Errorf("saver: unknown backend: %s", config.Backend)
+	}
+}
+
+func newFileSaver(config SaverConfig, compress bool) *fileSaver {
+	return &fileSaver{
+		compress:       compress,
+		filePath:       config.FilePath,
+		logger:         config.Logger,
+		rotateInterval: config.RotateInterval,
+		rotateSize:     config.RotateSize,
+		timeNow:        time.Now,
+	}
 }
 
 type fakeSaver struct{}
@@ -0,0 +1,189 @@
+package engine
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ooni/probe-engine/model"
+)
+
+// SaverBackend selects how a Saver saves measurements.
+type SaverBackend string
+
+const (
+	// SaverBackendJSONL appends each measurement to FilePath as
+	// a serialized JSON followed by a newline character.
+	SaverBackendJSONL = SaverBackend("jsonl")
+
+	// SaverBackendGzipJSONL is like SaverBackendJSONL except that
+	// the file is gzip compressed. We append a gzip member for each
+	// measurement, so the file is always a valid gzip stream even
+	// if we are interrupted while running.
+	SaverBackendGzipJSONL = SaverBackend("jsonl.gz")
+
+	// SaverBackendDirectory saves each measurement as a JSON file
+	// inside the FilePath directory. The file name depends on the
+	// measurement report ID and on the input index.
+	SaverBackendDirectory = SaverBackend("dir")
+)
+
+// saverWithIndex is a Saver that also wants to know the index of
+// the input. InputProcessor passes the index to such savers.
+type saverWithIndex interface {
+	SaveMeasurementWithIndex(idx int, m *model.Measurement) error
+}
+
+// fileSaver is a Saver that appends to a possibly compressed
+// JSONL file that it rotates depending on its size and age.
+type fileSaver struct {
+	compress       bool
+	filePath       string
+	logger         model.Logger
+	mu             sync.Mutex
+	rotateInterval time.Duration
+	rotateSize     int64
+	started        time.Time
+	timeNow        func() time.Time
+}
+
+func (fs *fileSaver) SaveMeasurement(m *model.Measurement) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	data = append(data, byte('\n'))
+	if fs.compress {
+		if data, err = gzipCompress(data); err != nil {
+			return err
+		}
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if err := fs.maybeRotateLocked(int64(len(data))); err != nil {
+		return err
+	}
+	fs.logger.Infof("saving measurement to %s", fs.filePath)
+	filep, err := os.OpenFile(fs.filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := filep.Write(data); err != nil {
+		filep.Close()
+		return err
+	}
+	return filep.Close()
+}
+
+// maybeRotateLocked renames the current file if writing size
+// more bytes would make it too large or if it is too old.
+func (fs *fileSaver) maybeRotateLocked(size int64) error {
+	now := fs.timeNow()
+	if fs.started.IsZero() {
+		fs.started = now
+	}
+	info, err := os.Stat(fs.filePath)
+	if err != nil || info.Size() <= 0 {
+		return nil // nothing to rotate
+	}
+	tooLarge := fs.rotateSize > 0 && info.Size()+size > fs.rotateSize
+	tooOld := fs.rotateInterval > 0 && now.Sub(fs.started) >= fs.rotateInterval
+	if !tooLarge && !tooOld {
+		return nil
+	}
+	rotated := rotatedFilePath(fs.filePath, now)
+	fs.logger.Infof("rotating %s to %s", fs.filePath, rotated)
+	if err := os.Rename(fs.filePath, rotated); err != nil {
+		return err
+	}
+	fs.started = now
+	return nil
+}
+
+// rotatedFilePath returns the path to which we rename filePath when
+// rotating. We insert the current time before the extension, e.g.,
+// `report.jsonl.gz` becomes `report-20201215T101159Z.jsonl.gz`.
+func rotatedFilePath(filePath string, now time.Time) string {
+	dir, base := filepath.Split(filePath)
+	var ext string
+	if idx := strings.Index(base, "."); idx > 0 {
+		base, ext = base[:idx], base[idx:]
+	}
+	stamp := now.UTC().Format("20060102T150405Z")
+	rotated := filepath.Join(dir, fmt.Sprintf("%s-%s%s", base, stamp, ext))
+	for cnt := 1; ; cnt++ {
+		if _, err := os.Stat(rotated); os.IsNotExist(err) {
+			return rotated
+		}
+		rotated = filepath.Join(dir, fmt.Sprintf("%s-%s-%d%s", base, stamp, cnt, ext))
+	}
+}
+
+// gzipCompress returns a gzip member containing data.
+func gzipCompress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+var _ Saver = &fileSaver{}
+
+// dirSaver is a Saver that saves each measurement into its own file
+// inside a directory. The file name is `<report_id>-<idx>.json`. When
+// the report ID is empty, because we're not submitting, we use instead
+// `local-<test_name>-<test_start_time>` to avoid collisions.
+type dirSaver struct {
+	dirPath string
+	logger  model.Logger
+	mu      sync.Mutex
+	nextIdx int
+}
+
+func (ds *dirSaver) SaveMeasurement(m *model.Measurement) error {
+	ds.mu.Lock()
+	idx := ds.nextIdx
+	ds.mu.Unlock()
+	return ds.SaveMeasurementWithIndex(idx, m)
+}
+
+func (ds *dirSaver) SaveMeasurementWithIndex(idx int, m *model.Measurement) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if idx >= ds.nextIdx {
+		ds.nextIdx = idx + 1
+	}
+	filePath := filepath.Join(ds.dirPath, fmt.Sprintf("%s-%d.json", dirSaverPrefix(m), idx))
+	ds.logger.Infof("saving measurement to %s", filePath)
+	return ioutil.WriteFile(filePath, data, 0600)
+}
+
+// dirSaverPrefix returns the prefix of the file name.
+func dirSaverPrefix(m *model.Measurement) string {
+	if m.ReportID != "" {
+		return m.ReportID
+	}
+	stamp := "unknown"
+	if t, err := time.Parse(dateFormat, m.TestStartTime); err == nil {
+		stamp = t.Format("20060102T150405Z")
+	}
+	return fmt.Sprintf("local-%s-%s", m.TestName, stamp)
+}
+
+var _ Saver = &dirSaver{}
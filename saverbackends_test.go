@@ -0,0 +1,195 @@
+package engine
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/model"
+)
+
+func TestNewSaverUnknownBackend(t *testing.T) {
+	saver, err := NewSaver(SaverConfig{
+		Backend:  "antani",
+		Enabled:  true,
+		FilePath: "report.jsonl",
+	})
+	if err == nil || err.Error() != "saver: unknown backend: antani" {
+		t.Fatalf("not the error we expected: %+v", err)
+	}
+	if saver != nil {
+		t.Fatal("saver should be nil here")
+	}
+}
+
+func TestSaverGzipJSONL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ooniengine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filePath := filepath.Join(dir, "report.jsonl.gz")
+	saver, err := NewSaver(SaverConfig{
+		Backend:  SaverBackendGzipJSONL,
+		Enabled:  true,
+		FilePath: filePath,
+		Logger:   log.Log,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, input := range []string{"a", "b"} {
+		m := &model.Measurement{Input: model.MeasurementTarget(input)}
+		if err := saver.SaveMeasurement(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	filep, err := os.Open(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer filep.Close()
+	reader, err := gzip.NewReader(filep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scanner := bufio.NewScanner(reader)
+	var inputs []string
+	for scanner.Scan() {
+		var m model.Measurement
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			t.Fatal(err)
+		}
+		inputs = append(inputs, string(m.Input))
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) != 2 || inputs[0] != "a" || inputs[1] != "b" {
+		t.Fatal("unexpected inputs", inputs)
+	}
+}
+
+func TestSaverRotateSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ooniengine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	saver, err := NewSaver(SaverConfig{
+		Enabled:    true,
+		FilePath:   filepath.Join(dir, "report.jsonl"),
+		Logger:     log.Log,
+		RotateSize: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := saver.SaveMeasurement(new(model.Measurement)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "report*.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Fatal("unexpected number of files", files)
+	}
+}
+
+func TestSaverRotateInterval(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ooniengine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	saver, err := NewSaver(SaverConfig{
+		Enabled:        true,
+		FilePath:       filepath.Join(dir, "report.jsonl"),
+		Logger:         log.Log,
+		RotateInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := saver.(*fileSaver)
+	now := time.Now()
+	fs.timeNow = func() time.Time {
+		return now
+	}
+	for i := 0; i < 2; i++ {
+		if err := saver.SaveMeasurement(new(model.Measurement)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now = now.Add(time.Hour)
+	if err := saver.SaveMeasurement(new(model.Measurement)); err != nil {
+		t.Fatal(err)
+	}
+	rotated := filepath.Join(dir, "report-"+now.UTC().Format("20060102T150405Z")+".jsonl")
+	if _, err := os.Stat(rotated); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRotatedFilePathAvoidsCollisions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ooniengine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	now := time.Date(2020, 12, 15, 10, 11, 59, 0, time.UTC)
+	first := rotatedFilePath(filepath.Join(dir, "report.jsonl.gz"), now)
+	if first != filepath.Join(dir, "report-20201215T101159Z.jsonl.gz") {
+		t.Fatal("unexpected file path", first)
+	}
+	if err := ioutil.WriteFile(first, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	second := rotatedFilePath(filepath.Join(dir, "report.jsonl.gz"), now)
+	if second != filepath.Join(dir, "report-20201215T101159Z-1.jsonl.gz") {
+		t.Fatal("unexpected file path", second)
+	}
+}
+
+func TestSaverDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ooniengine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	saver, err := NewSaver(SaverConfig{
+		Backend:  SaverBackendDirectory,
+		Enabled:  true,
+		FilePath: filepath.Join(dir, "report.d"),
+		Logger:   log.Log,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ipsw := NewInputProcessorSaverWrapper(saver)
+	m := &model.Measurement{ReportID: "20201215T101159Z_urlgetter_IT_30722_n1_xx"}
+	if err := ipsw.SaveMeasurement(4, m); err != nil {
+		t.Fatal(err)
+	}
+	m = &model.Measurement{TestName: "urlgetter", TestStartTime: "2020-12-15 10:11:59"}
+	if err := saver.SaveMeasurement(m); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{
+		"20201215T101159Z_urlgetter_IT_30722_n1_xx-4.json",
+		"local-urlgetter-20201215T101159Z-5.json",
+	} {
+		if _, err := os.Stat(filepath.Join(dir, "report.d", name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
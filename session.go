@@ -1,7 +1,9 @@
 package engine
 
 import (
+	"bytes"
 	"context"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -27,13 +29,23 @@ import (
 	"github.com/ooni/probe-engine/version"
 )
 
-// SessionConfig contains the Session config
+// SessionConfig contains the Session config.
+//
+// ExtraCAPEMs contains PEM bundles of CAs that the session trusts in
+// addition to the default ones, e.g., to use a probe-services deployment
+// with a private CA. SPKIPins maps backend host names to the base64
+// encoded SHA256 of the SPKI of the certificates we expect in their
+// chain. We fail the TLS handshake with pinned hosts if the verified
+// chain does not contain any of these certificates.
 type SessionConfig struct {
 	AssetsDir              string
 	AvailableProbeServices []model.Service
+	ExtraCAPEMs            [][]byte
 	KVStore                KVStore
 	Logger                 model.Logger
 	ProxyURL               *url.URL
+	ResolverURLs           []string
+	SPKIPins               map[string][]string
 	SoftwareName           string
 	SoftwareVersion        string
 	TempDir                string
@@ -47,13 +59,18 @@ type Session struct {
 	availableProbeServices   []model.Service
 	availableTestHelpers     map[string][]model.Service
 	byteCounter              *bytecounter.Counter
+	caBundle                 []byte
+	extraCAPEMs              [][]byte
+	httpConfig               netx.Config
 	httpDefaultTransport     netx.HTTPRoundTripper
 	kvStore                  model.KeyValueStore
 	location                 *model.LocationInfo
+	locationMu               sync.Mutex
 	logger                   model.Logger
 	proxyURL                 *url.URL
 	queryProbeServicesCount  *atomicx.Int64
 	resolver                 *sessionresolver.Resolver
+	resolverURLs             []string
 	selectedProbeServiceHook func(*model.Service)
 	selectedProbeService     *model.Service
 	softwareName             string
@@ -95,34 +112,106 @@ func NewSession(config SessionConfig) (*Session, error) {
 		assetsDir:               config.AssetsDir,
 		availableProbeServices:  config.AvailableProbeServices,
 		byteCounter:             bytecounter.New(),
+		extraCAPEMs:             config.ExtraCAPEMs,
 		kvStore:                 config.KVStore,
 		logger:                  config.Logger,
 		proxyURL:                config.ProxyURL,
 		queryProbeServicesCount: atomicx.NewInt64(),
+		resolverURLs:            config.ResolverURLs,
 		softwareName:            config.SoftwareName,
 		softwareVersion:         config.SoftwareVersion,
 		tempDir:                 tempDir,
 		torArgs:                 config.TorArgs,
 		torBinary:               config.TorBinary,
 	}
-	httpConfig := netx.Config{
+	sess.httpConfig = netx.Config{
 		ByteCounter:  sess.byteCounter,
 		BogonIsError: true,
 		Logger:       sess.logger,
+		ProxyURL:     config.ProxyURL,
+		TLSSPKIPins:  config.SPKIPins,
+	}
+	caBundle, err := sess.readCABundle()
+	if err == nil {
+		err = sess.setupTransports(caBundle)
+	}
+	if err != nil {
+		os.RemoveAll(tempDir)
+		return nil, err
 	}
-	sess.resolver = sessionresolver.New(httpConfig)
-	httpConfig.FullResolver = sess.resolver
-	httpConfig.ProxyURL = config.ProxyURL // no need to proxy the resolver
-	sess.httpDefaultTransport = netx.NewHTTPTransport(httpConfig)
 	return sess, nil
 }
 
+// setupTransports creates the session resolver and the default HTTP
+// transport, replacing the ones we are using, if any. The certificate
+// pool contains caBundle, or the vendored bundle if caBundle is nil,
+// plus the extra CAs passed to NewSession. Because the pool belongs
+// to this session, another session may use another CA bundle.
+func (s *Session) setupTransports(caBundle []byte) error {
+	var (
+		certPool *x509.CertPool // nil means using the default
+		err      error
+	)
+	switch {
+	case caBundle != nil:
+		certPool, err = netx.NewCertPoolWithPEMs(caBundle, s.extraCAPEMs...)
+		if err != nil {
+			return fmt.Errorf("invalid CA bundle or ExtraCAPEMs: %w", err)
+		}
+	case len(s.extraCAPEMs) > 0:
+		certPool, err = netx.NewDefaultCertPoolWithPEMs(s.extraCAPEMs...)
+		if err != nil {
+			return fmt.Errorf("invalid ExtraCAPEMs: %w", err)
+		}
+	}
+	httpConfig := s.httpConfig
+	httpConfig.CertPool = certPool
+	resolverConfig := httpConfig
+	resolverConfig.ProxyURL = nil // no need to proxy the resolver
+	resolver, err := sessionresolver.New(sessionresolver.Config{
+		HTTPConfig: resolverConfig,
+		KVStore:    s.kvStore,
+		Logger:     s.logger,
+		URLs:       s.resolverURLs,
+	})
+	if err != nil {
+		return err
+	}
+	httpConfig.FullResolver = resolver
+	httpConfig.HappyEyeballs = true // we're not measuring here
+	if s.httpDefaultTransport != nil {
+		s.httpDefaultTransport.CloseIdleConnections()
+		s.resolver.CloseIdleConnections()
+	}
+	s.caBundle = caBundle
+	s.httpDefaultTransport = netx.NewHTTPTransport(httpConfig)
+	s.resolver = resolver
+	return nil
+}
+
 // ASNDatabasePath returns the path where the ASN database path should
 // be if you have called s.FetchResourcesIdempotent.
 func (s *Session) ASNDatabasePath() string {
 	return filepath.Join(s.assetsDir, resources.ASNDatabaseName)
 }
 
+// CABundlePath returns the path where the CA bundle should be if it
+// has been downloaded as a resource. When there is no such file, we
+// use the bundle that has been vendored at build time.
+func (s *Session) CABundlePath() string {
+	return filepath.Join(s.assetsDir, resources.CABundleName)
+}
+
+// readCABundle returns the CA bundle inside the assets dir, or
+// nil if there is no such bundle and we should use the vendored one.
+func (s *Session) readCABundle() ([]byte, error) {
+	data, err := ioutil.ReadFile(s.CABundlePath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
 // KibiBytesReceived accounts for the KibiBytes received by the HTTP clients
 // managed by this session so far, including experiments.
 func (s *Session) KibiBytesReceived() float64 {
@@ -142,7 +231,10 @@ func (s *Session) KibiBytesSent() float64 {
 func (s *Session) Close() error {
 	s.httpDefaultTransport.CloseIdleConnections()
 	s.resolver.CloseIdleConnections()
-	s.logger.Infof("%s", s.resolver.Stats())
+	for _, stats := range s.resolver.Stats() {
+		s.logger.Infof("sessionresolver: %s: failure rate %d/%d; score %.2f; latency %s",
+			stats.URL, stats.Failures, stats.Queries, stats.Score, stats.Latency)
+	}
 	if s.tunnel != nil {
 		s.tunnel.Stop()
 	}
@@ -405,14 +497,24 @@ func (s *Session) UserAgent() (useragent string) {
 	return
 }
 
-// MaybeUpdateResources updates the resources if needed.
+// MaybeUpdateResources updates the resources if needed. If, after
+// the update, the CA bundle has changed, we recreate the session
+// transports such that they use the new bundle.
 func (s *Session) MaybeUpdateResources(ctx context.Context) error {
-	return (&resources.Client{
+	err := (&resources.Client{
 		HTTPClient: s.DefaultHTTPClient(),
 		Logger:     s.logger,
 		UserAgent:  s.UserAgent(),
 		WorkDir:    s.assetsDir,
 	}).Ensure(ctx)
+	if err != nil {
+		return err
+	}
+	caBundle, err := s.readCABundle()
+	if err != nil || bytes.Equal(caBundle, s.caBundle) {
+		return err
+	}
+	return s.setupTransports(caBundle)
 }
 
 func (s *Session) getAvailableProbeServices() []model.Service {
@@ -538,6 +640,10 @@ func (s *Session) LookupLocationContext(ctx context.Context) (out *model.Locatio
 // MaybeLookupLocationContext is like MaybeLookupLocation but with a context
 // that can be used to interrupt this long running operation.
 func (s *Session) MaybeLookupLocationContext(ctx context.Context) error {
+	// Lock so that experiments measuring in parallel do not concurrently
+	// attempt to lookup the location the first time.
+	s.locationMu.Lock()
+	defer s.locationMu.Unlock()
 	if s.location == nil {
 		location, err := s.LookupLocationContext(ctx)
 		if err != nil {
@@ -1,13 +1,21 @@
 package engine
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/pem"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
 	"syscall"
 	"testing"
 	"time"
@@ -17,7 +25,9 @@ import (
 	"github.com/ooni/probe-engine/geolocate"
 	"github.com/ooni/probe-engine/model"
 	"github.com/ooni/probe-engine/netx"
+	"github.com/ooni/probe-engine/netx/errorx"
 	"github.com/ooni/probe-engine/probeservices"
+	"github.com/ooni/probe-engine/resources"
 	"github.com/ooni/probe-engine/version"
 )
 
@@ -55,6 +65,15 @@ func TestNewSessionBuilderChecks(t *testing.T) {
 			TempDir:         "./nonexistent",
 		})
 	})
+	t.Run("with invalid resolver URL", func(t *testing.T) {
+		newSessionMustFail(t, SessionConfig{
+			AssetsDir:       "testdata",
+			Logger:          model.DiscardLogger,
+			ResolverURLs:    []string{"antani:///"},
+			SoftwareName:    "ooniprobe-engine",
+			SoftwareVersion: "0.0.1",
+		})
+	})
 }
 
 func TestNewSessionBuilderGood(t *testing.T) {
@@ -64,6 +83,69 @@ func TestNewSessionBuilderGood(t *testing.T) {
 	newSessionForTesting(t)
 }
 
+func TestNewSessionWithInvalidExtraCAPEMs(t *testing.T) {
+	newSessionMustFail(t, SessionConfig{
+		AssetsDir:       "testdata",
+		ExtraCAPEMs:     [][]byte{[]byte("antani")},
+		Logger:          model.DiscardLogger,
+		SoftwareName:    "ooniprobe-engine",
+		SoftwareVersion: "0.0.1",
+	})
+}
+
+func TestNewSessionWithInvalidCABundle(t *testing.T) {
+	assetsDir, err := ioutil.TempDir("", "ooniengine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(assetsDir)
+	bundlePath := filepath.Join(assetsDir, resources.CABundleName)
+	if err := ioutil.WriteFile(bundlePath, []byte("antani"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	newSessionMustFail(t, SessionConfig{
+		AssetsDir:       assetsDir,
+		Logger:          model.DiscardLogger,
+		SoftwareName:    "ooniprobe-engine",
+		SoftwareVersion: "0.0.1",
+	})
+}
+
+func TestNewSessionWithExtraCAPEMsAndSPKIPins(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	cert := server.Certificate()
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	serverPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	get := func(pin string) error {
+		sess, err := NewSession(SessionConfig{
+			AssetsDir:       "testdata",
+			ExtraCAPEMs:     [][]byte{serverPEM},
+			Logger:          model.DiscardLogger,
+			SPKIPins:        map[string][]string{"127.0.0.1": {pin}},
+			SoftwareName:    "ooniprobe-engine",
+			SoftwareVersion: "0.0.1",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sess.Close()
+		resp, err := sess.DefaultHTTPClient().Get(server.URL)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+	if err := get(base64.StdEncoding.EncodeToString(sum[:])); err != nil {
+		t.Fatal(err)
+	}
+	err := get("AAAA")
+	if err == nil || !strings.HasSuffix(err.Error(), errorx.FailureSSLPinMismatch) {
+		t.Fatal("not the error we expected", err)
+	}
+}
+
 func newSessionMustFail(t *testing.T, config SessionConfig) {
 	sess, err := NewSession(config)
 	if err == nil {
@@ -358,6 +440,96 @@ func TestSessionDownloadResources(t *testing.T) {
 	}
 }
 
+// FakeResourcesTransport is an HTTPRoundTripper that
+// always returns the same body.
+type FakeResourcesTransport struct {
+	Body []byte
+}
+
+func (txp FakeResourcesTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		Body:       ioutil.NopCloser(bytes.NewReader(txp.Body)),
+		Request:    req,
+		StatusCode: 200,
+	}, nil
+}
+
+func (txp FakeResourcesTransport) CloseIdleConnections() {}
+
+func TestSessionMaybeUpdateResourcesUpdatesCABundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	bundle := pem.EncodeToMemory(&pem.Block{
+		Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	var gzbody bytes.Buffer
+	gzwriter := gzip.NewWriter(&gzbody)
+	if _, err := gzwriter.Write(bundle); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzwriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	savedAll := resources.All
+	resources.All = map[string]resources.ResourceInfo{
+		resources.CABundleName: {
+			URLPath:  "/ca-bundle.pem.gz",
+			GzSHA256: fmt.Sprintf("%x", sha256.Sum256(gzbody.Bytes())),
+			SHA256:   fmt.Sprintf("%x", sha256.Sum256(bundle)),
+		},
+	}
+	defer func() { resources.All = savedAll }()
+	assetsDir, err := ioutil.TempDir("", "ooniengine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(assetsDir)
+	newSession := func(assetsDir string) *Session {
+		sess, err := NewSession(SessionConfig{
+			AssetsDir:       assetsDir,
+			Logger:          model.DiscardLogger,
+			SoftwareName:    "ooniprobe-engine",
+			SoftwareVersion: "0.0.1",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sess
+	}
+	get := func(sess *Session) error {
+		resp, err := sess.DefaultHTTPClient().Get(server.URL)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+	sess := newSession(assetsDir)
+	defer sess.Close()
+	other := newSession("testdata")
+	defer other.Close()
+	if get(sess) == nil {
+		t.Fatal("the vendored bundle should not trust the server")
+	}
+	sess.httpDefaultTransport.CloseIdleConnections()
+	sess.httpDefaultTransport = FakeResourcesTransport{Body: gzbody.Bytes()}
+	if err := sess.MaybeUpdateResources(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(sess.CABundlePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, bundle) {
+		t.Fatal("not the CA bundle we expected")
+	}
+	if err := get(sess); err != nil {
+		t.Fatal("the updated bundle should trust the server", err)
+	}
+	if get(other) == nil {
+		t.Fatal("the updated bundle should only affect its session")
+	}
+}
+
 func TestGetAvailableProbeServices(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skip test in short mode")
@@ -0,0 +1,322 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/ooni/probe-engine/model"
+)
+
+// ErrSinkClosed indicates that the sink has been closed.
+var ErrSinkClosed = errors.New("sink: closed")
+
+const (
+	// defaultSinkBufferSize is the default SinkConfig.BufferSize.
+	defaultSinkBufferSize = 64
+
+	// defaultSinkFlushInterval is the default SinkConfig.FlushInterval.
+	defaultSinkFlushInterval = 5 * time.Second
+
+	// defaultSinkTimeout is the default SinkConfig.Timeout.
+	defaultSinkTimeout = 30 * time.Second
+)
+
+// SinkConfig contains settings for NewSink.
+type SinkConfig struct {
+	// BatchSize is the maximum number of measurements we send
+	// together. When this value is zero or one, we POST each
+	// measurement as a JSON object. Otherwise, we POST a JSON
+	// array containing up to BatchSize measurements. This setting
+	// is ignored when streaming to a Unix domain socket.
+	BatchSize int
+
+	// BufferSize is the maximum number of measurements we buffer
+	// while waiting to send them. When the buffer is full, calls
+	// to Sink.SaveMeasurement block. If zero, we use a default.
+	BufferSize int
+
+	// FlushInterval is the maximum amount of time we wait to
+	// fill a batch before sending it. If zero, we use a default.
+	FlushInterval time.Duration
+
+	// HTTPClient is the optional HTTP client to use. If not
+	// set, we will use a client whose timeout is Timeout.
+	HTTPClient *http.Client
+
+	// Logger is the mandatory logger.
+	Logger model.Logger
+
+	// Timeout is the timeout for sending a batch. If zero, we
+	// use a default timeout.
+	Timeout time.Duration
+
+	// URL is the mandatory URL of the service where to stream
+	// measurements. We support `http` and `https` URLs, to which we
+	// POST measurements, and `unix` URLs (e.g. `unix:///tmp/sock`)
+	// to which we write newline-delimited JSON measurements.
+	URL string
+}
+
+// Sink streams measurements to a third party service. Sink is a Saver,
+// so you can use it with InputProcessor using NewInputProcessorSaverWrapper.
+//
+// SaveMeasurement only serializes the measurement and adds it to a
+// bounded buffer. A background goroutine sends the buffered measurements
+// to the service. If the service does not keep up, the buffer fills and
+// SaveMeasurement blocks, thus propagating backpressure to the caller.
+//
+// When sending fails, we keep the measurements we could not send and
+// we try again later. Until we succeed, SaveMeasurement first tries
+// to send the measurements we kept and, if that fails, returns the
+// error without accepting the new measurement. This allows the caller
+// (e.g. the InputProcessor's error policy) to decide what to do.
+//
+// You MUST call Close when done to flush buffered measurements.
+type Sink struct {
+	closed   bool
+	config   SinkConfig
+	done     chan interface{}
+	err      error
+	errmu    sync.Mutex
+	mu       sync.Mutex
+	queue    chan json.RawMessage
+	retry    chan chan error
+	send     func(batch []json.RawMessage) (int, error)
+	unixConn net.Conn
+}
+
+// NewSink creates a new Sink instance.
+func NewSink(config SinkConfig) (*Sink, error) {
+	URL, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, err
+	}
+	if config.BufferSize <= 0 {
+		config.BufferSize = defaultSinkBufferSize
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = defaultSinkFlushInterval
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultSinkTimeout
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: config.Timeout}
+	}
+	sink := &Sink{
+		config: config,
+		done:   make(chan interface{}),
+		queue:  make(chan json.RawMessage, config.BufferSize),
+		retry:  make(chan chan error),
+	}
+	switch URL.Scheme {
+	case "http", "https":
+		sink.send = sink.sendHTTP
+	case "unix":
+		if URL.Path == "" {
+			return nil, errors.New("sink: missing unix socket path")
+		}
+		config.BatchSize = 1
+		sink.send = func(batch []json.RawMessage) (int, error) {
+			return sink.sendUnix(URL.Path, batch)
+		}
+	default:
+		return nil, fmt.Errorf("sink: unsupported URL scheme: %s", URL.Scheme)
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 1
+	}
+	sink.config = config
+	go sink.loop()
+	return sink, nil
+}
+
+// SaveMeasurement adds the measurement to the queue of measurements
+// to send. This function blocks if the queue is full. If a previous
+// send failed, we first try to send again the measurements we kept
+// and return the error, without adding the measurement, on failure.
+func (s *Sink) SaveMeasurement(m *model.Measurement) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrSinkClosed
+	}
+	if s.lastError() != nil {
+		reply := make(chan error)
+		s.retry <- reply
+		if err := <-reply; err != nil {
+			return err
+		}
+	}
+	s.queue <- data
+	return nil
+}
+
+// Close flushes the buffered measurements and releases the resources
+// used by the sink. It returns an error if we could not send some of
+// the buffered measurements, which are therefore lost.
+func (s *Sink) Close() error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.mu.Unlock()
+	<-s.done
+	return s.lastError()
+}
+
+// loop is the background goroutine sending measurements.
+func (s *Sink) loop() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.config.FlushInterval)
+	defer ticker.Stop()
+	var batch []json.RawMessage
+	for {
+		select {
+		case data, ok := <-s.queue:
+			if !ok {
+				if s.flush(&batch) != nil {
+					s.config.Logger.Warnf("sink: lost %d measurements", len(batch))
+				}
+				if s.unixConn != nil {
+					s.unixConn.Close()
+				}
+				return
+			}
+			batch = append(batch, data)
+			if len(batch) >= s.config.BatchSize {
+				s.flush(&batch)
+			}
+		case <-ticker.C:
+			s.flush(&batch)
+		case reply := <-s.retry:
+			reply <- s.flush(&batch)
+		}
+	}
+}
+
+// flush sends the measurements in batch, at most BatchSize at a time,
+// and removes them from batch. We stop at the first error, keeping
+// in batch the measurements that we have not sent, so that we can
+// try again later. The error is recorded and returned.
+func (s *Sink) flush(batch *[]json.RawMessage) error {
+	for len(*batch) > 0 {
+		count := s.config.BatchSize
+		if count > len(*batch) {
+			count = len(*batch)
+		}
+		sent, err := s.send((*batch)[:count])
+		*batch = (*batch)[sent:]
+		if err != nil {
+			s.config.Logger.Warnf("sink: cannot send %d measurements: %s", count-sent, err.Error())
+			s.setLastError(err)
+			return err
+		}
+	}
+	s.setLastError(nil)
+	return nil
+}
+
+// lastError returns the error that occurred when we last sent
+// measurements, or nil if we succeeded.
+func (s *Sink) lastError() error {
+	s.errmu.Lock()
+	defer s.errmu.Unlock()
+	return s.err
+}
+
+func (s *Sink) setLastError(err error) {
+	s.errmu.Lock()
+	defer s.errmu.Unlock()
+	s.err = err
+}
+
+// sendHTTP posts the batch to the HTTP endpoint. It returns the
+// number of measurements sent, which is either zero or all of them.
+func (s *Sink) sendHTTP(batch []json.RawMessage) (int, error) {
+	var body interface{} = batch
+	if s.config.BatchSize <= 1 {
+		body = batch[0]
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return 0, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.Timeout)
+	defer cancel()
+	req, err := http.NewRequest("POST", s.config.URL, bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.config.HTTPClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return 0, fmt.Errorf("sink: request failed: %s", resp.Status)
+	}
+	return len(batch), nil
+}
+
+// sendUnix writes the batch to the unix domain socket, one measurement
+// per line. We keep using the same connection as long as it works and
+// reconnect on failure. It returns the number of measurements we have
+// fully written, such that we only send again the other ones.
+//
+// When a write fails midway through a measurement, the receiver sees a
+// line not terminated by a newline before the connection is closed. We
+// send this measurement again, in full, using a new connection, hence
+// receivers should discard such a truncated last line.
+func (s *Sink) sendUnix(path string, batch []json.RawMessage) (int, error) {
+	if s.unixConn == nil {
+		conn, err := net.DialTimeout("unix", path, s.config.Timeout)
+		if err != nil {
+			return 0, err
+		}
+		s.unixConn = conn
+	}
+	var buf bytes.Buffer
+	for _, data := range batch {
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+	s.unixConn.SetWriteDeadline(time.Now().Add(s.config.Timeout))
+	n, err := s.unixConn.Write(buf.Bytes())
+	if err != nil {
+		s.unixConn.Close()
+		s.unixConn = nil
+		return sinkLinesWritten(batch, n), err
+	}
+	return len(batch), nil
+}
+
+// sinkLinesWritten returns how many of the lines containing the
+// measurements in batch fit into the first n bytes.
+func sinkLinesWritten(batch []json.RawMessage, n int) (count int) {
+	for _, data := range batch {
+		n -= len(data) + 1 // newline
+		if n < 0 {
+			break
+		}
+		count++
+	}
+	return
+}
+
+var _ Saver = &Sink{}
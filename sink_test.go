@@ -0,0 +1,327 @@
+package engine
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/model"
+)
+
+type sinkTestServer struct {
+	bodies [][]byte
+	mu     sync.Mutex
+	status int
+}
+
+func (sts *sinkTestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	data, _ := ioutil.ReadAll(r.Body)
+	sts.mu.Lock()
+	sts.bodies = append(sts.bodies, data)
+	status := sts.status
+	sts.mu.Unlock()
+	if status != 0 {
+		w.WriteHeader(status)
+	}
+}
+
+func (sts *sinkTestServer) setStatus(status int) {
+	sts.mu.Lock()
+	sts.status = status
+	sts.mu.Unlock()
+}
+
+func (sts *sinkTestServer) inputs(t *testing.T) (out []string) {
+	sts.mu.Lock()
+	defer sts.mu.Unlock()
+	for _, body := range sts.bodies {
+		var m model.Measurement
+		if err := json.Unmarshal(body, &m); err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, string(m.Input))
+	}
+	return
+}
+
+func TestSinkHTTPSingle(t *testing.T) {
+	handler := &sinkTestServer{}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	sink, err := NewSink(SinkConfig{Logger: log.Log, URL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, input := range []string{"a", "b"} {
+		m := &model.Measurement{Input: model.MeasurementTarget(input)}
+		if err := sink.SaveMeasurement(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(handler.bodies) != 2 {
+		t.Fatal("unexpected number of requests")
+	}
+	var m model.Measurement
+	if err := json.Unmarshal(handler.bodies[1], &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Input != "b" {
+		t.Fatal("unexpected measurement")
+	}
+	if err := sink.SaveMeasurement(&m); !errors.Is(err, ErrSinkClosed) {
+		t.Fatalf("not the error we expected: %+v", err)
+	}
+}
+
+func TestSinkHTTPBatch(t *testing.T) {
+	handler := &sinkTestServer{}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	sink, err := NewSink(SinkConfig{
+		BatchSize:     2,
+		FlushInterval: time.Hour,
+		Logger:        log.Log,
+		URL:           server.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := sink.SaveMeasurement(new(model.Measurement)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(handler.bodies) != 2 {
+		t.Fatal("unexpected number of requests")
+	}
+	var first, second []model.Measurement
+	if err := json.Unmarshal(handler.bodies[0], &first); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(handler.bodies[1], &second); err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != 2 || len(second) != 1 {
+		t.Fatal("unexpected batches")
+	}
+}
+
+func TestSinkHTTPFailure(t *testing.T) {
+	handler := &sinkTestServer{status: 500}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	sink, err := NewSink(SinkConfig{Logger: log.Log, URL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.SaveMeasurement(new(model.Measurement)); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err == nil {
+		t.Fatal("expected an error here")
+	}
+}
+
+func TestSinkHTTPFailureIsReportedAndRetried(t *testing.T) {
+	handler := &sinkTestServer{status: 500}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	sink, err := NewSink(SinkConfig{
+		FlushInterval: time.Hour,
+		Logger:        log.Log,
+		URL:           server.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	save := func(input string) error {
+		return sink.SaveMeasurement(&model.Measurement{Input: model.MeasurementTarget(input)})
+	}
+	if err := save("a"); err != nil {
+		t.Fatal(err)
+	}
+	for len(handler.inputs(t)) < 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := save("b"); err == nil {
+		t.Fatal("expected an error here")
+	}
+	handler.setStatus(200)
+	if err := save("c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	inputs := handler.inputs(t)
+	if len(inputs) != 4 || inputs[2] != "a" || inputs[3] != "c" {
+		t.Fatal("unexpected requests", inputs)
+	}
+}
+
+func TestSinkDefaultHTTPClientHasTimeout(t *testing.T) {
+	sink, err := NewSink(SinkConfig{Logger: log.Log, URL: "http://127.0.0.1/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	if sink.config.HTTPClient.Timeout != defaultSinkTimeout {
+		t.Fatal("unexpected HTTP client timeout")
+	}
+}
+
+func TestSinkUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ooniengine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	lines := make(chan []string)
+	go func() {
+		var out []string
+		defer func() { lines <- out }()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			out = append(out, scanner.Text())
+		}
+	}()
+	sink, err := NewSink(SinkConfig{Logger: log.Log, URL: "unix://" + path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := sink.SaveMeasurement(new(model.Measurement)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if out := <-lines; len(out) != 3 {
+		t.Fatal("unexpected number of lines", out)
+	}
+}
+
+// sinkPartialConn is a net.Conn that only writes the first
+// Limit bytes and then fails, like a peer closing the socket.
+type sinkPartialConn struct {
+	net.Conn
+	Limit   int
+	Written []byte
+}
+
+func (c *sinkPartialConn) Write(b []byte) (int, error) {
+	if len(b) > c.Limit {
+		c.Written = append(c.Written, b[:c.Limit]...)
+		return c.Limit, errors.New("mocked error")
+	}
+	c.Written = append(c.Written, b...)
+	return len(b), nil
+}
+
+func (c *sinkPartialConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *sinkPartialConn) Close() error {
+	return nil
+}
+
+func TestSinkUnixPartialWrite(t *testing.T) {
+	sink := &Sink{config: SinkConfig{Logger: log.Log, Timeout: time.Second}}
+	batch := []json.RawMessage{
+		json.RawMessage(`{"a":1}`), json.RawMessage(`{"b":2}`), json.RawMessage(`{"c":3}`),
+	}
+	// We fully write the first line and cut the second one.
+	conn := &sinkPartialConn{Limit: len(batch[0]) + 1 + 3}
+	sink.unixConn = conn
+	sink.send = func(b []json.RawMessage) (int, error) {
+		return sink.sendUnix("/nonexistent", b)
+	}
+	sink.config.BatchSize = len(batch)
+	pending := batch
+	if err := sink.flush(&pending); err == nil {
+		t.Fatal("expected an error here")
+	}
+	if len(pending) != 2 || string(pending[0]) != `{"b":2}` {
+		t.Fatal("we did not drop the measurement we have written", pending)
+	}
+	if sink.unixConn != nil {
+		t.Fatal("we did not drop the broken connection")
+	}
+	if string(conn.Written) != "{\"a\":1}\n{\"b" {
+		t.Fatal("unexpected written bytes", string(conn.Written))
+	}
+}
+
+func TestSinkLinesWritten(t *testing.T) {
+	batch := []json.RawMessage{json.RawMessage(`{}`), json.RawMessage(`{}`)}
+	for n, expected := range []int{0, 0, 0, 1, 1, 1, 2} {
+		if count := sinkLinesWritten(batch, n); count != expected {
+			t.Fatal("unexpected count", n, count)
+		}
+	}
+}
+
+func TestNewSinkInvalidURL(t *testing.T) {
+	for _, URL := range []string{"\t", "ftp://x", "unix://"} {
+		if _, err := NewSink(SinkConfig{Logger: log.Log, URL: URL}); err == nil {
+			t.Fatal("expected an error here", URL)
+		}
+	}
+}
+
+func TestInputProcessorWithSink(t *testing.T) {
+	expected := errors.New("mocked error")
+	sink := &FakeInputProcessorSaver{Err: expected}
+	ip := InputProcessor{
+		Experiment: NewInputProcessorExperimentWrapper(
+			&FakeInputProcessorExperiment{},
+		),
+		Inputs: []model.URLInfo{{
+			URL: "https://www.kernel.org/",
+		}},
+		OnError:   InputProcessorContinueOnError,
+		Saver:     NewInputProcessorSaverWrapper(&FakeInputProcessorSaver{}),
+		Sink:      NewInputProcessorSaverWrapper(sink),
+		Submitter: NewInputProcessorSubmitterWrapper(&FakeInputProcessorSubmitter{}),
+	}
+	results, err := ip.RunWithResults(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.M) != 1 {
+		t.Fatal("measurement not passed to sink")
+	}
+	if results[0].Stage != InputProcessorStageSink || !errors.Is(results[0].Err, expected) {
+		t.Fatal("unexpected result")
+	}
+}
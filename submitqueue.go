@@ -0,0 +1,336 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ooni/probe-engine/model"
+)
+
+// ErrNoSuchSubmitQueueEntry indicates that the entry you wanted
+// to drop is not inside the submit queue.
+var ErrNoSuchSubmitQueueEntry = errors.New("submitqueue: no such entry")
+
+const (
+	// submitQueueIndexKey is the key used to store the queue index.
+	submitQueueIndexKey = "submitqueue.index"
+
+	// submitQueueEntryKeyPrefix is the prefix of the keys we use
+	// to store each entry of the queue.
+	submitQueueEntryKeyPrefix = "submitqueue.entry."
+
+	// submitQueueMinBackoff is the backoff after the first failure.
+	submitQueueMinBackoff = time.Minute
+
+	// submitQueueMaxBackoff is the maximum backoff.
+	submitQueueMaxBackoff = 24 * time.Hour
+)
+
+// SubmitQueueEntry is a measurement we could not submit.
+type SubmitQueueEntry struct {
+	// ID uniquely identifies this entry inside the queue.
+	ID int64
+
+	// Added is when we added this entry to the queue.
+	Added time.Time
+
+	// Attempts is the number of failed submission attempts.
+	Attempts int64
+
+	// LastError is the error that occurred last time we
+	// attempted to submit this measurement.
+	LastError string
+
+	// NextAttempt is the time before which we will not
+	// attempt to submit this measurement again.
+	NextAttempt time.Time
+
+	// Measurement is the serialized measurement.
+	Measurement json.RawMessage
+}
+
+// submitQueueIndex is the index saved into the KVStore. Each
+// entry is saved using a separate key, so that adding or updating
+// an entry does not require us to rewrite the whole queue.
+type submitQueueIndex struct {
+	IDs    []int64
+	NextID int64
+}
+
+// SubmitQueueSubmitter is the SubmitQueue's view of the code that
+// submits measurements. The probeservices.Submitter, which takes
+// care of opening a report that matches each measurement, belongs
+// to this interface and is what you SHOULD use.
+type SubmitQueueSubmitter interface {
+	Submit(ctx context.Context, m *model.Measurement) error
+}
+
+// SubmitQueueConfig contains settings for NewSubmitQueue.
+type SubmitQueueConfig struct {
+	// KVStore is the mandatory key-value store where we keep
+	// the measurements we could not submit. Typically, you want
+	// to use the session's KVStore here. Its Get method MUST
+	// return an error wrapping os.ErrNotExist for missing keys,
+	// as the FileSystemKVStore does. We treat any other error as
+	// fatal, rather than risking to overwrite the queue.
+	KVStore model.KeyValueStore
+
+	// Logger is the mandatory logger.
+	Logger model.Logger
+}
+
+// SubmitQueue is a persistent queue of measurements that we
+// could not submit to the OONI collector. We retry submitting
+// such measurements with exponential backoff when Flush is
+// called, which should typically happen on later runs.
+type SubmitQueue struct {
+	kvstore model.KeyValueStore
+	logger  model.Logger
+	mu      sync.Mutex
+	timeNow func() time.Time
+}
+
+// NewSubmitQueue creates a new SubmitQueue instance.
+func NewSubmitQueue(config SubmitQueueConfig) *SubmitQueue {
+	return &SubmitQueue{
+		kvstore: config.KVStore,
+		logger:  config.Logger,
+		timeNow: time.Now,
+	}
+}
+
+// Add adds a measurement to the queue. The reason argument
+// is the error that prevented us from submitting it.
+func (sq *SubmitQueue) Add(m *model.Measurement, reason error) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+	index, err := sq.loadIndexLocked()
+	if err != nil {
+		return err
+	}
+	now := sq.timeNow()
+	index.NextID++
+	entry := SubmitQueueEntry{
+		ID:          index.NextID,
+		Added:       now,
+		Attempts:    1,
+		LastError:   reason.Error(),
+		NextAttempt: now.Add(submitQueueBackoff(1)),
+		Measurement: data,
+	}
+	// Write the entry first, so that a failure while writing the
+	// index can at most leave behind an unreferenced entry.
+	if err := sq.storeEntryLocked(entry); err != nil {
+		return err
+	}
+	index.IDs = append(index.IDs, entry.ID)
+	return sq.storeIndexLocked(index)
+}
+
+// List returns the entries currently inside the queue.
+func (sq *SubmitQueue) List() ([]SubmitQueueEntry, error) {
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+	index, err := sq.loadIndexLocked()
+	if err != nil {
+		return nil, err
+	}
+	var entries []SubmitQueueEntry
+	for _, id := range index.IDs {
+		entry, err := sq.loadEntryLocked(id)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Drop removes the entry with the given ID from the queue.
+func (sq *SubmitQueue) Drop(id int64) error {
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+	index, err := sq.loadIndexLocked()
+	if err != nil {
+		return err
+	}
+	for idx, entryID := range index.IDs {
+		if entryID == id {
+			index.IDs = append(index.IDs[:idx], index.IDs[idx+1:]...)
+			if err := sq.storeIndexLocked(index); err != nil {
+				return err
+			}
+			sq.clearEntryLocked(id)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %d", ErrNoSuchSubmitQueueEntry, id)
+}
+
+// DropAll removes all the entries from the queue.
+func (sq *SubmitQueue) DropAll() error {
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+	index, err := sq.loadIndexLocked()
+	if err != nil {
+		return err
+	}
+	ids := index.IDs
+	index.IDs = nil
+	if err := sq.storeIndexLocked(index); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		sq.clearEntryLocked(id)
+	}
+	return nil
+}
+
+// Flush attempts to submit the measurements inside the queue using
+// the given submitter. Unless force is true, we skip entries whose
+// backoff has not expired yet. Measurements that we successfully
+// submit are removed from the queue. For the others, we increment
+// the backoff. Entries that we cannot read are kept in the queue. We
+// return the number of measurements we submitted. An error is only
+// returned if we cannot read or update the queue or if the context
+// is done, in which case we stop flushing.
+func (sq *SubmitQueue) Flush(
+	ctx context.Context, submitter SubmitQueueSubmitter, force bool) (int, error) {
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+	index, err := sq.loadIndexLocked()
+	if err != nil {
+		return 0, err
+	}
+	var (
+		keep      []int64
+		removed   []int64
+		submitted int
+	)
+	for idx, id := range index.IDs {
+		if ctx.Err() != nil {
+			keep = append(keep, index.IDs[idx:]...)
+			break
+		}
+		entry, err := sq.loadEntryLocked(id)
+		if err != nil {
+			sq.logger.Warnf("%s", err.Error())
+			keep = append(keep, id)
+			continue
+		}
+		if !force && sq.timeNow().Before(entry.NextAttempt) {
+			keep = append(keep, id)
+			continue
+		}
+		var m model.Measurement
+		if err := json.Unmarshal(entry.Measurement, &m); err != nil {
+			sq.logger.Warnf("submitqueue: dropping invalid entry %d: %s", entry.ID, err.Error())
+			removed = append(removed, id)
+			continue
+		}
+		sq.logger.Infof("submitqueue: submitting queued measurement %d", entry.ID)
+		if err := submitter.Submit(ctx, &m); err != nil {
+			sq.logger.Warnf("submitqueue: cannot submit %d: %s", entry.ID, err.Error())
+			entry.Attempts++
+			entry.LastError = err.Error()
+			entry.NextAttempt = sq.timeNow().Add(submitQueueBackoff(entry.Attempts))
+			if err := sq.storeEntryLocked(entry); err != nil {
+				sq.logger.Warnf("submitqueue: cannot update entry %d: %s", entry.ID, err.Error())
+			}
+			keep = append(keep, id)
+			continue
+		}
+		removed = append(removed, id)
+		submitted++
+	}
+	index.IDs = keep
+	if err := sq.storeIndexLocked(index); err != nil {
+		return submitted, err
+	}
+	for _, id := range removed {
+		sq.clearEntryLocked(id)
+	}
+	return submitted, ctx.Err()
+}
+
+// loadIndexLocked loads the queue index. A missing index is not an
+// error, since this is what happens the first time. Any other error
+// is returned, so that we never overwrite a queue we cannot read.
+func (sq *SubmitQueue) loadIndexLocked() (index submitQueueIndex, err error) {
+	data, err := sq.kvstore.Get(submitQueueIndexKey)
+	if errors.Is(err, os.ErrNotExist) {
+		return submitQueueIndex{}, nil
+	}
+	if err != nil {
+		return submitQueueIndex{}, fmt.Errorf("submitqueue: cannot read index: %w", err)
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return submitQueueIndex{}, fmt.Errorf("submitqueue: cannot parse index: %w", err)
+	}
+	return index, nil
+}
+
+func (sq *SubmitQueue) storeIndexLocked(index submitQueueIndex) error {
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	return sq.kvstore.Set(submitQueueIndexKey, data)
+}
+
+// loadEntryLocked loads the entry with the given ID.
+func (sq *SubmitQueue) loadEntryLocked(id int64) (entry SubmitQueueEntry, err error) {
+	data, err := sq.kvstore.Get(submitQueueEntryKey(id))
+	if err != nil {
+		return entry, fmt.Errorf("submitqueue: cannot read entry %d: %w", id, err)
+	}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return entry, fmt.Errorf("submitqueue: cannot parse entry %d: %w", id, err)
+	}
+	return entry, nil
+}
+
+func (sq *SubmitQueue) storeEntryLocked(entry SubmitQueueEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return sq.kvstore.Set(submitQueueEntryKey(entry.ID), data)
+}
+
+// clearEntryLocked releases the storage used by an entry that is
+// not referenced by the index anymore. Since the KVStore does not
+// allow us to delete keys, we overwrite the entry with an empty
+// value. Errors are not fatal, hence we just log them.
+func (sq *SubmitQueue) clearEntryLocked(id int64) {
+	if err := sq.kvstore.Set(submitQueueEntryKey(id), nil); err != nil {
+		sq.logger.Warnf("submitqueue: cannot clear entry %d: %s", id, err.Error())
+	}
+}
+
+// submitQueueEntryKey returns the key used to store an entry.
+func submitQueueEntryKey(id int64) string {
+	return fmt.Sprintf("%s%d", submitQueueEntryKeyPrefix, id)
+}
+
+// submitQueueBackoff returns the backoff after the given
+// number of failed submission attempts.
+func submitQueueBackoff(attempts int64) time.Duration {
+	backoff := submitQueueMinBackoff
+	for i := int64(1); i < attempts && backoff < submitQueueMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > submitQueueMaxBackoff {
+		backoff = submitQueueMaxBackoff
+	}
+	return backoff
+}
@@ -0,0 +1,277 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/internal/kvstore"
+	"github.com/ooni/probe-engine/model"
+)
+
+type FakeSubmitQueueSubmitter struct {
+	Err error
+	M   []*model.Measurement
+}
+
+func (fsqs *FakeSubmitQueueSubmitter) Submit(
+	ctx context.Context, m *model.Measurement) error {
+	fsqs.M = append(fsqs.M, m)
+	return fsqs.Err
+}
+
+func newSubmitQueueForTesting() *SubmitQueue {
+	return NewSubmitQueue(SubmitQueueConfig{
+		KVStore: kvstore.NewMemoryKeyValueStore(),
+		Logger:  log.Log,
+	})
+}
+
+func listSubmitQueue(t *testing.T, sq *SubmitQueue) []SubmitQueueEntry {
+	entries, err := sq.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestSubmitQueueAddAndList(t *testing.T) {
+	sq := newSubmitQueueForTesting()
+	if len(listSubmitQueue(t, sq)) != 0 {
+		t.Fatal("expected empty queue")
+	}
+	expected := errors.New("mocked error")
+	for _, input := range []string{"a", "b"} {
+		m := &model.Measurement{Input: model.MeasurementTarget(input)}
+		if err := sq.Add(m, expected); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries := listSubmitQueue(t, sq)
+	if len(entries) != 2 {
+		t.Fatal("unexpected number of entries")
+	}
+	if entries[0].ID == entries[1].ID {
+		t.Fatal("entries do not have unique IDs")
+	}
+	if entries[0].LastError != "mocked error" || entries[0].Attempts != 1 {
+		t.Fatal("unexpected entry state")
+	}
+}
+
+func TestSubmitQueueFlushHonoursBackoff(t *testing.T) {
+	sq := newSubmitQueueForTesting()
+	if err := sq.Add(new(model.Measurement), errors.New("mocked error")); err != nil {
+		t.Fatal(err)
+	}
+	sub := new(FakeSubmitQueueSubmitter)
+	count, err := sq.Flush(context.Background(), sub, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 || len(sub.M) != 0 || len(listSubmitQueue(t, sq)) != 1 {
+		t.Fatal("we did not honour the backoff")
+	}
+	sq.timeNow = func() time.Time {
+		return time.Now().Add(submitQueueMinBackoff)
+	}
+	count, err = sq.Flush(context.Background(), sub, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 || len(sub.M) != 1 || len(listSubmitQueue(t, sq)) != 0 {
+		t.Fatal("we did not submit the measurement")
+	}
+}
+
+func TestSubmitQueueFlushFailure(t *testing.T) {
+	sq := newSubmitQueueForTesting()
+	if err := sq.Add(new(model.Measurement), errors.New("mocked error")); err != nil {
+		t.Fatal(err)
+	}
+	sub := &FakeSubmitQueueSubmitter{Err: errors.New("another error")}
+	count, err := sq.Flush(context.Background(), sub, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := listSubmitQueue(t, sq)
+	if count != 0 || len(entries) != 1 {
+		t.Fatal("unexpected queue state")
+	}
+	if entries[0].Attempts != 2 || entries[0].LastError != "another error" {
+		t.Fatal("we did not update the entry")
+	}
+	if entries[0].NextAttempt.Sub(entries[0].Added) < 2*submitQueueMinBackoff {
+		t.Fatal("we did not increase the backoff")
+	}
+}
+
+func TestSubmitQueueFlushCancelledContext(t *testing.T) {
+	sq := newSubmitQueueForTesting()
+	if err := sq.Add(new(model.Measurement), errors.New("mocked error")); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	sub := new(FakeSubmitQueueSubmitter)
+	count, err := sq.Flush(ctx, sub, true)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("not the error we expected: %+v", err)
+	}
+	if count != 0 || len(listSubmitQueue(t, sq)) != 1 {
+		t.Fatal("unexpected queue state")
+	}
+}
+
+func TestSubmitQueueDrop(t *testing.T) {
+	sq := newSubmitQueueForTesting()
+	for i := 0; i < 3; i++ {
+		if err := sq.Add(new(model.Measurement), errors.New("mocked error")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries := listSubmitQueue(t, sq)
+	if err := sq.Drop(entries[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := sq.Drop(entries[1].ID); !errors.Is(err, ErrNoSuchSubmitQueueEntry) {
+		t.Fatalf("not the error we expected: %+v", err)
+	}
+	if len(listSubmitQueue(t, sq)) != 2 {
+		t.Fatal("we did not drop the entry")
+	}
+	if err := sq.DropAll(); err != nil {
+		t.Fatal(err)
+	}
+	if len(listSubmitQueue(t, sq)) != 0 {
+		t.Fatal("we did not drop all entries")
+	}
+}
+
+func TestSubmitQueueBackoff(t *testing.T) {
+	if submitQueueBackoff(1) != submitQueueMinBackoff {
+		t.Fatal("unexpected initial backoff")
+	}
+	if submitQueueBackoff(3) != 4*submitQueueMinBackoff {
+		t.Fatal("unexpected backoff growth")
+	}
+	if submitQueueBackoff(100) != submitQueueMaxBackoff {
+		t.Fatal("unexpected maximum backoff")
+	}
+}
+
+type FakeSubmitQueueKVStore struct {
+	model.KeyValueStore
+	GetErr error
+}
+
+func (kvs FakeSubmitQueueKVStore) Get(key string) ([]byte, error) {
+	if kvs.GetErr != nil {
+		return nil, kvs.GetErr
+	}
+	return kvs.KeyValueStore.Get(key)
+}
+
+func TestSubmitQueueUsesOneKeyPerEntry(t *testing.T) {
+	kvs := kvstore.NewMemoryKeyValueStore()
+	sq := NewSubmitQueue(SubmitQueueConfig{KVStore: kvs, Logger: log.Log})
+	m := &model.Measurement{Input: "https://www.example.com/"}
+	if err := sq.Add(m, errors.New("mocked error")); err != nil {
+		t.Fatal(err)
+	}
+	index, err := kvs.Get(submitQueueIndexKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(index), "www.example.com") {
+		t.Fatal("the index should not contain the measurement")
+	}
+	entry, err := kvs.Get(submitQueueEntryKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(entry), "www.example.com") {
+		t.Fatal("the entry should contain the measurement")
+	}
+	if err := sq.DropAll(); err != nil {
+		t.Fatal(err)
+	}
+	entry, err = kvs.Get(submitQueueEntryKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entry) != 0 {
+		t.Fatal("we did not clear the dropped entry")
+	}
+}
+
+func TestSubmitQueueWithCorruptIndex(t *testing.T) {
+	kvs := kvstore.NewMemoryKeyValueStore()
+	corrupt := []byte("{")
+	if err := kvs.Set(submitQueueIndexKey, corrupt); err != nil {
+		t.Fatal(err)
+	}
+	sq := NewSubmitQueue(SubmitQueueConfig{KVStore: kvs, Logger: log.Log})
+	if err := sq.Add(new(model.Measurement), errors.New("mocked error")); err == nil {
+		t.Fatal("expected an error here")
+	}
+	if _, err := sq.Flush(context.Background(), new(FakeSubmitQueueSubmitter), true); err == nil {
+		t.Fatal("expected an error here")
+	}
+	if err := sq.Drop(1); err == nil || errors.Is(err, ErrNoSuchSubmitQueueEntry) {
+		t.Fatalf("not the error we expected: %+v", err)
+	}
+	if _, err := sq.List(); err == nil {
+		t.Fatal("expected an error here")
+	}
+	data, err := kvs.Get(submitQueueIndexKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, corrupt) {
+		t.Fatal("we have overwritten the index")
+	}
+}
+
+func TestSubmitQueueWithKVStoreReadError(t *testing.T) {
+	expected := errors.New("mocked error")
+	kvs := kvstore.NewMemoryKeyValueStore()
+	sq := NewSubmitQueue(SubmitQueueConfig{
+		KVStore: FakeSubmitQueueKVStore{KeyValueStore: kvs, GetErr: expected},
+		Logger:  log.Log,
+	})
+	if err := sq.Add(new(model.Measurement), expected); !errors.Is(err, expected) {
+		t.Fatalf("not the error we expected: %+v", err)
+	}
+	if _, err := kvs.Get(submitQueueIndexKey); err == nil {
+		t.Fatal("we should not have written the index")
+	}
+}
+
+func TestSubmitQueueFlushKeepsUnreadableEntries(t *testing.T) {
+	kvs := kvstore.NewMemoryKeyValueStore()
+	sq := NewSubmitQueue(SubmitQueueConfig{KVStore: kvs, Logger: log.Log})
+	for i := 0; i < 2; i++ {
+		if err := sq.Add(new(model.Measurement), errors.New("mocked error")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := kvs.Set(submitQueueEntryKey(1), []byte("{")); err != nil {
+		t.Fatal(err)
+	}
+	sub := new(FakeSubmitQueueSubmitter)
+	count, err := sq.Flush(context.Background(), sub, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 || len(sub.M) != 1 {
+		t.Fatal("we did not submit the readable entry")
+	}
+	if _, err := sq.List(); err == nil {
+		t.Fatal("expected the unreadable entry to still be queued")
+	}
+}
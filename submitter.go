@@ -6,9 +6,6 @@ import (
 	"github.com/ooni/probe-engine/model"
 )
 
-// TODO(bassosimone): maybe keep track of which measurements
-// could not be submitted by a specific submitter?
-
 // Submitter submits a measurement to the OONI collector.
 type Submitter interface {
 	// SubmitAndUpdateMeasurementContext submits the measurement
@@ -27,6 +24,12 @@ type SubmitterConfig struct {
 
 	// Logger is the logger to be used.
 	Logger model.Logger
+
+	// Queue is the optional queue where to save measurements that
+	// we could not submit. When set, failing to open the report or to
+	// submit a measurement is not an error. We will instead add the
+	// measurements to the queue, so we can retry later.
+	Queue *SubmitQueue
 }
 
 // SubmitterExperiment is the Submitter's view of the Experiment.
@@ -55,11 +58,20 @@ func NewSubmitter(ctx context.Context, config SubmitterConfig) (Submitter, error
 	if !config.Enabled {
 		return stubSubmitter{}, nil
 	}
+	submitter := realSubmitter{
+		exp:    config.Experiment,
+		logger: config.Logger,
+		queue:  config.Queue,
+	}
 	if err := config.Experiment.OpenReportContext(ctx); err != nil {
-		return nil, err
+		if config.Queue == nil {
+			return nil, err
+		}
+		config.Logger.Warnf("cannot open report; will queue measurements: %s", err.Error())
+		return submitter, nil
 	}
 	config.Logger.Infof("ReportID: %s", config.Experiment.ReportID())
-	return realSubmitter{exp: config.Experiment, logger: config.Logger}, nil
+	return submitter, nil
 }
 
 type stubSubmitter struct{}
@@ -74,10 +86,16 @@ var _ Submitter = stubSubmitter{}
 type realSubmitter struct {
 	exp    SubmitterExperiment
 	logger model.Logger
+	queue  *SubmitQueue
 }
 
 func (rs realSubmitter) SubmitAndUpdateMeasurementContext(
 	ctx context.Context, m *model.Measurement) error {
 	rs.logger.Info("submitting measurement to OONI collector; please be patient...")
-	return rs.exp.SubmitAndUpdateMeasurementContext(ctx, m)
+	err := rs.exp.SubmitAndUpdateMeasurementContext(ctx, m)
+	if err == nil || rs.queue == nil {
+		return err
+	}
+	rs.logger.Warnf("cannot submit measurement; adding it to queue: %s", err.Error())
+	return rs.queue.Add(m, err)
 }
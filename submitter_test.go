@@ -86,3 +86,28 @@ func TestNewSubmitterOpenReportSuccess(t *testing.T) {
 		t.Fatalf("not the error we expected: %+v", err)
 	}
 }
+
+func TestNewSubmitterWithQueue(t *testing.T) {
+	expected := errors.New("mocked error")
+	ctx := context.Background()
+	queue := newSubmitQueueForTesting()
+	submitter, err := NewSubmitter(ctx, SubmitterConfig{
+		Enabled: true,
+		Experiment: FakeSubmitterExperiment{
+			OpenReportErr: expected,
+			SubmitErr:     expected,
+		},
+		Logger: log.Log,
+		Queue:  queue,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := new(model.Measurement)
+	if err := submitter.SubmitAndUpdateMeasurementContext(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+	if len(listSubmitQueue(t, queue)) != 1 {
+		t.Fatal("measurement not queued")
+	}
+}